@@ -2,19 +2,53 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	logger "github.com/roackb2/simple_db/internal/log"
-	"github.com/roackb2/simple_db/internal/parser"
 	"github.com/roackb2/simple_db/internal/repl"
+	"github.com/roackb2/simple_db/simpledb"
 )
 
 func main() {
+	debug := flag.Bool("debug", false, "print lexer and parser debug output")
+	flag.Parse()
+	logger.SetDebug(*debug)
+	path := "simple.db"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	db, err := simpledb.Open(path, nil)
+	if err != nil {
+		fmt.Println("Failed to open database:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	// A dedicated connection keeps transactions and prepared statements across lines.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		fmt.Println("Failed to open connection:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
 	repl.PrintUsage()
+	reader := bufio.NewReader(os.Stdin)
 	for {
 		repl.PrintPrompt()
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
+		input, err := reader.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(input) == "" {
+			fmt.Println()
+			return
+		}
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
 		if repl.IsMetaCommand(input) {
 			switch repl.HandleMetaCommand(input) {
 			case repl.CmdSuccess:
@@ -25,14 +59,40 @@ func main() {
 			}
 		}
 
-		stmt := *parser.PrepareStatement(input)
-		switch stmt.PrepareRes {
-		case parser.PrepareSuccess:
-			parser.ExecuteStatement(stmt)
-			continue
-		case parser.PrepareFail:
-			logger.Debug("Unrecognized keyword at start of %s", input)
-			continue
+		if err := run(conn, input); err != nil {
+			fmt.Println("Error:", err)
 		}
 	}
 }
+
+// run executes a statement and prints its rows, or OK for a statement
+// returning none.
+func run(conn *simpledb.Conn, input string) error {
+	rows, err := conn.Query(input)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if len(rows.Columns()) == 0 {
+		fmt.Println("OK")
+		return nil
+	}
+
+	var cells [][]string
+	for rows.Next() {
+		var row []string
+		for _, value := range rows.Values() {
+			if value == nil {
+				row = append(row, "NULL")
+			} else {
+				row = append(row, fmt.Sprint(value))
+			}
+		}
+		cells = append(cells, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	repl.PrintTable(rows.Columns(), cells)
+	return nil
+}
@@ -0,0 +1,663 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// RootPageID is the page holding the start of the serialized catalog.
+const RootPageID int64 = 0
+
+// Column describes a single column of a table. Default holds the text of
+// the expression giving the value of the column when an INSERT leaves it
+// out, and is empty when that is NULL.
+//
+// Field is the position of the column's value in the records of the table,
+// which stays the same when other columns are dropped. Records written
+// before the column was added have fewer fields and read as Missing, the
+// encoded value the column was added with, or NULL when that is empty.
+//
+// Sequence names the sequence a SERIAL, identity or AUTOINCREMENT column
+// takes its default from, which is dropped with the column. Identity is
+// "ALWAYS" for a column the sequence alone may fill, and AutoIncrement
+// advances the sequence past values inserted explicitly.
+type Column struct {
+	Name          string     `json:"name"`
+	Type          types.Type `json:"type"`
+	NotNull       bool       `json:"not_null,omitempty"`
+	Default       string     `json:"default,omitempty"`
+	Field         int        `json:"field"`
+	Missing       []byte     `json:"missing,omitempty"`
+	Sequence      string     `json:"sequence,omitempty"`
+	Identity      string     `json:"identity,omitempty"`
+	AutoIncrement bool       `json:"auto_increment,omitempty"`
+}
+
+// Index describes a B+ tree index over columns of a table. A unique index
+// holds no two entries with the same key, unless the key has a NULL.
+type Index struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RootPageID int64    `json:"root_page_id"`
+	Unique     bool     `json:"unique,omitempty"`
+}
+
+// ConstraintType is the kind of a constraint.
+type ConstraintType string
+
+const (
+	PrimaryKey ConstraintType = "PRIMARY KEY"
+	Unique     ConstraintType = "UNIQUE"
+	Check      ConstraintType = "CHECK"
+	ForeignKey ConstraintType = "FOREIGN KEY"
+	// NotNull constraints are the NotNull flags of columns; the type only
+	// names them when they are violated.
+	NotNull ConstraintType = "NOT NULL"
+)
+
+// ReferentialAction is what a foreign key does to the rows referencing a
+// key that is deleted or updated.
+type ReferentialAction string
+
+const (
+	NoAction ReferentialAction = "NO ACTION"
+	Restrict ReferentialAction = "RESTRICT"
+	Cascade  ReferentialAction = "CASCADE"
+	SetNull  ReferentialAction = "SET NULL"
+)
+
+// Constraint is a named rule the rows of a table must follow. PRIMARY KEY
+// and UNIQUE constraints are enforced through the unique index of the same
+// name over Columns. A CHECK constraint holds the text of its condition, and
+// when it was written after a column, that column in Columns. A FOREIGN KEY
+// requires Columns, unless one of them is NULL, to match RefColumns of a row
+// of RefTable, which are those of a key of that table.
+type Constraint struct {
+	Name    string         `json:"name"`
+	Type    ConstraintType `json:"type"`
+	Columns []string       `json:"columns,omitempty"`
+	Check   string         `json:"check,omitempty"`
+
+	RefTable          string            `json:"ref_table,omitempty"`
+	RefColumns        []string          `json:"ref_columns,omitempty"`
+	OnDelete          ReferentialAction `json:"on_delete,omitempty"`
+	OnUpdate          ReferentialAction `json:"on_update,omitempty"`
+	Deferrable        bool              `json:"deferrable,omitempty"`
+	InitiallyDeferred bool              `json:"initially_deferred,omitempty"`
+}
+
+// Reference is a foreign key of Table.
+type Reference struct {
+	Table      *Table
+	Constraint *Constraint
+}
+
+// ColumnStats summarizes the values of a column.
+type ColumnStats struct {
+	NullCount     int64 `json:"null_count"`
+	DistinctCount int64 `json:"distinct_count"`
+	// Histogram holds the bounds of equi-depth buckets over the non-NULL
+	// values: each of the len(Histogram)-1 buckets holds about as many values
+	// as the others. The first bound is the minimum, the last the maximum.
+	Histogram []types.Value `json:"histogram,omitempty"`
+}
+
+// TableStats summarizes the contents of a table for the planner. It is
+// collected by ANALYZE and isn't kept up to date as the table changes.
+type TableStats struct {
+	RowCount  int64                   `json:"row_count"`
+	PageCount int64                   `json:"page_count"`
+	Columns   map[string]*ColumnStats `json:"columns"` // keyed by lower-cased column name
+}
+
+// Column returns the statistics of the named column, or nil.
+func (s *TableStats) Column(name string) *ColumnStats {
+	if s == nil {
+		return nil
+	}
+	return s.Columns[strings.ToLower(name)]
+}
+
+// Table describes a table and where its records are stored. NumFields is
+// the number of fields of the records written now, including those of
+// dropped columns.
+type Table struct {
+	Name        string        `json:"name"`
+	Columns     []Column      `json:"columns"`
+	NumFields   int           `json:"num_fields"`
+	FirstPageID int64         `json:"first_page_id"`
+	Indexes     []*Index      `json:"indexes,omitempty"`
+	Constraints []*Constraint `json:"constraints,omitempty"`
+	Stats       *TableStats   `json:"stats,omitempty"`
+
+	fieldColumns []int // column of each field, -1 for dropped ones; built on first use
+}
+
+// FieldColumns returns the position in Columns of the column stored in each
+// field of the table's records, or -1 for the fields of dropped columns.
+// The table must not be changed once it is in use.
+func (t *Table) FieldColumns() []int {
+	if t.fieldColumns == nil {
+		t.fieldColumns = make([]int, t.NumFields)
+		for i := range t.fieldColumns {
+			t.fieldColumns[i] = -1
+		}
+		for i, col := range t.Columns {
+			t.fieldColumns[col.Field] = i
+		}
+	}
+	return t.fieldColumns
+}
+
+// Copy returns a copy of the table that can be changed without affecting it.
+func (t *Table) Copy() *Table {
+	c := &Table{
+		Name:        t.Name,
+		Columns:     append([]Column(nil), t.Columns...),
+		NumFields:   t.NumFields,
+		FirstPageID: t.FirstPageID,
+		Stats:       t.Stats,
+	}
+	for _, index := range t.Indexes {
+		copied := *index
+		copied.Columns = append([]string(nil), index.Columns...)
+		c.Indexes = append(c.Indexes, &copied)
+	}
+	for _, constraint := range t.Constraints {
+		copied := *constraint
+		copied.Columns = append([]string(nil), constraint.Columns...)
+		copied.RefColumns = append([]string(nil), constraint.RefColumns...)
+		c.Constraints = append(c.Constraints, &copied)
+	}
+	return c
+}
+
+// Constraint returns the named constraint of the table, or nil.
+func (t *Table) Constraint(name string) *Constraint {
+	for _, constraint := range t.Constraints {
+		if strings.EqualFold(constraint.Name, name) {
+			return constraint
+		}
+	}
+	return nil
+}
+
+// PrimaryKey returns the PRIMARY KEY constraint of the table, or nil.
+func (t *Table) PrimaryKey() *Constraint {
+	for _, constraint := range t.Constraints {
+		if constraint.Type == PrimaryKey {
+			return constraint
+		}
+	}
+	return nil
+}
+
+// ColumnIndex returns the position of the named column, or -1 if it doesn't exist.
+func (t *Table) ColumnIndex(name string) int {
+	for i, col := range t.Columns {
+		if strings.EqualFold(col.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IndexOn returns an index whose leading column is the named column, or nil.
+func (t *Table) IndexOn(column string) *Index {
+	for _, index := range t.Indexes {
+		if strings.EqualFold(index.Columns[0], column) {
+			return index
+		}
+	}
+	return nil
+}
+
+// sequenceReservation is how many values of a sequence are reserved at a
+// time. Only reservations are written to disk, so a restart skips the values
+// reserved but not handed out.
+const sequenceReservation = 32
+
+// Sequence generates integers from Start, stepping by Increment between
+// MinValue and MaxValue and wrapping around at the end when Cycle is set.
+// Reserved is the last value that may have been handed out, which is never
+// handed out again, or while Called is false, the first value to hand out.
+type Sequence struct {
+	Name      string `json:"name"`
+	Increment int64  `json:"increment"`
+	MinValue  int64  `json:"min_value"`
+	MaxValue  int64  `json:"max_value"`
+	Start     int64  `json:"start"`
+	Cycle     bool   `json:"cycle,omitempty"`
+	Reserved  int64  `json:"reserved"`
+	Called    bool   `json:"called,omitempty"`
+
+	next int64 // next value to hand out, when left > 0
+	left int64 // values reserved but not handed out yet
+}
+
+// step returns the value following v, or false when the sequence is
+// exhausted.
+func (s *Sequence) step(v int64) (int64, bool) {
+	if s.Increment > 0 && v > s.MaxValue-s.Increment {
+		return s.MinValue, s.Cycle
+	}
+	if s.Increment < 0 && v < s.MinValue-s.Increment {
+		return s.MaxValue, s.Cycle
+	}
+	return v + s.Increment, true
+}
+
+// Catalog holds the schema of every table in the database. It is persisted as
+// a JSON blob in the page chain starting at RootPageID.
+type Catalog struct {
+	Tables    map[string]*Table    `json:"tables"`
+	Sequences map[string]*Sequence `json:"sequences,omitempty"`
+	// Format is the layout of the records of the database, see FormatVersion.
+	Format int `json:"format"`
+	// NextTxnID is the first transaction ID not reserved yet.
+	NextTxnID uint64 `json:"next_txn_id,omitempty"`
+	// CommitLog is the first page of the commit log, zero while there is
+	// none, see txn.CommitLog. Transactions before CommitLogBase committed
+	// before the log was created.
+	CommitLog     int64  `json:"commit_log,omitempty"`
+	CommitLogBase uint64 `json:"commit_log_base,omitempty"`
+	// Running is set while the database is open: finding it set when
+	// loading the catalog means the last run crashed.
+	Running bool `json:"running,omitempty"`
+
+	bp      *storage.BufferPool
+	version uint64 // bumped on every schema change, not persisted
+}
+
+// Version identifies the current schema. It changes whenever a table or an
+// index is created or dropped, letting cached statements detect stale schema lookups.
+func (c *Catalog) Version() uint64 {
+	return c.version
+}
+
+// FormatVersion is the layout of the records written by this version: 1
+// added the version header of MVCC and 2 the commands in it, see
+// storage.NewVersion.
+const FormatVersion = 2
+
+// Load reads the catalog from the database file, initializing an empty one
+// when the file is new.
+func Load(bp *storage.BufferPool) (*Catalog, error) {
+	catalog := &Catalog{Tables: make(map[string]*Table), Sequences: make(map[string]*Sequence), Format: FormatVersion, bp: bp}
+	if bp.NumPages() == 0 {
+		pageID, _, err := bp.NewPage()
+		if err != nil {
+			return nil, err
+		}
+		if pageID != RootPageID {
+			return nil, fmt.Errorf("catalog root allocated at page %d", pageID)
+		}
+		if err := bp.UnpinPage(pageID, true); err != nil {
+			return nil, err
+		}
+		return catalog, catalog.Save()
+	}
+
+	blob, err := storage.ReadBlob(bp, RootPageID)
+	if err != nil {
+		return nil, err
+	}
+	catalog.Format = 0
+	if err := json.Unmarshal(blob, catalog); err != nil {
+		return nil, fmt.Errorf("corrupted catalog: %w", err)
+	}
+	if catalog.Format != FormatVersion {
+		if len(catalog.Tables) > 0 {
+			return nil, fmt.Errorf("database file has record format %d, this version reads format %d", catalog.Format, FormatVersion)
+		}
+		catalog.Format = FormatVersion
+	}
+	if catalog.Tables == nil {
+		catalog.Tables = make(map[string]*Table)
+	}
+	if catalog.Sequences == nil {
+		catalog.Sequences = make(map[string]*Sequence)
+	}
+	for _, table := range catalog.Tables {
+		if table.NumFields == 0 {
+			// Written before columns had fields of their own.
+			assignFields(table)
+		}
+	}
+	return catalog, nil
+}
+
+// Save writes the catalog back to its pages.
+func (c *Catalog) Save() error {
+	blob, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return storage.WriteBlob(c.bp, RootPageID, blob)
+}
+
+func key(name string) string {
+	return strings.ToLower(name)
+}
+
+// GetTable looks up a table by name.
+func (c *Catalog) GetTable(name string) (*Table, error) {
+	table, ok := c.Tables[key(name)]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return table, nil
+}
+
+// assignFields stores each column of a table in the field of its position.
+func assignFields(table *Table) {
+	for i := range table.Columns {
+		table.Columns[i].Field = i
+	}
+	table.NumFields = len(table.Columns)
+}
+
+// CreateTable registers a new table and persists the catalog. The columns
+// of a new table are given the fields of their positions.
+func (c *Catalog) CreateTable(table *Table) error {
+	if _, exists := c.Tables[key(table.Name)]; exists {
+		return fmt.Errorf("table %s already exists", table.Name)
+	}
+	if table.NumFields == 0 {
+		assignFields(table)
+	}
+	seen := make(map[string]bool)
+	for _, col := range table.Columns {
+		if seen[key(col.Name)] {
+			return fmt.Errorf("duplicate column %s in table %s", col.Name, table.Name)
+		}
+		seen[key(col.Name)] = true
+	}
+	names := make(map[string]bool)
+	for _, constraint := range table.Constraints {
+		if names[key(constraint.Name)] {
+			return fmt.Errorf("constraint %s for table %s already exists", constraint.Name, table.Name)
+		}
+		names[key(constraint.Name)] = true
+	}
+	c.Tables[key(table.Name)] = table
+	c.version++
+	return c.Save()
+}
+
+// DropTable removes a table and persists the catalog.
+func (c *Catalog) DropTable(name string) error {
+	if _, exists := c.Tables[key(name)]; !exists {
+		return fmt.Errorf("table %s does not exist", name)
+	}
+	for _, ref := range c.References(name) {
+		if !strings.EqualFold(ref.Table.Name, name) {
+			return fmt.Errorf("cannot drop table %s because constraint %s on table %s depends on it", name, ref.Constraint.Name, ref.Table.Name)
+		}
+	}
+	// TODO: The table's pages should be returned to a free list.
+	delete(c.Tables, key(name))
+	c.version++
+	return c.Save()
+}
+
+// ReplaceTable replaces the definition of the named table, which may give
+// it a new name, and persists the catalog.
+func (c *Catalog) ReplaceTable(name string, table *Table) error {
+	if _, exists := c.Tables[key(name)]; !exists {
+		return fmt.Errorf("table %s does not exist", name)
+	}
+	if !strings.EqualFold(name, table.Name) {
+		if _, exists := c.Tables[key(table.Name)]; exists {
+			return fmt.Errorf("table %s already exists", table.Name)
+		}
+	}
+	delete(c.Tables, key(name))
+	c.Tables[key(table.Name)] = table
+	c.version++
+	return c.Save()
+}
+
+// References returns the foreign keys referring to the named table, ordered
+// by the name of their table.
+func (c *Catalog) References(name string) []Reference {
+	var refs []Reference
+	for _, tableName := range c.TableNames() {
+		table := c.Tables[key(tableName)]
+		for _, constraint := range table.Constraints {
+			if constraint.Type == ForeignKey && strings.EqualFold(constraint.RefTable, name) {
+				refs = append(refs, Reference{Table: table, Constraint: constraint})
+			}
+		}
+	}
+	return refs
+}
+
+// FindIndex looks up an index by name and returns it with its table.
+func (c *Catalog) FindIndex(name string) (*Table, *Index, error) {
+	for _, table := range c.Tables {
+		for _, index := range table.Indexes {
+			if strings.EqualFold(index.Name, name) {
+				return table, index, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("index %s does not exist", name)
+}
+
+// CreateIndex registers an index on a table and persists the catalog. Index
+// names are unique across the database.
+func (c *Catalog) CreateIndex(tableName string, index *Index) error {
+	table, err := c.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	if _, _, err := c.FindIndex(index.Name); err == nil {
+		return fmt.Errorf("index %s already exists", index.Name)
+	}
+	if len(index.Columns) == 0 {
+		return fmt.Errorf("index %s has no columns", index.Name)
+	}
+	for _, col := range index.Columns {
+		if table.ColumnIndex(col) == -1 {
+			return fmt.Errorf("column %s does not exist in table %s", col, table.Name)
+		}
+	}
+	table.Indexes = append(table.Indexes, index)
+	c.version++
+	return c.Save()
+}
+
+// DropIndex removes an index and persists the catalog.
+func (c *Catalog) DropIndex(name string) error {
+	table, index, err := c.FindIndex(name)
+	if err != nil {
+		return err
+	}
+	// TODO: The index's pages should be returned to a free list.
+	for i, candidate := range table.Indexes {
+		if candidate == index {
+			table.Indexes = append(table.Indexes[:i:i], table.Indexes[i+1:]...)
+			break
+		}
+	}
+	c.version++
+	return c.Save()
+}
+
+// SetStats replaces the statistics of a table and persists the catalog.
+// Statistics don't change the schema, so the version stays the same.
+func (c *Catalog) SetStats(tableName string, stats *TableStats) error {
+	table, err := c.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	table.Stats = stats
+	return c.Save()
+}
+
+// TableNames returns the names of all tables in sorted order.
+func (c *Catalog) TableNames() []string {
+	names := make([]string, 0, len(c.Tables))
+	for _, table := range c.Tables {
+		names = append(names, table.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetSequence looks up a sequence by name.
+func (c *Catalog) GetSequence(name string) (*Sequence, error) {
+	seq, ok := c.Sequences[key(name)]
+	if !ok {
+		return nil, fmt.Errorf("sequence %s does not exist", name)
+	}
+	return seq, nil
+}
+
+// CreateSequence registers a new sequence and persists the catalog.
+func (c *Catalog) CreateSequence(seq *Sequence) error {
+	if _, exists := c.Sequences[key(seq.Name)]; exists {
+		return fmt.Errorf("sequence %s already exists", seq.Name)
+	}
+	c.Sequences[key(seq.Name)] = seq
+	c.version++
+	return c.Save()
+}
+
+// DropSequence removes a sequence and persists the catalog. Sequences of
+// columns go with their column.
+func (c *Catalog) DropSequence(name string) error {
+	if _, exists := c.Sequences[key(name)]; !exists {
+		return fmt.Errorf("sequence %s does not exist", name)
+	}
+	for _, tableName := range c.TableNames() {
+		for _, col := range c.Tables[key(tableName)].Columns {
+			if strings.EqualFold(col.Sequence, name) {
+				return fmt.Errorf("cannot drop sequence %s because column %s of table %s requires it", name, col.Name, tableName)
+			}
+		}
+	}
+	delete(c.Sequences, key(name))
+	c.version++
+	return c.Save()
+}
+
+// NextValue advances a sequence and returns its new value. Values are
+// reserved sequenceReservation at a time, and each reservation is on disk
+// before its first value is handed out, so that no value is handed out
+// twice even across a crash. Sequences aren't transactional: the values of
+// a transaction that rolls back are skipped.
+func (c *Catalog) NextValue(name string) (int64, error) {
+	seq, err := c.GetSequence(name)
+	if err != nil {
+		return 0, err
+	}
+	if seq.left == 0 {
+		next := seq.Reserved
+		if seq.Called {
+			var ok bool
+			if next, ok = seq.step(seq.Reserved); !ok {
+				if seq.Increment > 0 {
+					return 0, fmt.Errorf("nextval: reached maximum value of sequence %s (%d)", seq.Name, seq.MaxValue)
+				}
+				return 0, fmt.Errorf("nextval: reached minimum value of sequence %s (%d)", seq.Name, seq.MinValue)
+			}
+		}
+		last, left := next, int64(1)
+		for ; left < sequenceReservation; left++ {
+			v, ok := seq.step(last)
+			if !ok {
+				break
+			}
+			last = v
+		}
+		seq.Reserved, seq.Called = last, true
+		if err := c.sync(); err != nil {
+			return 0, err
+		}
+		seq.next, seq.left = next, left
+	}
+	value := seq.next
+	seq.next, _ = seq.step(seq.next)
+	seq.left--
+	return value, nil
+}
+
+// SetValue makes value the last value handed out by a sequence, or the next
+// one to hand out when called is false, and persists the catalog.
+func (c *Catalog) SetValue(name string, value int64, called bool) error {
+	seq, err := c.GetSequence(name)
+	if err != nil {
+		return err
+	}
+	if value < seq.MinValue || value > seq.MaxValue {
+		return fmt.Errorf("setval: value %d is out of bounds for sequence %s (%d..%d)", value, seq.Name, seq.MinValue, seq.MaxValue)
+	}
+	seq.Reserved, seq.Called, seq.left = value, called, 0
+	return c.sync()
+}
+
+// AdvanceSequence makes value the last value handed out by a sequence if the
+// sequence would otherwise hand it out later, so that a value supplied by
+// hand isn't generated again. Values outside the sequence's bounds are left
+// alone.
+func (c *Catalog) AdvanceSequence(name string, value int64) error {
+	seq, err := c.GetSequence(name)
+	if err != nil {
+		return err
+	}
+	if value < seq.MinValue || value > seq.MaxValue {
+		return nil
+	}
+	// last is the value the sequence handed out last, as far as the
+	// comparison is concerned.
+	last, called := seq.Reserved, seq.Called
+	if seq.left > 0 {
+		last, called = seq.next, false
+	}
+	ahead := value > last || (!called && value == last)
+	if seq.Increment < 0 {
+		ahead = value < last || (!called && value == last)
+	}
+	if !ahead {
+		return nil
+	}
+	seq.Reserved, seq.Called, seq.left = value, true, 0
+	return c.sync()
+}
+
+// ReserveTxnIDs reserves n transaction IDs and returns the first of them.
+// The reservation is on disk before it returns, so that no ID is used twice
+// even across a crash.
+func (c *Catalog) ReserveTxnIDs(n uint64) (uint64, error) {
+	first := c.NextTxnID
+	if first == 0 {
+		first = 1
+	}
+	c.NextTxnID = first + n
+	if err := c.sync(); err != nil {
+		c.NextTxnID = first
+		return 0, err
+	}
+	return first, nil
+}
+
+// SetRunning sets Running and writes the catalog to disk at once.
+func (c *Catalog) SetRunning(running bool) error {
+	c.Running = running
+	return c.sync()
+}
+
+// sync persists the catalog and writes its pages to disk at once.
+func (c *Catalog) sync() error {
+	if err := c.Save(); err != nil {
+		return err
+	}
+	return storage.FlushBlob(c.bp, RootPageID)
+}
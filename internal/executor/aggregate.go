@@ -0,0 +1,628 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+const (
+	// aggPartitions is the number of partitions rows are spilled to when the
+	// group table of a hash aggregation outgrows its memory budget.
+	aggPartitions = 8
+	// maxAggDepth bounds how often a spilled partition is partitioned again.
+	maxAggDepth = 4
+)
+
+// accumulator folds the argument values of an aggregate for one group.
+type accumulator interface {
+	add(args []types.Value) error
+	result() (types.Value, error)
+	// size estimates the memory held by the accumulator.
+	size() int
+}
+
+// aggregateCall is an aggregate function call compiled against the input rows.
+// fn is set for user-defined aggregates, whose arguments are converted to
+// their declared types.
+type aggregateCall struct {
+	name     string
+	distinct bool
+	args     []evaluator
+	argType  types.Type // type of the first argument
+	fn       *AggregateFunction
+}
+
+func compileAggregate(call *parser.FuncCall, sc *scope, params []types.Value) (*aggregateCall, error) {
+	agg := &aggregateCall{name: call.Name, distinct: call.Distinct}
+	agg.fn, _ = call.Func.(*AggregateFunction)
+	if call.Star {
+		if call.Name != "COUNT" {
+			return nil, fmt.Errorf("%s(*) is not supported", call.Name)
+		}
+		// COUNT(*) counts rows, so every row contributes a non-NULL value.
+		agg.args = []evaluator{func(Row) (types.Value, error) {
+			return types.NewInteger(1), nil
+		}}
+		agg.argType = types.TypeInteger
+		return agg, nil
+	}
+	if agg.fn == nil && len(call.Args) != 1 {
+		return nil, fmt.Errorf("%s takes exactly one argument", call.Name)
+	}
+	var nested *parser.FuncCall
+	for _, arg := range call.Args {
+		parser.WalkExpr(arg, func(e parser.Expr) bool {
+			if f, ok := e.(*parser.FuncCall); ok && f.IsAggregate() {
+				nested = f
+			}
+			return nested == nil
+		})
+	}
+	// Above a grouping, as for an aggregate called as a window function,
+	// the nested aggregates were computed by the grouping.
+	if nested != nil && !sc.grouped {
+		return nil, errors.New("aggregate function calls cannot be nested")
+	}
+	argTypes := make([]types.Type, len(call.Args))
+	agg.args = make([]evaluator, len(call.Args))
+	for i, arg := range call.Args {
+		var err error
+		if agg.args[i], argTypes[i], err = compileExpr(arg, sc, params); err != nil {
+			return nil, err
+		}
+	}
+	if agg.fn != nil {
+		if err := agg.convertArgs(argTypes); err != nil {
+			return nil, err
+		}
+		return agg, nil
+	}
+	agg.argType = argTypes[0]
+	return agg, nil
+}
+
+// convertArgs checks the arguments of a user-defined aggregate against its
+// declared types and converts them.
+func (a *aggregateCall) convertArgs(argTypes []types.Type) error {
+	params := a.fn.Args
+	if len(argTypes) != len(params) {
+		return signatureError(a.name, argTypes)
+	}
+	for i, param := range params {
+		if param == types.TypeNull || argTypes[i] == param {
+			continue
+		}
+		if argTypes[i] != types.TypeNull && !types.CanCast(argTypes[i], param) {
+			return signatureError(a.name, argTypes)
+		}
+		arg := a.args[i]
+		a.args[i] = func(row Row) (types.Value, error) {
+			value, err := arg(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			return value.Cast(param)
+		}
+	}
+	if len(params) > 0 {
+		a.argType = params[0]
+	}
+	return nil
+}
+
+// resultType is the type of the values the aggregate produces.
+func (a *aggregateCall) resultType() types.Type {
+	if a.fn != nil {
+		return a.fn.Returns
+	}
+	switch a.name {
+	case "COUNT":
+		return types.TypeInteger
+	case "AVG":
+		return types.TypeReal
+	}
+	return a.argType
+}
+
+func (a *aggregateCall) newAccumulator() accumulator {
+	var acc accumulator
+	switch a.name {
+	case "COUNT":
+		acc = &countAccumulator{}
+	case "SUM":
+		acc = &sumAccumulator{}
+	case "AVG":
+		acc = &avgAccumulator{}
+	case "MIN":
+		acc = &extremeAccumulator{want: -1}
+	case "MAX":
+		acc = &extremeAccumulator{want: 1}
+	}
+	if a.fn != nil {
+		acc = &userAccumulator{fn: a.fn, state: a.fn.Init()}
+	}
+	if a.distinct {
+		acc = &distinctAccumulator{inner: acc, seen: make(map[string]struct{})}
+	}
+	return acc
+}
+
+type countAccumulator struct {
+	count int64
+}
+
+func (c *countAccumulator) add(args []types.Value) error {
+	if !args[0].IsNull() {
+		c.count++
+	}
+	return nil
+}
+
+func (c *countAccumulator) result() (types.Value, error) { return types.NewInteger(c.count), nil }
+func (c *countAccumulator) size() int                    { return 16 }
+
+// sumAccumulator sums integers exactly and switches to floating point once a
+// real value is seen.
+type sumAccumulator struct {
+	seen   bool
+	isReal bool
+	i      int64
+	f      float64
+}
+
+func (s *sumAccumulator) add(args []types.Value) error {
+	switch value := args[0]; value.Type {
+	case types.TypeNull:
+		return nil
+	case types.TypeInteger:
+		if !s.isReal {
+			sum := s.i + value.Int
+			if (value.Int > 0 && sum < s.i) || (value.Int < 0 && sum > s.i) {
+				return errors.New("integer out of range in SUM")
+			}
+			s.i = sum
+		}
+		s.f += float64(value.Int)
+	case types.TypeReal:
+		if !s.isReal {
+			s.isReal = true
+			s.f = float64(s.i)
+		}
+		s.f += value.Float
+	default:
+		return fmt.Errorf("cannot sum values of type %s", value.Type)
+	}
+	s.seen = true
+	return nil
+}
+
+func (s *sumAccumulator) result() (types.Value, error) {
+	switch {
+	case !s.seen:
+		return types.Null(), nil
+	case s.isReal:
+		return types.NewReal(s.f), nil
+	}
+	return types.NewInteger(s.i), nil
+}
+
+func (s *sumAccumulator) size() int { return 32 }
+
+type avgAccumulator struct {
+	sum   float64
+	count int64
+}
+
+func (a *avgAccumulator) add(args []types.Value) error {
+	switch value := args[0]; value.Type {
+	case types.TypeNull:
+		return nil
+	case types.TypeInteger:
+		a.sum += float64(value.Int)
+	case types.TypeReal:
+		a.sum += value.Float
+	default:
+		return fmt.Errorf("cannot average values of type %s", value.Type)
+	}
+	a.count++
+	return nil
+}
+
+func (a *avgAccumulator) result() (types.Value, error) {
+	if a.count == 0 {
+		return types.Null(), nil
+	}
+	return types.NewReal(a.sum / float64(a.count)), nil
+}
+
+func (a *avgAccumulator) size() int { return 24 }
+
+// extremeAccumulator keeps the smallest (want -1) or largest (want 1) value.
+type extremeAccumulator struct {
+	want int
+	best types.Value
+}
+
+func (e *extremeAccumulator) add(args []types.Value) error {
+	value := args[0]
+	if value.IsNull() {
+		return nil
+	}
+	if e.best.IsNull() {
+		e.best = value
+		return nil
+	}
+	cmp, err := types.Compare(value, e.best)
+	if err != nil {
+		return err
+	}
+	if cmp == e.want {
+		e.best = value
+	}
+	return nil
+}
+
+func (e *extremeAccumulator) result() (types.Value, error) { return e.best, nil }
+func (e *extremeAccumulator) size() int                    { return 56 + len(e.best.Str) }
+
+// userAccumulator runs a user-defined aggregate function. Unless the
+// function asks for them, rows with a NULL argument are skipped.
+type userAccumulator struct {
+	fn    *AggregateFunction
+	state interface{}
+}
+
+func (u *userAccumulator) add(args []types.Value) error {
+	if !u.fn.CalledOnNullInput {
+		for _, arg := range args {
+			if arg.IsNull() {
+				return nil
+			}
+		}
+	}
+	state, err := u.fn.Step(u.state, append([]types.Value{}, args...))
+	if err != nil {
+		return err
+	}
+	u.state = state
+	return nil
+}
+
+func (u *userAccumulator) result() (types.Value, error) {
+	value, err := u.fn.Final(u.state)
+	if err != nil {
+		return types.Null(), err
+	}
+	return value.Cast(u.fn.Returns)
+}
+
+// size can't see into the state, so it assumes a small one.
+func (u *userAccumulator) size() int { return 64 }
+
+// distinctAccumulator passes each distinct combination of arguments without
+// NULLs to its inner accumulator once.
+type distinctAccumulator struct {
+	inner accumulator
+	seen  map[string]struct{}
+	bytes int
+}
+
+func (d *distinctAccumulator) add(args []types.Value) error {
+	for _, arg := range args {
+		if arg.IsNull() {
+			return nil
+		}
+	}
+	key := string(encodeRow(args))
+	if _, ok := d.seen[key]; ok {
+		return nil
+	}
+	d.seen[key] = struct{}{}
+	d.bytes += 32 + len(key)
+	return d.inner.add(args)
+}
+
+func (d *distinctAccumulator) result() (types.Value, error) { return d.inner.result() }
+func (d *distinctAccumulator) size() int                    { return d.inner.size() + d.bytes }
+
+// aggGroup is the state of one group: its key values and accumulators.
+type aggGroup struct {
+	keys Row
+	accs []accumulator
+}
+
+func newAggGroup(keys Row, aggs []*aggregateCall) *aggGroup {
+	g := &aggGroup{keys: keys, accs: make([]accumulator, len(aggs))}
+	for i, agg := range aggs {
+		g.accs[i] = agg.newAccumulator()
+	}
+	return g
+}
+
+func (g *aggGroup) size() int {
+	size := rowSize(g.keys)
+	for _, acc := range g.accs {
+		size += acc.size()
+	}
+	return size
+}
+
+// add folds a row into the group and returns how much the group grew.
+func (g *aggGroup) add(aggs []*aggregateCall, row Row) (int, error) {
+	grown := 0
+	for i, agg := range aggs {
+		args := make([]types.Value, len(agg.args))
+		for j, arg := range agg.args {
+			var err error
+			if args[j], err = arg(row); err != nil {
+				return 0, err
+			}
+		}
+		before := g.accs[i].size()
+		if err := g.accs[i].add(args); err != nil {
+			return 0, err
+		}
+		grown += g.accs[i].size() - before
+	}
+	return grown, nil
+}
+
+// output returns the group's row: the key values followed by the results of
+// the aggregates.
+func (g *aggGroup) output() (Row, error) {
+	row := make(Row, 0, len(g.keys)+len(g.accs))
+	row = append(row, g.keys...)
+	for _, acc := range g.accs {
+		value, err := acc.result()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, value)
+	}
+	return row, nil
+}
+
+func evalGroupKeys(groupBy []evaluator, row Row) (Row, error) {
+	keys := make(Row, len(groupBy))
+	for i, eval := range groupBy {
+		var err error
+		if keys[i], err = eval(row); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// hashAggregate groups its input in a hash table. When the table outgrows
+// workMem, rows of groups not yet in the table are partitioned by hash into
+// temporary heaps and each partition is aggregated on its own after the
+// groups in memory have been returned; a group's rows therefore always end
+// up in exactly one place.
+type hashAggregate struct {
+	ctx     context.Context
+	bp      *storage.BufferPool
+	child   RowIterator
+	groupBy []evaluator
+	aggs    []*aggregateCall
+	workMem int
+	depth   int
+
+	loaded     bool
+	groups     []*aggGroup
+	pos        int
+	partitions []*storage.TableHeap
+	current    *hashAggregate // aggregation of the partition being returned
+}
+
+func newHashAggregate(ctx context.Context, bp *storage.BufferPool, child RowIterator, groupBy []evaluator, aggs []*aggregateCall, workMem int) *hashAggregate {
+	return &hashAggregate{ctx: ctx, bp: bp, child: child, groupBy: groupBy, aggs: aggs, workMem: workMem}
+}
+
+func (h *hashAggregate) Next() (Row, error) {
+	if !h.loaded {
+		h.loaded = true
+		if err := h.load(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if err := h.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if h.pos < len(h.groups) {
+			g := h.groups[h.pos]
+			h.groups[h.pos] = nil
+			h.pos++
+			return g.output()
+		}
+		if h.current != nil {
+			row, err := h.current.Next()
+			if err != nil || row != nil {
+				return row, err
+			}
+			if err := h.current.Close(); err != nil {
+				return nil, err
+			}
+			h.current = nil
+		}
+		if len(h.partitions) == 0 {
+			return nil, nil
+		}
+		partition := h.partitions[0]
+		h.partitions = h.partitions[1:]
+		h.current = &hashAggregate{
+			ctx:     h.ctx,
+			bp:      h.bp,
+			child:   &partitionScan{tempScan: newTempScan(h.ctx, partition), heap: partition},
+			groupBy: h.groupBy,
+			aggs:    h.aggs,
+			workMem: h.workMem,
+			depth:   h.depth + 1,
+		}
+	}
+}
+
+func (h *hashAggregate) Close() error {
+	err := h.child.Close()
+	if h.current != nil {
+		if closeErr := h.current.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		h.current = nil
+	}
+	for _, partition := range h.partitions {
+		if freeErr := partition.Free(); freeErr != nil && err == nil {
+			err = freeErr
+		}
+	}
+	h.partitions = nil
+	h.groups = nil
+	return err
+}
+
+func (h *hashAggregate) load() error {
+	table := make(map[string]*aggGroup)
+	used := 0
+	spilling := false
+	for {
+		row, err := h.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		keys, err := evalGroupKeys(h.groupBy, row)
+		if err != nil {
+			return err
+		}
+		key := string(encodeRow(keys))
+		g, ok := table[key]
+		if !ok {
+			if spilling {
+				if err := h.spill(key, row); err != nil {
+					return err
+				}
+				continue
+			}
+			g = newAggGroup(keys, h.aggs)
+			table[key] = g
+			h.groups = append(h.groups, g)
+			used += g.size() + len(key)
+		}
+		grown, err := g.add(h.aggs, row)
+		if err != nil {
+			return err
+		}
+		used += grown
+		if used > h.workMem && len(h.groupBy) > 0 && h.depth < maxAggDepth {
+			spilling = true
+		}
+	}
+	// Without GROUP BY there is exactly one group, even for no input.
+	if len(h.groupBy) == 0 && len(h.groups) == 0 {
+		h.groups = append(h.groups, newAggGroup(nil, h.aggs))
+	}
+	return nil
+}
+
+// spill writes a row to the partition its group key hashes to.
+func (h *hashAggregate) spill(key string, row Row) error {
+	if h.partitions == nil {
+		h.partitions = make([]*storage.TableHeap, aggPartitions)
+		for i := range h.partitions {
+			partition, err := storage.CreateTempHeap(h.bp)
+			if err != nil {
+				return err
+			}
+			h.partitions[i] = partition
+		}
+	}
+	hash := fnv.New64a()
+	// Seed the hash with the depth so a partition that spills again is
+	// split differently.
+	hash.Write([]byte{byte(h.depth)})
+	hash.Write([]byte(key))
+	_, err := h.partitions[hash.Sum64()%aggPartitions].Insert(encodeRow(row))
+	return err
+}
+
+// partitionScan reads a spilled partition and frees it when closed.
+type partitionScan struct {
+	*tempScan
+	heap *storage.TableHeap
+}
+
+func (p *partitionScan) Close() error {
+	return p.heap.Free()
+}
+
+// streamAggregate aggregates input that is already ordered by the group keys,
+// so each group is complete as soon as the key changes and only one group is
+// held in memory.
+type streamAggregate struct {
+	child   RowIterator
+	groupBy []evaluator
+	aggs    []*aggregateCall
+
+	current  *aggGroup
+	key      string
+	done     bool // the input is exhausted
+	returned bool // the last group was returned
+}
+
+func newStreamAggregate(child RowIterator, groupBy []evaluator, aggs []*aggregateCall) *streamAggregate {
+	return &streamAggregate{child: child, groupBy: groupBy, aggs: aggs}
+}
+
+func (s *streamAggregate) Next() (Row, error) {
+	for !s.done {
+		row, err := s.child.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			s.done = true
+			break
+		}
+		keys, err := evalGroupKeys(s.groupBy, row)
+		if err != nil {
+			return nil, err
+		}
+		key := string(encodeRow(keys))
+		var finished *aggGroup
+		if s.current == nil || key != s.key {
+			finished = s.current
+			s.current = newAggGroup(keys, s.aggs)
+			s.key = key
+		}
+		if _, err := s.current.add(s.aggs, row); err != nil {
+			return nil, err
+		}
+		if finished != nil {
+			return finished.output()
+		}
+	}
+	if s.returned {
+		return nil, nil
+	}
+	s.returned = true
+	if s.current == nil {
+		if len(s.groupBy) > 0 {
+			return nil, nil
+		}
+		// Without GROUP BY there is exactly one group, even for no input.
+		s.current = newAggGroup(nil, s.aggs)
+	}
+	return s.current.output()
+}
+
+func (s *streamAggregate) Close() error {
+	return s.child.Close()
+}
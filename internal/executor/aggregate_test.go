@@ -0,0 +1,9 @@
+package executor
+
+import "testing"
+
+func TestHashAggregateSpill(t *testing.T) {
+	db := newTestDB(t)
+	db.fillSpillTables()
+	db.checkSpill("SELECT g, COUNT(*), SUM(id), MAX(pad) FROM a GROUP BY g", "HashAggregate")
+}
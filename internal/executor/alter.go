@@ -0,0 +1,545 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// ExecuteAlterTableStatement changes the definition of a table. Adding,
+// dropping and renaming columns only changes the catalog: records keep the
+// fields they were written with, see catalog.Column. Changing the type of a
+// column rewrites the table and its indexes.
+func (e *Executor) ExecuteAlterTableStatement(ctx context.Context, t *txn.Transaction, alterStmt *parser.AlterTableStatement) (*Result, error) {
+	table, err := e.catalog.GetTable(alterStmt.TableName)
+	if err != nil {
+		return nil, err
+	}
+	switch alterStmt.Action {
+	case parser.AlterAddColumn:
+		err = e.addColumn(ctx, t, table, alterStmt)
+	case parser.AlterDropColumn:
+		err = e.dropColumn(t, table, alterStmt.ColumnName)
+	case parser.AlterRenameColumn:
+		err = e.renameColumn(t, table, alterStmt.ColumnName, alterStmt.NewName)
+	case parser.AlterRenameTable:
+		err = e.renameTable(t, table, alterStmt.NewName)
+	case parser.AlterColumnType:
+		err = e.alterColumnType(ctx, t, table, alterStmt)
+	default:
+		err = fmt.Errorf("unsupported ALTER TABLE action %d", alterStmt.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{}, nil
+}
+
+// replaceTable replaces the definition of the named table in the catalog,
+// keeping the heap opened for it under its new name.
+func (e *Executor) replaceTable(name string, table *catalog.Table) error {
+	if err := e.catalog.ReplaceTable(name, table); err != nil {
+		return err
+	}
+	heap, ok := e.heaps[strings.ToLower(name)]
+	delete(e.heaps, strings.ToLower(name))
+	if ok && heap.FirstPageID == table.FirstPageID {
+		e.heaps[strings.ToLower(table.Name)] = heap
+	}
+	return nil
+}
+
+// alterTable replaces the definition of a table by altered, logging how to
+// restore it.
+func (e *Executor) alterTable(t *txn.Transaction, table, altered *catalog.Table) error {
+	if err := e.replaceTable(table.Name, altered); err != nil {
+		return err
+	}
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoAlterTable, Table: altered.Name, TableDef: table})
+	return nil
+}
+
+// addColumn adds a column to a table without touching its records: those
+// written before read the value the column is added with, its default
+// evaluated once. The existing rows are checked against the constraints of
+// the column. A default that isn't constant, like that of a SERIAL column,
+// is evaluated for each row instead, which rewrites the table.
+func (e *Executor) addColumn(ctx context.Context, t *txn.Transaction, table *catalog.Table, alterStmt *parser.AlterTableStatement) error {
+	def := alterStmt.Column
+	if table.ColumnIndex(def.Name) != -1 {
+		return fmt.Errorf("column %s of table %s already exists", def.Name, table.Name)
+	}
+	altered := table.Copy()
+	if err := e.defineColumn(t, altered, def); err != nil {
+		return err
+	}
+	col := &altered.Columns[len(altered.Columns)-1]
+	col.Field = altered.NumFields
+	altered.NumFields++
+	var eval evaluator
+	constant := true
+	if col.Default != "" {
+		expr, err := parser.ParseExpr(col.Default)
+		if err != nil {
+			return fmt.Errorf("default of column %s: %w", col.Name, err)
+		}
+		if eval, err = compileDefault(altered, *col, expr, e.withEnv(&scope{}, ctx, nil, nil, nil)); err != nil {
+			return err
+		}
+		constant = constantExpr(expr)
+	}
+	if eval != nil && constant {
+		value, err := eval(nil)
+		if err != nil {
+			return err
+		}
+		if value, err = coerceValue(altered, catalog.Column{Name: col.Name, Type: col.Type}, value); err != nil {
+			return err
+		}
+		if !value.IsNull() {
+			col.Missing = value.Encode()
+		}
+	}
+	numConstraints, numIndexes := len(altered.Constraints), len(altered.Indexes)
+	if err := e.defineConstraints(altered, alterStmt.Constraints); err != nil {
+		return err
+	}
+	if err := checkAutoIncrement(altered); err != nil {
+		return err
+	}
+	m := newModification(t)
+	queued := make(map[string]bool)
+	queueChecks := func(row Row) {
+		for _, constraint := range altered.Constraints[numConstraints:] {
+			if constraint.Type != catalog.ForeignKey {
+				continue
+			}
+			key := rowKey(altered, constraint.Columns, row)
+			if !hasNull(key) && !queued[constraint.Name+string(encodeRow(key))] {
+				queued[constraint.Name+string(encodeRow(key))] = true
+				m.queueCheck(altered, constraint, key, false)
+			}
+		}
+	}
+	if !constant {
+		err := e.rewriteTable(ctx, t, table, altered, func(row Row) (Row, error) {
+			value, err := eval(nil)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = coerceValue(altered, *col, value); err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+			queueChecks(row)
+			return row, nil
+		})
+		if err != nil {
+			return err
+		}
+		return e.finishModification(m)
+	}
+
+	// Only the new constraints need checking, so they are checked through a
+	// table holding just them.
+	added := &catalog.Table{
+		Name:        altered.Name,
+		Columns:     altered.Columns,
+		Indexes:     altered.Indexes[numIndexes:],
+		Constraints: altered.Constraints[numConstraints:],
+	}
+	checks, err := compileChecks(added)
+	if err != nil {
+		return err
+	}
+	scan := newSeqScan(ctx, e.tableHeap(altered), altered, nil)
+	scan.snapshot = nil
+	for {
+		row, err := scan.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if _, err := coerceValue(altered, *col, row[len(row)-1]); err != nil {
+			return err
+		}
+		if err := checkRow(altered, checks, row); err != nil {
+			return err
+		}
+		if err := e.checkUnique(added, row, nil); err != nil {
+			return err
+		}
+		if err := e.insertIndexEntries(added, row, scan.RID()); err != nil {
+			return err
+		}
+		queueChecks(row)
+	}
+	if err := e.alterTable(t, table, altered); err != nil {
+		return err
+	}
+	return e.finishModification(m)
+}
+
+// dropColumn removes a column from a table along with the indexes and
+// constraints using it, and its sequence if it has one. Its field stays in
+// the records, which is read no more.
+func (e *Executor) dropColumn(t *txn.Transaction, table *catalog.Table, name string) error {
+	i := table.ColumnIndex(name)
+	if i == -1 {
+		return fmt.Errorf("column %s of table %s does not exist", name, table.Name)
+	}
+	if len(table.Columns) == 1 {
+		return fmt.Errorf("cannot drop column %s because it is the only column of table %s", name, table.Name)
+	}
+	uses := func(columns []string) bool {
+		for _, column := range columns {
+			if strings.EqualFold(column, name) {
+				return true
+			}
+		}
+		return false
+	}
+	altered := table.Copy()
+	var constraints []*catalog.Constraint
+	for _, constraint := range altered.Constraints {
+		dropped := uses(constraint.Columns)
+		if constraint.Type == catalog.Check && !dropped {
+			refs, err := checkColumns(constraint.Check)
+			if err != nil {
+				return err
+			}
+			dropped = uses(refs)
+		}
+		if !dropped {
+			constraints = append(constraints, constraint)
+		}
+	}
+	altered.Constraints = constraints
+	for _, ref := range e.catalog.References(table.Name) {
+		// A foreign key of the table itself may go with the column.
+		own := strings.EqualFold(ref.Table.Name, table.Name)
+		if uses(ref.Constraint.RefColumns) && !(own && altered.Constraint(ref.Constraint.Name) == nil) {
+			return fmt.Errorf("cannot drop column %s of table %s because constraint %s on table %s depends on it",
+				name, table.Name, ref.Constraint.Name, ref.Table.Name)
+		}
+	}
+	var indexes []*catalog.Index
+	for _, def := range altered.Indexes {
+		if !uses(def.Columns) {
+			indexes = append(indexes, def)
+		}
+	}
+	altered.Indexes = indexes
+	altered.Columns = append(altered.Columns[:i:i], altered.Columns[i+1:]...)
+	if altered.Stats != nil {
+		altered.Stats = copyStats(altered.Stats)
+		delete(altered.Stats.Columns, strings.ToLower(name))
+	}
+	if err := e.alterTable(t, table, altered); err != nil {
+		return err
+	}
+	if seq := table.Columns[i].Sequence; seq != "" {
+		return e.dropSequence(t, seq)
+	}
+	return nil
+}
+
+// renameColumn renames a column of a table wherever the catalog names it,
+// including the foreign keys of other tables referencing it.
+func (e *Executor) renameColumn(t *txn.Transaction, table *catalog.Table, name, newName string) error {
+	i := table.ColumnIndex(name)
+	if i == -1 {
+		return fmt.Errorf("column %s of table %s does not exist", name, table.Name)
+	}
+	if table.ColumnIndex(newName) != -1 {
+		return fmt.Errorf("column %s of table %s already exists", newName, table.Name)
+	}
+	rename := func(columns []string) {
+		for j, column := range columns {
+			if strings.EqualFold(column, name) {
+				columns[j] = strings.ToLower(newName)
+			}
+		}
+	}
+	altered := table.Copy()
+	altered.Columns[i].Name = newName
+	for _, def := range altered.Indexes {
+		rename(def.Columns)
+	}
+	for _, constraint := range altered.Constraints {
+		rename(constraint.Columns)
+		if constraint.Type == catalog.ForeignKey && strings.EqualFold(constraint.RefTable, table.Name) {
+			rename(constraint.RefColumns)
+		}
+		if constraint.Type == catalog.Check {
+			check, err := rewriteColumnRefs(constraint.Check, func(ref *parser.ColumnRef) {
+				if strings.EqualFold(ref.Column, name) {
+					ref.Column = newName
+				}
+			})
+			if err != nil {
+				return err
+			}
+			constraint.Check = check
+		}
+	}
+	if altered.Stats != nil {
+		altered.Stats = copyStats(altered.Stats)
+		if stats, ok := altered.Stats.Columns[strings.ToLower(name)]; ok {
+			delete(altered.Stats.Columns, strings.ToLower(name))
+			altered.Stats.Columns[strings.ToLower(newName)] = stats
+		}
+	}
+	if err := e.alterTable(t, table, altered); err != nil {
+		return err
+	}
+	return e.alterReferences(t, table.Name, func(constraint *catalog.Constraint) {
+		rename(constraint.RefColumns)
+	})
+}
+
+// renameTable gives a table a new name, which the checks of the table and
+// the foreign keys referencing it follow.
+func (e *Executor) renameTable(t *txn.Transaction, table *catalog.Table, newName string) error {
+	altered := table.Copy()
+	altered.Name = newName
+	for _, constraint := range altered.Constraints {
+		if constraint.Type == catalog.ForeignKey && strings.EqualFold(constraint.RefTable, table.Name) {
+			constraint.RefTable = newName
+		}
+		if constraint.Type == catalog.Check {
+			check, err := rewriteColumnRefs(constraint.Check, func(ref *parser.ColumnRef) {
+				if strings.EqualFold(ref.Table, table.Name) {
+					ref.Table = newName
+				}
+			})
+			if err != nil {
+				return err
+			}
+			constraint.Check = check
+		}
+	}
+	if err := e.alterTable(t, table, altered); err != nil {
+		return err
+	}
+	return e.alterReferences(t, table.Name, func(constraint *catalog.Constraint) {
+		constraint.RefTable = newName
+	})
+}
+
+// alterReferences applies change to the foreign keys of other tables
+// referencing the named table, now renamed or with a column renamed.
+func (e *Executor) alterReferences(t *txn.Transaction, name string, change func(*catalog.Constraint)) error {
+	for _, ref := range e.catalog.References(name) {
+		if strings.EqualFold(ref.Table.Name, name) {
+			continue
+		}
+		// A table with several such foreign keys is replaced once per key.
+		table, err := e.catalog.GetTable(ref.Table.Name)
+		if err != nil {
+			return err
+		}
+		altered := table.Copy()
+		change(altered.Constraint(ref.Constraint.Name))
+		if err := e.alterTable(t, table, altered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alterColumnType changes the type of a column, converting its values by a
+// cast or the USING expression, which rewrites the table.
+func (e *Executor) alterColumnType(ctx context.Context, t *txn.Transaction, table *catalog.Table, alterStmt *parser.AlterTableStatement) error {
+	i := table.ColumnIndex(alterStmt.ColumnName)
+	if i == -1 {
+		return fmt.Errorf("column %s of table %s does not exist", alterStmt.ColumnName, table.Name)
+	}
+	newType, err := types.ParseType(alterStmt.TypeName)
+	if err != nil {
+		return err
+	}
+	name := table.Columns[i].Name
+	if table.Columns[i].Sequence != "" && newType != types.TypeInteger {
+		return fmt.Errorf("cannot alter type of column %s because it is filled from sequence %s", name, table.Columns[i].Sequence)
+	}
+	for _, constraint := range table.Constraints {
+		if constraint.Type == catalog.ForeignKey && sameColumns([]string{name}, constraint.Columns) {
+			return fmt.Errorf("cannot alter type of column %s because it is used by foreign key constraint %s", name, constraint.Name)
+		}
+	}
+	for _, ref := range e.catalog.References(table.Name) {
+		if sameColumns([]string{name}, ref.Constraint.RefColumns) {
+			return fmt.Errorf("cannot alter type of column %s because foreign key constraint %s on table %s depends on it",
+				name, ref.Constraint.Name, ref.Table.Name)
+		}
+	}
+
+	convert := func(row Row) (types.Value, error) { return row[i], nil }
+	if alterStmt.Using != nil {
+		eval, typ, err := compileExpr(alterStmt.Using, tableScope(table, table.Name), nil)
+		if err != nil {
+			return err
+		}
+		if !types.CanCast(typ, newType) {
+			return fmt.Errorf("result of USING clause for column %s cannot be cast automatically to type %s", name, newType)
+		}
+		convert = eval
+	} else if !types.CanCast(table.Columns[i].Type, newType) {
+		return fmt.Errorf("column %s cannot be cast automatically to type %s; specify a USING expression", name, newType)
+	}
+
+	altered := table.Copy()
+	altered.Columns[i].Type = newType
+	if col := altered.Columns[i]; col.Default != "" {
+		expr, err := parser.ParseExpr(col.Default)
+		if err != nil {
+			return fmt.Errorf("default of column %s: %w", col.Name, err)
+		}
+		eval, err := compileDefault(altered, col, expr, e.withEnv(&scope{}, ctx, nil, nil, nil))
+		if err == nil && constantExpr(expr) {
+			var value types.Value
+			if value, err = eval(nil); err == nil {
+				_, err = coerceValue(altered, catalog.Column{Name: col.Name, Type: col.Type}, value)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("default for column %s cannot be cast automatically to type %s", col.Name, newType)
+		}
+	}
+	if altered.Stats != nil {
+		altered.Stats = copyStats(altered.Stats)
+		delete(altered.Stats.Columns, strings.ToLower(name))
+	}
+	return e.rewriteTable(ctx, t, table, altered, func(row Row) (Row, error) {
+		value, err := convert(row)
+		if err != nil {
+			return nil, err
+		}
+		converted := append(Row(nil), row...)
+		converted[i] = value
+		return converted, nil
+	})
+}
+
+// rewriteTable replaces table by altered, writing the latest version of each
+// row of table, as convert makes it into a row of altered, to a new heap with
+// a field per column and rebuilding the indexes, checking the constraints of
+// the table on the way. The old heap and indexes are left as they are, so
+// that undoing the change only needs to restore the old definition. The new
+// versions are created by t, so transactions whose snapshots predate it find
+// the table empty once it commits.
+//
+// TODO: The old pages should be returned to a free list once the change
+// commits.
+func (e *Executor) rewriteTable(ctx context.Context, t *txn.Transaction, table, altered *catalog.Table, convert func(Row) (Row, error)) error {
+	for j := range altered.Columns {
+		altered.Columns[j].Field = j
+		altered.Columns[j].Missing = nil
+	}
+	altered.NumFields = len(altered.Columns)
+	checks, err := compileChecks(altered)
+	if err != nil {
+		return err
+	}
+	heap, err := storage.CreateTableHeap(e.bufferManager)
+	if err != nil {
+		return err
+	}
+	altered.FirstPageID = heap.FirstPageID
+	for _, def := range altered.Indexes {
+		tree, err := index.Create(e.bufferManager)
+		if err != nil {
+			return err
+		}
+		def.RootPageID = tree.RootPageID()
+	}
+
+	scan := newSeqScan(ctx, e.tableHeap(table), table, nil)
+	scan.snapshot = nil
+	for {
+		row, err := scan.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		converted, err := convert(row)
+		if err != nil {
+			return err
+		}
+		for j, col := range altered.Columns {
+			if converted[j], err = coerceValue(altered, col, converted[j]); err != nil {
+				return err
+			}
+		}
+		if err := checkRow(altered, checks, converted); err != nil {
+			return err
+		}
+		if err := e.checkUnique(altered, converted, nil); err != nil {
+			return err
+		}
+		rid, err := heap.Insert(storage.NewVersion(t.ID, t.Command(), encodeRecord(altered, converted)))
+		if err != nil {
+			return err
+		}
+		if err := e.insertIndexEntries(altered, converted, rid); err != nil {
+			return err
+		}
+	}
+	return e.alterTable(t, table, altered)
+}
+
+// checkColumns returns the names of the columns the condition of a check
+// refers to.
+func checkColumns(check string) ([]string, error) {
+	expr, err := parser.ParseExpr(check)
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	parser.WalkExpr(expr, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			columns = append(columns, ref.Column)
+		}
+		return true
+	})
+	return columns, nil
+}
+
+// rewriteColumnRefs applies change to the column references of the
+// condition of a check and returns its new text.
+func rewriteColumnRefs(check string, change func(*parser.ColumnRef)) (string, error) {
+	expr, err := parser.ParseExpr(check)
+	if err != nil {
+		return "", err
+	}
+	parser.WalkExpr(expr, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			change(ref)
+		}
+		return true
+	})
+	return expr.String(), nil
+}
+
+// copyStats returns a copy of the statistics of a table whose columns can
+// be changed.
+func copyStats(stats *catalog.TableStats) *catalog.TableStats {
+	copied := *stats
+	copied.Columns = make(map[string]*catalog.ColumnStats, len(stats.Columns))
+	for name, column := range stats.Columns {
+		copied.Columns[name] = column
+	}
+	return &copied
+}
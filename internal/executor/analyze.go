@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"context"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+)
+
+// ExecuteAnalyzeStatement collects the statistics the planner uses for one
+// table, or for every table, and stores them in the catalog. Statistics
+// aren't transactional: rolling back keeps them.
+func (e *Executor) ExecuteAnalyzeStatement(ctx context.Context, analyzeStmt *parser.AnalyzeStatement) (*Result, error) {
+	names := e.catalog.TableNames()
+	if analyzeStmt.TableName != "" {
+		names = []string{analyzeStmt.TableName}
+	}
+	for _, name := range names {
+		table, err := e.catalog.GetTable(name)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := e.analyzeTable(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.catalog.SetStats(table.Name, stats); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{}, nil
+}
+
+// analyzeTable scans a table and summarizes its rows.
+func (e *Executor) analyzeTable(ctx context.Context, table *catalog.Table) (*catalog.TableStats, error) {
+	collector := planner.NewCollector(table)
+	scan := newSeqScan(ctx, e.tableHeap(table), table, nil)
+	for {
+		row, err := scan.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return collector.Stats(), nil
+		}
+		collector.Add(scan.RID().PageID, row)
+	}
+}
@@ -0,0 +1,335 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// ConstraintError reports a row that violates a constraint of a table. For
+// NOT NULL, Column names the column and Constraint is empty; for keys, Key
+// holds the values of Columns that are already taken. For a foreign key of
+// Table referencing RefTable, Key holds the values of Columns missing from
+// RefTable or, when Referenced is set, the values of the referenced Columns
+// that rows of Table still refer to.
+type ConstraintError struct {
+	Type       catalog.ConstraintType
+	Table      string
+	Constraint string
+	Column     string
+	Columns    []string
+	Key        string
+	RefTable   string
+	Referenced bool
+}
+
+func (e *ConstraintError) Error() string {
+	switch e.Type {
+	case catalog.NotNull:
+		return fmt.Sprintf("column %s of table %s cannot be NULL", e.Column, e.Table)
+	case catalog.Check:
+		return fmt.Sprintf("new row for table %s violates check constraint %s", e.Table, e.Constraint)
+	case catalog.ForeignKey:
+		if e.Referenced {
+			return fmt.Sprintf("update or delete on table %s violates foreign key constraint %s on table %s: key (%s)=%s is still referenced from table %s",
+				e.RefTable, e.Constraint, e.Table, strings.Join(e.Columns, ", "), e.Key, e.Table)
+		}
+		return fmt.Sprintf("insert or update on table %s violates foreign key constraint %s: key (%s)=%s is not present in table %s",
+			e.Table, e.Constraint, strings.Join(e.Columns, ", "), e.Key, e.RefTable)
+	default:
+		return fmt.Sprintf("duplicate key value violates unique constraint %s: key (%s)=%s already exists",
+			e.Constraint, strings.Join(e.Columns, ", "), e.Key)
+	}
+}
+
+// defineColumn adds a column of CREATE TABLE to table, checking its default.
+// SERIAL, identity and AUTOINCREMENT columns get a sequence of their own.
+func (e *Executor) defineColumn(t *txn.Transaction, table *catalog.Table, def parser.ColumnDefinition) error {
+	typeName := def.TypeName
+	serial := serialTypes[strings.ToUpper(typeName)]
+	if serial {
+		typeName = "INTEGER"
+	}
+	colType, err := types.ParseType(typeName)
+	if err != nil {
+		return err
+	}
+	col := catalog.Column{Name: def.Name, Type: colType, NotNull: def.NotNull}
+	if serial || def.Identity != "" || def.AutoIncrement {
+		if err := e.defineSequenceColumn(t, table, &col, def); err != nil {
+			return err
+		}
+	} else if def.Default != nil {
+		eval, err := compileDefault(table, col, def.Default, e.withEnv(&scope{}, context.Background(), nil, nil, nil))
+		if err != nil {
+			return err
+		}
+		// Evaluating a constant default once catches values that don't
+		// convert to the column's type, like 'abc' for an integer.
+		if constantExpr(def.Default) {
+			value, err := eval(nil)
+			if err != nil {
+				return err
+			}
+			if _, err := coerceValue(table, catalog.Column{Name: col.Name, Type: col.Type}, value); err != nil {
+				return err
+			}
+		}
+		col.Default = def.Default.String()
+	}
+	table.Columns = append(table.Columns, col)
+	return nil
+}
+
+// compileDefault compiles the default of a column, which may not refer to
+// columns or subqueries.
+func compileDefault(table *catalog.Table, col catalog.Column, expr parser.Expr, sc *scope) (evaluator, error) {
+	var err error
+	parser.WalkExpr(expr, func(e parser.Expr) bool {
+		if _, ok := e.(*parser.ColumnRef); ok {
+			err = fmt.Errorf("cannot use column reference in DEFAULT expression of column %s", col.Name)
+		} else if parser.Subquery(e) != nil {
+			err = fmt.Errorf("cannot use subquery in DEFAULT expression of column %s", col.Name)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return compileAssignment(table, col, expr, sc, nil)
+}
+
+// compileDefaults compiles the defaults of the columns of a table in sc,
+// leaving nil for those that default to NULL.
+func compileDefaults(table *catalog.Table, sc *scope) ([]evaluator, error) {
+	defaults := make([]evaluator, len(table.Columns))
+	for i, col := range table.Columns {
+		if col.Default == "" {
+			continue
+		}
+		expr, err := parser.ParseExpr(col.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default of column %s: %w", col.Name, err)
+		}
+		if defaults[i], err = compileDefault(table, col, expr, sc); err != nil {
+			return nil, err
+		}
+	}
+	return defaults, nil
+}
+
+// defineConstraints adds the constraints of CREATE TABLE to table, naming
+// those left unnamed, and creates the unique indexes enforcing its keys.
+func (e *Executor) defineConstraints(table *catalog.Table, defs []parser.ConstraintDefinition) error {
+	taken := func(name string) bool {
+		if table.Constraint(name) != nil {
+			return true
+		}
+		_, _, err := e.catalog.FindIndex(name)
+		return err == nil
+	}
+	var keys []*catalog.Constraint
+	// Foreign keys come last, so that one referring to its own table finds
+	// the keys of the table wherever they are written.
+	ordered := make([]parser.ConstraintDefinition, 0, len(defs))
+	for _, def := range defs {
+		if def.Type != parser.ConstraintForeignKey {
+			ordered = append(ordered, def)
+		}
+	}
+	for _, def := range defs {
+		if def.Type == parser.ConstraintForeignKey {
+			ordered = append(ordered, def)
+		}
+	}
+	for _, def := range ordered {
+		constraint := &catalog.Constraint{Name: def.Name}
+		for _, name := range def.Columns {
+			if table.ColumnIndex(name) == -1 {
+				return fmt.Errorf("column %s named in constraint does not exist", name)
+			}
+			constraint.Columns = append(constraint.Columns, strings.ToLower(name))
+		}
+		switch def.Type {
+		case parser.ConstraintPrimaryKey, parser.ConstraintUnique:
+			constraint.Type = catalog.Unique
+			if def.Type == parser.ConstraintPrimaryKey {
+				if table.PrimaryKey() != nil {
+					return fmt.Errorf("multiple primary keys for table %s are not allowed", table.Name)
+				}
+				constraint.Type = catalog.PrimaryKey
+				// The columns of a primary key can't be NULL.
+				for _, name := range def.Columns {
+					table.Columns[table.ColumnIndex(name)].NotNull = true
+				}
+			}
+			keys = append(keys, constraint)
+		case parser.ConstraintCheck:
+			constraint.Type = catalog.Check
+			if _, err := compileCheck(table, def.Check); err != nil {
+				return err
+			}
+			constraint.Check = def.Check.String()
+		case parser.ConstraintForeignKey:
+			constraint.Type = catalog.ForeignKey
+			if err := e.defineForeignKey(table, def, constraint); err != nil {
+				return err
+			}
+		}
+		if constraint.Name == "" {
+			constraint.Name = defaultConstraintName(table, constraint, def.Column, taken)
+		} else if taken(constraint.Name) {
+			return fmt.Errorf("constraint %s already exists", constraint.Name)
+		}
+		table.Constraints = append(table.Constraints, constraint)
+	}
+	for _, constraint := range keys {
+		tree, err := index.Create(e.bufferManager)
+		if err != nil {
+			return err
+		}
+		table.Indexes = append(table.Indexes, &catalog.Index{
+			Name: constraint.Name, Columns: constraint.Columns, RootPageID: tree.RootPageID(), Unique: true,
+		})
+	}
+	return nil
+}
+
+// defaultConstraintName names a constraint after its table and columns:
+// books_pkey for a primary key, books_isbn_key for a unique key and
+// books_price_check for a check written after a column or
+// books_author_id_fkey for a foreign key, followed by a number when the name
+// is taken.
+func defaultConstraintName(table *catalog.Table, constraint *catalog.Constraint, column bool, taken func(string) bool) string {
+	name := strings.ToLower(table.Name)
+	switch constraint.Type {
+	case catalog.PrimaryKey:
+		name += "_pkey"
+	case catalog.Unique:
+		name += "_" + strings.Join(constraint.Columns, "_") + "_key"
+	case catalog.Check:
+		if column {
+			name += "_" + constraint.Columns[0]
+		}
+		name += "_check"
+	case catalog.ForeignKey:
+		name += "_" + strings.Join(constraint.Columns, "_") + "_fkey"
+	}
+	candidate := name
+	for i := 1; taken(candidate); i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	return candidate
+}
+
+// compileCheck compiles the condition of a CHECK constraint over the rows of
+// a table.
+func compileCheck(table *catalog.Table, expr parser.Expr) (evaluator, error) {
+	eval, typ, err := compileExpr(expr, tableScope(table, table.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if typ != types.TypeBoolean && typ != types.TypeNull {
+		return nil, fmt.Errorf("argument of CHECK must be type boolean, not type %s", typ)
+	}
+	return eval, nil
+}
+
+// tableCheck is a compiled CHECK constraint.
+type tableCheck struct {
+	name string
+	eval evaluator
+}
+
+// compileChecks compiles the CHECK constraints of a table.
+func compileChecks(table *catalog.Table) ([]tableCheck, error) {
+	var checks []tableCheck
+	for _, constraint := range table.Constraints {
+		if constraint.Type != catalog.Check {
+			continue
+		}
+		expr, err := parser.ParseExpr(constraint.Check)
+		if err != nil {
+			return nil, fmt.Errorf("check constraint %s: %w", constraint.Name, err)
+		}
+		eval, err := compileCheck(table, expr)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, tableCheck{name: constraint.Name, eval: eval})
+	}
+	return checks, nil
+}
+
+// checkRow fails with a ConstraintError when a row makes the condition of a
+// check false. A NULL condition passes.
+func checkRow(table *catalog.Table, checks []tableCheck, row Row) error {
+	for _, check := range checks {
+		value, err := check.eval(row)
+		if err != nil {
+			return err
+		}
+		if result, known, err := truth(value); err != nil {
+			return err
+		} else if known && !result {
+			return &ConstraintError{Type: catalog.Check, Table: table.Name, Constraint: check.name}
+		}
+	}
+	return nil
+}
+
+// checkUnique fails with a ConstraintError when the latest version of
+// another row than the one at self, if set, has the key of row in a unique
+// index of the table. Keys with a NULL never conflict.
+func (e *Executor) checkUnique(table *catalog.Table, row Row, self *storage.RID) error {
+	for _, def := range table.Indexes {
+		if !def.Unique {
+			continue
+		}
+		key := indexKey(table, def, row)
+		if hasNull(Row(key)) {
+			continue
+		}
+		iter, err := index.Open(e.bufferManager, def.RootPageID).Seek(key)
+		if err != nil {
+			return err
+		}
+		for {
+			entry, ok, err := iter.Next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if cmp, err := index.CompareKeys(entry.Key, key); err != nil {
+				return err
+			} else if cmp != 0 {
+				break
+			}
+			if self != nil && entry.RID == *self {
+				continue
+			}
+			if data, err := e.tableHeap(table).Get(entry.RID); err != nil {
+				return err
+			} else if !visible(nil, data) {
+				continue
+			}
+			constraintType := catalog.Unique
+			if constraint := table.Constraint(def.Name); constraint != nil {
+				constraintType = constraint.Type
+			}
+			return &ConstraintError{
+				Type: constraintType, Table: table.Name, Constraint: def.Name, Columns: def.Columns, Key: key.String(),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// DefaultMaxRecursion is how many times the recursive term of a WITH
+// RECURSIVE query may run by default.
+const DefaultMaxRecursion = 10000
+
+// cteSet holds the WITH queries in scope of a query, planned for the
+// statement being run.
+type cteSet map[*parser.CommonTableExpr]*cteTable
+
+// cteTable is a WITH query planned for a statement. Its rows are computed
+// when a reference first reads them and kept in a rowStore for the other
+// references, until the query with the WITH clause is closed.
+type cteTable struct {
+	def       *parser.CommonTableExpr
+	columns   []catalog.Column
+	estimate  planner.Estimate
+	anchor    *selectPlan
+	recursive *selectPlan
+	node      *explainNode
+	union     *explainNode
+
+	store *rowStore // nil until computed
+	// work holds the rows the recursive term reads: those the previous
+	// iteration found. recursing is set while the recursive term is
+	// planned, when references read work rather than store.
+	work      *rowStore
+	recursing bool
+}
+
+// planCTEs plans the queries of a WITH clause, adding them to those of the
+// enclosing queries in parent. It returns the WITH queries now in scope and
+// the new ones.
+func (e *Executor) planCTEs(ctx context.Context, with []*parser.CommonTableExpr, params []types.Value, x *explainer, outer *correlation, parent cteSet) (cteSet, []*cteTable, error) {
+	ctes := make(cteSet, len(parent)+len(with))
+	for def, t := range parent {
+		ctes[def] = t
+	}
+	own := make([]*cteTable, len(with))
+	for i, def := range with {
+		num := x.nextSubplan()
+		anchor, err := e.planSelect(ctx, def.Select, params, x, outer, ctes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(def.Columns) > len(anchor.columns) {
+			return nil, nil, fmt.Errorf("WITH query %s has %d columns available but %d columns specified", def.Name, len(anchor.columns), len(def.Columns))
+		}
+		t := &cteTable{def: def, columns: make([]catalog.Column, len(anchor.columns)), estimate: anchor.estimate, anchor: anchor}
+		copy(t.columns, anchor.columns)
+		for j, name := range def.Columns {
+			t.columns[j].Name = strings.ToLower(name)
+		}
+		node := anchor.node
+		if def.Recursive != nil {
+			ctes[def], t.recursing = t, true
+			t.recursive, err = e.planSelect(ctx, def.Recursive, params, x, outer, ctes)
+			t.recursing = false
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := t.checkRecursive(); err != nil {
+				return nil, nil, err
+			}
+			// The recursive term is assumed to run ten times.
+			t.estimate.Rows += 10 * t.recursive.estimate.Rows
+			t.estimate.Cost += 10 * t.recursive.estimate.Cost
+			union := t.estimate
+			t.union = x.node("Recursive Union", &union, anchor.node, t.recursive.node)
+			node = t.union
+		}
+		t.node = x.subplan(num, "CTE "+strings.ToLower(def.Name), node)
+		ctes[def] = t
+		own[i] = t
+	}
+	return ctes, own, nil
+}
+
+// checkRecursive checks that the rows of the recursive term fit the columns
+// the non-recursive term determines.
+func (t *cteTable) checkRecursive() error {
+	if len(t.recursive.columns) != len(t.columns) {
+		return fmt.Errorf("each UNION query of %s must have the same number of columns", t.def.Name)
+	}
+	for i, col := range t.recursive.columns {
+		if !types.CanCast(col.Type, t.columns[i].Type) {
+			return fmt.Errorf("recursive query %s column %d has type %s in non-recursive term but type %s overall",
+				t.def.Name, i+1, t.columns[i].Type, col.Type)
+		}
+		t.columns[i].NotNull = t.columns[i].NotNull && col.NotNull
+	}
+	return nil
+}
+
+// reference plans a reference to the WITH query in a FROM clause. In the
+// recursive term of the query itself, it reads the working table.
+func (e *Executor) reference(ctx context.Context, t *cteTable, x *explainer) *planner.Subquery {
+	plan := &selectPlan{columns: t.columns, estimate: t.estimate, scan: "CTE Scan"}
+	if t.recursing {
+		plan.scan, plan.estimate = "WorkTable Scan", t.anchor.estimate
+		plan.open = func() (RowIterator, error) {
+			return t.work.scan(), nil
+		}
+	} else {
+		plan.open = func() (RowIterator, error) {
+			if err := e.materialize(ctx, t, x); err != nil {
+				return nil, err
+			}
+			return t.store.scan(), nil
+		}
+	}
+	return &planner.Subquery{Estimate: plan.estimate, Columns: plan.columns, Plan: plan}
+}
+
+// materialize computes the rows of a WITH query unless it already has.
+func (e *Executor) materialize(ctx context.Context, t *cteTable, x *explainer) error {
+	if t.store != nil {
+		return nil
+	}
+	var rows RowIterator
+	if t.recursive == nil {
+		var err error
+		if rows, err = t.anchor.open(); err != nil {
+			return err
+		}
+	} else {
+		union := &recursiveUnion{
+			ctx: ctx, bp: e.bufferManager, workMem: e.workMem, table: t,
+			selfRef: parser.References(t.def.Recursive, t.def), maxIterations: e.maxRecursion,
+		}
+		if !t.def.UnionAll {
+			union.seen = make(map[string]bool)
+		}
+		rows = x.wrap(t.union, union)
+	}
+	rows = x.wrap(t.node, rows)
+	store := newRowStore(ctx, e.bufferManager, e.workMem)
+	for {
+		row, err := rows.Next()
+		if err == nil && row != nil {
+			err = store.add(row)
+		}
+		if err != nil {
+			rows.Close()
+			store.free()
+			return err
+		}
+		if row == nil {
+			break
+		}
+	}
+	if err := rows.Close(); err != nil {
+		store.free()
+		return err
+	}
+	t.store = store
+	return nil
+}
+
+// free drops the rows computed for the WITH query.
+func (t *cteTable) free() error {
+	if t.store == nil {
+		return nil
+	}
+	err := t.store.free()
+	t.store = nil
+	return err
+}
+
+// recursiveUnion produces the rows of a WITH RECURSIVE query. It returns the
+// rows of the non-recursive term, then runs the recursive term over the rows
+// found last, the working table, for as long as it finds new rows. Unless
+// the query uses UNION ALL, rows already found are dropped, which ends
+// queries over cyclic data.
+type recursiveUnion struct {
+	ctx           context.Context
+	bp            *storage.BufferPool
+	workMem       int
+	table         *cteTable
+	seen          map[string]bool // nil for UNION ALL
+	selfRef       bool            // whether the recursive term reads the working table
+	maxIterations int
+
+	started    bool
+	done       bool
+	current    RowIterator
+	next       *rowStore // rows found by the current iteration
+	iterations int
+}
+
+func (u *recursiveUnion) Next() (Row, error) {
+	t := u.table
+	for {
+		if err := u.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if u.done {
+			return nil, nil
+		}
+		if u.current == nil {
+			if err := u.advance(); err != nil || u.current == nil {
+				return nil, err
+			}
+		}
+		row, err := u.current.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			err := u.current.Close()
+			u.current = nil
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		row, err = castRow(row, t.columns)
+		if err != nil {
+			return nil, err
+		}
+		if u.seen != nil {
+			key := hashJoinKey(row)
+			if u.seen[key] {
+				continue
+			}
+			u.seen[key] = true
+		}
+		if err := u.next.add(row); err != nil {
+			return nil, err
+		}
+		return row, nil
+	}
+}
+
+// advance starts the next term: the non-recursive one first, then the
+// recursive one over the rows the last one found. It leaves current nil
+// when no rows were found.
+func (u *recursiveUnion) advance() error {
+	t := u.table
+	open := t.anchor.open
+	if u.started {
+		if err := u.freeWork(); err != nil {
+			return err
+		}
+		t.work, u.next = u.next, nil
+		if t.work.len() == 0 || !u.selfRef && u.iterations == 1 {
+			u.done = true
+			return nil
+		}
+		if u.iterations == u.maxIterations {
+			return fmt.Errorf("recursive query %s exceeded %d iterations", t.def.Name, u.maxIterations)
+		}
+		u.iterations++
+		open = t.recursive.open
+	}
+	u.started = true
+	u.next = newRowStore(u.ctx, u.bp, u.workMem)
+	rows, err := open()
+	if err != nil {
+		return err
+	}
+	u.current = rows
+	return nil
+}
+
+func (u *recursiveUnion) freeWork() error {
+	if u.table.work == nil {
+		return nil
+	}
+	err := u.table.work.free()
+	u.table.work = nil
+	return err
+}
+
+func (u *recursiveUnion) Close() error {
+	var err error
+	if u.current != nil {
+		err = u.current.Close()
+		u.current = nil
+	}
+	if freeErr := u.freeWork(); freeErr != nil && err == nil {
+		err = freeErr
+	}
+	if u.next != nil {
+		if freeErr := u.next.free(); freeErr != nil && err == nil {
+			err = freeErr
+		}
+		u.next = nil
+	}
+	return err
+}
+
+// castRow converts the values of a row to the types of columns.
+func castRow(row Row, columns []catalog.Column) (Row, error) {
+	for i, value := range row {
+		if value.IsNull() || value.Type == columns[i].Type {
+			continue
+		}
+		converted, err := value.Cast(columns[i].Type)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", columns[i].Name, err)
+		}
+		row[i] = converted
+	}
+	return row, nil
+}
+
+// cteCleanup drops the rows computed for the WITH queries of a query once
+// it is closed.
+type cteCleanup struct {
+	RowIterator
+	ctes []*cteTable
+}
+
+func (c *cteCleanup) Close() error {
+	err := c.RowIterator.Close()
+	for _, t := range c.ctes {
+		if freeErr := t.free(); freeErr != nil && err == nil {
+			err = freeErr
+		}
+	}
+	return err
+}
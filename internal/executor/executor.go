@@ -1,65 +1,522 @@
 package executor
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
 	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
 	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
 )
 
 // Executor is responsible for executing SQL statements.
 type Executor struct {
 	bufferManager *storage.BufferPool
+	catalog       *catalog.Catalog
+	heaps         map[string]*storage.TableHeap
+	workMem       int
+	joinMethod    JoinMethod
+	functions     *Functions
+	maxRecursion  int
 }
 
 // NewExecutor creates a new Executor.
-func NewExecutor(bufferManager *storage.BufferPool) *Executor {
+func NewExecutor(bufferManager *storage.BufferPool, catalog *catalog.Catalog) *Executor {
 	return &Executor{
 		bufferManager: bufferManager,
+		catalog:       catalog,
+		heaps:         make(map[string]*storage.TableHeap),
+		workMem:       DefaultWorkMem,
+		functions:     newFunctions(),
+		maxRecursion:  DefaultMaxRecursion,
+	}
+}
+
+// SetWorkMem sets the memory budget, in bytes, of sorts before they spill to
+// temporary pages. Values below one page are raised to one page.
+func (e *Executor) SetWorkMem(bytes int) {
+	if bytes < storage.PageSize {
+		bytes = storage.PageSize
+	}
+	e.workMem = bytes
+}
+
+// SetMaxRecursion sets how many times the recursive term of a WITH RECURSIVE
+// query may run before the statement fails, which stops queries that would
+// otherwise cycle forever.
+func (e *Executor) SetMaxRecursion(iterations int) {
+	e.maxRecursion = iterations
+}
+
+// Functions returns the registry of user-defined functions. Statements
+// calling them must be parsed with it as their parser.FunctionResolver.
+func (e *Executor) Functions() *Functions {
+	return e.functions
+}
+
+// Catalog returns the catalog the executor resolves tables against.
+func (e *Executor) Catalog() *catalog.Catalog {
+	return e.catalog
+}
+
+// Execute runs a parsed statement on behalf of a transaction, which must
+// already hold the locks listed by LockRequests. params holds the values bound
+// to the statement's parameters. Rows are read through the transaction's
+// snapshot, or as they are latest without one. Changes are recorded in the
+// transaction's undo log. Queries return a lazy row iterator in the result; the caller must
+// close it.
+func (e *Executor) Execute(ctx context.Context, t *txn.Transaction, stmt *parser.Statement, params []types.Value) (*Result, error) {
+	if t.ReadOnly && !IsReadOnly(stmt) {
+		return nil, txn.ErrReadOnly
+	}
+	if len(params) != stmt.NumParams {
+		return nil, fmt.Errorf("expected %d parameters, got %d", stmt.NumParams, len(params))
+	}
+	ctx = withSnapshot(ctx, t.Snapshot())
+	switch stmt.StatementType {
+	case parser.StatementSelect:
+		return e.ExecuteSelectStatement(ctx, stmt.SelectStmt, params)
+	case parser.StatementInsert:
+		return e.ExecuteInsertStatement(ctx, t, stmt.InsertStmt, params)
+	case parser.StatementUpdate:
+		return e.ExecuteUpdateStatement(ctx, t, stmt.UpdateStmt, params)
+	case parser.StatementDelete:
+		return e.ExecuteDeleteStatement(ctx, t, stmt.DeleteStmt, params)
+	case parser.StatementCreateTable:
+		return e.ExecuteCreateTableStatement(t, stmt.CreateStmt)
+	case parser.StatementDropTable:
+		return e.ExecuteDropTableStatement(t, stmt.DropStmt)
+	case parser.StatementCreateIndex:
+		return e.ExecuteCreateIndexStatement(ctx, t, stmt.CreateIdxStmt)
+	case parser.StatementDropIndex:
+		return e.ExecuteDropIndexStatement(t, stmt.DropIdxStmt)
+	case parser.StatementAnalyze:
+		return e.ExecuteAnalyzeStatement(ctx, stmt.AnalyzeStmt)
+	case parser.StatementExplain:
+		return e.ExecuteExplainStatement(ctx, t, stmt.ExplainStmt, params)
+	case parser.StatementSetConstraints:
+		return e.ExecuteSetConstraintsStatement(t, stmt.SetConsStmt)
+	case parser.StatementAlterTable:
+		return e.ExecuteAlterTableStatement(ctx, t, stmt.AlterStmt)
+	case parser.StatementCreateSequence:
+		return e.ExecuteCreateSequenceStatement(t, stmt.CreateSeqStmt)
+	case parser.StatementDropSequence:
+		return e.ExecuteDropSequenceStatement(t, stmt.DropSeqStmt)
+	case parser.StatementVacuum:
+		return e.ExecuteVacuumStatement(ctx, t, stmt.VacuumStmt)
+	default:
+		return nil, fmt.Errorf("unsupported statement type %d", stmt.StatementType)
+	}
+}
+
+// tableHeap returns the heap holding the records of a table.
+func (e *Executor) tableHeap(table *catalog.Table) *storage.TableHeap {
+	key := strings.ToLower(table.Name)
+	heap, ok := e.heaps[key]
+	if !ok || heap.FirstPageID != table.FirstPageID {
+		heap = storage.OpenTableHeap(e.bufferManager, table.FirstPageID)
+		e.heaps[key] = heap
+	}
+	return heap
+}
+
+// ExecuteCreateTableStatement allocates the table's first page, creates the
+// indexes of its keys and registers it in the catalog.
+func (e *Executor) ExecuteCreateTableStatement(t *txn.Transaction, createStmt *parser.CreateTableStatement) (*Result, error) {
+	if _, err := e.catalog.GetTable(createStmt.TableName); err == nil {
+		return nil, fmt.Errorf("table %s already exists", createStmt.TableName)
+	}
+	table := &catalog.Table{Name: createStmt.TableName}
+	for _, def := range createStmt.Columns {
+		if err := e.defineColumn(t, table, def); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.defineConstraints(table, createStmt.Constraints); err != nil {
+		return nil, err
+	}
+	if err := checkAutoIncrement(table); err != nil {
+		return nil, err
+	}
+	heap, err := storage.CreateTableHeap(e.bufferManager)
+	if err != nil {
+		return nil, err
+	}
+	table.FirstPageID = heap.FirstPageID
+	if err := e.catalog.CreateTable(table); err != nil {
+		return nil, err
 	}
+	e.heaps[strings.ToLower(table.Name)] = heap
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoCreateTable, Table: table.Name})
+	return &Result{}, nil
+}
+
+// ExecuteDropTableStatement removes a table from the catalog.
+func (e *Executor) ExecuteDropTableStatement(t *txn.Transaction, dropStmt *parser.DropTableStatement) (*Result, error) {
+	table, err := e.catalog.GetTable(dropStmt.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.ExecuteDropTable(table.Name); err != nil {
+		return nil, err
+	}
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoDropTable, Table: table.Name, TableDef: table})
+	// The sequences of SERIAL and identity columns go with their table.
+	for _, col := range table.Columns {
+		if col.Sequence != "" {
+			if err := e.dropSequence(t, col.Sequence); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Result{}, nil
+}
+
+// ExecuteDropTable removes a table from the catalog and forgets its heap.
+func (e *Executor) ExecuteDropTable(name string) error {
+	if err := e.catalog.DropTable(name); err != nil {
+		return err
+	}
+	delete(e.heaps, strings.ToLower(name))
+	return nil
+}
+
+// coerceValue converts a value to the column's type and enforces NOT NULL.
+func coerceValue(table *catalog.Table, col catalog.Column, value types.Value) (types.Value, error) {
+	if value.IsNull() {
+		if col.NotNull {
+			return value, &ConstraintError{Type: catalog.NotNull, Table: table.Name, Column: col.Name}
+		}
+		return value, nil
+	}
+	converted, err := value.Cast(col.Type)
+	if err != nil {
+		return value, fmt.Errorf("column %s: %w", col.Name, err)
+	}
+	return converted, nil
+}
+
+// compileAssignment compiles an expression whose value is stored in a
+// column, checking that its type converts to the column's.
+func compileAssignment(table *catalog.Table, col catalog.Column, expr parser.Expr, sc *scope, params []types.Value) (evaluator, error) {
+	eval, typ, err := compileExpr(expr, sc, params)
+	if err != nil {
+		return nil, err
+	}
+	if !types.CanCast(typ, col.Type) {
+		return nil, fmt.Errorf("column %s of table %s is of type %s but expression is of type %s", col.Name, table.Name, col.Type, typ)
+	}
+	return eval, nil
 }
 
 // ExecuteInsertStatement takes an InsertStatement and writes it to the appropriate pages.
-func (e *Executor) ExecuteInsertStatement(insertStmt *parser.InsertStatement) error {
-	// TODO: Table and schema lookup should be performed here.
-	// TODO: Constraint checks should be performed here.
+func (e *Executor) ExecuteInsertStatement(ctx context.Context, t *txn.Transaction, insertStmt *parser.InsertStatement, params []types.Value) (*Result, error) {
+	res, _, err := e.executeInsert(ctx, t, insertStmt, params, nil)
+	return res, err
+}
 
-	for _, values := range insertStmt.Values {
-		record := storage.NewRecord()
-		for _, value := range values {
-			// Assuming value is a string, we convert it to a byte slice.
-			// If it's not, you'll need to convert it accordingly.
-			record.AddField([]byte(string(value)))
+// executeInsert runs an INSERT, or only describes it when x explains it
+// without ANALYZE.
+func (e *Executor) executeInsert(ctx context.Context, t *txn.Transaction, insertStmt *parser.InsertStatement, params []types.Value, x *explainer) (*Result, *explainNode, error) {
+	table, err := e.catalog.GetTable(insertStmt.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes := make([]int, len(insertStmt.Columns))
+	given := make([]bool, len(table.Columns))
+	for i, name := range insertStmt.Columns {
+		idx := table.ColumnIndex(name)
+		if idx == -1 {
+			return nil, nil, fmt.Errorf("column %s does not exist in table %s", name, table.Name)
+		}
+		if given[idx] {
+			return nil, nil, fmt.Errorf("column %s specified more than once", name)
+		}
+		if table.Columns[idx].Identity == "ALWAYS" {
+			return nil, nil, fmt.Errorf("cannot insert a non-DEFAULT value into column %s", name)
+		}
+		indexes[i] = idx
+		given[idx] = true
+	}
+	sc := e.withEnv(&scope{}, ctx, x, nil, nil)
+	defaults, err := compileDefaults(table, sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	checks, err := compileChecks(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := make([][]evaluator, len(insertStmt.Values))
+	for r, values := range insertStmt.Values {
+		rows[r] = make([]evaluator, len(values))
+		for i, value := range values {
+			if rows[r][i], err = compileAssignment(table, table.Columns[indexes[i]], value, sc, params); err != nil {
+				return nil, nil, err
+			}
 		}
+	}
+	node := modifyNode(x, "Insert", table, planner.Estimate{Rows: float64(len(rows))})
+	if x.planOnly() {
+		return nil, node, nil
+	}
 
-		// Serialize the record for storage.
-		recordData := record.Serialize()
+	// Every row is computed before any is written, so that subqueries in
+	// the values don't see the rows being inserted.
+	records := make([]Row, len(rows))
+	for r, values := range rows {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		row := make(Row, len(table.Columns))
+		for i, value := range values {
+			if row[indexes[i]], err = value(nil); err != nil {
+				return nil, nil, err
+			}
+		}
+		// Columns left out of the statement take their default, or NULL.
+		// They come after the values, so that a default may read what
+		// nextval returned in them through currval.
+		for i := range row {
+			if given[i] {
+				continue
+			}
+			row[i] = types.Null()
+			if defaults[i] == nil {
+				continue
+			}
+			if row[i], err = defaults[i](nil); err != nil {
+				return nil, nil, err
+			}
+		}
+		for i, col := range table.Columns {
+			if row[i], err = coerceValue(table, col, row[i]); err != nil {
+				return nil, nil, err
+			}
+			// An explicit AUTOINCREMENT value moves its sequence past it,
+			// so that later generated values don't collide with it.
+			if col.AutoIncrement && given[i] {
+				if err := e.catalog.AdvanceSequence(col.Sequence, row[i].Int); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		if err := checkRow(table, checks, row); err != nil {
+			return nil, nil, err
+		}
+		records[r] = row
+	}
 
-		// TODO: Find the right page to insert the record.
-		// This could be a new page or an existing one with enough space.
-		pageID := e.findPageForRecord(recordData)
-		page, err := e.bufferManager.FetchPage(pageID)
+	heap := e.tableHeap(table)
+	seqCol := sequenceColumn(table)
+	result := &Result{}
+	m := newModification(t)
+	for _, row := range records {
+		// Keys are checked as each row is written, so that rows of the same
+		// statement conflict with each other too.
+		if err := e.checkUnique(table, row, nil); err != nil {
+			return nil, nil, err
+		}
+		// Serialize the record for storage and append it to the table heap.
+		rid, err := heap.Insert(storage.NewVersion(t.ID, t.Command(), encodeRecord(table, row)))
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-
-		// Add the record to the page.
-		if _, err := page.AddRecord(recordData); err != nil {
-			return err
+		if err := e.insertIndexEntries(table, row, rid); err != nil {
+			return nil, nil, err
+		}
+		t.AddUndo(txn.UndoRecord{Kind: txn.UndoInsert, Table: table.Name, RID: rid})
+		e.rowInserted(m, table, row)
+		result.RowsAffected++
+		result.LastInsertID = rid.Int64()
+		if seqCol >= 0 {
+			result.LastInsertID = row[seqCol].Int
 		}
+	}
+	// Foreign keys are checked once every row is in, so that rows may refer
+	// to others of the same statement.
+	if err := e.finishModification(m); err != nil {
+		return nil, nil, err
+	}
+	return result, node, nil
+}
 
-		// Assume bufferPage is the in-memory representation of the page.
-		bufferPage, err := e.bufferManager.GetBufferPage(pageID)
+// matchingRows returns a source of the rows of a table that match a WHERE
+// clause, read through the access path the planner chooses, its plan node
+// and the planner's estimate of it.
+func (e *Executor) matchingRows(ctx context.Context, table *catalog.Table, where parser.Expr, params []types.Value, x *explainer) (rowSource, *explainNode, planner.Estimate, error) {
+	plan, err := e.planFrom(table.Name, where, params)
+	if err != nil {
+		return nil, nil, planner.Estimate{}, err
+	}
+	source, _, node, err := e.buildPlan(ctx, plan, params, x, nil, nil)
+	return source, node, plan.Estimated(), err
+}
+
+// modifyNode returns the plan node of an operator writing the rows of input
+// to table, read by child, if any.
+func modifyNode(x *explainer, typ string, table *catalog.Table, input planner.Estimate, children ...*explainNode) *explainNode {
+	estimate := planner.ModifyEstimate(input, len(table.Indexes))
+	node := x.node(typ, &estimate, children...)
+	if node != nil {
+		node.relation = table.Name
+	}
+	return node
+}
+
+// collectMatches materializes the rows of a source with their record IDs, so
+// that modifications don't disturb the scan that finds them.
+func collectMatches(source rowSource) ([]storage.RID, []Row, error) {
+	rows, err := source()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	scan, ok := rows.(ridIterator)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot locate the rows of %T", rows)
+	}
+	var rids []storage.RID
+	var matches []Row
+	for {
+		row, err := scan.Next()
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		if row == nil {
+			return rids, matches, nil
 		}
-		bufferPage.IsDirty = true
+		rids = append(rids, scan.RID())
+		matches = append(matches, row)
 	}
+}
 
-	// TODO: Flushing to disk will be handled by the buffer manager.
-	return nil
+// ExecuteUpdateStatement rewrites every row matching the WHERE clause.
+func (e *Executor) ExecuteUpdateStatement(ctx context.Context, t *txn.Transaction, updateStmt *parser.UpdateStatement, params []types.Value) (*Result, error) {
+	res, _, err := e.executeUpdate(ctx, t, updateStmt, params, nil)
+	return res, err
 }
 
-func (e *Executor) findPageForRecord(recordData []byte) int64 {
-	// Placeholder: Implement logic to find the appropriate page ID for the new record.
-	// This might involve checking for pages with enough free space or allocating a new page.
-	return 0 // Example page ID
+// executeUpdate runs an UPDATE, or only describes it when x explains it
+// without ANALYZE.
+func (e *Executor) executeUpdate(ctx context.Context, t *txn.Transaction, updateStmt *parser.UpdateStatement, params []types.Value, x *explainer) (*Result, *explainNode, error) {
+	table, err := e.catalog.GetTable(updateStmt.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes := make([]int, len(updateStmt.Assignments))
+	exprs := make([]evaluator, len(updateStmt.Assignments))
+	sc := e.withEnv(tableScope(table, table.Name), ctx, x, nil, nil)
+	for i, assignment := range updateStmt.Assignments {
+		idx := table.ColumnIndex(assignment.Column)
+		if idx == -1 {
+			return nil, nil, fmt.Errorf("column %s does not exist in table %s", assignment.Column, table.Name)
+		}
+		if table.Columns[idx].Identity == "ALWAYS" {
+			return nil, nil, fmt.Errorf("column %s can only be updated to DEFAULT", assignment.Column)
+		}
+		indexes[i] = idx
+		if exprs[i], err = compileAssignment(table, table.Columns[idx], assignment.Value, sc, params); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	source, child, estimate, err := e.matchingRows(ctx, table, updateStmt.Where, params, x)
+	if err != nil {
+		return nil, nil, err
+	}
+	node := modifyNode(x, "Update", table, estimate, child)
+	if x.planOnly() {
+		return nil, node, nil
+	}
+	rids, rows, err := collectMatches(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The new values are computed before any row is written: every
+	// assignment, and every subquery, sees the table as it was before the
+	// update.
+	updates := make([][]types.Value, len(rows))
+	for i, row := range rows {
+		values := make([]types.Value, len(exprs))
+		for j, expr := range exprs {
+			value, err := expr(row)
+			if err != nil {
+				return nil, nil, err
+			}
+			if values[j], err = coerceValue(table, table.Columns[indexes[j]], value); err != nil {
+				return nil, nil, err
+			}
+		}
+		updates[i] = values
+	}
+	m := newModification(t)
+	for i, row := range rows {
+		if _, _, changed, exists := m.current(table, rids[i], row); !exists {
+			continue
+		} else if changed {
+			return nil, nil, fmt.Errorf("row to be updated was already modified by a referential action of the same statement")
+		}
+		updated := append(Row(nil), row...)
+		for j, idx := range indexes {
+			updated[idx] = updates[i][j]
+		}
+		if _, err := e.updateRow(m, table, rids[i], row, updated); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := e.finishModification(m); err != nil {
+		return nil, nil, err
+	}
+	return &Result{RowsAffected: int64(len(rows))}, node, nil
+}
+
+// ExecuteDeleteStatement removes every row matching the WHERE clause.
+func (e *Executor) ExecuteDeleteStatement(ctx context.Context, t *txn.Transaction, deleteStmt *parser.DeleteStatement, params []types.Value) (*Result, error) {
+	res, _, err := e.executeDelete(ctx, t, deleteStmt, params, nil)
+	return res, err
+}
+
+// executeDelete runs a DELETE, or only describes it when x explains it
+// without ANALYZE.
+func (e *Executor) executeDelete(ctx context.Context, t *txn.Transaction, deleteStmt *parser.DeleteStatement, params []types.Value, x *explainer) (*Result, *explainNode, error) {
+	table, err := e.catalog.GetTable(deleteStmt.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, child, estimate, err := e.matchingRows(ctx, table, deleteStmt.Where, params, x)
+	if err != nil {
+		return nil, nil, err
+	}
+	node := modifyNode(x, "Delete", table, estimate, child)
+	if x.planOnly() {
+		return nil, node, nil
+	}
+	rids, rows, err := collectMatches(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := newModification(t)
+	var deleted int64
+	for i, rid := range rids {
+		// In a table referring to itself, a row may already be gone with
+		// the row it referenced, or have been updated by a referential
+		// action.
+		rid, row, _, exists := m.current(table, rid, rows[i])
+		if !exists {
+			continue
+		}
+		if err := e.deleteRow(m, table, rid, row); err != nil {
+			return nil, nil, err
+		}
+		deleted++
+	}
+	if err := e.finishModification(m); err != nil {
+		return nil, nil, err
+	}
+	return &Result{RowsAffected: deleted}, node, nil
 }
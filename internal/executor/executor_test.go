@@ -0,0 +1,153 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// testDB runs statements straight on an executor, each in a transaction of
+// its own.
+type testDB struct {
+	t    *testing.T
+	bp   *storage.BufferPool
+	exec *Executor
+	txns *txn.Manager
+}
+
+func newTestDB(t *testing.T) *testDB {
+	t.Helper()
+	bp, err := storage.NewBufferPool(filepath.Join(t.TempDir(), "test.db"), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bp.Close() })
+	cat, err := catalog.Load(bp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testDB{t: t, bp: bp, exec: NewExecutor(bp, cat), txns: txn.NewManager(time.Second, cat.ReserveTxnIDs)}
+}
+
+// run executes a statement and returns its rows, as Go values.
+func (db *testDB) run(query string, args ...interface{}) [][]interface{} {
+	db.t.Helper()
+	stmt, err := parser.ParseWith(query, db.exec.Functions())
+	if err != nil {
+		db.t.Fatalf("%s: %v", query, err)
+	}
+	p := NewPreparedStatement(stmt)
+	params, err := db.exec.Bind(p, args)
+	if err != nil {
+		db.t.Fatalf("%s: %v", query, err)
+	}
+	t, err := db.txns.Begin(false, txn.ReadCommitted)
+	if err != nil {
+		db.t.Fatal(err)
+	}
+	defer db.txns.Finish(t, txn.StateCommitted)
+	if _, err := db.txns.TakeSnapshot(t); err != nil {
+		db.t.Fatal(err)
+	}
+	res, err := db.exec.Execute(context.Background(), t, p.Statement, params)
+	if err != nil {
+		db.t.Fatalf("%s: %v", query, err)
+	}
+	if res.Rows == nil {
+		return nil
+	}
+	defer res.Rows.Close()
+	var rows [][]interface{}
+	for {
+		row, err := res.Rows.Next()
+		if err != nil {
+			db.t.Fatalf("%s: %v", query, err)
+		}
+		if row == nil {
+			return rows
+		}
+		values := make([]interface{}, len(row))
+		for i, value := range row {
+			values[i] = value.Interface()
+		}
+		rows = append(rows, values)
+	}
+}
+
+// explain returns the text plan of a query.
+func (db *testDB) explain(query string) string {
+	db.t.Helper()
+	var lines []string
+	for _, row := range db.run("EXPLAIN " + query) {
+		lines = append(lines, row[0].(string))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkSpill runs a query whose plan has operator once with the default
+// memory budget and once with the smallest one, and checks that only the
+// second run spills to temporary pages, that both return the same rows and
+// that the temporary pages are freed once the rows are read. Rows are
+// compared in order only when the query has an ORDER BY.
+func (db *testDB) checkSpill(query, operator string) {
+	db.t.Helper()
+	if plan := db.explain(query); !strings.Contains(plan, operator) {
+		db.t.Fatalf("plan has no %s:\n%s", operator, plan)
+	}
+	pages := db.bp.NumTempPages()
+	want := db.run(query)
+	if len(want) == 0 {
+		db.t.Fatal("query returned no rows")
+	}
+	if spilled := db.bp.NumTempPages() - pages; spilled != 0 {
+		db.t.Fatalf("spilled %d pages within the default memory budget", spilled)
+	}
+	db.exec.SetWorkMem(0)
+	defer db.exec.SetWorkMem(DefaultWorkMem)
+	got := db.run(query)
+	if db.bp.NumTempPages() == pages {
+		db.t.Fatal("did not spill with the smallest memory budget")
+	}
+	if inUse := db.bp.TempPagesInUse(); inUse != 0 {
+		db.t.Fatalf("%d temporary pages are still in use", inUse)
+	}
+	if !strings.Contains(query, "ORDER BY") {
+		sortRows(got)
+		sortRows(want)
+	}
+	if !reflect.DeepEqual(got, want) {
+		db.t.Fatalf("got %d rows after spilling, want %d", len(got), len(want))
+	}
+}
+
+// sortRows puts rows in the order of their text.
+func sortRows(rows [][]interface{}) {
+	sort.Slice(rows, func(i, j int) bool {
+		return fmt.Sprint(rows[i]) < fmt.Sprint(rows[j])
+	})
+}
+
+// fillSpillTables creates the tables the spill tests read: a with 2000 rows
+// of 100 groups and b with a row for each of a's groups.
+func (db *testDB) fillSpillTables() {
+	db.t.Helper()
+	db.run("CREATE TABLE a (id INTEGER PRIMARY KEY, g INTEGER, pad TEXT)")
+	db.run("CREATE TABLE b (g INTEGER, name TEXT)")
+	pad := strings.Repeat("x", 100)
+	for i := 0; i < 2000; i++ {
+		db.run("INSERT INTO a (id, g, pad) VALUES (?, ?, ?)", i, (i*37)%100, pad)
+	}
+	for g := 0; g < 100; g++ {
+		db.run("INSERT INTO b (g, name) VALUES (?, ?)", g, strings.Repeat("y", 50)+string(rune('a'+g%26)))
+	}
+}
@@ -0,0 +1,486 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// explainNode is an operator of a query plan as EXPLAIN shows it.
+type explainNode struct {
+	typ      string // Operator name, such as "Seq Scan" or "Hash Join"
+	joinType parser.JoinType
+	join     bool
+	relation string
+	alias    string
+	index    string
+	props    []explainProp
+	estimate *planner.Estimate // nil for the headings of subplans, which aren't operators
+	children []*explainNode
+	stats    operatorStats
+}
+
+// explainProp is a detail of an operator, such as its filter condition.
+type explainProp struct {
+	key   string
+	value string
+}
+
+// operatorStats is what EXPLAIN ANALYZE measures of an operator. Time and
+// buffer accesses include those of the operator's inputs.
+type operatorStats struct {
+	rows    int64
+	loops   int64
+	elapsed time.Duration
+	buffers storage.BufferStats
+}
+
+// explainer collects the operators of a statement being explained. Builders
+// take a nil *explainer when the statement runs normally: then no nodes are
+// made and nothing is instrumented.
+type explainer struct {
+	bp      *storage.BufferPool
+	analyze bool
+	// subplans are the plans of the statement's subqueries in expressions
+	// and WITH queries, shown below the plan of the statement, in the order
+	// they appear.
+	subplans []*explainNode
+}
+
+// node returns a plan node over children, or nil when not explaining.
+func (x *explainer) node(typ string, estimate *planner.Estimate, children ...*explainNode) *explainNode {
+	if x == nil {
+		return nil
+	}
+	return &explainNode{typ: typ, estimate: estimate, children: children}
+}
+
+// scanNode returns the node of an operator reading a relation.
+func (x *explainer) scanNode(typ string, rel *planner.Relation, estimate planner.Estimate) *explainNode {
+	node := x.node(typ, &estimate)
+	if node != nil {
+		node.relation = rel.Table.Name
+		node.alias = rel.Name
+	}
+	return node
+}
+
+// nextSubplan numbers the plan of a subquery about to be planned, so that
+// subqueries are numbered in the order they appear rather than the order
+// their planning completes.
+func (x *explainer) nextSubplan() int {
+	if x == nil {
+		return 0
+	}
+	x.subplans = append(x.subplans, nil)
+	return len(x.subplans)
+}
+
+// subplan returns the node numbered num heading the plan of a subquery in
+// an expression or of a WITH query.
+func (x *explainer) subplan(num int, title string, plan *explainNode) *explainNode {
+	if x == nil {
+		return nil
+	}
+	node := x.node(title, nil, plan)
+	x.subplans[num-1] = node
+	return node
+}
+
+// planOnly reports whether the statement is explained without running it.
+func (x *explainer) planOnly() bool {
+	return x != nil && !x.analyze
+}
+
+// track makes EXPLAIN ANALYZE measure the iterators a source opens.
+func (x *explainer) track(node *explainNode, source rowSource) rowSource {
+	if x == nil || !x.analyze {
+		return source
+	}
+	return func() (RowIterator, error) {
+		start, before := time.Now(), x.bp.Stats()
+		rows, err := source()
+		node.stats.loops++
+		node.record(start, before, x.bp)
+		if err != nil {
+			return nil, err
+		}
+		return &instrumentedIterator{child: rows, node: node, bp: x.bp}, nil
+	}
+}
+
+// wrap makes EXPLAIN ANALYZE measure an iterator.
+func (x *explainer) wrap(node *explainNode, rows RowIterator) RowIterator {
+	if x == nil || !x.analyze {
+		return rows
+	}
+	node.stats.loops++
+	return &instrumentedIterator{child: rows, node: node, bp: x.bp}
+}
+
+// prop adds a condition to the details of a node. Nil conditions are left out.
+func (n *explainNode) prop(key string, expr parser.Expr) {
+	if n != nil && expr != nil {
+		n.props = append(n.props, explainProp{key, expr.String()})
+	}
+}
+
+// record adds the time and buffer accesses since start to the node.
+func (n *explainNode) record(start time.Time, before storage.BufferStats, bp *storage.BufferPool) {
+	n.stats.elapsed += time.Since(start)
+	n.stats.buffers = n.stats.buffers.Add(bp.Stats().Sub(before))
+}
+
+// instrumentedIterator counts the rows, time and buffer accesses of the
+// iterator it wraps.
+type instrumentedIterator struct {
+	child RowIterator
+	node  *explainNode
+	bp    *storage.BufferPool
+}
+
+func (it *instrumentedIterator) Next() (Row, error) {
+	start, before := time.Now(), it.bp.Stats()
+	row, err := it.child.Next()
+	it.node.record(start, before, it.bp)
+	if row != nil {
+		it.node.stats.rows++
+	}
+	return row, err
+}
+
+// RID returns the record ID of the last row, when the child can tell.
+func (it *instrumentedIterator) RID() storage.RID {
+	return it.child.(ridIterator).RID()
+}
+
+func (it *instrumentedIterator) Close() error {
+	start, before := time.Now(), it.bp.Stats()
+	err := it.child.Close()
+	it.node.record(start, before, it.bp)
+	return err
+}
+
+// sortKeysString describes ORDER BY items for EXPLAIN.
+func sortKeysString(items []parser.OrderByItem) string {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Expr.String()
+		if item.Desc {
+			keys[i] += " DESC"
+		}
+		switch item.Nulls {
+		case parser.NullsFirst:
+			keys[i] += " NULLS FIRST"
+		case parser.NullsLast:
+			keys[i] += " NULLS LAST"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// keysCondition returns the equalities of join keys as one condition.
+func keysCondition(keys []planner.EquiKey) parser.Expr {
+	conds := make([]parser.Expr, len(keys))
+	for i, key := range keys {
+		conds[i] = &parser.BinaryExpr{Op: "=", Left: key.Left, Right: key.Right}
+	}
+	return parser.Conjoin(conds)
+}
+
+// withoutConds returns the conjuncts of cond that are not among those of
+// removed.
+func withoutConds(cond, removed parser.Expr) parser.Expr {
+	skip := make(map[parser.Expr]bool)
+	for _, c := range parser.Conjuncts(removed) {
+		skip[c] = true
+	}
+	var rest []parser.Expr
+	for _, c := range parser.Conjuncts(cond) {
+		if !skip[c] {
+			rest = append(rest, c)
+		}
+	}
+	return parser.Conjoin(rest)
+}
+
+// ExecuteExplainStatement describes the plan of a statement. With ANALYZE the
+// statement runs, so that data modifications take effect, its rows are
+// discarded and each operator reports what it actually did.
+func (e *Executor) ExecuteExplainStatement(ctx context.Context, t *txn.Transaction, explainStmt *parser.ExplainStatement, params []types.Value) (*Result, error) {
+	x := &explainer{bp: e.bufferManager, analyze: explainStmt.Analyze}
+	stmt := explainStmt.Statement
+	start, before := time.Now(), e.bufferManager.Stats()
+	var root *explainNode
+	var planning, execution time.Duration
+	switch stmt.StatementType {
+	case parser.StatementSelect:
+		res, node, err := e.buildSelect(ctx, stmt.SelectStmt, params, x)
+		if err != nil {
+			return nil, err
+		}
+		root = node
+		planning = time.Since(start)
+		if !x.analyze {
+			res.Rows.Close()
+			break
+		}
+		start = time.Now()
+		if err := drain(res.Rows); err != nil {
+			return nil, err
+		}
+		execution = time.Since(start)
+	case parser.StatementInsert, parser.StatementUpdate, parser.StatementDelete:
+		var res *Result
+		var err error
+		switch stmt.StatementType {
+		case parser.StatementInsert:
+			res, root, err = e.executeInsert(ctx, t, stmt.InsertStmt, params, x)
+		case parser.StatementUpdate:
+			res, root, err = e.executeUpdate(ctx, t, stmt.UpdateStmt, params, x)
+		default:
+			res, root, err = e.executeDelete(ctx, t, stmt.DeleteStmt, params, x)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !x.analyze {
+			planning = time.Since(start)
+			break
+		}
+		// Planning and execution interleave, so only the total is known.
+		execution = time.Since(start)
+		root.stats = operatorStats{
+			rows: res.RowsAffected, loops: 1, elapsed: execution,
+			buffers: e.bufferManager.Stats().Sub(before),
+		}
+	default:
+		return nil, fmt.Errorf("cannot explain statement type %d", stmt.StatementType)
+	}
+	root.children = append(root.children, x.subplans...)
+
+	var lines []string
+	if explainStmt.Format == parser.ExplainJSON {
+		doc, err := explainJSON(root, x.analyze, planning, execution)
+		if err != nil {
+			return nil, err
+		}
+		lines = []string{doc}
+	} else {
+		lines = explainText(root, x.analyze, planning, execution)
+	}
+	rows := make([]Row, len(lines))
+	for i, line := range lines {
+		rows[i] = Row{types.NewText(line)}
+	}
+	return &Result{
+		Columns: []catalog.Column{{Name: "QUERY PLAN", Type: types.TypeText}},
+		Rows:    &rowList{rows: rows},
+	}, nil
+}
+
+// drain reads an iterator to the end and closes it.
+func drain(rows RowIterator) error {
+	for {
+		row, err := rows.Next()
+		if err != nil || row == nil {
+			closeErr := rows.Close()
+			if err == nil {
+				err = closeErr
+			}
+			return err
+		}
+	}
+}
+
+// rowList returns rows held in memory.
+type rowList struct {
+	rows []Row
+	pos  int
+}
+
+func (l *rowList) Next() (Row, error) {
+	if l.pos >= len(l.rows) {
+		return nil, nil
+	}
+	l.pos++
+	return l.rows[l.pos-1], nil
+}
+
+func (l *rowList) Close() error {
+	return nil
+}
+
+// title is the heading of a node in the text format, such as
+// "Hash Left Join" or "Index Scan using idx on orders o".
+func (n *explainNode) title() string {
+	title := n.typ
+	if n.join && n.joinType != parser.JoinInner {
+		title = strings.TrimSuffix(title, " Join") + " " + joinTypeName(n.joinType) + " Join"
+	}
+	if n.index != "" {
+		title += " using " + n.index
+	}
+	if n.relation != "" {
+		title += " on " + n.relation
+		if n.alias != "" && !strings.EqualFold(n.alias, n.relation) {
+			title += " " + n.alias
+		}
+	}
+	return title
+}
+
+// joinTypeName returns a join type as EXPLAIN names it, such as "Left".
+func joinTypeName(t parser.JoinType) string {
+	name := t.String()
+	return name[:1] + strings.ToLower(name[1:])
+}
+
+// explainText renders a plan as an indented tree, one line per row of the
+// result, in the style of PostgreSQL.
+func explainText(root *explainNode, analyze bool, planning, execution time.Duration) []string {
+	var lines []string
+	var walk func(n *explainNode, depth int)
+	walk = func(n *explainNode, depth int) {
+		prefix := ""
+		if depth > 0 {
+			prefix = strings.Repeat(" ", 6*(depth-1)) + "  ->  "
+		}
+		line := prefix + n.title()
+		if n.estimate != nil {
+			line += fmt.Sprintf("  (cost=%.2f rows=%.0f)", n.estimate.Cost, n.estimate.Rows)
+		}
+		if analyze {
+			if n.stats.loops == 0 {
+				line += " (never executed)"
+			} else {
+				line += fmt.Sprintf(" (actual time=%.3f ms rows=%d loops=%d)",
+					milliseconds(n.stats.elapsed), n.stats.rows, n.stats.loops)
+			}
+		}
+		lines = append(lines, line)
+		indent := strings.Repeat(" ", len(prefix)+2)
+		for _, prop := range n.props {
+			lines = append(lines, indent+prop.key+": "+prop.value)
+		}
+		if analyze && n.stats.loops > 0 {
+			b := n.stats.buffers
+			lines = append(lines, fmt.Sprintf("%sBuffers: hit=%d miss=%d read=%d written=%d",
+				indent, b.Hits, b.Misses, b.Reads, b.Writes))
+		}
+		for _, child := range n.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(root, 0)
+	if planning > 0 {
+		lines = append(lines, fmt.Sprintf("Planning Time: %.3f ms", milliseconds(planning)))
+	}
+	if analyze {
+		lines = append(lines, fmt.Sprintf("Execution Time: %.3f ms", milliseconds(execution)))
+	}
+	return lines
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// jsonObject is a JSON object that keeps its fields in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// explainJSON renders a plan as a JSON document shaped like PostgreSQL's.
+func explainJSON(root *explainNode, analyze bool, planning, execution time.Duration) (string, error) {
+	var convert func(n *explainNode) jsonObject
+	convert = func(n *explainNode) jsonObject {
+		obj := jsonObject{{"Node Type", n.typ}}
+		if n.join {
+			obj = append(obj, jsonField{"Join Type", joinTypeName(n.joinType)})
+		}
+		if n.index != "" {
+			obj = append(obj, jsonField{"Index Name", n.index})
+		}
+		if n.relation != "" {
+			obj = append(obj, jsonField{"Relation Name", n.relation})
+			if n.alias != "" {
+				obj = append(obj, jsonField{"Alias", n.alias})
+			}
+		}
+		if n.estimate != nil {
+			obj = append(obj, jsonField{"Total Cost", n.estimate.Cost}, jsonField{"Plan Rows", n.estimate.Rows})
+		}
+		if analyze {
+			b := n.stats.buffers
+			obj = append(obj,
+				jsonField{"Actual Total Time", milliseconds(n.stats.elapsed)},
+				jsonField{"Actual Rows", n.stats.rows},
+				jsonField{"Actual Loops", n.stats.loops},
+				jsonField{"Buffer Hits", b.Hits},
+				jsonField{"Buffer Misses", b.Misses},
+				jsonField{"Buffer Reads", b.Reads},
+				jsonField{"Buffer Writes", b.Writes},
+			)
+		}
+		for _, prop := range n.props {
+			obj = append(obj, jsonField{prop.key, prop.value})
+		}
+		if len(n.children) > 0 {
+			plans := make([]jsonObject, len(n.children))
+			for i, child := range n.children {
+				plans[i] = convert(child)
+			}
+			obj = append(obj, jsonField{"Plans", plans})
+		}
+		return obj
+	}
+	doc := jsonObject{{"Plan", convert(root)}}
+	if planning > 0 {
+		doc = append(doc, jsonField{"Planning Time", milliseconds(planning)})
+	}
+	if analyze {
+		doc = append(doc, jsonField{"Execution Time", milliseconds(execution)})
+	}
+	out, err := json.MarshalIndent([]jsonObject{doc}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
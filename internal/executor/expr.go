@@ -0,0 +1,659 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// evaluator computes the value of an expression for a row.
+type evaluator func(row Row) (types.Value, error)
+
+// scope describes the layout of the rows an expression is evaluated on.
+type scope struct {
+	columns []catalog.Column
+	// tables holds the lower-cased name or alias of the table each column
+	// comes from, "" for computed columns.
+	tables []string
+	// computed maps the text of expressions already computed by an earlier
+	// operator, such as group keys and aggregates, to their position.
+	computed map[string]int
+	// keys is the scope the computed expressions were compiled in. Their
+	// column references are qualified against it before the lookup.
+	keys *scope
+	// grouped is set above an aggregation, where a column may only be used
+	// through a group key or an aggregate.
+	grouped bool
+	// env is what compiling subqueries needs, nil where they aren't allowed.
+	env *queryEnv
+}
+
+// tableScope is the scope of the rows of a table referred to as refName.
+func tableScope(table *catalog.Table, refName string) *scope {
+	sc := &scope{columns: table.Columns, tables: make([]string, len(table.Columns))}
+	for i := range sc.tables {
+		sc.tables[i] = strings.ToLower(refName)
+	}
+	return sc
+}
+
+// joinScopes is the scope of rows made of a left row followed by a right
+// row. Columns of a side padded with NULLs by an outer join become nullable.
+func joinScopes(left, right *scope, leftNullable, rightNullable bool) *scope {
+	sc := &scope{env: left.env}
+	for _, side := range []struct {
+		sc       *scope
+		nullable bool
+	}{{left, leftNullable}, {right, rightNullable}} {
+		for i, col := range side.sc.columns {
+			if side.nullable {
+				col.NotNull = false
+			}
+			sc.columns = append(sc.columns, col)
+			sc.tables = append(sc.tables, side.sc.tables[i])
+		}
+	}
+	return sc
+}
+
+// hasTable reports whether a table is referred to as name in the scope.
+func (sc *scope) hasTable(name string) bool {
+	for _, table := range sc.tables {
+		if table != "" && strings.EqualFold(table, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// owns reports whether a reference names a column or a table of the scope
+// rather than one of an enclosing query.
+func (sc *scope) owns(ref *parser.ColumnRef) bool {
+	if ref.Table != "" {
+		return sc.hasTable(ref.Table)
+	}
+	for _, col := range sc.columns {
+		if strings.EqualFold(col.Name, ref.Column) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the position of the column a reference names.
+func (sc *scope) resolve(ref *parser.ColumnRef) (int, error) {
+	idx := -1
+	for i, col := range sc.columns {
+		if !strings.EqualFold(col.Name, ref.Column) {
+			continue
+		}
+		if ref.Table != "" && !strings.EqualFold(sc.tables[i], ref.Table) {
+			continue
+		}
+		if idx != -1 {
+			return -1, fmt.Errorf("column reference %s is ambiguous", ref)
+		}
+		idx = i
+	}
+	if idx == -1 {
+		if ref.Table != "" && !sc.hasTable(ref.Table) {
+			return -1, fmt.Errorf("missing FROM-clause entry for table %s", ref.Table)
+		}
+		return -1, fmt.Errorf("column %s does not exist", ref)
+	}
+	return idx, nil
+}
+
+// qualify rewrites column references to name their table, so that equal
+// expressions written with and without qualifiers have the same text.
+func (sc *scope) qualify(expr parser.Expr) parser.Expr {
+	return parser.RewriteExpr(expr, func(e parser.Expr) parser.Expr {
+		ref, ok := e.(*parser.ColumnRef)
+		if !ok {
+			return nil
+		}
+		idx, err := sc.resolve(ref)
+		if err != nil || sc.tables[idx] == "" {
+			return nil
+		}
+		return &parser.ColumnRef{Table: sc.tables[idx], Column: sc.columns[idx].Name}
+	})
+}
+
+// exprKey is the text identifying an expression computed in this scope.
+func (sc *scope) exprKey(expr parser.Expr) string {
+	return sc.qualify(expr).String()
+}
+
+// lookup finds an expression computed by an earlier operator.
+func (sc *scope) lookup(expr parser.Expr) (int, bool) {
+	if sc.computed == nil {
+		return -1, false
+	}
+	key := expr.String()
+	if sc.keys != nil {
+		key = sc.keys.exprKey(expr)
+	}
+	idx, ok := sc.computed[key]
+	return idx, ok
+}
+
+// covers reports whether every column an expression references resolves in
+// the scope.
+func (sc *scope) covers(expr parser.Expr) bool {
+	covered := true
+	parser.WalkExpr(expr, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			if _, err := sc.resolve(ref); err != nil {
+				covered = false
+			}
+		}
+		return covered
+	})
+	return covered
+}
+
+// compileExpr resolves the column references of an expression against a
+// scope and binds its parameters. It returns the evaluator together with the
+// type of the values it produces; TypeNull means the type is only known at
+// run time.
+func compileExpr(expr parser.Expr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	if idx, ok := sc.lookup(expr); ok {
+		return columnEvaluator(idx), sc.columns[idx].Type, nil
+	}
+	switch e := expr.(type) {
+	case *parser.Literal, *parser.Param:
+		value, err := evalOperand(expr, params)
+		if err != nil {
+			return nil, types.TypeNull, err
+		}
+		return func(Row) (types.Value, error) {
+			return value, nil
+		}, value.Type, nil
+	case *parser.ColumnRef:
+		// Columns the query doesn't have may belong to the query a
+		// subquery is nested in.
+		outer := sc.env != nil && sc.env.outer != nil
+		if sc.grouped {
+			if outer && sc.keys != nil && !sc.keys.owns(e) {
+				return sc.env.outer.compile(e, params)
+			}
+			return nil, types.TypeNull, fmt.Errorf("column %s must appear in the GROUP BY clause or be used in an aggregate function", e.Column)
+		}
+		idx, err := sc.resolve(e)
+		if err != nil {
+			if outer && !sc.owns(e) {
+				return sc.env.outer.compile(e, params)
+			}
+			return nil, types.TypeNull, err
+		}
+		return columnEvaluator(idx), sc.columns[idx].Type, nil
+	case *parser.UnaryExpr:
+		return compileUnary(e, sc, params)
+	case *parser.BinaryExpr:
+		return compileBinary(e, sc, params)
+	case *parser.IsNullExpr:
+		operand, _, err := compileExpr(e.Expr, sc, params)
+		if err != nil {
+			return nil, types.TypeNull, err
+		}
+		return func(row Row) (types.Value, error) {
+			value, err := operand(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			return types.NewBoolean(value.IsNull() != e.Not), nil
+		}, types.TypeBoolean, nil
+	case *parser.FuncCall:
+		return compileFuncCall(e, sc, params)
+	case *parser.InExpr:
+		if e.Subquery != nil {
+			return compileSubquery(e, sc, params)
+		}
+		return compileIn(e, sc, params)
+	case *parser.SubqueryExpr, *parser.ExistsExpr:
+		return compileSubquery(expr, sc, params)
+	case *parser.LikeExpr:
+		return compileLike(e, sc, params)
+	case *parser.CaseExpr:
+		return compileCase(e, sc, params)
+	case *parser.CastExpr:
+		operand, typ, err := compileExpr(e.Expr, sc, params)
+		if err != nil {
+			return nil, types.TypeNull, err
+		}
+		if !types.CanCast(typ, e.Type) {
+			return nil, types.TypeNull, fmt.Errorf("cannot cast type %s to %s", typ, e.Type)
+		}
+		return func(row Row) (types.Value, error) {
+			value, err := operand(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			return value.Cast(e.Type)
+		}, e.Type, nil
+	default:
+		return nil, types.TypeNull, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+func columnEvaluator(idx int) evaluator {
+	return func(row Row) (types.Value, error) {
+		return row[idx], nil
+	}
+}
+
+// compileCondition compiles an expression used as a condition, which must
+// be of type BOOLEAN.
+func compileCondition(expr parser.Expr, sc *scope, params []types.Value) (evaluator, error) {
+	eval, typ, err := compileExpr(expr, sc, params)
+	if err != nil {
+		return nil, err
+	}
+	if typ != types.TypeBoolean && typ != types.TypeNull {
+		return nil, fmt.Errorf("condition must be of type BOOLEAN, not %s", typ)
+	}
+	return eval, nil
+}
+
+func compileUnary(e *parser.UnaryExpr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	operand, typ, err := compileExpr(e.Operand, sc, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	switch e.Op {
+	case "NOT":
+		if typ != types.TypeBoolean && typ != types.TypeNull {
+			return nil, types.TypeNull, fmt.Errorf("argument of NOT must be of type BOOLEAN, not %s", typ)
+		}
+		return func(row Row) (types.Value, error) {
+			value, err := operand(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			b, known, err := truth(value)
+			if err != nil || !known {
+				return types.Null(), err
+			}
+			return types.NewBoolean(!b), nil
+		}, types.TypeBoolean, nil
+	case "-":
+		if _, err := types.ArithmeticType("-", types.TypeInteger, typ); err != nil {
+			return nil, types.TypeNull, fmt.Errorf("operator does not exist: -%s", typ)
+		}
+		zero := types.NewInteger(0)
+		return func(row Row) (types.Value, error) {
+			value, err := operand(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			if value.Type == types.TypeReal {
+				return types.NewReal(-value.Float), nil
+			}
+			return types.Arithmetic("-", zero, value)
+		}, typ, nil
+	}
+	return nil, types.TypeNull, fmt.Errorf("unsupported operator %s", e.Op)
+}
+
+// comparable reports whether values of the two types can be compared. TEXT
+// converts to the type of the other side.
+func comparable(a, b types.Type) bool {
+	return a == b || a == types.TypeNull || b == types.TypeNull ||
+		a == types.TypeText || b == types.TypeText ||
+		(a == types.TypeInteger || a == types.TypeReal) && (b == types.TypeInteger || b == types.TypeReal)
+}
+
+func compileBinary(e *parser.BinaryExpr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	left, lt, err := compileExpr(e.Left, sc, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	right, rt, err := compileExpr(e.Right, sc, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	operands := func(row Row) (types.Value, types.Value, error) {
+		lv, err := left(row)
+		if err != nil {
+			return lv, lv, err
+		}
+		rv, err := right(row)
+		return lv, rv, err
+	}
+	switch e.Op {
+	case "AND", "OR":
+		for _, typ := range []types.Type{lt, rt} {
+			if typ != types.TypeBoolean && typ != types.TypeNull {
+				return nil, types.TypeNull, fmt.Errorf("argument of %s must be of type BOOLEAN, not %s", e.Op, typ)
+			}
+		}
+		isAnd := e.Op == "AND"
+		return func(row Row) (types.Value, error) {
+			lv, err := left(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			l, lknown, err := truth(lv)
+			if err != nil {
+				return types.Null(), err
+			}
+			// FALSE AND x is FALSE and TRUE OR x is TRUE whatever x is.
+			if lknown && l != isAnd {
+				return types.NewBoolean(l), nil
+			}
+			rv, err := right(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			r, rknown, err := truth(rv)
+			if err != nil {
+				return types.Null(), err
+			}
+			if rknown && r != isAnd {
+				return types.NewBoolean(r), nil
+			}
+			if !lknown || !rknown {
+				return types.Null(), nil
+			}
+			return types.NewBoolean(isAnd), nil
+		}, types.TypeBoolean, nil
+	case "+", "-", "*", "/", "%":
+		typ, err := types.ArithmeticType(e.Op, lt, rt)
+		if err != nil {
+			return nil, types.TypeNull, err
+		}
+		return func(row Row) (types.Value, error) {
+			lv, rv, err := operands(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			return types.Arithmetic(e.Op, lv, rv)
+		}, typ, nil
+	case "||":
+		return func(row Row) (types.Value, error) {
+			lv, rv, err := operands(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			return types.Concat(lv, rv), nil
+		}, types.TypeText, nil
+	default:
+		if !comparable(lt, rt) {
+			return nil, types.TypeNull, fmt.Errorf("operator does not exist: %s %s %s", lt, e.Op, rt)
+		}
+		return func(row Row) (types.Value, error) {
+			lv, rv, err := operands(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			if lv.IsNull() || rv.IsNull() {
+				return types.Null(), nil
+			}
+			cmp, err := types.Compare(lv, rv)
+			if err != nil {
+				return types.Null(), err
+			}
+			result, err := compareResult(e.Op, cmp)
+			if err != nil {
+				return types.Null(), err
+			}
+			return types.NewBoolean(result), nil
+		}, types.TypeBoolean, nil
+	}
+}
+
+// compileIn compiles x IN (list), which is TRUE if x equals an item, NULL
+// if it doesn't but x or an item is NULL, and FALSE otherwise.
+func compileIn(e *parser.InExpr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	operand, typ, err := compileExpr(e.Expr, sc, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	items := make([]evaluator, len(e.List))
+	for i, item := range e.List {
+		eval, itemType, err := compileExpr(item, sc, params)
+		if err != nil {
+			return nil, types.TypeNull, err
+		}
+		if !comparable(typ, itemType) {
+			return nil, types.TypeNull, fmt.Errorf("operator does not exist: %s = %s", typ, itemType)
+		}
+		items[i] = eval
+	}
+	return func(row Row) (types.Value, error) {
+		value, err := operand(row)
+		if err != nil || value.IsNull() {
+			return types.Null(), err
+		}
+		sawNull := false
+		for _, item := range items {
+			iv, err := item(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			if iv.IsNull() {
+				sawNull = true
+				continue
+			}
+			cmp, err := types.Compare(value, iv)
+			if err != nil {
+				return types.Null(), err
+			}
+			if cmp == 0 {
+				return types.NewBoolean(!e.Not), nil
+			}
+		}
+		if sawNull {
+			return types.Null(), nil
+		}
+		return types.NewBoolean(e.Not), nil
+	}, types.TypeBoolean, nil
+}
+
+// compileLike compiles LIKE and ILIKE. The pattern is usually the same for
+// every row, so its last translation is kept.
+func compileLike(e *parser.LikeExpr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	operand, typ, err := compileExpr(e.Expr, sc, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	pattern, patternType, err := compileExpr(e.Pattern, sc, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	for _, t := range []types.Type{typ, patternType} {
+		if t != types.TypeText && t != types.TypeNull {
+			op := "LIKE"
+			if e.CaseInsensitive {
+				op = "ILIKE"
+			}
+			return nil, types.TypeNull, fmt.Errorf("operator does not exist: %s %s %s", typ, op, patternType)
+		}
+	}
+	var lastPattern string
+	var re *regexp.Regexp
+	return func(row Row) (types.Value, error) {
+		value, err := operand(row)
+		if err != nil || value.IsNull() {
+			return types.Null(), err
+		}
+		pv, err := pattern(row)
+		if err != nil || pv.IsNull() {
+			return types.Null(), err
+		}
+		if re == nil || pv.String() != lastPattern {
+			lastPattern = pv.String()
+			if re, err = likeRegexp(lastPattern, e.CaseInsensitive); err != nil {
+				return types.Null(), err
+			}
+		}
+		return types.NewBoolean(re.MatchString(value.String()) != e.Not), nil
+	}, types.TypeBoolean, nil
+}
+
+// likeRegexp translates a LIKE pattern into a regular expression matching
+// the whole text. A backslash makes the next character match itself.
+func likeRegexp(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)")
+	if caseInsensitive {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteByte('^')
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteByte('.')
+		case '\\':
+			i++
+			if i == len(runes) {
+				return nil, errors.New("LIKE pattern must not end with escape character")
+			}
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteByte('$')
+	return regexp.Compile(sb.String())
+}
+
+// compileCase compiles a CASE expression. Its branches must have types that
+// can be matched; integers are converted to reals when mixed with them.
+func compileCase(e *parser.CaseExpr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	var operand evaluator
+	var operandType types.Type
+	if e.Operand != nil {
+		var err error
+		if operand, operandType, err = compileExpr(e.Operand, sc, params); err != nil {
+			return nil, types.TypeNull, err
+		}
+	}
+	conds := make([]evaluator, len(e.Whens))
+	results := make([]evaluator, len(e.Whens), len(e.Whens)+1)
+	resultTypes := make([]types.Type, len(e.Whens), len(e.Whens)+1)
+	for i, when := range e.Whens {
+		var err error
+		var condType types.Type
+		if conds[i], condType, err = compileExpr(when.Cond, sc, params); err != nil {
+			return nil, types.TypeNull, err
+		}
+		if operand != nil && !comparable(operandType, condType) {
+			return nil, types.TypeNull, fmt.Errorf("operator does not exist: %s = %s", operandType, condType)
+		}
+		if operand == nil && condType != types.TypeBoolean && condType != types.TypeNull {
+			return nil, types.TypeNull, fmt.Errorf("argument of CASE/WHEN must be of type BOOLEAN, not %s", condType)
+		}
+		if results[i], resultTypes[i], err = compileExpr(when.Result, sc, params); err != nil {
+			return nil, types.TypeNull, err
+		}
+	}
+	if e.Else != nil {
+		eval, typ, err := compileExpr(e.Else, sc, params)
+		if err != nil {
+			return nil, types.TypeNull, err
+		}
+		results, resultTypes = append(results, eval), append(resultTypes, typ)
+	}
+	typ, err := commonType("CASE", resultTypes)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	return func(row Row) (types.Value, error) {
+		var value types.Value
+		if operand != nil {
+			var err error
+			if value, err = operand(row); err != nil {
+				return types.Null(), err
+			}
+		}
+		for i, cond := range conds {
+			cv, err := cond(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			matched := false
+			if operand == nil {
+				if matched, _, err = truth(cv); err != nil {
+					return types.Null(), err
+				}
+			} else if !value.IsNull() && !cv.IsNull() {
+				cmp, err := types.Compare(value, cv)
+				if err != nil {
+					return types.Null(), err
+				}
+				matched = cmp == 0
+			}
+			if matched {
+				return resultOf(results[i], row, typ)
+			}
+		}
+		if e.Else != nil {
+			return resultOf(results[len(results)-1], row, typ)
+		}
+		return types.Null(), nil
+	}, typ, nil
+}
+
+// commonType returns the type values of the given types are converted to
+// when they make up the results of one expression, such as the branches of
+// a CASE.
+func commonType(construct string, ts []types.Type) (types.Type, error) {
+	common := types.TypeNull
+	for _, t := range ts {
+		switch {
+		case t == types.TypeNull || t == common:
+		case common == types.TypeNull:
+			common = t
+		case (common == types.TypeInteger || common == types.TypeReal) && (t == types.TypeInteger || t == types.TypeReal):
+			common = types.TypeReal
+		default:
+			return types.TypeNull, fmt.Errorf("%s types %s and %s cannot be matched", construct, common, t)
+		}
+	}
+	return common, nil
+}
+
+// resultOf evaluates an expression and converts its value to typ.
+func resultOf(eval evaluator, row Row, typ types.Type) (types.Value, error) {
+	value, err := eval(row)
+	if err != nil {
+		return types.Null(), err
+	}
+	return value.Cast(typ)
+}
+
+// truth interprets a value as a condition. known is false for NULL.
+func truth(value types.Value) (result bool, known bool, err error) {
+	switch value.Type {
+	case types.TypeNull:
+		return false, false, nil
+	case types.TypeBoolean:
+		return value.Bool, true, nil
+	}
+	return false, false, fmt.Errorf("condition must be of type BOOLEAN, not %s", value.Type)
+}
+
+// evalOperand resolves a literal or a bound parameter to its value.
+func evalOperand(expr parser.Expr, params []types.Value) (types.Value, error) {
+	switch e := expr.(type) {
+	case *parser.Literal:
+		return e.Value, nil
+	case *parser.Param:
+		if e.Index < 1 || e.Index > len(params) {
+			return types.Null(), fmt.Errorf("no value bound for parameter %d", e.Index)
+		}
+		return params[e.Index-1], nil
+	default:
+		return types.Null(), fmt.Errorf("unsupported expression %T", expr)
+	}
+}
@@ -0,0 +1,191 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// exprTable is the table test expressions are evaluated against, over the
+// row exprRow.
+var exprTable = &catalog.Table{
+	Name: "t",
+	Columns: []catalog.Column{
+		{Name: "i", Type: types.TypeInteger, NotNull: true},
+		{Name: "r", Type: types.TypeReal},
+		{Name: "s", Type: types.TypeText},
+		{Name: "b", Type: types.TypeBoolean},
+		{Name: "n", Type: types.TypeInteger},
+	},
+}
+
+var exprRow = Row{types.NewInteger(7), types.NewReal(2.5), types.NewText("Hello"), types.NewBoolean(true), types.Null()}
+
+// evalExpr compiles an expression against exprTable and evaluates it on
+// exprRow, returning its static type too.
+func evalExpr(text string) (types.Value, types.Type, error) {
+	expr, err := parser.ParseExpr(text)
+	if err != nil {
+		return types.Null(), types.TypeNull, err
+	}
+	eval, typ, err := compileExpr(expr, tableScope(exprTable, "t"), nil)
+	if err != nil {
+		return types.Null(), types.TypeNull, err
+	}
+	value, err := eval(exprRow)
+	return value, typ, err
+}
+
+type exprTest struct {
+	expr string
+	want types.Value
+}
+
+func runExprTests(t *testing.T, tests []exprTest) {
+	t.Helper()
+	for _, test := range tests {
+		got, typ, err := evalExpr(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s = %v (%s), want %v (%s)", test.expr, got, got.Type, test.want, test.want.Type)
+		}
+		if !got.IsNull() && typ != types.TypeNull && typ != got.Type {
+			t.Errorf("%s: compiled as %s, evaluated to %s", test.expr, typ, got.Type)
+		}
+	}
+}
+
+var (
+	nullVal  = types.Null()
+	trueVal  = types.NewBoolean(true)
+	falseVal = types.NewBoolean(false)
+	intVal   = types.NewInteger
+	realVal  = types.NewReal
+	textVal  = types.NewText
+)
+
+func TestEvalArithmetic(t *testing.T) {
+	runExprTests(t, []exprTest{
+		{"1 + 2 * 3", intVal(7)},
+		{"(1 + 2) * 3", intVal(9)},
+		{"i - 10", intVal(-3)},
+		{"-i", intVal(-7)},
+		{"7 / 2", intVal(3)},
+		{"-7 / 2", intVal(-3)},
+		{"7 % 3", intVal(1)},
+		{"7.0 / 2", realVal(3.5)},
+		{"i / 2.0", realVal(3.5)},
+		{"r * 2", realVal(5)},
+		{"i + r", realVal(9.5)},
+		{"s || ' world'", textVal("Hello world")},
+		{"s || i", textVal("Hello7")},
+		{"i > 5 AND r < 3", trueVal},
+		{"i BETWEEN 1 AND 7", trueVal},
+		{"i NOT BETWEEN 1 AND 6", trueVal},
+		{"i IN (1, 7, 9)", trueVal},
+		{"s IN ('a', 'b')", falseVal},
+		{"s LIKE 'H%o'", trueVal},
+		{"s LIKE 'h%'", falseVal},
+		{"s ILIKE 'h%'", trueVal},
+		{"s LIKE 'Hell_'", trueVal},
+		{"s NOT LIKE '%x%'", trueVal},
+		{"CASE WHEN i > 5 THEN 'big' ELSE 'small' END", textVal("big")},
+		{"CASE i WHEN 1 THEN 'one' WHEN 7 THEN 'seven' END", textVal("seven")},
+		{"CASE WHEN i < 0 THEN 1 END", nullVal},
+		{"ABS(-3)", intVal(3)},
+		{"ROUND(2.567, 2)", realVal(2.57)},
+		{"LENGTH(s)", intVal(5)},
+		{"UPPER(s)", textVal("HELLO")},
+		{"SUBSTR(s, 2, 3)", textVal("ell")},
+		{"GREATEST(1, i, 3)", intVal(7)},
+		{"LEAST(4, i, 3)", intVal(3)},
+		{"EXTRACT(year FROM '2024-03-05 10:30:00')", realVal(2024)},
+	})
+}
+
+func TestEvalNull(t *testing.T) {
+	runExprTests(t, []exprTest{
+		{"n + 1", nullVal},
+		{"n * 0", nullVal},
+		{"n = n", nullVal},
+		{"n <> 1", nullVal},
+		{"s || n", nullVal},
+		{"n IS NULL", trueVal},
+		{"n IS NOT NULL", falseVal},
+		{"i IS NULL", falseVal},
+		{"NOT (n = 1)", nullVal},
+		// AND and OR use three-valued logic: NULL is unknown, not false.
+		{"n = 1 AND FALSE", falseVal},
+		{"n = 1 AND TRUE", nullVal},
+		{"n = 1 OR TRUE", trueVal},
+		{"n = 1 OR FALSE", nullVal},
+		// IN is unknown rather than false when the list holds a NULL.
+		{"i IN (1, n)", nullVal},
+		{"i IN (7, n)", trueVal},
+		{"i NOT IN (1, n)", nullVal},
+		{"n IN (1, 2)", nullVal},
+		{"n BETWEEN 1 AND 2", nullVal},
+		{"CAST(n AS TEXT) LIKE 'a'", nullVal},
+		{"CASE WHEN n = 1 THEN 'x' ELSE 'y' END", textVal("y")},
+		{"CASE n WHEN NULL THEN 'x' ELSE 'y' END", textVal("y")},
+		{"COALESCE(n, i)", intVal(7)},
+		{"COALESCE(n, NULL)", nullVal},
+		{"NULLIF(i, 7)", nullVal},
+		{"NULLIF(i, 8)", intVal(7)},
+		{"ABS(n)", nullVal},
+		{"GREATEST(n, 1)", intVal(1)},
+	})
+}
+
+func TestEvalCast(t *testing.T) {
+	runExprTests(t, []exprTest{
+		{"CAST('42' AS INTEGER)", intVal(42)},
+		{"' 42 '::INTEGER", intVal(42)},
+		{"CAST(i AS TEXT)", textVal("7")},
+		{"CAST(i AS REAL)", realVal(7)},
+		// REAL to INTEGER truncates toward zero.
+		{"CAST(r AS INTEGER)", intVal(2)},
+		{"CAST(2.9 AS INTEGER)", intVal(2)},
+		{"CAST(-2.5 AS INTEGER)", intVal(-2)},
+		{"CAST('2.5' AS REAL)", realVal(2.5)},
+		{"CAST(r AS TEXT)", textVal("2.5")},
+		{"CAST('true' AS BOOLEAN)", trueVal},
+		{"CAST('f' AS BOOLEAN)", falseVal},
+		{"CAST(b AS TEXT)", textVal("true")},
+		{"CAST(b AS INTEGER)", intVal(1)},
+		{"CAST(0 AS BOOLEAN)", falseVal},
+		{"CAST(n AS TEXT)", nullVal},
+		{"n::REAL", nullVal},
+		{"CAST(NULL AS INTEGER)", nullVal},
+		{"'1' = 1", trueVal},
+	})
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, expr := range []string{
+		"1 / 0",
+		"i % 0",
+		"1.0 / 0",
+		"CAST('abc' AS INTEGER)",
+		"CAST('maybe' AS BOOLEAN)",
+		"9223372036854775807 + 1",
+		"s + 1",
+		"b * 2",
+		"i LIKE 'a'",
+		"missing + 1",
+		"x.i",
+		"NOSUCHFUNCTION(1)",
+		"ABS('a', 'b')",
+		"SUM(i)",
+		"EXTRACT(year FROM 'not a date')",
+	} {
+		if got, _, err := evalExpr(expr); err == nil {
+			t.Errorf("%s = %v, want an error", expr, got)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// referentialActions maps the actions of the parser to those of the catalog.
+var referentialActions = map[parser.ReferentialAction]catalog.ReferentialAction{
+	parser.ActionNoAction: catalog.NoAction,
+	parser.ActionRestrict: catalog.Restrict,
+	parser.ActionCascade:  catalog.Cascade,
+	parser.ActionSetNull:  catalog.SetNull,
+}
+
+// defineForeignKey fills in the foreign key of table constraint, checking
+// that it refers to a key of the referenced table with columns of the same
+// types. A table may refer to itself.
+func (e *Executor) defineForeignKey(table *catalog.Table, def parser.ConstraintDefinition, constraint *catalog.Constraint) error {
+	parent := table
+	if !strings.EqualFold(def.RefTable, table.Name) {
+		var err error
+		if parent, err = e.catalog.GetTable(def.RefTable); err != nil {
+			return err
+		}
+	}
+	constraint.RefTable = parent.Name
+	if len(def.RefColumns) == 0 {
+		pk := parent.PrimaryKey()
+		if pk == nil {
+			return fmt.Errorf("there is no primary key for referenced table %s", parent.Name)
+		}
+		constraint.RefColumns = pk.Columns
+	}
+	for _, name := range def.RefColumns {
+		if parent.ColumnIndex(name) == -1 {
+			return fmt.Errorf("column %s referenced in foreign key constraint does not exist", name)
+		}
+		constraint.RefColumns = append(constraint.RefColumns, strings.ToLower(name))
+	}
+	if len(constraint.RefColumns) != len(constraint.Columns) {
+		return fmt.Errorf("number of referencing and referenced columns for foreign key disagree")
+	}
+	if uniqueKey(parent, constraint.RefColumns) == nil {
+		return fmt.Errorf("there is no unique constraint matching given keys for referenced table %s", parent.Name)
+	}
+	for i, name := range constraint.Columns {
+		col := table.Columns[table.ColumnIndex(name)]
+		ref := parent.Columns[parent.ColumnIndex(constraint.RefColumns[i])]
+		if col.Type != ref.Type {
+			return fmt.Errorf("foreign key columns %s and %s are of incompatible types: %s and %s", col.Name, ref.Name, col.Type, ref.Type)
+		}
+	}
+	constraint.OnDelete = referentialActions[def.OnDelete]
+	constraint.OnUpdate = referentialActions[def.OnUpdate]
+	constraint.Deferrable = def.Deferrable
+	constraint.InitiallyDeferred = def.InitiallyDeferred
+	return nil
+}
+
+// uniqueKey returns the PRIMARY KEY or UNIQUE constraint of a table over
+// exactly the given columns, in any order, or nil.
+func uniqueKey(table *catalog.Table, columns []string) *catalog.Constraint {
+	for _, constraint := range table.Constraints {
+		if constraint.Type != catalog.PrimaryKey && constraint.Type != catalog.Unique {
+			continue
+		}
+		if len(constraint.Columns) == len(columns) && sameColumns(constraint.Columns, columns) {
+			return constraint
+		}
+	}
+	return nil
+}
+
+// sameColumns reports whether every column of a is in b.
+func sameColumns(a, b []string) bool {
+	for _, name := range a {
+		found := false
+		for _, other := range b {
+			if strings.EqualFold(name, other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// modification carries the state of a statement writing rows across the
+// tables its foreign keys reach: the CHECK constraints compiled for them, the
+// foreign key checks left for the end of the statement, and the rows that
+// referential actions changed.
+type modification struct {
+	t        *txn.Transaction
+	checks   map[string][]tableCheck
+	pending  []txn.DeferredCheck
+	cascaded map[string]map[storage.RID]cascadedRow // keyed by lower-cased table name
+}
+
+// cascadedRow is a row a referential action deleted, or updated into row
+// stored at rid.
+type cascadedRow struct {
+	deleted bool
+	rid     storage.RID
+	row     Row
+}
+
+func newModification(t *txn.Transaction) *modification {
+	return &modification{
+		t:        t,
+		checks:   make(map[string][]tableCheck),
+		cascaded: make(map[string]map[storage.RID]cascadedRow),
+	}
+}
+
+// tableChecks returns the compiled CHECK constraints of a table.
+func (m *modification) tableChecks(table *catalog.Table) ([]tableCheck, error) {
+	key := strings.ToLower(table.Name)
+	if checks, ok := m.checks[key]; ok {
+		return checks, nil
+	}
+	checks, err := compileChecks(table)
+	if err != nil {
+		return nil, err
+	}
+	m.checks[key] = checks
+	return checks, nil
+}
+
+// current follows the changes referential actions of the statement made to
+// the row of table read at rid. It returns where the row is now and what it
+// holds, whether it changed, and false once it is deleted.
+func (m *modification) current(table *catalog.Table, rid storage.RID, row Row) (storage.RID, Row, bool, bool) {
+	changes := m.cascaded[strings.ToLower(table.Name)]
+	changed := false
+	for {
+		change, ok := changes[rid]
+		if !ok {
+			return rid, row, changed, true
+		}
+		if change.deleted {
+			return rid, nil, true, false
+		}
+		if change.rid == rid {
+			// Updated in place.
+			return rid, change.row, true, true
+		}
+		rid, row, changed = change.rid, change.row, true
+	}
+}
+
+func (m *modification) cascade(table *catalog.Table, rid storage.RID, change cascadedRow) {
+	key := strings.ToLower(table.Name)
+	if m.cascaded[key] == nil {
+		m.cascaded[key] = make(map[storage.RID]cascadedRow)
+	}
+	m.cascaded[key][rid] = change
+}
+
+// finishModification runs the foreign key checks left for the end of the statement.
+func (e *Executor) finishModification(m *modification) error {
+	for _, check := range m.pending {
+		if err := e.verifyReference(check); err != nil {
+			return err
+		}
+	}
+	m.pending = nil
+	return nil
+}
+
+// queueCheck records that the rows of table must not reference key through
+// constraint unless the referenced table has it, to be checked at the end of
+// the statement, or at commit when the constraint is deferred.
+func (m *modification) queueCheck(table *catalog.Table, constraint *catalog.Constraint, key Row, parent bool) {
+	check := txn.DeferredCheck{Table: table.Name, Constraint: constraint.Name, Key: key, Parent: parent}
+	if constraint.Deferrable && m.t.ConstraintDeferred(constraint.Name, constraint.InitiallyDeferred) {
+		m.t.Defer(check)
+		return
+	}
+	m.pending = append(m.pending, check)
+}
+
+// rowInserted checks the foreign keys of a new row.
+func (e *Executor) rowInserted(m *modification, table *catalog.Table, row Row) {
+	for _, constraint := range table.Constraints {
+		if constraint.Type != catalog.ForeignKey {
+			continue
+		}
+		if key := rowKey(table, constraint.Columns, row); !hasNull(key) {
+			m.queueCheck(table, constraint, key, false)
+		}
+	}
+}
+
+// rowUpdated checks the foreign keys of a row whose key columns changed and
+// applies the ON UPDATE actions of the foreign keys referencing its old key.
+func (e *Executor) rowUpdated(m *modification, table *catalog.Table, before, after Row) error {
+	for _, constraint := range table.Constraints {
+		if constraint.Type != catalog.ForeignKey {
+			continue
+		}
+		key := rowKey(table, constraint.Columns, after)
+		if hasNull(key) {
+			continue
+		}
+		if changed, err := keyChanged(rowKey(table, constraint.Columns, before), key); err != nil {
+			return err
+		} else if changed {
+			m.queueCheck(table, constraint, key, false)
+		}
+	}
+	for _, ref := range e.catalog.References(table.Name) {
+		oldKey := rowKey(table, ref.Constraint.RefColumns, before)
+		newKey := rowKey(table, ref.Constraint.RefColumns, after)
+		if changed, err := keyChanged(oldKey, newKey); err != nil {
+			return err
+		} else if changed {
+			if err := e.referentialAction(m, ref, ref.Constraint.OnUpdate, oldKey, newKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rowDeleted applies the ON DELETE actions of the foreign keys referencing a
+// deleted row.
+func (e *Executor) rowDeleted(m *modification, table *catalog.Table, row Row) error {
+	for _, ref := range e.catalog.References(table.Name) {
+		key := rowKey(table, ref.Constraint.RefColumns, row)
+		if err := e.referentialAction(m, ref, ref.Constraint.OnDelete, key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referentialAction applies action to the rows of ref referencing oldKey,
+// which was deleted when newKey is nil and changed to newKey otherwise.
+func (e *Executor) referentialAction(m *modification, ref catalog.Reference, action catalog.ReferentialAction, oldKey, newKey Row) error {
+	if hasNull(oldKey) {
+		return nil
+	}
+	if action == catalog.NoAction {
+		// The key may come back before the check runs, as when a row is
+		// deleted and inserted again.
+		m.queueCheck(ref.Table, ref.Constraint, oldKey, true)
+		return nil
+	}
+	rids, rows, err := e.findRows(ref.Table, ref.Constraint.Columns, oldKey, -1)
+	if err != nil {
+		return err
+	}
+	if action == catalog.Restrict {
+		if len(rids) > 0 {
+			return referencedError(ref.Table, ref.Constraint, oldKey)
+		}
+		return nil
+	}
+	for i, rid := range rids {
+		if action == catalog.Cascade && newKey == nil {
+			m.cascade(ref.Table, rid, cascadedRow{deleted: true})
+			if err := e.deleteRow(m, ref.Table, rid, rows[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		updated := append(Row(nil), rows[i]...)
+		for j, name := range ref.Constraint.Columns {
+			value := types.Null()
+			if action == catalog.Cascade {
+				value = newKey[j]
+			}
+			updated[ref.Table.ColumnIndex(name)] = value
+		}
+		newRID, err := e.updateRow(m, ref.Table, rid, rows[i], updated)
+		if err != nil {
+			return err
+		}
+		m.cascade(ref.Table, rid, cascadedRow{rid: newRID, row: updated})
+	}
+	return nil
+}
+
+// deleteRow deletes the version of a row of table stored at rid and applies
+// the ON DELETE actions of the foreign keys referencing it. The version and
+// its index entries stay for the snapshots that still see it.
+func (e *Executor) deleteRow(m *modification, table *catalog.Table, rid storage.RID, row Row) error {
+	if err := e.expireVersion(m.t, table, rid); err != nil {
+		return err
+	}
+	m.t.AddUndo(txn.UndoRecord{Kind: txn.UndoDelete, Table: table.Name, RID: rid})
+	return e.rowDeleted(m, table, row)
+}
+
+// updateRow replaces the row of table stored at rid by updated after
+// checking the constraints of the table, and returns where the new version
+// is stored. The foreign keys of the row and those referencing it are then
+// checked or acted on.
+func (e *Executor) updateRow(m *modification, table *catalog.Table, rid storage.RID, row, updated Row) (storage.RID, error) {
+	var err error
+	for i, col := range table.Columns {
+		if updated[i], err = coerceValue(table, col, updated[i]); err != nil {
+			return rid, err
+		}
+	}
+	checks, err := m.tableChecks(table)
+	if err != nil {
+		return rid, err
+	}
+	if err := checkRow(table, checks, updated); err != nil {
+		return rid, err
+	}
+	// A concurrent update is reported before any key it may have taken.
+	if err := e.expireVersion(m.t, table, rid); err != nil {
+		return rid, err
+	}
+	err = e.checkUnique(table, updated, &rid)
+	var newRID storage.RID
+	if err == nil {
+		newRID, err = e.tableHeap(table).Insert(storage.NewVersion(m.t.ID, m.t.Command(), encodeRecord(table, updated)))
+	}
+	if err != nil {
+		e.tableHeap(table).SetXmax(rid, 0, 0)
+		return rid, err
+	}
+	m.t.AddUndo(txn.UndoRecord{Kind: txn.UndoUpdate, Table: table.Name, RID: rid, NewRID: newRID})
+	if err := e.insertIndexEntries(table, updated, newRID); err != nil {
+		return rid, err
+	}
+	return newRID, e.rowUpdated(m, table, row, updated)
+}
+
+// verifyReference fails when rows of the table of a check still reference
+// its key although the referenced table no longer has it. Checks of
+// constraints or tables dropped since they were recorded pass.
+func (e *Executor) verifyReference(check txn.DeferredCheck) error {
+	table, err := e.catalog.GetTable(check.Table)
+	if err != nil {
+		return nil
+	}
+	constraint := table.Constraint(check.Constraint)
+	if constraint == nil || constraint.Type != catalog.ForeignKey {
+		return nil
+	}
+	parent, err := e.catalog.GetTable(constraint.RefTable)
+	if err != nil {
+		return err
+	}
+	key := Row(check.Key)
+	if rids, _, err := e.findRows(parent, constraint.RefColumns, key, 1); err != nil || len(rids) > 0 {
+		return err
+	}
+	if rids, _, err := e.findRows(table, constraint.Columns, key, 1); err != nil || len(rids) == 0 {
+		return err
+	}
+	if check.Parent {
+		return referencedError(table, constraint, key)
+	}
+	return &ConstraintError{
+		Type: catalog.ForeignKey, Table: table.Name, Constraint: constraint.Name,
+		Columns: constraint.Columns, Key: index.Key(key).String(), RefTable: constraint.RefTable,
+	}
+}
+
+// referencedError reports a key of the table referenced by constraint that
+// rows of table still refer to.
+func referencedError(table *catalog.Table, constraint *catalog.Constraint, key Row) error {
+	return &ConstraintError{
+		Type: catalog.ForeignKey, Table: table.Name, Constraint: constraint.Name,
+		Columns: constraint.RefColumns, Key: index.Key(key).String(), RefTable: constraint.RefTable, Referenced: true,
+	}
+}
+
+// CheckDeferred runs the foreign key checks a transaction deferred until
+// commit, and fails with the first violation.
+func (e *Executor) CheckDeferred(t *txn.Transaction) error {
+	for _, check := range t.TakeDeferred(nil) {
+		if err := e.verifyReference(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExecuteSetConstraintsStatement changes when the checks of deferrable
+// constraints run for the rest of the transaction. Checks deferred so far
+// run at once for the constraints made IMMEDIATE.
+func (e *Executor) ExecuteSetConstraintsStatement(t *txn.Transaction, setStmt *parser.SetConstraintsStatement) (*Result, error) {
+	for _, name := range setStmt.Names {
+		exists, deferrable := false, false
+		for _, tableName := range e.catalog.TableNames() {
+			table, _ := e.catalog.GetTable(tableName)
+			if constraint := table.Constraint(name); constraint != nil {
+				exists = true
+				deferrable = deferrable || constraint.Deferrable
+			}
+		}
+		if !exists {
+			return nil, fmt.Errorf("constraint %s does not exist", name)
+		}
+		if !deferrable {
+			return nil, fmt.Errorf("constraint %s is not deferrable", name)
+		}
+	}
+	t.SetConstraintsDeferred(setStmt.Names, setStmt.Deferred)
+	if setStmt.Deferred {
+		return &Result{}, nil
+	}
+	checks := t.TakeDeferred(setStmt.Names)
+	for _, check := range checks {
+		if err := e.verifyReference(check); err != nil {
+			// The checks still have to pass before the transaction commits.
+			for _, check := range checks {
+				t.Defer(check)
+			}
+			return nil, err
+		}
+	}
+	return &Result{}, nil
+}
+
+// rowKey extracts the values of the named columns from a row.
+func rowKey(table *catalog.Table, columns []string, row Row) Row {
+	key := make(Row, len(columns))
+	for i, name := range columns {
+		key[i] = row[table.ColumnIndex(name)]
+	}
+	return key
+}
+
+// keyChanged reports whether two keys differ. NULLs are equal here.
+func keyChanged(a, b Row) (bool, error) {
+	cmp, err := index.CompareKeys(index.Key(a), index.Key(b))
+	return cmp != 0, err
+}
+
+// findRows returns up to limit rows of table, or all of them when limit is
+// negative, whose columns hold key, in their latest versions. It seeks an
+// index whose leading columns are those columns, and scans the table when
+// there is none.
+func (e *Executor) findRows(table *catalog.Table, columns []string, key Row, limit int) ([]storage.RID, []Row, error) {
+	var rids []storage.RID
+	var rows []Row
+	for _, def := range table.Indexes {
+		if len(def.Columns) < len(columns) || !sameColumns(def.Columns[:len(columns)], columns) {
+			continue
+		}
+		// Order the key as the index orders its columns.
+		seek := make(index.Key, len(columns))
+		for i, name := range def.Columns[:len(columns)] {
+			for j, other := range columns {
+				if strings.EqualFold(name, other) {
+					seek[i] = key[j]
+				}
+			}
+		}
+		iter, err := index.Open(e.bufferManager, def.RootPageID).Seek(seek)
+		if err != nil {
+			return nil, nil, err
+		}
+		heap := e.tableHeap(table)
+		for limit < 0 || len(rids) < limit {
+			entry, ok, err := iter.Next()
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				break
+			}
+			if cmp, err := index.CompareKeys(entry.Key, seek); err != nil {
+				return nil, nil, err
+			} else if cmp != 0 {
+				break
+			}
+			data, err := heap.Get(entry.RID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !visible(nil, data) {
+				continue
+			}
+			row, err := decodeRow(table, data)
+			if err != nil {
+				return nil, nil, err
+			}
+			rids = append(rids, entry.RID)
+			rows = append(rows, row)
+		}
+		return rids, rows, nil
+	}
+	iter := e.tableHeap(table).Iterator()
+	for limit < 0 || len(rids) < limit {
+		rid, data, err := iter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if data == nil {
+			break
+		}
+		if !visible(nil, data) {
+			continue
+		}
+		row, err := decodeRow(table, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if changed, err := keyChanged(rowKey(table, columns, row), key); err != nil {
+			return nil, nil, err
+		} else if !changed {
+			rids = append(rids, rid)
+			rows = append(rows, row)
+		}
+	}
+	return rids, rows, nil
+}
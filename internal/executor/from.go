@@ -0,0 +1,432 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// JoinMethod selects the algorithm used to execute joins.
+type JoinMethod = planner.JoinMethod
+
+const (
+	// JoinAuto lets the planner choose the cheapest method for each join.
+	JoinAuto            = planner.JoinAuto
+	JoinNestedLoop      = planner.JoinNestedLoop
+	JoinIndexNestedLoop = planner.JoinIndexNestedLoop
+	JoinHash            = planner.JoinHash
+	JoinMerge           = planner.JoinMerge
+)
+
+// SetJoinMethod forces joins to use the given algorithm where it applies.
+// Joins it can't execute use the planner's choice.
+func (e *Executor) SetJoinMethod(method JoinMethod) {
+	e.joinMethod = method
+}
+
+// fromScope returns the scope of the rows produced by a FROM clause, given
+// its planned subqueries.
+func (e *Executor) fromScope(ref parser.TableRef, derived map[parser.TableRef]*planner.Subquery) (*scope, error) {
+	switch r := ref.(type) {
+	case *parser.TableName:
+		if sub := derived[r]; sub != nil {
+			return tableScope(derivedTable(r.Name, sub), r.RefName()), nil
+		}
+		table, err := e.catalog.GetTable(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		return tableScope(table, r.RefName()), nil
+	case *parser.DerivedTable:
+		return tableScope(derivedTable(r.Alias, derived[r]), r.Alias), nil
+	case *parser.Join:
+		left, err := e.fromScope(r.Left, derived)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.fromScope(r.Right, derived)
+		if err != nil {
+			return nil, err
+		}
+		return joinScopes(left, right, r.Type == parser.JoinRight || r.Type == parser.JoinFull,
+			r.Type == parser.JoinLeft || r.Type == parser.JoinFull), nil
+	}
+	return nil, fmt.Errorf("unsupported FROM clause %T", ref)
+}
+
+// planFrom chooses a physical plan for reading the rows of a table that
+// satisfy the WHERE condition of an UPDATE or DELETE.
+func (e *Executor) planFrom(table string, where parser.Expr, params []types.Value) (planner.Plan, error) {
+	q, err := planner.BuildTable(e.catalog, table, where)
+	if err != nil {
+		return nil, err
+	}
+	return e.optimize(q, params), nil
+}
+
+func (e *Executor) optimize(q *planner.Query, params []types.Value) planner.Plan {
+	return planner.Optimize(q, params, planner.Options{JoinMethod: e.joinMethod, WorkMem: e.workMem})
+}
+
+// buildFrom returns a source of the rows of a FROM clause that satisfy the
+// WHERE condition, their scope, the plan node producing them and the
+// planner's estimate of them. Columns are in the order the tables are
+// listed, whatever the join order the planner chose. Only the columns that
+// outputs, the expressions evaluated on the rows, and the conditions refer
+// to are read; the others are NULL. derived holds the planned subqueries and
+// WITH queries of the FROM clause, outer links to the enclosing query of a
+// subquery and ctes holds the WITH queries in scope.
+func (e *Executor) buildFrom(ctx context.Context, from parser.TableRef, where parser.Expr, derived map[parser.TableRef]*planner.Subquery, outputs []parser.Expr, params []types.Value, x *explainer, outer *correlation, ctes cteSet) (rowSource, *scope, *explainNode, planner.Estimate, error) {
+	q, err := planner.Build(e.catalog, from, where, derived)
+	if err != nil {
+		return nil, nil, nil, planner.Estimate{}, err
+	}
+	q.Project(outputs)
+	plan := e.optimize(q, params)
+	source, sc, node, err := e.buildPlan(ctx, plan, params, x, outer, ctes)
+	if err != nil {
+		return nil, nil, nil, planner.Estimate{}, err
+	}
+	estimate := plan.Estimated()
+
+	rels := plan.Relations()
+	offsets := make([]int, len(rels))
+	inOrder := true
+	pos := 0
+	for i, rel := range rels {
+		if rel.ID != i {
+			inOrder = false
+		}
+		offsets[rel.ID] = pos
+		pos += len(rel.Table.Columns)
+	}
+	if inOrder {
+		return source, sc, node, estimate, nil
+	}
+	reordered := &scope{env: sc.env}
+	var exprs []evaluator
+	for id, offset := range offsets {
+		for i := range rels[indexOfRelation(rels, id)].Table.Columns {
+			exprs = append(exprs, columnEvaluator(offset+i))
+			reordered.columns = append(reordered.columns, sc.columns[offset+i])
+			reordered.tables = append(reordered.tables, sc.tables[offset+i])
+		}
+	}
+	return func() (RowIterator, error) {
+		rows, err := source()
+		if err != nil {
+			return nil, err
+		}
+		return &projection{child: rows, exprs: exprs}, nil
+	}, reordered, node, estimate, nil
+}
+
+func indexOfRelation(rels []*planner.Relation, id int) int {
+	for i, rel := range rels {
+		if rel.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// compileOptional compiles a condition that may be absent.
+func compileOptional(expr parser.Expr, sc *scope, params []types.Value) (evaluator, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	return compileCondition(expr, sc, params)
+}
+
+// compileConstant evaluates an expression that doesn't refer to columns.
+func compileConstant(expr parser.Expr, params []types.Value) (types.Value, error) {
+	eval, _, err := compileExpr(expr, &scope{}, params)
+	if err != nil {
+		return types.Value{}, err
+	}
+	return eval(nil)
+}
+
+// hashable reports whether equal keys of the two types encode alike, which
+// hash joins rely on. Text compared with numbers is only equal after a cast.
+func hashable(a, b types.Type) bool {
+	numeric := func(t types.Type) bool { return t == types.TypeInteger || t == types.TypeReal }
+	return a == b || (numeric(a) && numeric(b)) || a == types.TypeNull || b == types.TypeNull
+}
+
+// buildPlan turns a physical plan into a source of its rows, their scope and
+// the node describing it to EXPLAIN. The conditions of a subquery's plan may
+// refer to the enclosing query through outer.
+func (e *Executor) buildPlan(ctx context.Context, plan planner.Plan, params []types.Value, x *explainer, outer *correlation, ctes cteSet) (rowSource, *scope, *explainNode, error) {
+	switch p := plan.(type) {
+	case *planner.SeqScan:
+		table := p.Relation.Table
+		sc := e.withEnv(tableScope(table, p.Relation.Name), ctx, x, outer, ctes)
+		where, err := compileOptional(p.Filter, sc, params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		node := x.scanNode("Seq Scan", p.Relation, p.Estimate)
+		node.prop("Filter", p.Filter)
+		heap := e.tableHeap(table)
+		return x.track(node, func() (RowIterator, error) {
+			scan := newSeqScan(ctx, heap, table, where)
+			scan.columns = p.Relation.Columns
+			return scan, nil
+		}), sc, node, nil
+	case *planner.SubqueryScan:
+		sc := e.withEnv(tableScope(p.Relation.Table, p.Relation.Name), ctx, x, outer, ctes)
+		where, err := compileOptional(p.Filter, sc, params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sub := p.Relation.Subquery.Plan.(*selectPlan)
+		typ := sub.scan
+		if typ == "" {
+			typ = "Subquery Scan"
+		}
+		node := x.scanNode(typ, p.Relation, p.Estimate)
+		if node != nil {
+			node.prop("Filter", p.Filter)
+			if sub.node != nil {
+				node.children = append(node.children, sub.node)
+			}
+		}
+		return x.track(node, func() (RowIterator, error) {
+			rows, err := sub.open()
+			if err != nil || where == nil {
+				return rows, err
+			}
+			return &filter{child: rows, cond: where}, nil
+		}), sc, node, nil
+	case *planner.IndexScan:
+		table := p.Relation.Table
+		sc := e.withEnv(tableScope(table, p.Relation.Name), ctx, x, outer, ctes)
+		where, err := compileOptional(p.Filter, sc, params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		var bounds [2]*indexBound
+		for i, bound := range []*planner.Bound{p.Lower, p.Upper} {
+			if bound == nil {
+				continue
+			}
+			value, err := compileConstant(bound.Value, params)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			bounds[i] = &indexBound{value: value, inclusive: bound.Inclusive}
+		}
+		node := x.scanNode("Index Scan", p.Relation, p.Estimate)
+		if node != nil {
+			node.index = p.Index.Name
+			node.prop("Index Cond", p.IndexCond)
+			node.prop("Filter", withoutConds(p.Filter, p.IndexCond))
+		}
+		tree := index.Open(e.bufferManager, p.Index.RootPageID)
+		heap := e.tableHeap(table)
+		return x.track(node, func() (RowIterator, error) {
+			return &indexScan{
+				ctx: ctx, tree: tree, heap: heap, table: table,
+				lower: bounds[0], upper: bounds[1], where: where, columns: p.Relation.Columns,
+				snapshot: snapshotFrom(ctx),
+			}, nil
+		}), sc, node, nil
+	case *planner.Filter:
+		source, sc, child, err := e.buildPlan(ctx, p.Input, params, x, outer, ctes)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cond, err := compileCondition(p.Cond, sc, params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		node := x.node("Filter", &p.Estimate, child)
+		node.prop("Filter", p.Cond)
+		if lit, ok := p.Cond.(*parser.Literal); ok && lit.Value.Type == types.TypeBoolean && !lit.Value.Bool {
+			// A condition folded to FALSE spares reading the input.
+			return x.track(node, func() (RowIterator, error) {
+				return &rowList{}, nil
+			}), sc, node, nil
+		}
+		return x.track(node, func() (RowIterator, error) {
+			rows, err := source()
+			if err != nil {
+				return nil, err
+			}
+			return &filter{child: rows, cond: cond}, nil
+		}), sc, node, nil
+	case *planner.Join:
+		return e.buildJoin(ctx, p, params, x, outer, ctes)
+	}
+	return nil, nil, nil, fmt.Errorf("unsupported plan node %T", plan)
+}
+
+// buildJoin turns a join of the physical plan into a join operator. Semi and
+// anti joins produce rows of the left input only, but their conditions are
+// evaluated on joined rows like those of other joins.
+func (e *Executor) buildJoin(ctx context.Context, p *planner.Join, params []types.Value, x *explainer, outer *correlation, ctes cteSet) (rowSource, *scope, *explainNode, error) {
+	left, leftScope, leftNode, err := e.buildPlan(ctx, p.Left, params, x, outer, ctes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	right, rightScope, rightNode, err := e.buildPlan(ctx, p.Right, params, x, outer, ctes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	joinType := p.Type
+	joined := joinScopes(leftScope, rightScope, joinType == parser.JoinFull,
+		joinType == parser.JoinLeft || joinType == parser.JoinFull)
+	leftWidth, rightWidth := len(leftScope.columns), len(rightScope.columns)
+	out := joined
+	if joinType == parser.JoinSemi || joinType == parser.JoinAnti {
+		out = leftScope
+	}
+
+	var leftKeys, rightKeys []evaluator
+	canHash := true
+	for _, key := range p.Keys {
+		l, lt, err := compileExpr(key.Left, leftScope, params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		r, rt, err := compileExpr(key.Right, rightScope, params)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		leftKeys, rightKeys = append(leftKeys, l), append(rightKeys, r)
+		canHash = canHash && hashable(lt, rt)
+	}
+	residual, err := compileOptional(p.Residual, joined, params)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	method := p.Method
+	if method == planner.JoinHash && !canHash {
+		method = planner.JoinNestedLoop
+	}
+	node := x.node(method.String(), &p.Estimate, leftNode, rightNode)
+	if node != nil {
+		node.join, node.joinType = true, joinType
+	}
+	switch method {
+	case planner.JoinIndexNestedLoop:
+		// Keys beyond the one the index is probed with are checked with
+		// the residual condition.
+		var rest []parser.Expr
+		for _, key := range p.Keys[1:] {
+			rest = append(rest, &parser.BinaryExpr{Op: "=", Left: key.Left, Right: key.Right})
+		}
+		if p.Residual != nil {
+			rest = append(rest, p.Residual)
+		}
+		if residual, err = compileOptional(parser.Conjoin(rest), joined, params); err != nil {
+			return nil, nil, nil, err
+		}
+		inner := p.Right.(*planner.SeqScan).Relation
+		if node != nil {
+			// The inner rows are looked up by the join itself rather than
+			// scanned by an input.
+			node.children = node.children[:1]
+			node.index, node.relation, node.alias = p.Index.Name, inner.Table.Name, inner.Name
+			node.prop("Index Cond", keysCondition(p.Keys[:1]))
+			node.prop("Join Filter", parser.Conjoin(rest))
+		}
+		tree := index.Open(e.bufferManager, p.Index.RootPageID)
+		heap := e.tableHeap(inner.Table)
+		return x.track(node, func() (RowIterator, error) {
+			outer, err := left()
+			if err != nil {
+				return nil, err
+			}
+			return &indexNestedLoopJoin{
+				ctx: ctx, outer: outer, joinType: joinType, outerKeys: leftKeys[:1],
+				tree: tree, heap: heap, table: inner.Table, columns: inner.Columns, residual: residual,
+				snapshot: snapshotFrom(ctx),
+			}, nil
+		}), out, node, nil
+	case planner.JoinHash:
+		node.prop("Hash Cond", keysCondition(p.Keys))
+		node.prop("Join Filter", p.Residual)
+		return x.track(node, func() (RowIterator, error) {
+			probe, err := left()
+			if err != nil {
+				return nil, err
+			}
+			build, err := right()
+			if err != nil {
+				probe.Close()
+				return nil, err
+			}
+			return &hashJoin{
+				ctx: ctx, bp: e.bufferManager, probe: probe, build: build, joinType: joinType,
+				probeKeys: leftKeys, buildKeys: rightKeys, residual: residual,
+				probeWidth: leftWidth, buildWidth: rightWidth, workMem: e.workMem,
+			}, nil
+		}), out, node, nil
+	case planner.JoinMerge:
+		node.prop("Merge Cond", keysCondition(p.Keys))
+		node.prop("Join Filter", p.Residual)
+		// Both inputs are sorted on their keys first.
+		var sortNodes [2]*explainNode
+		if node != nil {
+			leftItems := make([]parser.OrderByItem, len(p.Keys))
+			rightItems := make([]parser.OrderByItem, len(p.Keys))
+			for i, key := range p.Keys {
+				leftItems[i].Expr, rightItems[i].Expr = key.Left, key.Right
+			}
+			sides := []planner.Plan{p.Left, p.Right}
+			for i, items := range [][]parser.OrderByItem{leftItems, rightItems} {
+				estimate := planner.SortEstimate(sides[i].Estimated(), planner.Width(sides[i]), e.workMem, -1)
+				sortNodes[i] = sortNode(x, items, node.children[i], estimate)
+			}
+			node.children = sortNodes[:]
+		}
+		sortKeys := func(keys []evaluator) []sortKey {
+			sk := make([]sortKey, len(keys))
+			for i, key := range keys {
+				sk[i] = sortKey{eval: key}
+			}
+			return sk
+		}
+		return x.track(node, func() (RowIterator, error) {
+			l, err := left()
+			if err != nil {
+				return nil, err
+			}
+			r, err := right()
+			if err != nil {
+				l.Close()
+				return nil, err
+			}
+			return &mergeJoin{
+				left:     x.wrap(sortNodes[0], newSortOperator(ctx, e.bufferManager, l, sortKeys(leftKeys), e.workMem, -1)),
+				right:    x.wrap(sortNodes[1], newSortOperator(ctx, e.bufferManager, r, sortKeys(rightKeys), e.workMem, -1)),
+				joinType: joinType, leftKeys: leftKeys, rightKeys: rightKeys, residual: residual,
+				leftWidth: leftWidth, rightWidth: rightWidth,
+			}, nil
+		}), out, node, nil
+	}
+
+	// A nested loop join evaluates the whole condition on joined rows.
+	cond, err := compileOptional(p.Condition(), joined, params)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	node.prop("Join Filter", p.Condition())
+	return x.track(node, func() (RowIterator, error) {
+		outer, err := left()
+		if err != nil {
+			return nil, err
+		}
+		return &nestedLoopJoin{
+			ctx: ctx, outer: outer, inner: right, joinType: joinType, cond: cond,
+			outerWidth: leftWidth, innerWidth: rightWidth, workMem: e.workMem,
+		}, nil
+	}), out, node, nil
+}
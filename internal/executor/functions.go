@@ -0,0 +1,680 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// numeric is the parameter type of functions taking an INTEGER or a REAL
+// and keeping it as it is.
+const numeric types.Type = -1
+
+// builtin is a built-in scalar function.
+type builtin struct {
+	// params are the types the arguments are converted to. TypeNull accepts
+	// any type. The last optional parameters may be left out, and the last
+	// parameter of a variadic function repeats.
+	params   []types.Type
+	optional int
+	variadic bool
+	// result returns the type of the result given the types of the
+	// arguments.
+	result func(args []types.Type) (types.Type, error)
+	// nullable functions are called with NULL arguments. The others return
+	// NULL if any argument is NULL.
+	nullable bool
+	// volatile functions may return different results when called with the
+	// same arguments. Calls of the others whose arguments are the same for
+	// every row are made once per statement.
+	volatile bool
+	call     func(args []types.Value) (types.Value, error)
+}
+
+func returns(t types.Type) func([]types.Type) (types.Type, error) {
+	return func([]types.Type) (types.Type, error) { return t, nil }
+}
+
+func sameAsFirst(args []types.Type) (types.Type, error) {
+	return args[0], nil
+}
+
+func commonOf(name string) func([]types.Type) (types.Type, error) {
+	return func(args []types.Type) (types.Type, error) { return commonType(name, args) }
+}
+
+// param returns the type of the i-th parameter.
+func (fn *builtin) param(i int) types.Type {
+	if i >= len(fn.params) {
+		return fn.params[len(fn.params)-1]
+	}
+	return fn.params[i]
+}
+
+// accepts reports whether the function can be called with arguments of the
+// given types.
+func (fn *builtin) accepts(args []types.Type) bool {
+	if len(args) < len(fn.params)-fn.optional || (len(args) > len(fn.params) && !fn.variadic) {
+		return false
+	}
+	for i, arg := range args {
+		switch param := fn.param(i); {
+		case arg == types.TypeNull || param == types.TypeNull || arg == param:
+		case param == numeric || param == types.TypeReal:
+			if arg != types.TypeInteger && arg != types.TypeReal {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func signatureError(name string, args []types.Type) error {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = arg.String()
+	}
+	return fmt.Errorf("function %s(%s) does not exist", strings.ToLower(name), strings.Join(names, ", "))
+}
+
+// compileFuncCall compiles a call of a scalar function, checking the types
+// of its arguments.
+func compileFuncCall(e *parser.FuncCall, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	if e.IsAggregate() {
+		return nil, types.TypeNull, fmt.Errorf("aggregate function %s is not allowed here", e.Name)
+	}
+	if e.Over != nil {
+		return nil, types.TypeNull, fmt.Errorf("window function %s is not allowed here", e.Name)
+	}
+	if e.Func == nil && parser.IsBuiltinWindow(e.Name) {
+		return nil, types.TypeNull, fmt.Errorf("window function %s requires an OVER clause", e.Name)
+	}
+	fn, ok := e.Func.(*builtin)
+	if !ok && e.Func == nil && sequenceFunctions[e.Name] {
+		return compileSequenceCall(e, sc, params)
+	}
+	if !ok {
+		fn, ok = builtins[e.Name]
+	}
+	if !ok && e.Name != "COALESCE" {
+		return nil, types.TypeNull, fmt.Errorf("function %s does not exist", strings.ToLower(e.Name))
+	}
+	if e.Star || e.Distinct {
+		return nil, types.TypeNull, fmt.Errorf("%s is not an aggregate function", strings.ToLower(e.Name))
+	}
+	args := make([]evaluator, len(e.Args))
+	argTypes := make([]types.Type, len(e.Args))
+	for i, arg := range e.Args {
+		var err error
+		if args[i], argTypes[i], err = compileExpr(arg, sc, params); err != nil {
+			return nil, types.TypeNull, err
+		}
+	}
+	if e.Name == "COALESCE" {
+		return compileCoalesce(args, argTypes)
+	}
+	if !fn.accepts(argTypes) {
+		return nil, types.TypeNull, signatureError(e.Name, argTypes)
+	}
+	typ, err := fn.result(argTypes)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	values := make([]types.Value, len(args))
+	call := func(row Row) (types.Value, error) {
+		for i, arg := range args {
+			value, err := arg(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			if value.IsNull() {
+				if !fn.nullable {
+					return types.Null(), nil
+				}
+				values[i] = value
+				continue
+			}
+			switch param := fn.param(i); param {
+			case types.TypeNull:
+			case numeric:
+				if value.Type != types.TypeInteger && value.Type != types.TypeReal {
+					return types.Null(), fmt.Errorf("function %s does not accept %s", strings.ToLower(e.Name), value.Type)
+				}
+			default:
+				if value, err = value.Cast(param); err != nil {
+					return types.Null(), err
+				}
+			}
+			values[i] = value
+		}
+		result, err := fn.call(values)
+		if err != nil {
+			return types.Null(), err
+		}
+		return result.Cast(typ)
+	}
+	if fn.volatile {
+		return call, typ, nil
+	}
+	for _, arg := range e.Args {
+		if !constantExpr(arg) {
+			return call, typ, nil
+		}
+	}
+	// The arguments are the same for every row, so the result is too.
+	var done bool
+	var value types.Value
+	return func(row Row) (types.Value, error) {
+		if !done {
+			var err error
+			if value, err = call(row); err != nil {
+				return types.Null(), err
+			}
+			done = true
+		}
+		return value, nil
+	}, typ, nil
+}
+
+// constantExpr reports whether expr has the same value for every row of a
+// statement: it refers to no columns, calls no volatile or aggregate
+// functions and has no subqueries, which may be correlated.
+func constantExpr(expr parser.Expr) bool {
+	constant := true
+	parser.WalkExpr(expr, func(e parser.Expr) bool {
+		switch e := e.(type) {
+		case *parser.ColumnRef, *parser.SubqueryExpr, *parser.ExistsExpr:
+			constant = false
+		case *parser.InExpr:
+			constant = e.Subquery == nil
+		case *parser.FuncCall:
+			fn, ok := e.Func.(*builtin)
+			if !ok {
+				fn = builtins[e.Name]
+			}
+			constant = !e.IsAggregate() && (fn == nil || !fn.volatile) && !sequenceFunctions[e.Name]
+		}
+		return constant
+	})
+	return constant
+}
+
+// compileCoalesce compiles COALESCE, which returns its first argument that
+// isn't NULL without evaluating the rest.
+func compileCoalesce(args []evaluator, argTypes []types.Type) (evaluator, types.Type, error) {
+	if len(args) == 0 {
+		return nil, types.TypeNull, signatureError("COALESCE", argTypes)
+	}
+	typ, err := commonType("COALESCE", argTypes)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	return func(row Row) (types.Value, error) {
+		for _, arg := range args {
+			value, err := arg(row)
+			if err != nil || !value.IsNull() {
+				if err != nil {
+					return types.Null(), err
+				}
+				return value.Cast(typ)
+			}
+		}
+		return types.Null(), nil
+	}, typ, nil
+}
+
+// builtins are the built-in scalar functions by upper-cased name.
+var builtins = map[string]*builtin{}
+
+func init() {
+	text, integer, float, anyType := types.TypeText, types.TypeInteger, types.TypeReal, types.TypeNull
+	register := func(fn *builtin, names ...string) {
+		for _, name := range names {
+			builtins[name] = fn
+		}
+	}
+
+	// Strings. Lengths and positions count characters, not bytes.
+	register(&builtin{params: []types.Type{text}, result: returns(integer), call: func(args []types.Value) (types.Value, error) {
+		return types.NewInteger(int64(utf8.RuneCountInString(args[0].Str))), nil
+	}}, "LENGTH", "CHAR_LENGTH")
+	register(textFunction(strings.ToUpper), "UPPER")
+	register(textFunction(strings.ToLower), "LOWER")
+	register(textFunction(reverse), "REVERSE")
+	for name, trim := range map[string]func(string, string) string{
+		"TRIM": strings.Trim, "LTRIM": strings.TrimLeft, "RTRIM": strings.TrimRight,
+	} {
+		trim := trim
+		register(&builtin{params: []types.Type{text, text}, optional: 1, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+			chars := " "
+			if len(args) > 1 {
+				chars = args[1].Str
+			}
+			return types.NewText(trim(args[0].Str, chars)), nil
+		}}, name)
+	}
+	register(&builtin{params: []types.Type{text, integer, integer}, optional: 1, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+		runes := []rune(args[0].Str)
+		start, end := args[1].Int, int64(len(runes))+1
+		if len(args) > 2 {
+			if args[2].Int < 0 {
+				return types.Null(), errors.New("negative substring length not allowed")
+			}
+			end = start + args[2].Int
+		}
+		start, end = clampInt(start, 1, int64(len(runes))+1), clampInt(end, 1, int64(len(runes))+1)
+		if end < start {
+			end = start
+		}
+		return types.NewText(string(runes[start-1 : end-1])), nil
+	}}, "SUBSTR", "SUBSTRING")
+	register(&builtin{params: []types.Type{text, text, text}, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+		if args[1].Str == "" {
+			return args[0], nil
+		}
+		return types.NewText(strings.ReplaceAll(args[0].Str, args[1].Str, args[2].Str)), nil
+	}}, "REPLACE")
+	register(&builtin{params: []types.Type{anyType}, variadic: true, nullable: true, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			if !arg.IsNull() {
+				sb.WriteString(arg.String())
+			}
+		}
+		return types.NewText(sb.String()), nil
+	}}, "CONCAT")
+	for name, fromLeft := range map[string]bool{"LEFT": true, "RIGHT": false} {
+		fromLeft := fromLeft
+		register(&builtin{params: []types.Type{text, integer}, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+			runes := []rune(args[0].Str)
+			// A negative count leaves out that many characters instead.
+			n := args[1].Int
+			if n < 0 {
+				n += int64(len(runes))
+			}
+			n = clampInt(n, 0, int64(len(runes)))
+			if fromLeft {
+				return types.NewText(string(runes[:n])), nil
+			}
+			return types.NewText(string(runes[int64(len(runes))-n:])), nil
+		}}, name)
+	}
+	register(&builtin{params: []types.Type{text, text}, result: returns(integer), call: func(args []types.Value) (types.Value, error) {
+		pos := strings.Index(args[0].Str, args[1].Str)
+		if pos < 0 {
+			return types.NewInteger(0), nil
+		}
+		return types.NewInteger(int64(utf8.RuneCountInString(args[0].Str[:pos])) + 1), nil
+	}}, "STRPOS")
+	register(&builtin{params: []types.Type{text, integer}, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+		if args[1].Int <= 0 {
+			return types.NewText(""), nil
+		}
+		return types.NewText(strings.Repeat(args[0].Str, int(args[1].Int))), nil
+	}}, "REPEAT")
+	for name, left := range map[string]bool{"LPAD": true, "RPAD": false} {
+		left := left
+		register(&builtin{params: []types.Type{text, integer, text}, optional: 1, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+			fill := " "
+			if len(args) > 2 {
+				fill = args[2].Str
+			}
+			return types.NewText(pad(args[0].Str, int(clampInt(args[1].Int, 0, math.MaxInt32)), fill, left)), nil
+		}}, name)
+	}
+
+	// Numbers.
+	register(&builtin{params: []types.Type{numeric}, result: sameAsFirst, call: func(args []types.Value) (types.Value, error) {
+		if args[0].Type == types.TypeReal {
+			return types.NewReal(math.Abs(args[0].Float)), nil
+		}
+		if args[0].Int < 0 {
+			return types.Arithmetic("-", types.NewInteger(0), args[0])
+		}
+		return args[0], nil
+	}}, "ABS")
+	register(&builtin{params: []types.Type{numeric}, result: sameAsFirst, call: func(args []types.Value) (types.Value, error) {
+		switch {
+		case args[0].Type == types.TypeInteger:
+			return types.NewInteger(compareInt(args[0].Int, 0)), nil
+		case args[0].Float > 0:
+			return types.NewReal(1), nil
+		case args[0].Float < 0:
+			return types.NewReal(-1), nil
+		}
+		return types.NewReal(0), nil
+	}}, "SIGN")
+	for name, round := range map[string]func(float64) float64{
+		"ROUND": math.Round, "TRUNC": math.Trunc,
+	} {
+		round := round
+		register(&builtin{params: []types.Type{numeric, integer}, optional: 1, result: sameAsFirst, call: func(args []types.Value) (types.Value, error) {
+			var digits int64
+			if len(args) > 1 {
+				digits = args[1].Int
+			}
+			if args[0].Type == types.TypeInteger && digits >= 0 {
+				return args[0], nil
+			}
+			scale := math.Pow(10, float64(clampInt(digits, -308, 308)))
+			value := round(toFloat(args[0])*scale) / scale
+			if args[0].Type == types.TypeInteger {
+				return types.NewReal(value).Cast(types.TypeInteger)
+			}
+			return types.NewReal(value), nil
+		}}, name)
+	}
+	for name, round := range map[string]func(float64) float64{
+		"CEIL": math.Ceil, "CEILING": math.Ceil, "FLOOR": math.Floor,
+	} {
+		round := round
+		register(&builtin{params: []types.Type{numeric}, result: sameAsFirst, call: func(args []types.Value) (types.Value, error) {
+			if args[0].Type == types.TypeInteger {
+				return args[0], nil
+			}
+			return types.NewReal(round(args[0].Float)), nil
+		}}, name)
+	}
+	register(&builtin{params: []types.Type{numeric, numeric}, result: func(args []types.Type) (types.Type, error) {
+		return types.ArithmeticType("%", args[0], args[1])
+	}, call: func(args []types.Value) (types.Value, error) {
+		return types.Arithmetic("%", args[0], args[1])
+	}}, "MOD")
+	register(realFunction(func(x float64) (float64, error) {
+		if x < 0 {
+			return 0, errors.New("cannot take square root of a negative number")
+		}
+		return math.Sqrt(x), nil
+	}), "SQRT")
+	register(realFunction(func(x float64) (float64, error) { return math.Exp(x), nil }), "EXP")
+	register(realFunction(logarithm), "LN")
+	register(&builtin{params: []types.Type{float, float}, optional: 1, result: returns(float), call: func(args []types.Value) (types.Value, error) {
+		// LOG(x) is the base 10 logarithm, LOG(b, x) the base b one.
+		base, x := 10.0, args[0].Float
+		if len(args) > 1 {
+			base, x = args[0].Float, args[1].Float
+		}
+		lx, err := logarithm(x)
+		if err != nil {
+			return types.Null(), err
+		}
+		lb, err := logarithm(base)
+		if err != nil {
+			return types.Null(), err
+		}
+		if lb == 0 {
+			return types.Null(), types.ErrDivisionByZero
+		}
+		return types.NewReal(lx / lb), nil
+	}}, "LOG")
+	register(&builtin{params: []types.Type{float, float}, result: returns(float), call: func(args []types.Value) (types.Value, error) {
+		x, y := args[0].Float, args[1].Float
+		if x == 0 && y < 0 {
+			return types.Null(), errors.New("zero raised to a negative power is undefined")
+		}
+		if x < 0 && y != math.Trunc(y) {
+			return types.Null(), errors.New("a negative number raised to a non-integer power yields a complex result")
+		}
+		return types.NewReal(math.Pow(x, y)), nil
+	}}, "POWER", "POW")
+	register(&builtin{params: []types.Type{}, result: returns(float), call: func([]types.Value) (types.Value, error) {
+		return types.NewReal(math.Pi), nil
+	}}, "PI")
+	register(&builtin{params: []types.Type{}, result: returns(float), volatile: true, call: func([]types.Value) (types.Value, error) {
+		return types.NewReal(rand.Float64()), nil
+	}}, "RANDOM")
+
+	// Comparisons.
+	for name, sign := range map[string]int{"GREATEST": 1, "LEAST": -1} {
+		name, sign := name, sign
+		// NULL arguments are ignored.
+		register(&builtin{params: []types.Type{anyType}, variadic: true, nullable: true, result: commonOf(name), call: func(args []types.Value) (types.Value, error) {
+			best := types.Null()
+			for _, arg := range args {
+				if arg.IsNull() {
+					continue
+				}
+				if best.IsNull() {
+					best = arg
+					continue
+				}
+				cmp, err := types.Compare(arg, best)
+				if err != nil {
+					return types.Null(), err
+				}
+				if cmp*sign > 0 {
+					best = arg
+				}
+			}
+			return best, nil
+		}}, name)
+	}
+	register(&builtin{params: []types.Type{anyType, anyType}, nullable: true, result: func(args []types.Type) (types.Type, error) {
+		if !comparable(args[0], args[1]) {
+			return types.TypeNull, fmt.Errorf("operator does not exist: %s = %s", args[0], args[1])
+		}
+		return args[0], nil
+	}, call: func(args []types.Value) (types.Value, error) {
+		if args[0].IsNull() || args[1].IsNull() {
+			return args[0], nil
+		}
+		cmp, err := types.Compare(args[0], args[1])
+		if err != nil || cmp == 0 {
+			return types.Null(), err
+		}
+		return args[0], nil
+	}}, "NULLIF")
+
+	// Dates and times, represented as TEXT such as '2024-01-31 13:45:00'.
+	for name, layout := range map[string]string{
+		"NOW": timestampLayout, "CURRENT_TIMESTAMP": timestampLayout,
+		"CURRENT_DATE": dateLayout, "CURRENT_TIME": "15:04:05",
+	} {
+		layout := layout
+		register(&builtin{params: []types.Type{}, result: returns(text), call: func([]types.Value) (types.Value, error) {
+			return types.NewText(time.Now().Format(layout)), nil
+		}}, name)
+	}
+	register(&builtin{params: []types.Type{text}, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+		t, err := parseTimestamp(args[0].Str)
+		if err != nil {
+			return types.Null(), err
+		}
+		return types.NewText(t.Format(dateLayout)), nil
+	}}, "DATE")
+	register(&builtin{params: []types.Type{text, text}, result: returns(float), call: func(args []types.Value) (types.Value, error) {
+		t, err := parseTimestamp(args[1].Str)
+		if err != nil {
+			return types.Null(), err
+		}
+		part, err := datePart(strings.ToLower(args[0].Str), t)
+		if err != nil {
+			return types.Null(), err
+		}
+		return types.NewReal(part), nil
+	}}, "DATE_PART")
+	register(&builtin{params: []types.Type{text, text}, result: returns(text), call: func(args []types.Value) (types.Value, error) {
+		t, err := parseTimestamp(args[1].Str)
+		if err != nil {
+			return types.Null(), err
+		}
+		truncated, err := dateTrunc(strings.ToLower(args[0].Str), t)
+		if err != nil {
+			return types.Null(), err
+		}
+		return types.NewText(truncated.Format(timestampLayout)), nil
+	}}, "DATE_TRUNC")
+}
+
+// textFunction is a function from TEXT to TEXT.
+func textFunction(fn func(string) string) *builtin {
+	return &builtin{params: []types.Type{types.TypeText}, result: returns(types.TypeText), call: func(args []types.Value) (types.Value, error) {
+		return types.NewText(fn(args[0].Str)), nil
+	}}
+}
+
+// realFunction is a function from REAL to REAL.
+func realFunction(fn func(float64) (float64, error)) *builtin {
+	return &builtin{params: []types.Type{types.TypeReal}, result: returns(types.TypeReal), call: func(args []types.Value) (types.Value, error) {
+		result, err := fn(args[0].Float)
+		if err != nil {
+			return types.Null(), err
+		}
+		return types.NewReal(result), nil
+	}}
+}
+
+func logarithm(x float64) (float64, error) {
+	switch {
+	case x == 0:
+		return 0, errors.New("cannot take logarithm of zero")
+	case x < 0:
+		return 0, errors.New("cannot take logarithm of a negative number")
+	}
+	return math.Log(x), nil
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// pad fills s to length characters with repetitions of fill, or truncates
+// it to length.
+func pad(s string, length int, fill string, left bool) string {
+	runes := []rune(s)
+	if len(runes) >= length {
+		return string(runes[:length])
+	}
+	fillRunes := []rune(fill)
+	if len(fillRunes) == 0 {
+		return s
+	}
+	padding := make([]rune, length-len(runes))
+	for i := range padding {
+		padding[i] = fillRunes[i%len(fillRunes)]
+	}
+	if left {
+		return string(padding) + s
+	}
+	return s + string(padding)
+}
+
+func clampInt(x, low, high int64) int64 {
+	if x < low {
+		return low
+	}
+	if x > high {
+		return high
+	}
+	return x
+}
+
+func compareInt(a, b int64) int64 {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v types.Value) float64 {
+	if v.Type == types.TypeInteger {
+		return float64(v.Int)
+	}
+	return v.Float
+}
+
+const (
+	dateLayout      = "2006-01-02"
+	timestampLayout = "2006-01-02 15:04:05"
+)
+
+// timestampLayouts are the accepted forms of dates and timestamps.
+var timestampLayouts = []string{
+	timestampLayout,
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"2006-01-02 15:04",
+	dateLayout,
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid input syntax for type timestamp: %q", s)
+}
+
+// datePart returns a field of a timestamp, as EXTRACT does.
+func datePart(field string, t time.Time) (float64, error) {
+	switch field {
+	case "year":
+		return float64(t.Year()), nil
+	case "quarter":
+		return float64((int(t.Month())-1)/3 + 1), nil
+	case "month":
+		return float64(t.Month()), nil
+	case "week":
+		_, week := t.ISOWeek()
+		return float64(week), nil
+	case "day":
+		return float64(t.Day()), nil
+	case "dow":
+		return float64(t.Weekday()), nil
+	case "doy":
+		return float64(t.YearDay()), nil
+	case "hour":
+		return float64(t.Hour()), nil
+	case "minute":
+		return float64(t.Minute()), nil
+	case "second":
+		return float64(t.Second()) + float64(t.Nanosecond())/1e9, nil
+	case "epoch":
+		return float64(t.UnixNano()) / 1e9, nil
+	}
+	return 0, fmt.Errorf("unit %q not recognized", field)
+}
+
+// dateTrunc truncates a timestamp to the start of the given unit.
+func dateTrunc(field string, t time.Time) (time.Time, error) {
+	year, month, day := t.Date()
+	switch field {
+	case "year":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	case "quarter":
+		return time.Date(year, (month-1)/3*3+1, 1, 0, 0, 0, 0, t.Location()), nil
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location()), nil
+	case "week":
+		// Weeks start on Monday.
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location()), nil
+	case "day":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location()), nil
+	case "hour":
+		return t.Truncate(time.Hour), nil
+	case "minute":
+		return t.Truncate(time.Minute), nil
+	case "second":
+		return t.Truncate(time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("unit %q not recognized", field)
+}
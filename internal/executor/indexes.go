@@ -0,0 +1,128 @@
+package executor
+
+import (
+	"fmt"
+
+	"context"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// ExecuteCreateIndexStatement builds a B+ tree over the existing rows of a
+// table and registers it in the catalog.
+func (e *Executor) ExecuteCreateIndexStatement(ctx context.Context, t *txn.Transaction, createIdx *parser.CreateIndexStatement) (*Result, error) {
+	table, err := e.catalog.GetTable(createIdx.TableName)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := index.Create(e.bufferManager)
+	if err != nil {
+		return nil, err
+	}
+	def := &catalog.Index{Name: createIdx.IndexName, Columns: createIdx.Columns, RootPageID: tree.RootPageID()}
+	// Registering first validates the name and columns before any work.
+	if err := e.catalog.CreateIndex(table.Name, def); err != nil {
+		return nil, err
+	}
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoCreateIndex, Table: table.Name, IndexDef: def})
+	if err := e.fillIndex(ctx, table, def, tree); err != nil {
+		return nil, err
+	}
+	return &Result{}, nil
+}
+
+// fillIndex adds the rows of a table to a new index. Every version is
+// indexed, for the snapshots that still see old ones.
+func (e *Executor) fillIndex(ctx context.Context, table *catalog.Table, def *catalog.Index, tree *index.BTree) error {
+	iter := e.tableHeap(table).Iterator()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rid, data, err := iter.Next()
+		if err != nil || data == nil {
+			return err
+		}
+		row, err := decodeRow(table, data)
+		if err != nil {
+			return err
+		}
+		if err := tree.Insert(indexKey(table, def, row), rid); err != nil {
+			return err
+		}
+	}
+}
+
+// ExecuteDropIndexStatement removes an index from the catalog.
+func (e *Executor) ExecuteDropIndexStatement(t *txn.Transaction, dropIdx *parser.DropIndexStatement) (*Result, error) {
+	table, def, err := e.catalog.FindIndex(dropIdx.IndexName)
+	if err != nil {
+		return nil, err
+	}
+	if constraint := table.Constraint(def.Name); constraint != nil {
+		return nil, fmt.Errorf("cannot drop index %s because constraint %s on table %s requires it", def.Name, constraint.Name, table.Name)
+	}
+	if err := e.catalog.DropIndex(def.Name); err != nil {
+		return nil, err
+	}
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoDropIndex, Table: table.Name, IndexDef: def})
+	return &Result{}, nil
+}
+
+// IndexTable returns the name of the table an index belongs to.
+func (e *Executor) IndexTable(name string) (string, error) {
+	table, _, err := e.catalog.FindIndex(name)
+	if err != nil {
+		return "", err
+	}
+	return table.Name, nil
+}
+
+// indexKey extracts the key of a row for an index.
+func indexKey(table *catalog.Table, def *catalog.Index, row Row) index.Key {
+	key := make(index.Key, len(def.Columns))
+	for i, col := range def.Columns {
+		key[i] = row[table.ColumnIndex(col)]
+	}
+	return key
+}
+
+// insertIndexEntries adds a row stored at rid to every index of its table.
+func (e *Executor) insertIndexEntries(table *catalog.Table, row Row, rid storage.RID) error {
+	for _, def := range table.Indexes {
+		if err := index.Open(e.bufferManager, def.RootPageID).Insert(indexKey(table, def, row), rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIndexEntries removes a row stored at rid from every index of its table.
+func (e *Executor) deleteIndexEntries(table *catalog.Table, row Row, rid storage.RID) error {
+	for _, def := range table.Indexes {
+		if err := index.Open(e.bufferManager, def.RootPageID).Delete(indexKey(table, def, row), rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStoredIndexEntries unindexes the row currently stored at rid.
+func (e *Executor) deleteStoredIndexEntries(table *catalog.Table, rid storage.RID) error {
+	if len(table.Indexes) == 0 {
+		return nil
+	}
+	data, err := e.tableHeap(table).Get(rid)
+	if err != nil {
+		return err
+	}
+	row, err := decodeRow(table, data)
+	if err != nil {
+		return err
+	}
+	return e.deleteIndexEntries(table, row, rid)
+}
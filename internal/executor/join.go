@@ -0,0 +1,800 @@
+package executor
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// rowSource opens a new iterator over the same rows each time it is called,
+// letting nested loop joins scan their inner side repeatedly.
+type rowSource func() (RowIterator, error)
+
+// nullRow is the padding for the missing side of an outer join row.
+func nullRow(width int) Row {
+	row := make(Row, width)
+	for i := range row {
+		row[i] = types.Null()
+	}
+	return row
+}
+
+func concatRows(left, right Row) Row {
+	row := make(Row, 0, len(left)+len(right))
+	return append(append(row, left...), right...)
+}
+
+func evalKeys(keys []evaluator, row Row) (Row, error) {
+	values := make(Row, len(keys))
+	for i, key := range keys {
+		var err error
+		if values[i], err = key(row); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func hasNull(values Row) bool {
+	for _, value := range values {
+		if value.IsNull() {
+			return true
+		}
+	}
+	return false
+}
+
+// hashJoinKey encodes join key values so that equal numbers encode the same
+// whether they are stored as integers or reals.
+func hashJoinKey(values Row) string {
+	normalized := make(Row, len(values))
+	for i, value := range values {
+		if value.Type == types.TypeReal && value.Float == math.Trunc(value.Float) &&
+			value.Float >= math.MinInt64 && value.Float <= math.MaxInt64 {
+			value = types.NewInteger(int64(value.Float))
+		}
+		normalized[i] = value
+	}
+	return string(encodeRow(normalized))
+}
+
+// filtersOuter reports whether a join only filters the rows of its left
+// input, by whether they have a match, rather than joining them.
+func filtersOuter(joinType parser.JoinType) bool {
+	return joinType == parser.JoinSemi || joinType == parser.JoinAnti
+}
+
+// compareKeyRows compares two tuples of non-NULL join keys.
+func compareKeyRows(a, b Row) (int, error) {
+	for i := range a {
+		cmp, err := types.Compare(a[i], b[i])
+		if err != nil || cmp != 0 {
+			return cmp, err
+		}
+	}
+	return 0, nil
+}
+
+// nestedLoopJoin is a block nested loop join. It reads a block of outer rows
+// that fits in workMem and joins it against one scan of the inner side, so
+// the inner side is scanned once per block rather than once per row. It
+// evaluates any join condition and supports inner, left, full, cross, semi
+// and anti joins.
+type nestedLoopJoin struct {
+	ctx        context.Context
+	outer      RowIterator
+	inner      rowSource
+	joinType   parser.JoinType
+	cond       evaluator
+	outerWidth int
+	innerWidth int
+	workMem    int
+
+	block        []Row
+	blockMatched []bool
+	innerIter    RowIterator
+	innerPos     int
+	innerMatched []bool // FULL joins: inner rows matched by any block
+	outerDone    bool
+	finalIter    RowIterator // FULL joins: the scan for unmatched inner rows
+	pending      []Row
+}
+
+func (j *nestedLoopJoin) Next() (Row, error) {
+	for {
+		if err := j.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(j.pending) > 0 {
+			row := j.pending[0]
+			j.pending = j.pending[1:]
+			return row, nil
+		}
+		if j.outerDone {
+			return j.nextUnmatchedInner()
+		}
+		if j.innerIter == nil {
+			if err := j.loadBlock(); err != nil {
+				return nil, err
+			}
+			if len(j.block) == 0 {
+				j.outerDone = true
+				continue
+			}
+			iter, err := j.inner()
+			if err != nil {
+				return nil, err
+			}
+			j.innerIter, j.innerPos = iter, 0
+		}
+		innerRow, err := j.innerIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if innerRow == nil {
+			if err := j.innerIter.Close(); err != nil {
+				return nil, err
+			}
+			j.innerIter = nil
+			switch j.joinType {
+			case parser.JoinLeft, parser.JoinFull:
+				for i, outerRow := range j.block {
+					if !j.blockMatched[i] {
+						j.pending = append(j.pending, concatRows(outerRow, nullRow(j.innerWidth)))
+					}
+				}
+			case parser.JoinSemi, parser.JoinAnti:
+				for i, outerRow := range j.block {
+					if j.blockMatched[i] == (j.joinType == parser.JoinSemi) {
+						j.pending = append(j.pending, outerRow)
+					}
+				}
+			}
+			j.block = nil
+			continue
+		}
+		if j.joinType == parser.JoinFull && j.innerPos >= len(j.innerMatched) {
+			j.innerMatched = append(j.innerMatched, false)
+		}
+		for i, outerRow := range j.block {
+			if j.blockMatched[i] && filtersOuter(j.joinType) {
+				continue
+			}
+			row := concatRows(outerRow, innerRow)
+			ok, err := satisfies(j.cond, row)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				j.blockMatched[i] = true
+				if j.joinType == parser.JoinFull {
+					j.innerMatched[j.innerPos] = true
+				}
+				if !filtersOuter(j.joinType) {
+					j.pending = append(j.pending, row)
+				}
+			}
+		}
+		j.innerPos++
+	}
+}
+
+func (j *nestedLoopJoin) loadBlock() error {
+	used := 0
+	for used < j.workMem {
+		row, err := j.outer.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		j.block = append(j.block, row)
+		used += rowSize(row)
+	}
+	j.blockMatched = make([]bool, len(j.block))
+	return nil
+}
+
+// nextUnmatchedInner returns the inner rows no outer row matched, padded with
+// NULLs, once the outer side is exhausted in a FULL join.
+func (j *nestedLoopJoin) nextUnmatchedInner() (Row, error) {
+	if j.joinType != parser.JoinFull {
+		return nil, nil
+	}
+	if j.finalIter == nil {
+		iter, err := j.inner()
+		if err != nil {
+			return nil, err
+		}
+		j.finalIter, j.innerPos = iter, 0
+	}
+	for {
+		innerRow, err := j.finalIter.Next()
+		if err != nil || innerRow == nil {
+			return nil, err
+		}
+		pos := j.innerPos
+		j.innerPos++
+		if pos >= len(j.innerMatched) || !j.innerMatched[pos] {
+			return concatRows(nullRow(j.outerWidth), innerRow), nil
+		}
+	}
+}
+
+func (j *nestedLoopJoin) Close() error {
+	err := j.outer.Close()
+	for _, iter := range []RowIterator{j.innerIter, j.finalIter} {
+		if iter != nil {
+			if closeErr := iter.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// indexNestedLoopJoin probes a B+ tree index on the inner table with the key
+// of each outer row and fetches the matching inner rows visible through a
+// snapshot from the heap. It supports inner, left, semi and anti joins.
+type indexNestedLoopJoin struct {
+	ctx       context.Context
+	outer     RowIterator
+	joinType  parser.JoinType
+	outerKeys []evaluator
+	tree      *index.BTree
+	heap      *storage.TableHeap
+	table     *catalog.Table
+	columns   []bool // columns of table to decode, nil for all
+	residual  evaluator
+	snapshot  *txn.Snapshot
+
+	current Row
+	key     index.Key
+	iter    *index.Iterator
+	matched bool
+}
+
+func (j *indexNestedLoopJoin) Next() (Row, error) {
+	for {
+		if err := j.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if j.current == nil {
+			row, err := j.outer.Next()
+			if err != nil || row == nil {
+				return nil, err
+			}
+			keys, err := evalKeys(j.outerKeys, row)
+			if err != nil {
+				return nil, err
+			}
+			j.current, j.matched, j.iter = row, false, nil
+			if !hasNull(keys) {
+				j.key = index.Key(keys)
+				if j.iter, err = j.tree.Seek(j.key); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if j.iter != nil {
+			entry, ok, err := j.iter.Next()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				cmp, err := index.CompareKeys(entry.Key, j.key)
+				if err != nil {
+					return nil, err
+				}
+				if cmp == 0 {
+					data, ok, err := getVersion(j.heap, entry.RID)
+					if err != nil {
+						return nil, err
+					}
+					if !ok || !visible(j.snapshot, data) {
+						continue
+					}
+					innerRow, err := decodeColumns(j.table, data, j.columns)
+					if err != nil {
+						return nil, err
+					}
+					row := concatRows(j.current, innerRow)
+					if ok, err := satisfies(j.residual, row); err != nil || !ok {
+						if err != nil {
+							return nil, err
+						}
+						continue
+					}
+					j.matched = true
+					if !filtersOuter(j.joinType) {
+						return row, nil
+					}
+				}
+			}
+			j.iter = nil
+		}
+		outerRow := j.current
+		j.current = nil
+		switch {
+		case j.joinType == parser.JoinLeft && !j.matched:
+			return concatRows(outerRow, nullRow(len(j.table.Columns))), nil
+		case filtersOuter(j.joinType) && j.matched == (j.joinType == parser.JoinSemi):
+			return outerRow, nil
+		}
+	}
+}
+
+func (j *indexNestedLoopJoin) Close() error {
+	return j.outer.Close()
+}
+
+// hashBuildEntry is a row of the build side of a hash join.
+type hashBuildEntry struct {
+	row     Row
+	matched bool
+}
+
+// hashJoin builds a hash table on the right input and probes it with the
+// rows of the left input. When the build side exceeds workMem it falls back
+// to a grace hash join: both inputs are partitioned by key hash into
+// temporary heaps and each pair of partitions is joined on its own. It
+// supports inner, left, full, semi and anti joins.
+type hashJoin struct {
+	ctx        context.Context
+	bp         *storage.BufferPool
+	probe      RowIterator
+	build      RowIterator
+	joinType   parser.JoinType
+	probeKeys  []evaluator
+	buildKeys  []evaluator
+	residual   evaluator
+	probeWidth int
+	buildWidth int
+	workMem    int
+	depth      int
+
+	built      bool
+	table      map[string][]*hashBuildEntry
+	entries    []*hashBuildEntry // every build row, for FULL joins
+	probeRow   Row
+	candidates []*hashBuildEntry
+	matched    bool
+	probeDone  bool
+	unmatched  int // position in entries of the FULL join's final pass
+
+	partitions []joinPartition
+	current    *hashJoin
+}
+
+// joinPartition holds the rows of both inputs whose keys hash alike.
+type joinPartition struct {
+	probe *storage.TableHeap
+	build *storage.TableHeap
+}
+
+func (j *hashJoin) Next() (Row, error) {
+	if !j.built {
+		j.built = true
+		if err := j.buildTable(); err != nil {
+			return nil, err
+		}
+	}
+	if j.partitions != nil || j.current != nil {
+		return j.nextPartitioned()
+	}
+	for {
+		if err := j.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if j.probeRow != nil {
+			for len(j.candidates) > 0 {
+				entry := j.candidates[0]
+				j.candidates = j.candidates[1:]
+				row := concatRows(j.probeRow, entry.row)
+				ok, err := satisfies(j.residual, row)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					j.matched = true
+					entry.matched = true
+					if filtersOuter(j.joinType) {
+						j.candidates = nil
+						break
+					}
+					return row, nil
+				}
+			}
+			probeRow := j.probeRow
+			j.probeRow = nil
+			switch {
+			case !j.matched && (j.joinType == parser.JoinLeft || j.joinType == parser.JoinFull):
+				return concatRows(probeRow, nullRow(j.buildWidth)), nil
+			case filtersOuter(j.joinType) && j.matched == (j.joinType == parser.JoinSemi):
+				return probeRow, nil
+			}
+		}
+		if j.probeDone {
+			return j.nextUnmatchedBuild(), nil
+		}
+		row, err := j.probe.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			j.probeDone = true
+			continue
+		}
+		keys, err := evalKeys(j.probeKeys, row)
+		if err != nil {
+			return nil, err
+		}
+		j.probeRow, j.matched, j.candidates = row, false, nil
+		if !hasNull(keys) {
+			j.candidates = j.table[hashJoinKey(keys)]
+		}
+	}
+}
+
+// nextUnmatchedBuild returns the build rows no probe row matched, padded
+// with NULLs, once probing is over in a FULL join.
+func (j *hashJoin) nextUnmatchedBuild() Row {
+	if j.joinType != parser.JoinFull {
+		return nil
+	}
+	for j.unmatched < len(j.entries) {
+		entry := j.entries[j.unmatched]
+		j.unmatched++
+		if !entry.matched {
+			return concatRows(nullRow(j.probeWidth), entry.row)
+		}
+	}
+	return nil
+}
+
+func (j *hashJoin) buildTable() error {
+	j.table = make(map[string][]*hashBuildEntry)
+	used := 0
+	for {
+		row, err := j.build.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return nil
+		}
+		if j.partitions != nil {
+			if err := j.spill(row, j.buildKeys, false); err != nil {
+				return err
+			}
+			continue
+		}
+		keys, err := evalKeys(j.buildKeys, row)
+		if err != nil {
+			return err
+		}
+		entry := &hashBuildEntry{row: row}
+		j.entries = append(j.entries, entry)
+		if !hasNull(keys) {
+			key := hashJoinKey(keys)
+			j.table[key] = append(j.table[key], entry)
+		}
+		used += rowSize(row) + 32
+		if used > j.workMem && j.depth < maxAggDepth {
+			if err := j.startPartitioning(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startPartitioning moves the build rows read so far into partitions; the
+// rest of both inputs is partitioned as it is read.
+func (j *hashJoin) startPartitioning() error {
+	j.partitions = make([]joinPartition, aggPartitions)
+	for i := range j.partitions {
+		probe, err := storage.CreateTempHeap(j.bp)
+		if err != nil {
+			return err
+		}
+		build, err := storage.CreateTempHeap(j.bp)
+		if err != nil {
+			probe.Free()
+			return err
+		}
+		j.partitions[i] = joinPartition{probe: probe, build: build}
+	}
+	for _, entry := range j.entries {
+		if err := j.spill(entry.row, j.buildKeys, false); err != nil {
+			return err
+		}
+	}
+	j.entries, j.table = nil, nil
+	return nil
+}
+
+// spill writes a row to the partition its key hashes to. Rows with NULL keys
+// match nothing and all go to the first partition.
+func (j *hashJoin) spill(row Row, keyEvals []evaluator, probe bool) error {
+	keys, err := evalKeys(keyEvals, row)
+	if err != nil {
+		return err
+	}
+	partition := 0
+	if !hasNull(keys) {
+		hash := fnv.New64a()
+		hash.Write([]byte{byte(j.depth)})
+		hash.Write([]byte(hashJoinKey(keys)))
+		partition = int(hash.Sum64() % aggPartitions)
+	}
+	heap := j.partitions[partition].build
+	if probe {
+		heap = j.partitions[partition].probe
+	}
+	_, err = heap.Insert(encodeRow(row))
+	return err
+}
+
+func (j *hashJoin) nextPartitioned() (Row, error) {
+	if !j.probeDone {
+		j.probeDone = true
+		for {
+			row, err := j.probe.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				break
+			}
+			if err := j.spill(row, j.probeKeys, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for {
+		if j.current != nil {
+			row, err := j.current.Next()
+			if err != nil || row != nil {
+				return row, err
+			}
+			if err := j.current.Close(); err != nil {
+				return nil, err
+			}
+			j.current = nil
+		}
+		if len(j.partitions) == 0 {
+			return nil, nil
+		}
+		partition := j.partitions[0]
+		j.partitions = j.partitions[1:]
+		j.current = &hashJoin{
+			ctx:        j.ctx,
+			bp:         j.bp,
+			probe:      &partitionScan{tempScan: newTempScan(j.ctx, partition.probe), heap: partition.probe},
+			build:      &partitionScan{tempScan: newTempScan(j.ctx, partition.build), heap: partition.build},
+			joinType:   j.joinType,
+			probeKeys:  j.probeKeys,
+			buildKeys:  j.buildKeys,
+			residual:   j.residual,
+			probeWidth: j.probeWidth,
+			buildWidth: j.buildWidth,
+			workMem:    j.workMem,
+			depth:      j.depth + 1,
+		}
+	}
+}
+
+func (j *hashJoin) Close() error {
+	err := j.probe.Close()
+	if closeErr := j.build.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if j.current != nil {
+		if closeErr := j.current.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		j.current = nil
+	}
+	for _, partition := range j.partitions {
+		for _, heap := range []*storage.TableHeap{partition.probe, partition.build} {
+			if freeErr := heap.Free(); freeErr != nil && err == nil {
+				err = freeErr
+			}
+		}
+	}
+	j.partitions = nil
+	return err
+}
+
+// mergeJoin joins two inputs sorted on their join keys by advancing through
+// both in step. The right rows sharing a key are buffered so every left row
+// with that key can be joined with them. It supports inner, left and full
+// joins.
+type mergeJoin struct {
+	left       RowIterator
+	right      RowIterator
+	joinType   parser.JoinType
+	leftKeys   []evaluator
+	rightKeys  []evaluator
+	residual   evaluator
+	leftWidth  int
+	rightWidth int
+
+	started      bool
+	leftRow      Row
+	leftKey      Row
+	rightRow     Row
+	rightKey     Row
+	group        []Row
+	groupMatched []bool
+	groupKey     Row
+	pending      []Row
+}
+
+func (j *mergeJoin) advanceLeft() error {
+	row, err := j.left.Next()
+	if err != nil {
+		return err
+	}
+	j.leftRow, j.leftKey = row, nil
+	if row != nil {
+		j.leftKey, err = evalKeys(j.leftKeys, row)
+	}
+	return err
+}
+
+func (j *mergeJoin) advanceRight() error {
+	row, err := j.right.Next()
+	if err != nil {
+		return err
+	}
+	j.rightRow, j.rightKey = row, nil
+	if row != nil {
+		j.rightKey, err = evalKeys(j.rightKeys, row)
+	}
+	return err
+}
+
+// flushGroup drops the buffered right rows, first emitting those no left row
+// matched in a FULL join.
+func (j *mergeJoin) flushGroup() {
+	if j.joinType == parser.JoinFull {
+		for i, row := range j.group {
+			if !j.groupMatched[i] {
+				j.pending = append(j.pending, concatRows(nullRow(j.leftWidth), row))
+			}
+		}
+	}
+	j.group, j.groupMatched, j.groupKey = nil, nil, nil
+}
+
+func (j *mergeJoin) emitLeftUnmatched() {
+	if j.joinType == parser.JoinLeft || j.joinType == parser.JoinFull {
+		j.pending = append(j.pending, concatRows(j.leftRow, nullRow(j.rightWidth)))
+	}
+}
+
+func (j *mergeJoin) emitRightUnmatched() {
+	if j.joinType == parser.JoinFull {
+		j.pending = append(j.pending, concatRows(nullRow(j.leftWidth), j.rightRow))
+	}
+}
+
+func (j *mergeJoin) Next() (Row, error) {
+	if !j.started {
+		j.started = true
+		if err := j.advanceLeft(); err != nil {
+			return nil, err
+		}
+		if err := j.advanceRight(); err != nil {
+			return nil, err
+		}
+	}
+	for len(j.pending) == 0 {
+		if err := j.step(); err != nil {
+			return nil, err
+		}
+		if j.leftRow == nil && j.rightRow == nil && j.group == nil && len(j.pending) == 0 {
+			return nil, nil
+		}
+	}
+	row := j.pending[0]
+	j.pending = j.pending[1:]
+	return row, nil
+}
+
+// step consumes input until it has produced output or exhausted both sides.
+func (j *mergeJoin) step() error {
+	if j.leftRow == nil {
+		j.flushGroup()
+		if j.rightRow != nil {
+			j.emitRightUnmatched()
+			return j.advanceRight()
+		}
+		return nil
+	}
+	if hasNull(j.leftKey) {
+		j.emitLeftUnmatched()
+		return j.advanceLeft()
+	}
+	if j.group != nil {
+		cmp, err := compareKeyRows(j.leftKey, j.groupKey)
+		if err != nil {
+			return err
+		}
+		if cmp == 0 {
+			matched := false
+			for i, rightRow := range j.group {
+				row := concatRows(j.leftRow, rightRow)
+				ok, err := satisfies(j.residual, row)
+				if err != nil {
+					return err
+				}
+				if ok {
+					matched = true
+					j.groupMatched[i] = true
+					j.pending = append(j.pending, row)
+				}
+			}
+			if !matched {
+				j.emitLeftUnmatched()
+			}
+			return j.advanceLeft()
+		}
+		j.flushGroup()
+		return nil
+	}
+	if j.rightRow == nil {
+		j.emitLeftUnmatched()
+		return j.advanceLeft()
+	}
+	if hasNull(j.rightKey) {
+		j.emitRightUnmatched()
+		return j.advanceRight()
+	}
+	cmp, err := compareKeyRows(j.leftKey, j.rightKey)
+	if err != nil {
+		return err
+	}
+	switch {
+	case cmp < 0:
+		j.emitLeftUnmatched()
+		return j.advanceLeft()
+	case cmp > 0:
+		j.emitRightUnmatched()
+		return j.advanceRight()
+	}
+	// Buffer every right row with this key.
+	j.groupKey = j.rightKey
+	for j.rightRow != nil && !hasNull(j.rightKey) {
+		cmp, err := compareKeyRows(j.rightKey, j.groupKey)
+		if err != nil {
+			return err
+		}
+		if cmp != 0 {
+			break
+		}
+		j.group = append(j.group, j.rightRow)
+		j.groupMatched = append(j.groupMatched, false)
+		if err := j.advanceRight(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *mergeJoin) Close() error {
+	err := j.left.Close()
+	if closeErr := j.right.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
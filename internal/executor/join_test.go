@@ -0,0 +1,10 @@
+package executor
+
+import "testing"
+
+func TestHashJoinSpill(t *testing.T) {
+	db := newTestDB(t)
+	db.fillSpillTables()
+	db.exec.SetJoinMethod(JoinHash)
+	db.checkSpill("SELECT a.id, b.name FROM b JOIN a ON a.g = b.g", "Hash Join")
+}
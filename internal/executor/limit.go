@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// limitBounds evaluates the LIMIT and OFFSET of a query. limit is -1 when
+// every row is wanted, as with LIMIT ALL or LIMIT NULL.
+func limitBounds(selectStmt *parser.SelectStatement, params []types.Value) (limit, offset int64, err error) {
+	bound := func(expr parser.Expr, clause string) (int64, error) {
+		if expr == nil {
+			return -1, nil
+		}
+		variables := false
+		parser.WalkExpr(expr, func(e parser.Expr) bool {
+			if _, ok := e.(*parser.ColumnRef); ok {
+				variables = true
+			}
+			return !variables
+		})
+		if variables {
+			return 0, fmt.Errorf("argument of %s must not contain variables", clause)
+		}
+		eval, typ, err := compileExpr(expr, &scope{}, params)
+		if err != nil {
+			return 0, err
+		}
+		if typ != types.TypeInteger && typ != types.TypeNull {
+			return 0, fmt.Errorf("argument of %s must be type integer, not type %s", clause, typ)
+		}
+		value, err := eval(nil)
+		if err != nil || value.IsNull() {
+			return -1, err
+		}
+		if value.Type != types.TypeInteger {
+			return 0, fmt.Errorf("argument of %s must be type integer, not type %s", clause, value.Type)
+		}
+		if value.Int < 0 {
+			return 0, fmt.Errorf("%s must not be negative", clause)
+		}
+		return value.Int, nil
+	}
+	if limit, err = bound(selectStmt.Limit, "LIMIT"); err != nil {
+		return 0, 0, err
+	}
+	if offset, err = bound(selectStmt.Offset, "OFFSET"); err != nil {
+		return 0, 0, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset, nil
+}
+
+// topN returns how many rows a sort below a LIMIT needs to keep, or -1 when
+// it needs them all.
+func topN(limit, offset int64) int {
+	if limit < 0 || limit+offset > math.MaxInt32 {
+		return -1
+	}
+	return int(limit + offset)
+}
+
+// limitPlan puts a Limit operator over plan unless every row is wanted.
+func limitPlan(plan *selectPlan, limit, offset int64, x *explainer) *selectPlan {
+	if limit < 0 && offset == 0 {
+		return plan
+	}
+	estimate := planner.LimitEstimate(plan.estimate, limit, offset)
+	node := x.node("Limit", &estimate, plan.node)
+	open := plan.open
+	return &selectPlan{
+		columns: plan.columns,
+		open: func() (RowIterator, error) {
+			rows, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return x.wrap(node, &limitIterator{child: rows, limit: limit, offset: offset}), nil
+		},
+		node:     node,
+		estimate: estimate,
+	}
+}
+
+// limitIterator skips the first offset rows of its child and returns at
+// most limit of the others. It stops reading its child once it has
+// returned them, so the operators below don't produce rows nobody reads.
+type limitIterator struct {
+	child    RowIterator
+	limit    int64 // -1 for no limit
+	offset   int64
+	returned int64
+}
+
+func (l *limitIterator) Next() (Row, error) {
+	if l.limit >= 0 && l.returned >= l.limit {
+		return nil, nil
+	}
+	for ; l.offset > 0; l.offset-- {
+		row, err := l.child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+	}
+	row, err := l.child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	l.returned++
+	return row, nil
+}
+
+func (l *limitIterator) Close() error {
+	return l.child.Close()
+}
@@ -0,0 +1,209 @@
+package executor
+
+import (
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// LockRequest is a lock a statement needs before it can run.
+type LockRequest struct {
+	Resource string
+	Mode     txn.LockMode
+	// Snapshot is set on the shared locks of tables the statement only
+	// reads through its snapshot, which only SERIALIZABLE transactions need.
+	Snapshot bool
+}
+
+// TableResource returns the lock resource name of a table.
+func TableResource(table string) string {
+	return "table:" + strings.ToLower(table)
+}
+
+// LockRequests lists the table locks a statement must hold. Readers take
+// shared locks, writers and DDL take exclusive locks on the tables they
+// change and shared locks on those their subqueries read. ALTER TABLE locks
+// the tables whose foreign keys it may change as well. DROP INDEX locks the
+// index's table, which is looked up in the catalog. ANALYZE only reads the
+// tables it analyzes, and VACUUM locks those it vacuums. EXPLAIN needs the
+// locks of the statement it explains, but only shared ones unless it runs
+// the statement. Tables read to check foreign keys are locked whatever the
+// isolation level, as the checks look at their latest rows.
+func (e *Executor) LockRequests(stmt *parser.Statement) []LockRequest {
+	switch stmt.StatementType {
+	case parser.StatementSelect:
+		return readLocks(nil, parser.SelectTables(stmt.SelectStmt))
+	case parser.StatementInsert:
+		var read []*parser.TableName
+		for _, values := range stmt.InsertStmt.Values {
+			for _, value := range values {
+				read = append(read, parser.ExprTables(value)...)
+			}
+		}
+		return readLocks(e.foreignKeyLocks(stmt.InsertStmt.TableName, false), read)
+	case parser.StatementUpdate:
+		read := parser.ExprTables(stmt.UpdateStmt.Where)
+		for _, assignment := range stmt.UpdateStmt.Assignments {
+			read = append(read, parser.ExprTables(assignment.Value)...)
+		}
+		return readLocks(e.foreignKeyLocks(stmt.UpdateStmt.TableName, true), read)
+	case parser.StatementDelete:
+		return readLocks(e.foreignKeyLocks(stmt.DeleteStmt.TableName, true), parser.ExprTables(stmt.DeleteStmt.Where))
+	case parser.StatementCreateTable:
+		reqs := []LockRequest{{TableResource(stmt.CreateStmt.TableName), txn.LockExclusive, false}}
+		var read []*parser.TableName
+		for _, def := range stmt.CreateStmt.Constraints {
+			if def.Type == parser.ConstraintForeignKey {
+				read = append(read, &parser.TableName{Name: def.RefTable})
+			}
+		}
+		return referenceLocks(reqs, read)
+	case parser.StatementDropTable:
+		return []LockRequest{{TableResource(stmt.DropStmt.TableName), txn.LockExclusive, false}}
+	case parser.StatementAlterTable:
+		// The tables referencing the table may have their foreign keys
+		// changed along with it.
+		alterStmt := stmt.AlterStmt
+		reqs := e.foreignKeyLocks(alterStmt.TableName, true)
+		if alterStmt.Action == parser.AlterRenameTable && !strings.EqualFold(alterStmt.NewName, alterStmt.TableName) {
+			reqs = append(reqs, LockRequest{TableResource(alterStmt.NewName), txn.LockExclusive, false})
+		}
+		var read []*parser.TableName
+		for _, def := range alterStmt.Constraints {
+			if def.Type == parser.ConstraintForeignKey {
+				read = append(read, &parser.TableName{Name: def.RefTable})
+			}
+		}
+		return referenceLocks(reqs, read)
+	case parser.StatementCreateIndex:
+		return []LockRequest{{TableResource(stmt.CreateIdxStmt.TableName), txn.LockExclusive, false}}
+	case parser.StatementDropIndex:
+		table, err := e.IndexTable(stmt.DropIdxStmt.IndexName)
+		if err != nil {
+			// Execution reports the missing index.
+			return nil
+		}
+		return []LockRequest{{TableResource(table), txn.LockExclusive, false}}
+	case parser.StatementAnalyze:
+		names := e.catalog.TableNames()
+		if stmt.AnalyzeStmt.TableName != "" {
+			names = []string{stmt.AnalyzeStmt.TableName}
+		}
+		reqs := make([]LockRequest, len(names))
+		for i, name := range names {
+			reqs[i] = LockRequest{TableResource(name), txn.LockShared, true}
+		}
+		return reqs
+	case parser.StatementVacuum:
+		names := e.catalog.TableNames()
+		if stmt.VacuumStmt.TableName != "" {
+			names = []string{stmt.VacuumStmt.TableName}
+		}
+		reqs := make([]LockRequest, len(names))
+		for i, name := range names {
+			reqs[i] = LockRequest{TableResource(name), txn.LockExclusive, false}
+		}
+		return reqs
+	case parser.StatementExplain:
+		reqs := e.LockRequests(stmt.ExplainStmt.Statement)
+		if !stmt.ExplainStmt.Analyze {
+			for i := range reqs {
+				reqs[i].Mode = txn.LockShared
+			}
+		}
+		return reqs
+	default:
+		return nil
+	}
+}
+
+// foreignKeyLocks lists the locks needed to write rows of a table, given
+// whether rows may be updated or deleted: an exclusive lock on the table and
+// shared locks on the tables its foreign keys reference. Updates and deletes
+// also take exclusive locks on the tables referencing it, whose rows
+// referential actions may change, and in turn on those their rows need.
+func (e *Executor) foreignKeyLocks(name string, modifies bool) []LockRequest {
+	written := []string{name}
+	seen := map[string]bool{strings.ToLower(name): true}
+	for i := 0; modifies && i < len(written); i++ {
+		for _, ref := range e.catalog.References(written[i]) {
+			if !seen[strings.ToLower(ref.Table.Name)] {
+				seen[strings.ToLower(ref.Table.Name)] = true
+				written = append(written, ref.Table.Name)
+			}
+		}
+	}
+	var reqs []LockRequest
+	var read []*parser.TableName
+	for _, tableName := range written {
+		reqs = append(reqs, LockRequest{TableResource(tableName), txn.LockExclusive, false})
+		table, err := e.catalog.GetTable(tableName)
+		if err != nil {
+			// Execution reports the missing table.
+			continue
+		}
+		for _, constraint := range table.Constraints {
+			if constraint.Type == catalog.ForeignKey {
+				read = append(read, &parser.TableName{Name: constraint.RefTable})
+			}
+		}
+	}
+	return referenceLocks(reqs, read)
+}
+
+// readLocks adds shared locks on the tables read through the snapshot to
+// reqs, skipping those already requested.
+func readLocks(reqs []LockRequest, tables []*parser.TableName) []LockRequest {
+	return sharedLocks(reqs, tables, true)
+}
+
+// referenceLocks adds shared locks on the tables referenced by foreign keys
+// to reqs, skipping those already requested.
+func referenceLocks(reqs []LockRequest, tables []*parser.TableName) []LockRequest {
+	return sharedLocks(reqs, tables, false)
+}
+
+func sharedLocks(reqs []LockRequest, tables []*parser.TableName, snapshot bool) []LockRequest {
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		seen[req.Resource] = true
+	}
+	for _, table := range tables {
+		resource := TableResource(table.Name)
+		if !seen[resource] {
+			seen[resource] = true
+			reqs = append(reqs, LockRequest{resource, txn.LockShared, snapshot})
+		}
+	}
+	return reqs
+}
+
+// IsReadOnly reports whether a statement leaves the database unchanged.
+func IsReadOnly(stmt *parser.Statement) bool {
+	switch stmt.StatementType {
+	case parser.StatementSelect, parser.StatementSetConstraints:
+		return true
+	case parser.StatementExplain:
+		return !stmt.ExplainStmt.Analyze || IsReadOnly(stmt.ExplainStmt.Statement)
+	default:
+		return false
+	}
+}
+
+// IsSessionStatement reports whether a statement manages the session, such as
+// transaction control, named prepared statements or cursors, rather than
+// touching data directly.
+func IsSessionStatement(stmt *parser.Statement) bool {
+	switch stmt.StatementType {
+	case parser.StatementBegin, parser.StatementCommit, parser.StatementRollback,
+		parser.StatementPrepare, parser.StatementExecute, parser.StatementDeallocate,
+		parser.StatementDeclare, parser.StatementFetch, parser.StatementClose,
+		parser.StatementSetTransaction, parser.StatementSavepoint, parser.StatementRollbackTo,
+		parser.StatementRelease, parser.StatementCheckpoint:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+type snapshotKey struct{}
+
+// withSnapshot returns a context whose scans read through snapshot.
+func withSnapshot(ctx context.Context, snapshot *txn.Snapshot) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, snapshot)
+}
+
+// snapshotFrom returns the snapshot of a context, or nil.
+func snapshotFrom(ctx context.Context) *txn.Snapshot {
+	s, _ := ctx.Value(snapshotKey{}).(*txn.Snapshot)
+	return s
+}
+
+// visible reports whether the row version stored in data is visible through
+// snapshot. A nil snapshot sees the latest version of each row, the one
+// nobody deleted: constraint checks and referential actions look at rows
+// that way, under the locks that keep other writers of the table out.
+func visible(snapshot *txn.Snapshot, data []byte) bool {
+	if snapshot == nil {
+		return storage.VersionXmax(data) == 0
+	}
+	return snapshot.Visible(storage.VersionXmin(data), storage.VersionCmin(data), storage.VersionXmax(data), storage.VersionCmax(data))
+}
+
+// getVersion reads the row version an index entry points at. The version
+// may have been removed by VACUUM since a paused index scan copied the
+// entry, in which case ok is false.
+func getVersion(heap *storage.TableHeap, rid storage.RID) (data []byte, ok bool, err error) {
+	data, err = heap.Get(rid)
+	if errors.Is(err, storage.ErrRecordDeleted) {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+// expireVersion marks the version of a row stored at rid as deleted by t.
+// The version was found through the snapshot of t, so another transaction
+// deleting it means that one committed after the snapshot was taken, which
+// only a REPEATABLE READ snapshot can miss.
+func (e *Executor) expireVersion(t *txn.Transaction, table *catalog.Table, rid storage.RID) error {
+	heap := e.tableHeap(table)
+	xmax, err := heap.Xmax(rid)
+	if err != nil {
+		return err
+	}
+	if xmax != 0 {
+		return txn.ErrSerialization
+	}
+	return heap.SetXmax(rid, t.ID, t.Command())
+}
+
+// ExecuteVacuumStatement removes the row versions of a table, or of every
+// table, that no snapshot can see anymore, along with their index entries.
+func (e *Executor) ExecuteVacuumStatement(ctx context.Context, t *txn.Transaction, vacuumStmt *parser.VacuumStatement) (*Result, error) {
+	snapshot := t.Snapshot()
+	if snapshot == nil {
+		return nil, errors.New("VACUUM needs a snapshot")
+	}
+	names := e.catalog.TableNames()
+	if vacuumStmt.TableName != "" {
+		names = []string{vacuumStmt.TableName}
+	}
+	result := &Result{}
+	for _, name := range names {
+		table, err := e.catalog.GetTable(name)
+		if err != nil {
+			return nil, err
+		}
+		removed, err := e.vacuumTable(ctx, table, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		result.RowsAffected += removed
+	}
+	return result, nil
+}
+
+// vacuumTable removes the dead versions of a table and returns how many.
+func (e *Executor) vacuumTable(ctx context.Context, table *catalog.Table, snapshot *txn.Snapshot) (int64, error) {
+	heap := e.tableHeap(table)
+	iter := heap.Iterator()
+	var removed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		rid, data, err := iter.Next()
+		if err != nil || data == nil {
+			return removed, err
+		}
+		if !snapshot.Dead(storage.VersionXmax(data)) {
+			continue
+		}
+		row, err := decodeRow(table, data)
+		if err != nil {
+			return removed, err
+		}
+		if err := e.deleteIndexEntries(table, row, rid); err != nil {
+			return removed, err
+		}
+		if err := heap.Delete(rid); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+}
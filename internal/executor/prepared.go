@@ -0,0 +1,284 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// PreparedStatement caches a parsed statement together with the types of its
+// parameters, inferred from the columns they are compared with or assigned to.
+// The inferred types are refreshed when the catalog changes.
+type PreparedStatement struct {
+	Statement      *parser.Statement
+	ParamTypes     []types.Type // TypeNull when a parameter's type can't be inferred
+	catalogVersion uint64
+	resolved       bool
+}
+
+// NewPreparedStatement wraps a parsed statement. Parameter types are inferred
+// on first use.
+func NewPreparedStatement(stmt *parser.Statement) *PreparedStatement {
+	return &PreparedStatement{Statement: stmt}
+}
+
+// NumParams returns the number of parameters the statement expects.
+func (p *PreparedStatement) NumParams() int {
+	return p.Statement.NumParams
+}
+
+// Prepare infers the parameter types of a prepared statement against the
+// current catalog, unless they are already up to date.
+func (e *Executor) Prepare(p *PreparedStatement) error {
+	if p.resolved && p.catalogVersion == e.catalog.Version() {
+		return nil
+	}
+	paramTypes := make([]types.Type, p.Statement.NumParams)
+	setType := func(param *parser.Param, colType types.Type) error {
+		if prev := paramTypes[param.Index-1]; prev != types.TypeNull && prev != colType {
+			return fmt.Errorf("parameter %d used as both %s and %s", param.Index, prev, colType)
+		}
+		paramTypes[param.Index-1] = colType
+		return nil
+	}
+	infer := func(table *catalog.Table, column string, expr parser.Expr) error {
+		param, ok := expr.(*parser.Param)
+		if !ok {
+			return nil
+		}
+		idx := table.ColumnIndex(column)
+		if idx == -1 {
+			return fmt.Errorf("column %s does not exist in table %s", column, table.Name)
+		}
+		return setType(param, table.Columns[idx].Type)
+	}
+	// Subqueries in the FROM clause are planned for the types of their
+	// columns, with NULLs standing in for the parameters.
+	nulls := make([]types.Value, p.Statement.NumParams)
+	for i := range nulls {
+		nulls[i] = types.Null()
+	}
+	x := &explainer{bp: e.bufferManager}
+
+	// inferExpr types parameters compared with a column, combined with one
+	// arithmetically, listed in IN after one, or used as a LIKE pattern.
+	// Columns resolve in the first of scopes that has them: a subquery's
+	// own, then those of the queries it is nested in.
+	var inferSelect func(sel *parser.SelectStatement, outer []*scope, ctes cteSet) error
+	var inferExpr func(scopes []*scope, ctes cteSet, where parser.Expr) error
+	inferExpr = func(scopes []*scope, ctes cteSet, where parser.Expr) error {
+		var err error
+		pairWith := func(a, b parser.Expr) {
+			ref, isRef := a.(*parser.ColumnRef)
+			param, isParam := b.(*parser.Param)
+			if !isRef || !isParam || err != nil {
+				return
+			}
+			// Unknown columns are reported when the statement runs.
+			for _, sc := range scopes {
+				if idx, resolveErr := sc.resolve(ref); resolveErr == nil {
+					err = setType(param, sc.columns[idx].Type)
+					return
+				}
+			}
+		}
+		parser.WalkExpr(where, func(expr parser.Expr) bool {
+			if sub := parser.Subquery(expr); sub != nil && err == nil {
+				err = inferSelect(sub, scopes, ctes)
+			}
+			switch e := expr.(type) {
+			case *parser.BinaryExpr:
+				if e.Op != "AND" && e.Op != "OR" && e.Op != "||" {
+					pairWith(e.Left, e.Right)
+					pairWith(e.Right, e.Left)
+				}
+			case *parser.InExpr:
+				for _, item := range e.List {
+					pairWith(e.Expr, item)
+				}
+			case *parser.LikeExpr:
+				if param, ok := e.Pattern.(*parser.Param); ok && err == nil {
+					err = setType(param, types.TypeText)
+				}
+			}
+			return err == nil
+		})
+		return err
+	}
+	inferSelect = func(sel *parser.SelectStatement, outer []*scope, ctes cteSet) error {
+		ctx := context.Background()
+		if len(sel.With) > 0 {
+			var err error
+			if ctes, _, err = e.planCTEs(ctx, sel.With, nulls, x, nil, ctes); err != nil {
+				return err
+			}
+			for _, cte := range sel.With {
+				if err := inferSelect(cte.Select, outer, ctes); err != nil {
+					return err
+				}
+				if cte.Recursive != nil {
+					if err := inferSelect(cte.Recursive, outer, ctes); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		// LIMIT and OFFSET take integers.
+		for _, expr := range []parser.Expr{sel.Limit, sel.Offset} {
+			if param, ok := expr.(*parser.Param); ok {
+				if err := setType(param, types.TypeInteger); err != nil {
+					return err
+				}
+			}
+		}
+		if sel.Set != nil {
+			if err := inferSelect(sel.Set.Left, outer, ctes); err != nil {
+				return err
+			}
+			return inferSelect(sel.Set.Right, outer, ctes)
+		}
+		derived, err := e.planDerived(ctx, sel.From, nulls, x, nil, ctes)
+		if err != nil {
+			return err
+		}
+		// The subqueries of the FROM clause can't see its other tables.
+		var inferDerived func(ref parser.TableRef) error
+		inferDerived = func(ref parser.TableRef) error {
+			switch r := ref.(type) {
+			case *parser.Join:
+				if err := inferDerived(r.Left); err != nil {
+					return err
+				}
+				return inferDerived(r.Right)
+			case *parser.DerivedTable:
+				return inferSelect(r.Select, outer, ctes)
+			}
+			return nil
+		}
+		if err := inferDerived(sel.From); err != nil {
+			return err
+		}
+		sc, err := e.fromScope(sel.From, derived)
+		if err != nil {
+			return err
+		}
+		scopes := append([]*scope{sc}, outer...)
+		for _, expr := range sel.Exprs() {
+			if err := inferExpr(scopes, ctes, expr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stmt := p.Statement
+	if stmt.StatementType == parser.StatementExplain {
+		stmt = stmt.ExplainStmt.Statement
+	}
+	switch stmt.StatementType {
+	case parser.StatementSelect:
+		if err := inferSelect(stmt.SelectStmt, nil, nil); err != nil {
+			return err
+		}
+	case parser.StatementInsert:
+		table, err := e.catalog.GetTable(stmt.InsertStmt.TableName)
+		if err != nil {
+			return err
+		}
+		for _, values := range stmt.InsertStmt.Values {
+			for i, value := range values {
+				if err := infer(table, stmt.InsertStmt.Columns[i], value); err != nil {
+					return err
+				}
+				if err := inferExpr(nil, nil, value); err != nil {
+					return err
+				}
+			}
+		}
+	case parser.StatementUpdate:
+		table, err := e.catalog.GetTable(stmt.UpdateStmt.TableName)
+		if err != nil {
+			return err
+		}
+		for _, assignment := range stmt.UpdateStmt.Assignments {
+			if err := infer(table, assignment.Column, assignment.Value); err != nil {
+				return err
+			}
+			if err := inferExpr([]*scope{tableScope(table, table.Name)}, nil, assignment.Value); err != nil {
+				return err
+			}
+		}
+		if err := inferExpr([]*scope{tableScope(table, table.Name)}, nil, stmt.UpdateStmt.Where); err != nil {
+			return err
+		}
+	case parser.StatementDelete:
+		table, err := e.catalog.GetTable(stmt.DeleteStmt.TableName)
+		if err != nil {
+			return err
+		}
+		if err := inferExpr([]*scope{tableScope(table, table.Name)}, nil, stmt.DeleteStmt.Where); err != nil {
+			return err
+		}
+	}
+	if err := e.check(stmt, paramTypes); err != nil {
+		return err
+	}
+	p.ParamTypes = paramTypes
+	p.catalogVersion = e.catalog.Version()
+	p.resolved = true
+	return nil
+}
+
+// Bind converts the arguments supplied at execution time to the statement's
+// parameter types.
+func (e *Executor) Bind(p *PreparedStatement, args []interface{}) ([]types.Value, error) {
+	if len(args) != p.NumParams() {
+		return nil, fmt.Errorf("expected %d parameters, got %d", p.NumParams(), len(args))
+	}
+	if err := e.Prepare(p); err != nil {
+		return nil, err
+	}
+	params := make([]types.Value, len(args))
+	for i, arg := range args {
+		value, err := types.FromInterface(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+		}
+		if params[i], err = value.Cast(p.ParamTypes[i]); err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+		}
+	}
+	return params, nil
+}
+
+// check compiles a statement without running it, as EXPLAIN does, so that
+// errors such as mismatched types are reported when it is prepared. The
+// parameters stand in as values of their inferred types.
+func (e *Executor) check(stmt *parser.Statement, paramTypes []types.Type) error {
+	params := make([]types.Value, len(paramTypes))
+	for i, typ := range paramTypes {
+		params[i] = types.Null()
+		if typ != types.TypeNull {
+			params[i], _ = types.NewInteger(0).Cast(typ)
+		}
+	}
+	ctx, x := context.Background(), &explainer{bp: e.bufferManager}
+	var err error
+	switch stmt.StatementType {
+	case parser.StatementSelect:
+		var res *Result
+		if res, _, err = e.buildSelect(ctx, stmt.SelectStmt, params, x); err == nil {
+			res.Rows.Close()
+		}
+	case parser.StatementInsert:
+		_, _, err = e.executeInsert(ctx, nil, stmt.InsertStmt, params, x)
+	case parser.StatementUpdate:
+		_, _, err = e.executeUpdate(ctx, nil, stmt.UpdateStmt, params, x)
+	case parser.StatementDelete:
+		_, _, err = e.executeDelete(ctx, nil, stmt.DeleteStmt, params, x)
+	}
+	return err
+}
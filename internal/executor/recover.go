@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"context"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// Recover brings the tables back to the changes of the transactions the
+// commit log says committed, after a crash. A transaction may have crashed
+// halfway through writing its pages back: the versions it created are
+// removed and those it deleted live again. The indexes may hold entries of
+// versions removed, or miss some of those kept, so each is rebuilt from its
+// table; the pages of the old ones are not reused. The caller flushes the
+// pages changed.
+func (e *Executor) Recover(ctx context.Context, clog *txn.CommitLog) error {
+	for _, name := range e.catalog.TableNames() {
+		table, err := e.catalog.GetTable(name)
+		if err != nil {
+			return err
+		}
+		if err := e.recoverTable(ctx, table, clog); err != nil {
+			return err
+		}
+		for _, def := range table.Indexes {
+			tree, err := index.Create(e.bufferManager)
+			if err != nil {
+				return err
+			}
+			if err := e.fillIndex(ctx, table, def, tree); err != nil {
+				return err
+			}
+			def.RootPageID = tree.RootPageID()
+		}
+	}
+	return e.catalog.Save()
+}
+
+// recoverTable removes the versions of a table created by transactions that
+// didn't commit and clears the deletions of those.
+func (e *Executor) recoverTable(ctx context.Context, table *catalog.Table, clog *txn.CommitLog) error {
+	heap := e.tableHeap(table)
+	iter := heap.Iterator()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rid, data, err := iter.Next()
+		if err != nil || data == nil {
+			return err
+		}
+		committed, err := clog.Committed(storage.VersionXmin(data))
+		if err != nil {
+			return err
+		}
+		if !committed {
+			if err := heap.Delete(rid); err != nil {
+				return err
+			}
+			continue
+		}
+		xmax := storage.VersionXmax(data)
+		if xmax == 0 {
+			continue
+		}
+		committed, err = clog.Committed(xmax)
+		if err != nil {
+			return err
+		}
+		if !committed {
+			if err := heap.SetXmax(rid, 0, 0); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package executor
+
+import (
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Row is a single tuple produced by a query.
+type Row []types.Value
+
+// RowIterator produces the rows of a query one at a time.
+type RowIterator interface {
+	// Next returns the next row, or nil once the rows are exhausted.
+	Next() (Row, error)
+	// Close releases any resources held by the iterator.
+	Close() error
+}
+
+// Result is the outcome of executing a statement. Queries fill Columns and
+// Rows, other statements report RowsAffected and LastInsertID.
+type Result struct {
+	Columns      []catalog.Column
+	Rows         RowIterator
+	RowsAffected int64
+	LastInsertID int64
+}
+
+// ColumnNames returns the names of the result columns.
+func (r *Result) ColumnNames() []string {
+	names := make([]string, len(r.Columns))
+	for i, col := range r.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
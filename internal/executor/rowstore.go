@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"context"
+
+	"github.com/roackb2/simple_db/internal/storage"
+)
+
+// rowStore keeps rows to be read back, any number of times, in the order
+// they were added. Rows are held in memory until they exceed workMem; the
+// rest go to a temporary heap.
+type rowStore struct {
+	ctx     context.Context
+	bp      *storage.BufferPool
+	workMem int
+
+	rows  []Row
+	used  int
+	heap  *storage.TableHeap // nil until rows spill
+	count int
+}
+
+func newRowStore(ctx context.Context, bp *storage.BufferPool, workMem int) *rowStore {
+	return &rowStore{ctx: ctx, bp: bp, workMem: workMem}
+}
+
+func (s *rowStore) add(row Row) error {
+	s.count++
+	if s.heap == nil && s.used+rowSize(row) > s.workMem {
+		heap, err := storage.CreateTempHeap(s.bp)
+		if err != nil {
+			return err
+		}
+		s.heap = heap
+	}
+	if s.heap != nil {
+		_, err := s.heap.Insert(encodeRow(row))
+		return err
+	}
+	s.rows = append(s.rows, row)
+	s.used += rowSize(row)
+	return nil
+}
+
+// len returns the number of rows added.
+func (s *rowStore) len() int {
+	return s.count
+}
+
+// scan returns an iterator over the rows added so far.
+func (s *rowStore) scan() RowIterator {
+	scan := &storeScan{list: &rowList{rows: s.rows}}
+	if s.heap != nil {
+		scan.spilled = newTempScan(s.ctx, s.heap)
+	}
+	return scan
+}
+
+// free releases the temporary pages of the store.
+func (s *rowStore) free() error {
+	s.rows, s.used, s.count = nil, 0, 0
+	if s.heap == nil {
+		return nil
+	}
+	err := s.heap.Free()
+	s.heap = nil
+	return err
+}
+
+// storeScan reads the rows of a rowStore: those in memory, then those on
+// temporary pages.
+type storeScan struct {
+	list    *rowList
+	spilled *tempScan
+}
+
+func (s *storeScan) Next() (Row, error) {
+	if row, _ := s.list.Next(); row != nil {
+		return row, nil
+	}
+	if s.spilled == nil {
+		return nil, nil
+	}
+	return s.spilled.Next()
+}
+
+func (s *storeScan) Close() error {
+	return nil
+}
@@ -0,0 +1,396 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/index"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// encodeRow serializes a row into a record in column order.
+func encodeRow(row Row) []byte {
+	record := storage.NewRecord()
+	for _, value := range row {
+		record.AddField(value.Encode())
+	}
+	return record.Serialize()
+}
+
+// decodeValues deserializes a record into a row with one value per field.
+func decodeValues(data []byte) (Row, error) {
+	record, err := storage.DeserializeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	row := make(Row, len(record.Fields))
+	for i, field := range record.Fields {
+		if row[i], err = types.Decode(field); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// decodeRow deserializes a record into a row of the table's width.
+func decodeRow(table *catalog.Table, data []byte) (Row, error) {
+	return decodeColumns(table, data, nil)
+}
+
+// encodeRecord serializes a row of a table into a record with a field per
+// column, dropped ones included, laid out as the table's columns say.
+func encodeRecord(table *catalog.Table, row Row) []byte {
+	if table.NumFields == len(row) && identityLayout(table) {
+		return encodeRow(row)
+	}
+	fields := make(Row, table.NumFields)
+	for i := range fields {
+		fields[i] = types.Null()
+	}
+	for i, col := range table.Columns {
+		fields[col.Field] = row[i]
+	}
+	return encodeRow(fields)
+}
+
+// identityLayout reports whether each column of a table is stored in the
+// field of its position.
+func identityLayout(table *catalog.Table) bool {
+	for i, col := range table.Columns {
+		if col.Field != i {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeColumns deserializes the columns of a row version that columns
+// selects, or all of them if it is nil, into a row of the table's width. The
+// other columns are left NULL.
+func decodeColumns(table *catalog.Table, data []byte, columns []bool) (Row, error) {
+	data = storage.VersionRecord(data)
+	row := make(Row, len(table.Columns))
+	for i := range row {
+		row[i] = types.Null()
+	}
+	var fieldColumns []int
+	if table.NumFields > 0 {
+		fieldColumns = table.FieldColumns()
+	}
+	fields := 0
+	err := storage.ReadFields(data, func(i int, field []byte) error {
+		fields = i + 1
+		col := i
+		if fieldColumns != nil {
+			if i >= len(fieldColumns) {
+				return nil
+			}
+			col = fieldColumns[i]
+		}
+		if col < 0 || col >= len(row) || (columns != nil && !columns[col]) {
+			return nil
+		}
+		var err error
+		row[col], err = types.Decode(field)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Records written before a column was added read as the value it was
+	// added with.
+	for i, col := range table.Columns {
+		if col.Field >= fields && col.Missing != nil && (columns == nil || columns[i]) {
+			if row[i], err = types.Decode(col.Missing); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return row, nil
+}
+
+// seqScan iterates over every row of a table visible through a snapshot
+// that satisfies an optional condition. Only the columns selected by
+// columns are decoded, unless it is nil.
+type seqScan struct {
+	ctx      context.Context
+	table    *catalog.Table
+	iter     *storage.HeapIterator
+	where    evaluator
+	columns  []bool
+	snapshot *txn.Snapshot // nil reads the latest versions, see visible
+	rid      storage.RID
+}
+
+// newSeqScan returns a scan reading through the snapshot of ctx.
+func newSeqScan(ctx context.Context, heap *storage.TableHeap, table *catalog.Table, where evaluator) *seqScan {
+	return &seqScan{ctx: ctx, table: table, iter: heap.Iterator(), where: where, snapshot: snapshotFrom(ctx)}
+}
+
+func (s *seqScan) Next() (Row, error) {
+	for {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		rid, data, err := s.iter.Next()
+		if err != nil || data == nil {
+			return nil, err
+		}
+		if !visible(s.snapshot, data) {
+			continue
+		}
+		row, err := decodeColumns(s.table, data, s.columns)
+		if err != nil {
+			return nil, err
+		}
+		match, err := satisfies(s.where, row)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			s.rid = rid
+			return row, nil
+		}
+	}
+}
+
+// RID returns the record ID of the last row returned by Next.
+func (s *seqScan) RID() storage.RID {
+	return s.rid
+}
+
+func (s *seqScan) Close() error {
+	return nil
+}
+
+// indexBound limits an index scan on the leading column of the index.
+type indexBound struct {
+	value     types.Value
+	inclusive bool
+}
+
+// indexScan iterates over the rows of a table whose leading index column
+// lies between two optional bounds, in index order, keeping those visible
+// through a snapshot that satisfy an optional condition.
+// NULL keys never lie within bounds.
+type indexScan struct {
+	ctx      context.Context
+	tree     *index.BTree
+	heap     *storage.TableHeap
+	table    *catalog.Table
+	lower    *indexBound
+	upper    *indexBound
+	where    evaluator
+	columns  []bool
+	snapshot *txn.Snapshot
+	iter     *index.Iterator
+	done     bool
+	rid      storage.RID
+}
+
+func (s *indexScan) Next() (Row, error) {
+	if s.iter == nil && !s.done {
+		if (s.lower != nil && s.lower.value.IsNull()) || (s.upper != nil && s.upper.value.IsNull()) {
+			s.done = true
+			return nil, nil
+		}
+		var start index.Key
+		if s.lower != nil {
+			start = index.Key{s.lower.value}
+		}
+		iter, err := s.tree.Seek(start)
+		if err != nil {
+			return nil, err
+		}
+		s.iter = iter
+	}
+	for !s.done {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry, ok, err := s.iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			s.done = true
+			break
+		}
+		key := entry.Key[:1]
+		if key[0].IsNull() {
+			continue
+		}
+		if s.lower != nil && !s.lower.inclusive {
+			cmp, err := index.CompareKeys(key, index.Key{s.lower.value})
+			if err != nil {
+				return nil, err
+			}
+			if cmp == 0 {
+				continue
+			}
+		}
+		if s.upper != nil {
+			cmp, err := index.CompareKeys(key, index.Key{s.upper.value})
+			if err != nil {
+				return nil, err
+			}
+			if cmp > 0 || (cmp == 0 && !s.upper.inclusive) {
+				s.done = true
+				break
+			}
+		}
+		data, ok, err := getVersion(s.heap, entry.RID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !visible(s.snapshot, data) {
+			continue
+		}
+		row, err := decodeColumns(s.table, data, s.columns)
+		if err != nil {
+			return nil, err
+		}
+		match, err := satisfies(s.where, row)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			s.rid = entry.RID
+			return row, nil
+		}
+	}
+	return nil, nil
+}
+
+// RID returns the record ID of the last row returned by Next.
+func (s *indexScan) RID() storage.RID {
+	return s.rid
+}
+
+func (s *indexScan) Close() error {
+	return nil
+}
+
+// satisfies reports whether a condition is true for a row. A nil condition
+// accepts every row; NULL counts as false.
+func satisfies(cond evaluator, row Row) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	value, err := cond(row)
+	if err != nil {
+		return false, err
+	}
+	result, known, err := truth(value)
+	return result && known, err
+}
+
+// compareResult applies a comparison operator to the result of types.Compare.
+func compareResult(operator string, cmp int) (bool, error) {
+	switch operator {
+	case "=":
+		return cmp == 0, nil
+	case "!=", "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	default:
+		return false, fmt.Errorf("unsupported operator %s", operator)
+	}
+}
+
+// projection computes the select list from the rows of a child iterator.
+type projection struct {
+	child RowIterator
+	exprs []evaluator
+}
+
+func (p *projection) Next() (Row, error) {
+	row, err := p.child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	out := make(Row, len(p.exprs))
+	for i, expr := range p.exprs {
+		if out[i], err = expr(row); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (p *projection) Close() error {
+	return p.child.Close()
+}
+
+// filter passes on the rows of a child iterator for which a condition is true.
+type filter struct {
+	child RowIterator
+	cond  evaluator
+}
+
+func (f *filter) Next() (Row, error) {
+	for {
+		row, err := f.child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		ok, err := satisfies(f.cond, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return row, nil
+		}
+	}
+}
+
+// RID returns the record ID of the last row. The child must be a ridIterator.
+func (f *filter) RID() storage.RID {
+	return f.child.(ridIterator).RID()
+}
+
+func (f *filter) Close() error {
+	return f.child.Close()
+}
+
+// ridIterator is an iterator over the rows of a table that can tell where
+// the last row it returned is stored.
+type ridIterator interface {
+	RowIterator
+	RID() storage.RID
+}
+
+// tempScan reads back the rows spilled to a temporary heap.
+type tempScan struct {
+	ctx  context.Context
+	iter *storage.HeapIterator
+}
+
+func newTempScan(ctx context.Context, heap *storage.TableHeap) *tempScan {
+	return &tempScan{ctx: ctx, iter: heap.Iterator()}
+}
+
+func (s *tempScan) Next() (Row, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, data, err := s.iter.Next()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return decodeValues(data)
+}
+
+func (s *tempScan) Close() error {
+	return nil
+}
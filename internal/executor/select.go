@@ -0,0 +1,493 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// outputColumn is a select list entry with "*" expanded.
+type outputColumn struct {
+	expr parser.Expr
+	name string
+}
+
+// ExecuteSelectStatement builds the iterator producing the rows of a SELECT:
+// the plan the planner chooses for the FROM and WHERE clauses, then grouping,
+// HAVING, window functions and sorting where requested, the projection of
+// the select list and the removal of duplicates for DISTINCT. The rows of
+// compound queries are combined by planSetOperation.
+func (e *Executor) ExecuteSelectStatement(ctx context.Context, selectStmt *parser.SelectStatement, params []types.Value) (*Result, error) {
+	res, _, err := e.buildSelect(ctx, selectStmt, params, nil)
+	return res, err
+}
+
+// buildSelect builds the iterator of a SELECT and, when x is set, the plan
+// node at the top of its operators.
+func (e *Executor) buildSelect(ctx context.Context, selectStmt *parser.SelectStatement, params []types.Value, x *explainer) (*Result, *explainNode, error) {
+	plan, err := e.planSelect(ctx, selectStmt, params, x, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := plan.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Result{Columns: plan.columns, Rows: rows}, plan.node, nil
+}
+
+// selectPlan is a planned SELECT: the columns of its rows, a source opening
+// a new iterator over them, the plan node at the top of its operators and
+// what the planner expects of it. Subqueries open their plan every time
+// they run. scan names the operator reading the plan in a FROM clause, if
+// not a Subquery Scan.
+type selectPlan struct {
+	columns  []catalog.Column
+	open     rowSource
+	node     *explainNode
+	estimate planner.Estimate
+	scan     string
+}
+
+// planSelect plans a SELECT. For a subquery, outer links to the query it is
+// nested in, whose columns it may refer to, and ctes holds the WITH queries
+// of the enclosing queries.
+func (e *Executor) planSelect(ctx context.Context, selectStmt *parser.SelectStatement, params []types.Value, x *explainer, outer *correlation, ctes cteSet) (*selectPlan, error) {
+	var own []*cteTable
+	if len(selectStmt.With) > 0 {
+		var err error
+		if ctes, own, err = e.planCTEs(ctx, selectStmt.With, params, x, outer, ctes); err != nil {
+			return nil, err
+		}
+	}
+	limit, offset, err := limitBounds(selectStmt, params)
+	if err != nil {
+		return nil, err
+	}
+	if selectStmt.Set != nil {
+		plan, err := e.planSetOperation(ctx, selectStmt, params, x, outer, ctes, topN(limit, offset))
+		if err != nil {
+			return nil, err
+		}
+		return withCleanup(limitPlan(plan, limit, offset, x), own), nil
+	}
+	derived, err := e.planDerived(ctx, selectStmt.From, params, x, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	from, err := e.fromScope(selectStmt.From, derived)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := expandSelectList(from, selectStmt.Fields)
+	if err != nil {
+		return nil, err
+	}
+	orderBy, err := resolveOutputRefs(selectStmt.OrderBy, outputs)
+	if err != nil {
+		return nil, err
+	}
+	used := append(postAggregateExprs(selectStmt, outputs, orderBy), selectStmt.GroupBy...)
+	source, from, node, estimate, err := e.buildFrom(ctx, selectStmt.From, selectStmt.Where, derived, used, params, x, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without ANALYZE, EXPLAIN builds the operators over no rows so that
+	// nothing is read.
+	if x.planOnly() {
+		source = func() (RowIterator, error) {
+			return &rowList{}, nil
+		}
+	}
+	sc := from
+	sorted := false
+	var aggregate, sorter func(RowIterator) RowIterator
+	if isAggregateQuery(selectStmt, outputs, orderBy) {
+		if aggregate, sc, sorted, node, estimate, err = e.buildAggregation(ctx, sc, selectStmt, outputs, orderBy, params, x, node, estimate); err != nil {
+			return nil, err
+		}
+	}
+	var window func(RowIterator) RowIterator
+	if hasWindows(outputs, orderBy) {
+		if window, sc, sorted, node, estimate, err = e.buildWindows(ctx, sc, outputs, orderBy, params, x, node, estimate); err != nil {
+			return nil, err
+		}
+	}
+	if len(orderBy) > 0 && !sorted && !selectStmt.Distinct {
+		keys, err := compileSortKeys(orderBy, sc, params)
+		if err != nil {
+			return nil, err
+		}
+		bound := topN(limit, offset)
+		estimate = planner.SortEstimate(estimate, planner.RowWidth(len(sc.columns)), e.workMem, bound)
+		sortNode := topNSortNode(x, orderBy, node, estimate, bound)
+		node = sortNode
+		sorter = func(rows RowIterator) RowIterator {
+			return x.wrap(sortNode, newSortOperator(ctx, e.bufferManager, rows, keys, e.workMem, bound))
+		}
+	}
+
+	columns := make([]catalog.Column, len(outputs))
+	exprs := make([]evaluator, len(outputs))
+	for i, output := range outputs {
+		eval, typ, err := compileExpr(output.expr, sc, params)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = eval
+		columns[i] = catalog.Column{Name: output.name, Type: typ}
+		if ref, ok := output.expr.(*parser.ColumnRef); ok {
+			if idx, err := from.resolve(ref); err == nil {
+				columns[i].NotNull = from.columns[idx].NotNull
+			}
+		}
+	}
+	// DISTINCT groups the output rows, sorting them for the ORDER BY, which
+	// must therefore list output columns.
+	var distinct func(RowIterator) RowIterator
+	if selectStmt.Distinct {
+		positions, err := distinctOrder(from, orderBy, outputs)
+		if err != nil {
+			return nil, err
+		}
+		distinct, node, _, estimate = e.buildGrouping(ctx, columns, nil, orderBy, positions, estimate, x, node, "HashAggregate", "Unique")
+	}
+	plan := &selectPlan{
+		columns: columns,
+		open: func() (RowIterator, error) {
+			rows, err := source()
+			if err != nil {
+				return nil, err
+			}
+			if aggregate != nil {
+				rows = aggregate(rows)
+			}
+			if window != nil {
+				rows = window(rows)
+			}
+			if sorter != nil {
+				rows = sorter(rows)
+			}
+			rows = &projection{child: rows, exprs: exprs}
+			if distinct != nil {
+				rows = distinct(rows)
+			}
+			return rows, nil
+		},
+		node:     node,
+		estimate: estimate,
+	}
+	return withCleanup(limitPlan(plan, limit, offset, x), own), nil
+}
+
+// withCleanup makes the rows of plan drop those of the WITH queries in own
+// once they are closed.
+func withCleanup(plan *selectPlan, own []*cteTable) *selectPlan {
+	if len(own) == 0 {
+		return plan
+	}
+	open := plan.open
+	plan.open = func() (RowIterator, error) {
+		rows, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &cteCleanup{RowIterator: rows, ctes: own}, nil
+	}
+	return plan
+}
+
+// sortNode returns the plan node of sorting the rows of child on items,
+// which the planner expects to cost estimate.
+func sortNode(x *explainer, items []parser.OrderByItem, child *explainNode, estimate planner.Estimate) *explainNode {
+	node := x.node("Sort", &estimate, child)
+	if node != nil {
+		node.props = append(node.props, explainProp{"Sort Key", sortKeysString(items)})
+	}
+	return node
+}
+
+// topNSortNode is sortNode for a sort keeping only its first bound rows,
+// unless bound is -1.
+func topNSortNode(x *explainer, items []parser.OrderByItem, child *explainNode, estimate planner.Estimate, bound int) *explainNode {
+	node := sortNode(x, items, child, estimate)
+	if node != nil && bound >= 0 {
+		node.props = append(node.props, explainProp{"Sort Method", "top-N heapsort"})
+	}
+	return node
+}
+
+// expandSelectList replaces "*" with the columns of the FROM clause and
+// names each output column after its alias, its column or its function.
+func expandSelectList(from *scope, items []parser.SelectItem) ([]outputColumn, error) {
+	var outputs []outputColumn
+	for _, item := range items {
+		if item.Star {
+			if item.Table != "" && !from.hasTable(item.Table) {
+				return nil, fmt.Errorf("missing FROM-clause entry for table %s", item.Table)
+			}
+			for i, col := range from.columns {
+				if item.Table == "" || strings.EqualFold(from.tables[i], item.Table) {
+					ref := &parser.ColumnRef{Table: from.tables[i], Column: col.Name}
+					outputs = append(outputs, outputColumn{expr: ref, name: col.Name})
+				}
+			}
+			continue
+		}
+		output := outputColumn{expr: item.Expr, name: item.Alias}
+		if output.name == "" {
+			switch e := item.Expr.(type) {
+			case *parser.ColumnRef:
+				output.name = e.Column
+				if idx, err := from.resolve(e); err == nil {
+					output.name = from.columns[idx].Name
+				}
+			case *parser.FuncCall:
+				output.name = strings.ToLower(e.Name)
+			default:
+				output.name = e.String()
+			}
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// resolveOutputRefs replaces ORDER BY items that refer to the select list by
+// position or by alias with the expression they refer to.
+func resolveOutputRefs(items []parser.OrderByItem, outputs []outputColumn) ([]parser.OrderByItem, error) {
+	resolved := make([]parser.OrderByItem, len(items))
+	for i, item := range items {
+		expr, err := resolveOutputRef(item.Expr, outputs)
+		if err != nil {
+			return nil, err
+		}
+		item.Expr = expr
+		resolved[i] = item
+	}
+	return resolved, nil
+}
+
+func resolveOutputRef(expr parser.Expr, outputs []outputColumn) (parser.Expr, error) {
+	switch e := expr.(type) {
+	case *parser.Literal:
+		if e.Value.Type != types.TypeInteger {
+			return nil, fmt.Errorf("position must be an integer, got %s", e.Value)
+		}
+		pos := e.Value.Int
+		if pos < 1 || pos > int64(len(outputs)) {
+			return nil, fmt.Errorf("position %d is not in select list", pos)
+		}
+		return outputs[pos-1].expr, nil
+	case *parser.ColumnRef:
+		for _, output := range outputs {
+			if _, isColumn := output.expr.(*parser.ColumnRef); !isColumn && strings.EqualFold(output.name, e.Column) {
+				return output.expr, nil
+			}
+		}
+	}
+	return expr, nil
+}
+
+// compileSortKeys compiles ORDER BY items against the rows being sorted.
+func compileSortKeys(items []parser.OrderByItem, sc *scope, params []types.Value) ([]sortKey, error) {
+	keys := make([]sortKey, 0, len(items))
+	for _, item := range items {
+		key := sortKey{desc: item.Desc, nullsFirst: item.Desc}
+		switch item.Nulls {
+		case parser.NullsFirst:
+			key.nullsFirst = true
+		case parser.NullsLast:
+			key.nullsFirst = false
+		}
+		eval, _, err := compileExpr(item.Expr, sc, params)
+		if err != nil {
+			return nil, err
+		}
+		key.eval = eval
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// collectAggregates returns the distinct aggregate calls in the expressions,
+// in the order they first appear. Calls are told apart by their key in sc.
+func collectAggregates(sc *scope, exprs []parser.Expr) []*parser.FuncCall {
+	var calls []*parser.FuncCall
+	seen := make(map[string]bool)
+	for _, expr := range exprs {
+		parser.WalkExpr(expr, func(e parser.Expr) bool {
+			call, ok := e.(*parser.FuncCall)
+			if !ok || !call.IsAggregate() {
+				return true
+			}
+			if key := sc.exprKey(call); !seen[key] {
+				seen[key] = true
+				calls = append(calls, call)
+			}
+			return false
+		})
+	}
+	return calls
+}
+
+// postAggregateExprs are the expressions evaluated on the grouped rows.
+func postAggregateExprs(selectStmt *parser.SelectStatement, outputs []outputColumn, orderBy []parser.OrderByItem) []parser.Expr {
+	var exprs []parser.Expr
+	for _, output := range outputs {
+		exprs = append(exprs, output.expr)
+	}
+	if selectStmt.Having != nil {
+		exprs = append(exprs, selectStmt.Having)
+	}
+	for _, item := range orderBy {
+		exprs = append(exprs, item.Expr)
+	}
+	return exprs
+}
+
+// hasWindows reports whether the select list or ORDER BY calls a window
+// function.
+func hasWindows(outputs []outputColumn, orderBy []parser.OrderByItem) bool {
+	found := false
+	visit := func(e parser.Expr) bool {
+		if call, ok := e.(*parser.FuncCall); ok && call.Over != nil {
+			found = true
+		}
+		return !found
+	}
+	for _, output := range outputs {
+		parser.WalkExpr(output.expr, visit)
+	}
+	for _, item := range orderBy {
+		parser.WalkExpr(item.Expr, visit)
+	}
+	return found
+}
+
+func isAggregateQuery(selectStmt *parser.SelectStatement, outputs []outputColumn, orderBy []parser.OrderByItem) bool {
+	if len(selectStmt.GroupBy) > 0 || selectStmt.Having != nil {
+		return true
+	}
+	for _, expr := range postAggregateExprs(selectStmt, outputs, orderBy) {
+		found := false
+		parser.WalkExpr(expr, func(e parser.Expr) bool {
+			if call, ok := e.(*parser.FuncCall); ok && call.IsAggregate() {
+				found = true
+			}
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// buildAggregation builds the grouping and HAVING operators. It returns a
+// function putting them on top of the rows of the FROM clause, the scope of
+// the grouped rows, which hold the group keys followed by the aggregate
+// results, whether the grouped rows already come out in ORDER BY order, and
+// the plan node and estimate of the grouping, given that of its input.
+//
+// A hash aggregation is used unless the ORDER BY consists of the group keys:
+// then the input is sorted on them and aggregated by streaming through the
+// ordered groups, which leaves the output in the requested order.
+func (e *Executor) buildAggregation(ctx context.Context, in *scope, selectStmt *parser.SelectStatement, outputs []outputColumn, orderBy []parser.OrderByItem, params []types.Value, x *explainer, child *explainNode, input planner.Estimate) (func(RowIterator) RowIterator, *scope, bool, *explainNode, planner.Estimate, error) {
+	out := &scope{computed: make(map[string]int), keys: in, grouped: true, env: in.env}
+
+	groupBy := make([]evaluator, len(selectStmt.GroupBy))
+	groupKeys := make(map[string]bool)
+	var groupNames []string
+	for i, expr := range selectStmt.GroupBy {
+		expr, err := resolveOutputRef(expr, outputs)
+		if err != nil {
+			return nil, nil, false, nil, planner.Estimate{}, err
+		}
+		eval, typ, err := compileExpr(expr, in, params)
+		if err != nil {
+			return nil, nil, false, nil, planner.Estimate{}, err
+		}
+		groupBy[i] = eval
+		groupNames = append(groupNames, expr.String())
+		key := in.exprKey(expr)
+		groupKeys[key] = true
+		out.computed[key] = len(out.columns)
+		out.columns = append(out.columns, catalog.Column{Name: key, Type: typ})
+		out.tables = append(out.tables, "")
+	}
+
+	var aggs []*aggregateCall
+	for _, call := range collectAggregates(in, postAggregateExprs(selectStmt, outputs, orderBy)) {
+		agg, err := compileAggregate(call, in, params)
+		if err != nil {
+			return nil, nil, false, nil, planner.Estimate{}, err
+		}
+		aggs = append(aggs, agg)
+		key := in.exprKey(call)
+		out.computed[key] = len(out.columns)
+		out.columns = append(out.columns, catalog.Column{Name: key, Type: agg.resultType()})
+		out.tables = append(out.tables, "")
+	}
+
+	sorted := len(groupBy) > 0 && len(orderBy) > 0
+	covered := make(map[string]bool)
+	for _, item := range orderBy {
+		key := in.exprKey(item.Expr)
+		sorted = sorted && groupKeys[key]
+		covered[key] = true
+	}
+	sorted = sorted && len(covered) == len(groupKeys)
+	var node *explainNode
+	var estimate planner.Estimate
+	var aggregate func(RowIterator) RowIterator
+	if sorted {
+		keys, err := compileSortKeys(orderBy, in, params)
+		if err != nil {
+			return nil, nil, false, nil, planner.Estimate{}, err
+		}
+		ordered := planner.SortEstimate(input, planner.RowWidth(len(in.columns)), e.workMem, -1)
+		estimate = planner.AggregateEstimate(ordered, len(groupBy), len(aggs))
+		sorter := sortNode(x, orderBy, child, ordered)
+		aggregate = func(rows RowIterator) RowIterator {
+			rows = x.wrap(sorter, newSortOperator(ctx, e.bufferManager, rows, keys, e.workMem, -1))
+			return newStreamAggregate(rows, groupBy, aggs)
+		}
+		node = x.node("GroupAggregate", &estimate, sorter)
+	} else {
+		aggregate = func(rows RowIterator) RowIterator {
+			return newHashAggregate(ctx, e.bufferManager, rows, groupBy, aggs, e.workMem)
+		}
+		estimate = planner.AggregateEstimate(input, len(groupBy), len(aggs))
+		if len(groupBy) > 0 {
+			node = x.node("HashAggregate", &estimate, child)
+		} else {
+			node = x.node("Aggregate", &estimate, child)
+		}
+	}
+	if node != nil && len(groupBy) > 0 {
+		node.props = append(node.props, explainProp{"Group Key", strings.Join(groupNames, ", ")})
+	}
+
+	var having evaluator
+	if selectStmt.Having != nil {
+		var err error
+		if having, err = compileCondition(selectStmt.Having, out, params); err != nil {
+			return nil, nil, false, nil, planner.Estimate{}, err
+		}
+		node.prop("Filter", selectStmt.Having)
+	}
+	return func(rows RowIterator) RowIterator {
+		rows = aggregate(rows)
+		if having != nil {
+			rows = &filter{child: rows, cond: having}
+		}
+		return x.wrap(node, rows)
+	}, out, sorted, node, estimate, nil
+}
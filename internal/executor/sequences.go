@@ -0,0 +1,263 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/txn"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Session holds what a connection remembers between statements: the last
+// value nextval returned for each sequence, which currval reads.
+type Session struct {
+	currval map[string]int64 // keyed by lower-cased sequence name
+}
+
+// NewSession returns the state of a new connection.
+func NewSession() *Session {
+	return &Session{currval: make(map[string]int64)}
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context that runs statements in session s.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// sessionFrom returns the session of a context, or nil.
+func sessionFrom(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// sequenceFunctions are the functions reading and advancing sequences,
+// whose calls are compiled by compileSequenceCall.
+var sequenceFunctions = map[string]bool{"NEXTVAL": true, "CURRVAL": true, "SETVAL": true}
+
+// compileSequenceCall compiles nextval(name), currval(name) or
+// setval(name, value [, is_called]). They need the executor and the
+// session of the statement, so they are only allowed where subqueries are.
+func compileSequenceCall(e *parser.FuncCall, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	if sc.env == nil {
+		return nil, types.TypeNull, fmt.Errorf("function %s is not allowed here", strings.ToLower(e.Name))
+	}
+	args := make([]evaluator, len(e.Args))
+	argTypes := make([]types.Type, len(e.Args))
+	for i, arg := range e.Args {
+		var err error
+		if args[i], argTypes[i], err = compileExpr(arg, sc, params); err != nil {
+			return nil, types.TypeNull, err
+		}
+	}
+	want, optional := []types.Type{types.TypeText}, 0
+	if e.Name == "SETVAL" {
+		want, optional = []types.Type{types.TypeText, types.TypeInteger, types.TypeBoolean}, 1
+	}
+	if len(args) < len(want)-optional || len(args) > len(want) {
+		return nil, types.TypeNull, signatureError(e.Name, argTypes)
+	}
+	for i, typ := range argTypes {
+		if typ != types.TypeNull && typ != want[i] {
+			return nil, types.TypeNull, signatureError(e.Name, argTypes)
+		}
+	}
+	cat := sc.env.e.catalog
+	// A sequence named by a literal has to exist already.
+	if lit, ok := e.Args[0].(*parser.Literal); ok && lit.Value.Type == types.TypeText {
+		if _, err := cat.GetSequence(lit.Value.Str); err != nil {
+			return nil, types.TypeNull, err
+		}
+	}
+	session := sessionFrom(sc.env.ctx)
+	name := e.Name
+	values := make([]types.Value, len(args))
+	return func(row Row) (types.Value, error) {
+		for i, arg := range args {
+			value, err := arg(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			if value.IsNull() {
+				return types.Null(), nil
+			}
+			values[i] = value
+		}
+		seqName := strings.ToLower(values[0].Str)
+		switch name {
+		case "NEXTVAL":
+			value, err := cat.NextValue(seqName)
+			if err != nil {
+				return types.Null(), err
+			}
+			if session != nil {
+				session.currval[seqName] = value
+			}
+			return types.NewInteger(value), nil
+		case "CURRVAL":
+			if _, err := cat.GetSequence(seqName); err != nil {
+				return types.Null(), err
+			}
+			value, ok := int64(0), false
+			if session != nil {
+				value, ok = session.currval[seqName]
+			}
+			if !ok {
+				return types.Null(), fmt.Errorf("currval of sequence %s is not yet defined in this session", seqName)
+			}
+			return types.NewInteger(value), nil
+		default:
+			called := len(values) < 3 || values[2].Bool
+			if err := cat.SetValue(seqName, values[1].Int, called); err != nil {
+				return types.Null(), err
+			}
+			return values[1], nil
+		}
+	}, types.TypeInteger, nil
+}
+
+// ExecuteCreateSequenceStatement registers a new sequence. Options left out
+// default to counting up from 1, or down from -1 for a negative increment.
+func (e *Executor) ExecuteCreateSequenceStatement(t *txn.Transaction, createSeq *parser.CreateSequenceStatement) (*Result, error) {
+	seq, err := newSequence(createSeq)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.createSequence(t, seq); err != nil {
+		return nil, err
+	}
+	return &Result{}, nil
+}
+
+// newSequence checks the options of CREATE SEQUENCE and fills in the
+// defaults of those left out.
+func newSequence(createSeq *parser.CreateSequenceStatement) (*catalog.Sequence, error) {
+	seq := &catalog.Sequence{Name: createSeq.Name, Increment: 1, Cycle: createSeq.Cycle}
+	if createSeq.Increment != nil {
+		seq.Increment = *createSeq.Increment
+	}
+	if seq.Increment == 0 {
+		return nil, fmt.Errorf("INCREMENT must not be zero")
+	}
+	seq.MinValue, seq.MaxValue = 1, math.MaxInt64
+	if seq.Increment < 0 {
+		seq.MinValue, seq.MaxValue = math.MinInt64, -1
+	}
+	if createSeq.MinValue != nil {
+		seq.MinValue = *createSeq.MinValue
+	}
+	if createSeq.MaxValue != nil {
+		seq.MaxValue = *createSeq.MaxValue
+	}
+	if seq.MinValue >= seq.MaxValue {
+		return nil, fmt.Errorf("MINVALUE (%d) must be less than MAXVALUE (%d)", seq.MinValue, seq.MaxValue)
+	}
+	seq.Start = seq.MinValue
+	if seq.Increment < 0 {
+		seq.Start = seq.MaxValue
+	}
+	if createSeq.Start != nil {
+		seq.Start = *createSeq.Start
+	}
+	if seq.Start < seq.MinValue {
+		return nil, fmt.Errorf("START value (%d) cannot be less than MINVALUE (%d)", seq.Start, seq.MinValue)
+	}
+	if seq.Start > seq.MaxValue {
+		return nil, fmt.Errorf("START value (%d) cannot be greater than MAXVALUE (%d)", seq.Start, seq.MaxValue)
+	}
+	seq.Reserved = seq.Start
+	return seq, nil
+}
+
+// createSequence registers a sequence, logging how to drop it.
+func (e *Executor) createSequence(t *txn.Transaction, seq *catalog.Sequence) error {
+	if err := e.catalog.CreateSequence(seq); err != nil {
+		return err
+	}
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoCreateSequence, SequenceDef: seq})
+	return nil
+}
+
+// ExecuteDropSequenceStatement removes a sequence from the catalog.
+func (e *Executor) ExecuteDropSequenceStatement(t *txn.Transaction, dropSeq *parser.DropSequenceStatement) (*Result, error) {
+	if err := e.dropSequence(t, dropSeq.Name); err != nil {
+		return nil, err
+	}
+	return &Result{}, nil
+}
+
+// dropSequence removes a sequence, logging how to restore it.
+func (e *Executor) dropSequence(t *txn.Transaction, name string) error {
+	seq, err := e.catalog.GetSequence(name)
+	if err != nil {
+		return err
+	}
+	if err := e.catalog.DropSequence(seq.Name); err != nil {
+		return err
+	}
+	t.AddUndo(txn.UndoRecord{Kind: txn.UndoDropSequence, SequenceDef: seq})
+	return nil
+}
+
+// serialTypes are the pseudo-types of columns filled from a sequence.
+var serialTypes = map[string]bool{"SERIAL": true, "BIGSERIAL": true, "SMALLSERIAL": true}
+
+// defineSequenceColumn gives a SERIAL, identity or AUTOINCREMENT column a
+// sequence of its own, named after its table and column, as its default.
+func (e *Executor) defineSequenceColumn(t *txn.Transaction, table *catalog.Table, col *catalog.Column, def parser.ColumnDefinition) error {
+	if col.Type != types.TypeInteger {
+		return fmt.Errorf("column %s: identity and AUTOINCREMENT columns must be of type INTEGER, not %s", col.Name, col.Type)
+	}
+	if def.Default != nil {
+		return fmt.Errorf("both default and identity specified for column %s of table %s", col.Name, table.Name)
+	}
+	name := strings.ToLower(table.Name + "_" + col.Name + "_seq")
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := e.catalog.GetSequence(candidate); err != nil {
+			break
+		}
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	seq, err := newSequence(&parser.CreateSequenceStatement{Name: candidate})
+	if err != nil {
+		return err
+	}
+	if err := e.createSequence(t, seq); err != nil {
+		return err
+	}
+	col.NotNull = true
+	col.Default = fmt.Sprintf("nextval('%s')", candidate)
+	col.Sequence = candidate
+	col.Identity = def.Identity
+	col.AutoIncrement = def.AutoIncrement
+	return nil
+}
+
+// checkAutoIncrement fails unless every AUTOINCREMENT column of a table is
+// its primary key on its own.
+func checkAutoIncrement(table *catalog.Table) error {
+	pk := table.PrimaryKey()
+	for _, col := range table.Columns {
+		if col.AutoIncrement && (pk == nil || len(pk.Columns) != 1 || !strings.EqualFold(pk.Columns[0], col.Name)) {
+			return fmt.Errorf("AUTOINCREMENT is only allowed on an INTEGER PRIMARY KEY")
+		}
+	}
+	return nil
+}
+
+// sequenceColumn returns the position of the first column of a table filled
+// from a sequence of its own, or -1.
+func sequenceColumn(table *catalog.Table) int {
+	for i, col := range table.Columns {
+		if col.Sequence != "" {
+			return i
+		}
+	}
+	return -1
+}
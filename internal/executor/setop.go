@@ -0,0 +1,340 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// planSetOperation plans a compound query. The rows of its two queries are
+// appended and, unless the operation is UNION ALL, grouped to remove
+// duplicates or to count how often each row appears on either side. The
+// final sort keeps only its first bound rows, unless bound is -1.
+func (e *Executor) planSetOperation(ctx context.Context, selectStmt *parser.SelectStatement, params []types.Value, x *explainer, outer *correlation, ctes cteSet, bound int) (*selectPlan, error) {
+	set := selectStmt.Set
+	left, err := e.planSelect(ctx, set.Left, params, x, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.planSelect(ctx, set.Right, params, x, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	if len(left.columns) != len(right.columns) {
+		return nil, fmt.Errorf("each %s query must have the same number of columns", set.Op)
+	}
+	columns := make([]catalog.Column, len(left.columns))
+	for i, col := range left.columns {
+		other := right.columns[i]
+		typ, err := commonType(set.Op.String(), []types.Type{col.Type, other.Type})
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = catalog.Column{Name: col.Name, Type: typ, NotNull: col.NotNull && other.NotNull}
+	}
+	positions, err := compoundOrder(selectStmt.OrderBy, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	// INTERSECT and EXCEPT tag each row with the side it comes from.
+	width := len(columns)
+	tagged := set.Op != parser.SetUnion
+	appended := planner.AppendEstimate(left.estimate, right.estimate)
+	node := x.node("Append", &appended, left.node, right.node)
+	sides := []*selectPlan{left, right}
+	appendRows := func() RowIterator {
+		return &appendIterator{sides: sides, columns: columns, tagged: tagged}
+	}
+	estimate := appended
+	switch set.Op {
+	case parser.SetIntersect:
+		estimate.Rows = left.estimate.Rows
+		if right.estimate.Rows < estimate.Rows {
+			estimate.Rows = right.estimate.Rows
+		}
+	case parser.SetExcept:
+		estimate.Rows = left.estimate.Rows
+	}
+
+	var group func(RowIterator) RowIterator
+	sorted := false
+	if !set.All || tagged {
+		var aggs []*aggregateCall
+		hashTitle, sortTitle := "HashAggregate", "Unique"
+		if tagged {
+			aggs = []*aggregateCall{tagCount(width, 0), tagCount(width, 1)}
+			hashTitle, sortTitle = "HashSetOp "+setOpTitle(set), "SetOp "+setOpTitle(set)
+		}
+		group, node, sorted, estimate = e.buildGrouping(ctx, columns, aggs, selectStmt.OrderBy, positions, estimate, x, node, hashTitle, sortTitle)
+		if tagged {
+			inner := group
+			group = func(rows RowIterator) RowIterator {
+				return &setOpOutput{child: inner(rows), op: set.Op, all: set.All, width: width}
+			}
+		}
+	}
+	var sorter func(RowIterator) RowIterator
+	if len(positions) > 0 && !sorted {
+		keys := positionSortKeys(selectStmt.OrderBy, positions)
+		estimate = planner.SortEstimate(estimate, planner.RowWidth(len(columns)), e.workMem, bound)
+		sortNode := topNSortNode(x, selectStmt.OrderBy, node, estimate, bound)
+		node = sortNode
+		sorter = func(rows RowIterator) RowIterator {
+			return x.wrap(sortNode, newSortOperator(ctx, e.bufferManager, rows, keys, e.workMem, bound))
+		}
+	}
+	return &selectPlan{
+		columns: columns,
+		open: func() (RowIterator, error) {
+			rows := appendRows()
+			if group != nil {
+				rows = group(rows)
+			}
+			if sorter != nil {
+				rows = sorter(rows)
+			}
+			return rows, nil
+		},
+		node:     node,
+		estimate: estimate,
+	}, nil
+}
+
+// setOpTitle names a set operation in a plan, as "Intersect All".
+func setOpTitle(set *parser.SetOperation) string {
+	title := set.Op.String()[:1] + strings.ToLower(set.Op.String()[1:])
+	if set.All {
+		title += " All"
+	}
+	return title
+}
+
+// compoundOrder resolves the ORDER BY of a compound query to the positions
+// of the columns it sorts on. Items may only name a column or give its
+// position.
+func compoundOrder(items []parser.OrderByItem, columns []catalog.Column) ([]int, error) {
+	positions := make([]int, len(items))
+	for i, item := range items {
+		positions[i] = -1
+		switch e := item.Expr.(type) {
+		case *parser.Literal:
+			if e.Value.Type == types.TypeInteger && e.Value.Int >= 1 && e.Value.Int <= int64(len(columns)) {
+				positions[i] = int(e.Value.Int) - 1
+			} else {
+				return nil, fmt.Errorf("position %s is not in select list", e)
+			}
+		case *parser.ColumnRef:
+			for j, col := range columns {
+				if e.Table == "" && strings.EqualFold(col.Name, e.Column) {
+					positions[i] = j
+					break
+				}
+			}
+		}
+		if positions[i] == -1 {
+			return nil, fmt.Errorf("invalid UNION/INTERSECT/EXCEPT ORDER BY clause: only result column names can be used, not %s", item.Expr)
+		}
+	}
+	return positions, nil
+}
+
+// distinctOrder resolves the ORDER BY of a SELECT DISTINCT to the positions
+// of the output columns it sorts on. Expressions are matched by their key in
+// sc.
+func distinctOrder(sc *scope, items []parser.OrderByItem, outputs []outputColumn) ([]int, error) {
+	positions := make([]int, len(items))
+	for i, item := range items {
+		positions[i] = -1
+		key := sc.exprKey(item.Expr)
+		for j, output := range outputs {
+			if sc.exprKey(output.expr) == key {
+				positions[i] = j
+				break
+			}
+		}
+		if positions[i] == -1 {
+			return nil, errors.New("for SELECT DISTINCT, ORDER BY expressions must appear in select list")
+		}
+	}
+	return positions, nil
+}
+
+// positionSortKeys returns the sort keys of ORDER BY items over the columns
+// at positions.
+func positionSortKeys(items []parser.OrderByItem, positions []int) []sortKey {
+	keys := make([]sortKey, len(items))
+	for i, item := range items {
+		keys[i] = sortKey{eval: columnEvaluator(positions[i]), desc: item.Desc, nullsFirst: nullsFirst(item)}
+	}
+	return keys
+}
+
+// buildGrouping builds the operator grouping equal rows on the given
+// columns, computing aggs over each group. When order is given, or when the
+// rows aren't expected to fit in work memory, the rows are sorted on the
+// ORDER BY columns then on the others and grouped as they stream past,
+// which leaves the groups in ORDER BY order; the operator is then named
+// sortTitle, over a Sort. Otherwise a hash aggregation named hashTitle is
+// used, which partitions groups to temporary pages when they outgrow work
+// memory. It returns the function putting the operator on top of its input,
+// its plan node, whether the groups come out in ORDER BY order and the
+// estimate of the groups, given that of the input.
+func (e *Executor) buildGrouping(ctx context.Context, columns []catalog.Column, aggs []*aggregateCall, order []parser.OrderByItem, positions []int, estimate planner.Estimate, x *explainer, child *explainNode, hashTitle, sortTitle string) (func(RowIterator) RowIterator, *explainNode, bool, planner.Estimate) {
+	groupBy := make([]evaluator, len(columns))
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		groupBy[i] = columnEvaluator(i)
+		names[i] = col.Name
+	}
+	rowBytes := float64(rowSize(make(Row, len(columns))))
+	if len(order) == 0 && estimate.Rows*rowBytes <= float64(e.workMem) {
+		grouped := planner.AggregateEstimate(estimate, len(columns), len(aggs))
+		node := x.node(hashTitle, &grouped, child)
+		if node != nil {
+			node.props = append(node.props, explainProp{"Group Key", strings.Join(names, ", ")})
+		}
+		return func(rows RowIterator) RowIterator {
+			return x.wrap(node, newHashAggregate(ctx, e.bufferManager, rows, groupBy, aggs, e.workMem))
+		}, node, false, grouped
+	}
+
+	keys := positionSortKeys(order, positions)
+	items := append([]parser.OrderByItem{}, order...)
+	used := make(map[int]bool)
+	for _, pos := range positions {
+		used[pos] = true
+	}
+	for i, col := range columns {
+		if !used[i] {
+			keys = append(keys, sortKey{eval: columnEvaluator(i)})
+			items = append(items, parser.OrderByItem{Expr: &parser.ColumnRef{Column: col.Name}})
+		}
+	}
+	ordered := planner.SortEstimate(estimate, planner.RowWidth(len(columns)), e.workMem, -1)
+	grouped := planner.AggregateEstimate(ordered, len(columns), len(aggs))
+	sorter := sortNode(x, items, child, ordered)
+	node := x.node(sortTitle, &grouped, sorter)
+	return func(rows RowIterator) RowIterator {
+		rows = x.wrap(sorter, newSortOperator(ctx, e.bufferManager, rows, keys, e.workMem, -1))
+		return x.wrap(node, newStreamAggregate(rows, groupBy, aggs))
+	}, node, true, grouped
+}
+
+// tagCount counts the rows of a group that come from the given side of a set
+// operation, whose tag follows the width columns of the row.
+func tagCount(width int, side int64) *aggregateCall {
+	return &aggregateCall{
+		name: "COUNT",
+		args: []evaluator{func(row Row) (types.Value, error) {
+			if row[width].Int != side {
+				return types.Null(), nil
+			}
+			return types.NewInteger(1), nil
+		}},
+		argType: types.TypeInteger,
+	}
+}
+
+// appendIterator returns the rows of each side in turn, converted to the
+// types of the columns and, when tagged, followed by the number of their
+// side.
+type appendIterator struct {
+	sides   []*selectPlan
+	columns []catalog.Column
+	tagged  bool
+
+	side    int
+	current RowIterator
+}
+
+func (a *appendIterator) Next() (Row, error) {
+	for a.side < len(a.sides) {
+		if a.current == nil {
+			rows, err := a.sides[a.side].open()
+			if err != nil {
+				return nil, err
+			}
+			a.current = rows
+		}
+		row, err := a.current.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			err := a.current.Close()
+			a.current = nil
+			a.side++
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if row, err = castRow(row, a.columns); err != nil {
+			return nil, err
+		}
+		if a.tagged {
+			row = append(row, types.NewInteger(int64(a.side)))
+		}
+		return row, nil
+	}
+	return nil, nil
+}
+
+func (a *appendIterator) Close() error {
+	if a.current == nil {
+		return nil
+	}
+	err := a.current.Close()
+	a.current = nil
+	return err
+}
+
+// setOpOutput turns the groups of an INTERSECT or EXCEPT, each holding a
+// row with the number of times it appears on the left and on the right, into
+// the rows of the result.
+type setOpOutput struct {
+	child RowIterator
+	op    parser.SetOperator
+	all   bool
+	width int
+
+	row       Row
+	remaining int64
+}
+
+func (s *setOpOutput) Next() (Row, error) {
+	for s.remaining == 0 {
+		group, err := s.child.Next()
+		if err != nil || group == nil {
+			return nil, err
+		}
+		left, right := group[s.width].Int, group[s.width+1].Int
+		switch {
+		case s.op == parser.SetIntersect && s.all:
+			s.remaining = left
+			if right < left {
+				s.remaining = right
+			}
+		case s.op == parser.SetIntersect && left > 0 && right > 0:
+			s.remaining = 1
+		case s.op == parser.SetExcept && s.all && left > right:
+			s.remaining = left - right
+		case s.op == parser.SetExcept && !s.all && left > 0 && right == 0:
+			s.remaining = 1
+		}
+		s.row = group[:s.width]
+	}
+	s.remaining--
+	return append(Row(nil), s.row...), nil
+}
+
+func (s *setOpOutput) Close() error {
+	return s.child.Close()
+}
@@ -0,0 +1,9 @@
+package executor
+
+import "testing"
+
+func TestDistinctSpill(t *testing.T) {
+	db := newTestDB(t)
+	db.fillSpillTables()
+	db.checkSpill("SELECT DISTINCT g, pad FROM a", "HashAggregate")
+}
@@ -0,0 +1,357 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// DefaultWorkMem is the memory budget, in bytes, an operator such as sort may
+// use before spilling to temporary pages.
+const DefaultWorkMem = 4 << 20
+
+// sortKey is one ORDER BY key resolved against the input rows.
+type sortKey struct {
+	eval       evaluator
+	desc       bool
+	nullsFirst bool
+}
+
+// sortEntry is a row together with its precomputed sort key values.
+type sortEntry struct {
+	keys []types.Value
+	row  Row
+}
+
+func makeSortEntry(keys []sortKey, row Row) (sortEntry, error) {
+	entry := sortEntry{keys: make([]types.Value, len(keys)), row: row}
+	for i, key := range keys {
+		value, err := key.eval(row)
+		if err != nil {
+			return entry, err
+		}
+		entry.keys[i] = value
+	}
+	return entry, nil
+}
+
+// compareEntries orders two entries by the sort keys.
+func compareEntries(keys []sortKey, a, b sortEntry) (int, error) {
+	for i, key := range keys {
+		av, bv := a.keys[i], b.keys[i]
+		var cmp int
+		switch {
+		case av.IsNull() && bv.IsNull():
+			cmp = 0
+		case av.IsNull() || bv.IsNull():
+			// NULLs are placed independently of the sort direction.
+			cmp = 1
+			if av.IsNull() == key.nullsFirst {
+				cmp = -1
+			}
+			return cmp, nil
+		default:
+			var err error
+			if cmp, err = types.Compare(av, bv); err != nil {
+				return 0, err
+			}
+		}
+		if key.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return 0, nil
+}
+
+// rowSize estimates the memory taken by a row.
+func rowSize(row Row) int {
+	size := 24
+	for _, value := range row {
+		size += 48 + len(value.Str)
+	}
+	return size
+}
+
+// sortOperator sorts the rows of its child. Rows are sorted in memory until
+// they exceed workMem, after which each sorted batch is spilled as a run of
+// temporary pages and the runs are combined with a k-way merge. With a limit
+// only the first limit rows are kept, using a bounded heap.
+type sortOperator struct {
+	ctx     context.Context
+	bp      *storage.BufferPool
+	child   RowIterator
+	keys    []sortKey
+	workMem int
+	limit   int // -1 when every row is needed
+
+	loaded bool
+	rows   []sortEntry // sorted rows when nothing was spilled
+	pos    int
+	runs   []*storage.TableHeap
+	merger *runMerger
+}
+
+func newSortOperator(ctx context.Context, bp *storage.BufferPool, child RowIterator, keys []sortKey, workMem, limit int) *sortOperator {
+	return &sortOperator{ctx: ctx, bp: bp, child: child, keys: keys, workMem: workMem, limit: limit}
+}
+
+func (s *sortOperator) Next() (Row, error) {
+	if !s.loaded {
+		s.loaded = true
+		var err error
+		if s.limit >= 0 {
+			err = s.loadTopN()
+		} else {
+			err = s.load()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.merger != nil {
+		return s.merger.next()
+	}
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	row := s.rows[s.pos].row
+	s.rows[s.pos] = sortEntry{}
+	s.pos++
+	return row, nil
+}
+
+func (s *sortOperator) Close() error {
+	err := s.child.Close()
+	for _, run := range s.runs {
+		if freeErr := run.Free(); freeErr != nil && err == nil {
+			err = freeErr
+		}
+	}
+	s.runs = nil
+	s.rows = nil
+	return err
+}
+
+// sortEntries sorts a batch of entries in place.
+func (s *sortOperator) sortEntries(entries []sortEntry) error {
+	var sortErr error
+	sort.SliceStable(entries, func(i, j int) bool {
+		cmp, err := compareEntries(s.keys, entries[i], entries[j])
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return cmp < 0
+	})
+	return sortErr
+}
+
+// load consumes the child, spilling sorted runs whenever the buffered rows
+// exceed the memory budget.
+func (s *sortOperator) load() error {
+	used := 0
+	for {
+		row, err := s.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		entry, err := makeSortEntry(s.keys, row)
+		if err != nil {
+			return err
+		}
+		s.rows = append(s.rows, entry)
+		used += rowSize(row)
+		if used > s.workMem {
+			if err := s.spill(); err != nil {
+				return err
+			}
+			used = 0
+		}
+	}
+	if len(s.runs) == 0 {
+		return s.sortEntries(s.rows)
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	merger, err := newRunMerger(s.keys, s.runs)
+	if err != nil {
+		return err
+	}
+	s.merger = merger
+	return nil
+}
+
+// spill sorts the buffered rows and writes them out as a new run.
+func (s *sortOperator) spill() error {
+	if err := s.sortEntries(s.rows); err != nil {
+		return err
+	}
+	run, err := storage.CreateTempHeap(s.bp)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	for _, entry := range s.rows {
+		if _, err := run.Insert(encodeRow(entry.row)); err != nil {
+			return err
+		}
+	}
+	s.rows = nil
+	return nil
+}
+
+// loadTopN keeps the first limit rows in a max-heap ordered by the sort keys,
+// so rows that can't make it into the result are dropped immediately.
+func (s *sortOperator) loadTopN() error {
+	top := &entryHeap{keys: s.keys, reverse: true}
+	for {
+		row, err := s.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if s.limit == 0 {
+			continue
+		}
+		entry, err := makeSortEntry(s.keys, row)
+		if err != nil {
+			return err
+		}
+		if top.Len() < s.limit {
+			heap.Push(top, entry)
+		} else {
+			cmp, err := compareEntries(s.keys, entry, top.entries[0])
+			if err != nil {
+				return err
+			}
+			if cmp < 0 {
+				top.entries[0] = entry
+				heap.Fix(top, 0)
+			}
+		}
+		if top.err != nil {
+			return top.err
+		}
+	}
+	s.rows = top.entries
+	return s.sortEntries(s.rows)
+}
+
+// entryHeap is a heap of sort entries, smallest first or, when reverse is
+// set, largest first.
+type entryHeap struct {
+	keys    []sortKey
+	entries []sortEntry
+	reverse bool
+	err     error
+}
+
+func (h *entryHeap) Len() int { return len(h.entries) }
+
+func (h *entryHeap) Less(i, j int) bool {
+	cmp, err := compareEntries(h.keys, h.entries[i], h.entries[j])
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *entryHeap) Swap(i, j int) { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+
+func (h *entryHeap) Push(x interface{}) { h.entries = append(h.entries, x.(sortEntry)) }
+
+func (h *entryHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// runCursor reads a sorted run one row at a time.
+type runCursor struct {
+	iter *storage.HeapIterator
+}
+
+func (c *runCursor) next(keys []sortKey) (sortEntry, bool, error) {
+	_, data, err := c.iter.Next()
+	if err != nil || data == nil {
+		return sortEntry{}, false, err
+	}
+	row, err := decodeValues(data)
+	if err != nil {
+		return sortEntry{}, false, err
+	}
+	entry, err := makeSortEntry(keys, row)
+	return entry, err == nil, err
+}
+
+// runMerger performs a k-way merge of sorted runs using a heap holding the
+// current row of each run.
+type runMerger struct {
+	keys    []sortKey
+	cursors []*runCursor
+	heap    *entryHeap
+	sources []int // run each heap entry came from, parallel to heap entries
+}
+
+func newRunMerger(keys []sortKey, runs []*storage.TableHeap) (*runMerger, error) {
+	m := &runMerger{keys: keys, heap: &entryHeap{keys: keys}}
+	for i, run := range runs {
+		cursor := &runCursor{iter: run.Iterator()}
+		m.cursors = append(m.cursors, cursor)
+		entry, ok, err := cursor.next(keys)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.push(entry, i)
+		}
+	}
+	return m, m.heap.err
+}
+
+// push adds an entry to the heap, tagging it with the index of its run in the
+// last key slot so the run can be advanced once the entry is popped.
+func (m *runMerger) push(entry sortEntry, source int) {
+	entry.row = append(entry.row, types.NewInteger(int64(source)))
+	heap.Push(m.heap, entry)
+}
+
+func (m *runMerger) next() (Row, error) {
+	if m.heap.Len() == 0 {
+		return nil, nil
+	}
+	entry := heap.Pop(m.heap).(sortEntry)
+	if m.heap.err != nil {
+		return nil, m.heap.err
+	}
+	source := int(entry.row[len(entry.row)-1].Int)
+	row := entry.row[:len(entry.row)-1]
+
+	next, ok, err := m.cursors[source].next(m.keys)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		m.push(next, source)
+	}
+	return row, m.heap.err
+}
@@ -0,0 +1,9 @@
+package executor
+
+import "testing"
+
+func TestSortSpill(t *testing.T) {
+	db := newTestDB(t)
+	db.fillSpillTables()
+	db.checkSpill("SELECT g, id, pad FROM a ORDER BY g DESC, id", "Sort")
+}
@@ -0,0 +1,297 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// queryEnv is what compiling a subquery needs from the statement it appears
+// in: the executor, the statement's context and explainer, the query the
+// expression belongs to when that is itself a subquery, and the WITH
+// queries in scope.
+type queryEnv struct {
+	e     *Executor
+	ctx   context.Context
+	x     *explainer
+	outer *correlation
+	ctes  cteSet
+}
+
+// withEnv lets the expressions compiled in sc have subqueries, nested in
+// the query outer links to when it is set.
+func (e *Executor) withEnv(sc *scope, ctx context.Context, x *explainer, outer *correlation, ctes cteSet) *scope {
+	sc.env = &queryEnv{e: e, ctx: ctx, x: x, outer: outer, ctes: ctes}
+	return sc
+}
+
+// correlation links a subquery to the query it is nested in: the scope of
+// the expression holding the subquery and the row that expression is being
+// evaluated on. Column references the subquery can't resolve itself are
+// compiled in that scope and read from that row. Once one is, the subquery
+// is correlated and runs again for every row instead of once.
+type correlation struct {
+	scope *scope
+	row   Row
+	used  bool
+}
+
+// compile compiles a reference to a column of the enclosing query.
+func (c *correlation) compile(ref *parser.ColumnRef, params []types.Value) (evaluator, types.Type, error) {
+	eval, typ, err := compileExpr(ref, c.scope, params)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	c.used = true
+	return func(Row) (types.Value, error) {
+		return eval(c.row)
+	}, typ, nil
+}
+
+// compileSubquery compiles a scalar subquery, EXISTS or IN over a subquery.
+// The subquery is planned once. An uncorrelated one runs the first time it
+// is needed and its result is kept; a correlated one runs for each row.
+func compileSubquery(expr parser.Expr, sc *scope, params []types.Value) (evaluator, types.Type, error) {
+	if sc.env == nil {
+		return nil, types.TypeNull, errors.New("subqueries are not supported here")
+	}
+	env := sc.env
+	var operand evaluator
+	var operandType types.Type
+	if in, ok := expr.(*parser.InExpr); ok {
+		var err error
+		if operand, operandType, err = compileExpr(in.Expr, sc, params); err != nil {
+			return nil, types.TypeNull, err
+		}
+	}
+	num := env.x.nextSubplan()
+	corr := &correlation{scope: sc}
+	plan, err := env.e.planSelect(env.ctx, parser.Subquery(expr), params, env.x, corr, env.ctes)
+	if err != nil {
+		return nil, types.TypeNull, err
+	}
+	_, exists := expr.(*parser.ExistsExpr)
+	if !exists && len(plan.columns) != 1 {
+		return nil, types.TypeNull, errors.New("subquery must return only one column")
+	}
+	title := fmt.Sprintf("InitPlan %d", num)
+	if corr.used {
+		title = fmt.Sprintf("SubPlan %d", num)
+	}
+	node := env.x.subplan(num, title, plan.node)
+	run := func(row Row) (RowIterator, error) {
+		corr.row = row
+		rows, err := plan.open()
+		if err != nil {
+			return nil, err
+		}
+		return env.x.wrap(node, rows), nil
+	}
+
+	switch e := expr.(type) {
+	case *parser.ExistsExpr:
+		eval := func(row Row) (types.Value, error) {
+			rows, err := run(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			first, err := rows.Next()
+			if err = closeIterator(rows, err); err != nil {
+				return types.Null(), err
+			}
+			return types.NewBoolean(first != nil), nil
+		}
+		if !corr.used {
+			eval = memoize(eval)
+		}
+		return eval, types.TypeBoolean, nil
+	case *parser.InExpr:
+		colType := plan.columns[0].Type
+		if !comparable(operandType, colType) {
+			return nil, types.TypeNull, fmt.Errorf("operator does not exist: %s = %s", operandType, colType)
+		}
+		hashed := operandType != types.TypeNull && colType != types.TypeNull && hashable(operandType, colType)
+		load := func(row Row) (*valueSet, error) {
+			rows, err := run(row)
+			if err != nil {
+				return nil, err
+			}
+			return collectValues(rows, hashed)
+		}
+		var cached *valueSet
+		return func(row Row) (types.Value, error) {
+			value, err := operand(row)
+			if err != nil {
+				return types.Null(), err
+			}
+			set := cached
+			if set == nil {
+				if set, err = load(row); err != nil {
+					return types.Null(), err
+				}
+				if !corr.used {
+					cached = set
+				}
+			}
+			return set.in(value, e.Not)
+		}, types.TypeBoolean, nil
+	}
+	eval := func(row Row) (types.Value, error) {
+		rows, err := run(row)
+		if err != nil {
+			return types.Null(), err
+		}
+		first, err := rows.Next()
+		if err == nil && first != nil {
+			var second Row
+			if second, err = rows.Next(); err == nil && second != nil {
+				err = errors.New("more than one row returned by a subquery used as an expression")
+			}
+		}
+		if err = closeIterator(rows, err); err != nil || first == nil {
+			return types.Null(), err
+		}
+		return first[0], nil
+	}
+	if !corr.used {
+		eval = memoize(eval)
+	}
+	return eval, plan.columns[0].Type, nil
+}
+
+// closeIterator closes rows, returning err or else the error of closing.
+func closeIterator(rows RowIterator, err error) error {
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// memoize returns an evaluator computing its value once, on the first row.
+func memoize(eval evaluator) evaluator {
+	var value types.Value
+	done := false
+	return func(row Row) (types.Value, error) {
+		if !done {
+			var err error
+			if value, err = eval(row); err != nil {
+				return types.Null(), err
+			}
+			done = true
+		}
+		return value, nil
+	}
+}
+
+// valueSet holds the values an IN subquery returns.
+type valueSet struct {
+	values  []types.Value
+	hashed  map[string]bool // nil when values are compared one by one
+	hasNull bool
+}
+
+// collectValues reads the single-column rows of a subquery into a set and
+// closes them. Values are hashed when equal values encode alike.
+func collectValues(rows RowIterator, hashed bool) (*valueSet, error) {
+	set := &valueSet{}
+	if hashed {
+		set.hashed = make(map[string]bool)
+	}
+	for {
+		row, err := rows.Next()
+		if err != nil || row == nil {
+			return set, closeIterator(rows, err)
+		}
+		switch {
+		case row[0].IsNull():
+			set.hasNull = true
+		case hashed:
+			set.hashed[hashJoinKey(row[:1])] = true
+		default:
+			set.values = append(set.values, row[0])
+		}
+	}
+}
+
+func (s *valueSet) empty() bool {
+	return !s.hasNull && len(s.values) == 0 && len(s.hashed) == 0
+}
+
+// in returns value IN the set, or NOT IN when not is set: TRUE if the set
+// holds the value, NULL if it doesn't but the value or a member is NULL,
+// and FALSE otherwise. Nothing is in an empty set, not even NULL.
+func (s *valueSet) in(value types.Value, not bool) (types.Value, error) {
+	if s.empty() {
+		return types.NewBoolean(not), nil
+	}
+	if value.IsNull() {
+		return types.Null(), nil
+	}
+	if s.hashed != nil {
+		if s.hashed[hashJoinKey(Row{value})] {
+			return types.NewBoolean(!not), nil
+		}
+	} else {
+		for _, member := range s.values {
+			cmp, err := types.Compare(value, member)
+			if err != nil {
+				return types.Null(), err
+			}
+			if cmp == 0 {
+				return types.NewBoolean(!not), nil
+			}
+		}
+	}
+	if s.hasNull {
+		return types.Null(), nil
+	}
+	return types.NewBoolean(not), nil
+}
+
+// planDerived plans the subqueries and references to WITH queries of a FROM
+// clause. Subqueries may refer to the columns of the queries the FROM clause
+// is nested in, through outer, but not to the other tables of the FROM
+// clause.
+func (e *Executor) planDerived(ctx context.Context, ref parser.TableRef, params []types.Value, x *explainer, outer *correlation, ctes cteSet) (map[parser.TableRef]*planner.Subquery, error) {
+	derived := make(map[parser.TableRef]*planner.Subquery)
+	var walk func(ref parser.TableRef) error
+	walk = func(ref parser.TableRef) error {
+		switch r := ref.(type) {
+		case *parser.Join:
+			if err := walk(r.Left); err != nil {
+				return err
+			}
+			return walk(r.Right)
+		case *parser.TableName:
+			if r.CTE != nil {
+				t := ctes[r.CTE]
+				if t == nil {
+					return fmt.Errorf("WITH query %s is not planned", r.Name)
+				}
+				derived[r] = e.reference(ctx, t, x)
+			}
+		case *parser.DerivedTable:
+			plan, err := e.planSelect(ctx, r.Select, params, x, outer, ctes)
+			if err != nil {
+				return err
+			}
+			derived[r] = &planner.Subquery{Estimate: plan.estimate, Columns: plan.columns, Plan: plan}
+		}
+		return nil
+	}
+	if err := walk(ref); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// derivedTable describes the columns of a planned subquery or WITH query of
+// the FROM clause as a table of the given name.
+func derivedTable(name string, sub *planner.Subquery) *catalog.Table {
+	return &catalog.Table{Name: name, Columns: sub.Columns}
+}
@@ -0,0 +1,183 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// ScalarFunction is a user-defined function returning one value per call.
+type ScalarFunction struct {
+	Name string
+	// Args are the types the arguments are converted to. TypeNull accepts a
+	// value of any type as it is.
+	Args []types.Type
+	// Variadic functions take any number of arguments of their last type.
+	Variadic bool
+	// Returns is the type of the result, which Func's return value is
+	// converted to.
+	Returns types.Type
+	// Volatile functions may return different results when called with the
+	// same arguments, like RANDOM. Calls of deterministic functions whose
+	// arguments are the same for every row are made once per statement.
+	Volatile bool
+	// CalledOnNullInput functions are called with NULL arguments. Calls of
+	// the others return NULL if any argument is NULL.
+	CalledOnNullInput bool
+	Func              func(args []types.Value) (types.Value, error)
+}
+
+// AggregateFunction is a user-defined aggregate function. Each group starts
+// with the state returned by Init, Step folds the arguments of each row into
+// the state and Final turns the state into the result.
+type AggregateFunction struct {
+	Name string
+	// Args are the types the arguments are converted to. TypeNull accepts a
+	// value of any type as it is.
+	Args []types.Type
+	// Returns is the type of the result, which Final's return value is
+	// converted to.
+	Returns types.Type
+	// CalledOnNullInput aggregates see rows with NULL arguments. The others
+	// skip them, like SUM does.
+	CalledOnNullInput bool
+	Init              func() interface{}
+	Step              func(state interface{}, args []types.Value) (interface{}, error)
+	Final             func(state interface{}) (types.Value, error)
+}
+
+// Functions is the registry of the user-defined functions of an executor.
+// The parser resolves calls against it, so registering a function only
+// affects statements parsed afterwards. It is safe for concurrent use.
+type Functions struct {
+	mu         sync.RWMutex
+	scalars    map[string]*builtin
+	aggregates map[string]*AggregateFunction
+}
+
+func newFunctions() *Functions {
+	return &Functions{
+		scalars:    make(map[string]*builtin),
+		aggregates: make(map[string]*AggregateFunction),
+	}
+}
+
+// RegisterScalar adds a scalar function, replacing any user-defined function
+// of the same name.
+func (f *Functions) RegisterScalar(fn *ScalarFunction) error {
+	name, err := checkFunction(fn.Name, fn.Args, fn.Returns)
+	if err != nil {
+		return err
+	}
+	if fn.Func == nil {
+		return fmt.Errorf("function %s has no implementation", strings.ToLower(name))
+	}
+	if fn.Variadic && len(fn.Args) == 0 {
+		return fmt.Errorf("variadic function %s must declare an argument type", strings.ToLower(name))
+	}
+	params := append([]types.Type{}, fn.Args...)
+	call := fn.Func
+	def := &builtin{
+		params:   params,
+		variadic: fn.Variadic,
+		result:   returns(fn.Returns),
+		nullable: fn.CalledOnNullInput,
+		volatile: fn.Volatile,
+		call: func(args []types.Value) (types.Value, error) {
+			return call(append([]types.Value{}, args...))
+		},
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.aggregates, name)
+	f.scalars[name] = def
+	return nil
+}
+
+// RegisterAggregate adds an aggregate function, replacing any user-defined
+// function of the same name.
+func (f *Functions) RegisterAggregate(fn *AggregateFunction) error {
+	name, err := checkFunction(fn.Name, fn.Args, fn.Returns)
+	if err != nil {
+		return err
+	}
+	if fn.Init == nil || fn.Step == nil || fn.Final == nil {
+		return fmt.Errorf("aggregate function %s needs Init, Step and Final", strings.ToLower(name))
+	}
+	if len(fn.Args) == 0 {
+		return fmt.Errorf("aggregate function %s must take at least one argument", strings.ToLower(name))
+	}
+	def := *fn
+	def.Name = name
+	def.Args = append([]types.Type{}, fn.Args...)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.scalars, name)
+	f.aggregates[name] = &def
+	return nil
+}
+
+// Unregister removes the user-defined function with the given name and
+// reports whether there was one.
+func (f *Functions) Unregister(name string) bool {
+	name = strings.ToUpper(name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, scalar := f.scalars[name]
+	_, aggregate := f.aggregates[name]
+	delete(f.scalars, name)
+	delete(f.aggregates, name)
+	return scalar || aggregate
+}
+
+// ResolveFunction implements parser.FunctionResolver.
+func (f *Functions) ResolveFunction(name string) (interface{}, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if fn, ok := f.scalars[name]; ok {
+		return fn, false
+	}
+	if fn, ok := f.aggregates[name]; ok {
+		return fn, true
+	}
+	return nil, false
+}
+
+// checkFunction validates the signature of a function to register and
+// returns its upper-cased name.
+func checkFunction(name string, args []types.Type, result types.Type) (string, error) {
+	if name == "" {
+		return "", errors.New("function name is required")
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "", fmt.Errorf("invalid function name %q", name)
+		}
+	}
+	name = strings.ToUpper(name)
+	if _, ok := builtins[name]; ok || name == "COALESCE" || name == "EXTRACT" || parser.IsBuiltinAggregate(name) ||
+		parser.IsBuiltinWindow(name) || sequenceFunctions[name] {
+		return "", fmt.Errorf("function %s is built in", strings.ToLower(name))
+	}
+	for _, arg := range args {
+		if !validType(arg) && arg != types.TypeNull {
+			return "", fmt.Errorf("function %s has an argument of unknown type %d", strings.ToLower(name), arg)
+		}
+	}
+	if !validType(result) {
+		return "", fmt.Errorf("function %s must return INTEGER, REAL, TEXT or BOOLEAN", strings.ToLower(name))
+	}
+	return name, nil
+}
+
+func validType(t types.Type) bool {
+	switch t {
+	case types.TypeInteger, types.TypeReal, types.TypeText, types.TypeBoolean:
+		return true
+	}
+	return false
+}
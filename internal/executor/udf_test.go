@@ -0,0 +1,21 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+func TestRegisterBuiltinName(t *testing.T) {
+	f := newFunctions()
+	call := func(args []types.Value) (types.Value, error) { return types.NewInteger(42), nil }
+	for _, name := range []string{"abs", "coalesce", "extract", "count", "nextval", "currval", "setval", "row_number", "rank", "lag"} {
+		err := f.RegisterScalar(&ScalarFunction{Name: name, Args: []types.Type{types.TypeText}, Returns: types.TypeInteger, Func: call})
+		if err == nil {
+			t.Errorf("registering %s succeeded, want an error", name)
+		}
+	}
+	if err := f.RegisterScalar(&ScalarFunction{Name: "answer", Returns: types.TypeInteger, Func: call}); err != nil {
+		t.Fatal(err)
+	}
+}
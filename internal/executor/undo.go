@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// Undo applies undo records, newest first, reverting the changes they describe.
+func (e *Executor) Undo(records []txn.UndoRecord) error {
+	for _, rec := range records {
+		if err := e.undoRecord(rec); err != nil {
+			return fmt.Errorf("undo failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (e *Executor) undoRecord(rec txn.UndoRecord) error {
+	switch rec.Kind {
+	case txn.UndoCreateTable:
+		return e.ExecuteDropTable(rec.Table)
+	case txn.UndoDropTable:
+		if err := e.catalog.CreateTable(rec.TableDef); err != nil {
+			return err
+		}
+		return nil
+	case txn.UndoCreateIndex:
+		return e.catalog.DropIndex(rec.IndexDef.Name)
+	case txn.UndoDropIndex:
+		return e.catalog.CreateIndex(rec.Table, rec.IndexDef)
+	case txn.UndoAlterTable:
+		return e.replaceTable(rec.Table, rec.TableDef)
+	case txn.UndoCreateSequence:
+		return e.catalog.DropSequence(rec.SequenceDef.Name)
+	case txn.UndoDropSequence:
+		return e.catalog.CreateSequence(rec.SequenceDef)
+	}
+
+	table, err := e.catalog.GetTable(rec.Table)
+	if err != nil {
+		return err
+	}
+	heap := e.tableHeap(table)
+	switch rec.Kind {
+	case txn.UndoInsert:
+		if err := e.deleteStoredIndexEntries(table, rec.RID); err != nil {
+			return err
+		}
+		return heap.Delete(rec.RID)
+	case txn.UndoDelete:
+		return heap.SetXmax(rec.RID, 0, 0)
+	case txn.UndoUpdate:
+		if err := e.deleteStoredIndexEntries(table, rec.NewRID); err != nil {
+			return err
+		}
+		if err := heap.Delete(rec.NewRID); err != nil {
+			return err
+		}
+		return heap.SetXmax(rec.RID, 0, 0)
+	default:
+		return fmt.Errorf("unknown undo record kind %d", rec.Kind)
+	}
+}
@@ -0,0 +1,731 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/planner"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// windowCall is a window function call compiled against the input rows of
+// the window operator.
+type windowCall struct {
+	name  string
+	args  []evaluator
+	typ   types.Type
+	agg   *aggregateCall // set when an aggregate is called as a window function
+	frame windowFrame
+}
+
+// windowFrame is the frame of a window function call with its offsets
+// evaluated.
+type windowFrame struct {
+	mode        parser.FrameMode
+	start, end  parser.BoundType
+	startOffset types.Value
+	endOffset   types.Value
+}
+
+// windowClause is the window functions computed over one partitioning and
+// ordering of the rows, by one window operator.
+type windowClause struct {
+	partitionBy []parser.Expr
+	orderBy     []parser.OrderByItem
+	calls       []*parser.FuncCall
+}
+
+// sortItems are the keys the rows of a window clause are sorted on: the
+// partition keys, then the ORDER BY of the clause.
+func (w *windowClause) sortItems() []parser.OrderByItem {
+	items := make([]parser.OrderByItem, 0, len(w.partitionBy)+len(w.orderBy))
+	for _, expr := range w.partitionBy {
+		items = append(items, parser.OrderByItem{Expr: expr})
+	}
+	return append(items, w.orderBy...)
+}
+
+// collectWindows returns the distinct window function calls in the
+// expressions, grouped by the partitioning and ordering they are computed
+// over, in the order they first appear. Calls and clauses are told apart by
+// their key in sc.
+func collectWindows(sc *scope, exprs []parser.Expr) []*windowClause {
+	var clauses []*windowClause
+	byKey := make(map[string]*windowClause)
+	seen := make(map[string]bool)
+	for _, expr := range exprs {
+		parser.WalkExpr(expr, func(e parser.Expr) bool {
+			call, ok := e.(*parser.FuncCall)
+			if !ok || call.Over == nil {
+				return true
+			}
+			if key := sc.exprKey(call); !seen[key] {
+				seen[key] = true
+				spec := &parser.WindowSpec{PartitionBy: call.Over.PartitionBy, OrderBy: call.Over.OrderBy}
+				clauseKey := sc.qualify(&parser.FuncCall{Over: spec}).String()
+				clause := byKey[clauseKey]
+				if clause == nil {
+					clause = &windowClause{partitionBy: spec.PartitionBy, orderBy: spec.OrderBy}
+					byKey[clauseKey] = clause
+					clauses = append(clauses, clause)
+				}
+				clause.calls = append(clause.calls, call)
+			}
+			return false
+		})
+	}
+	return clauses
+}
+
+// buildWindows builds the window operators computing the window function
+// calls in outputs and orderBy. It returns a function putting them on top
+// of the rows in scope in, the scope of their output rows, which hold the
+// input columns followed by the results of the calls, whether those rows
+// already come out in ORDER BY order, and the plan node and estimate of the
+// last operator, given the estimate of its input.
+//
+// Each window clause sorts the rows on its partition and ORDER BY keys and
+// computes its calls a partition at a time. A clause whose sort order gives
+// the ORDER BY of the query is computed last, so that no sort is needed
+// after it.
+func (e *Executor) buildWindows(ctx context.Context, in *scope, outputs []outputColumn, orderBy []parser.OrderByItem, params []types.Value, x *explainer, child *explainNode, estimate planner.Estimate) (func(RowIterator) RowIterator, *scope, bool, *explainNode, planner.Estimate, error) {
+	keys := in
+	if in.keys != nil {
+		keys = in.keys
+	}
+	var exprs []parser.Expr
+	for _, output := range outputs {
+		exprs = append(exprs, output.expr)
+	}
+	for _, item := range orderBy {
+		exprs = append(exprs, item.Expr)
+	}
+	clauses := collectWindows(keys, exprs)
+	sorted := false
+	for i, clause := range clauses {
+		if len(orderBy) > 0 && sortedOn(keys, clause.sortItems(), orderBy) {
+			clauses = append(append(clauses[:i:i], clauses[i+1:]...), clause)
+			sorted = true
+			break
+		}
+	}
+
+	out := &scope{computed: make(map[string]int), keys: keys, grouped: in.grouped, env: in.env}
+	out.columns = append(out.columns, in.columns...)
+	out.tables = append(out.tables, in.tables...)
+	for key, idx := range in.computed {
+		out.computed[key] = idx
+	}
+	var stages []func(RowIterator) RowIterator
+	node := child
+	for _, clause := range clauses {
+		items := clause.sortItems()
+		sortKeys, err := compileSortKeys(items, in, params)
+		if err != nil {
+			return nil, nil, false, nil, planner.Estimate{}, err
+		}
+		orderTypes := make([]types.Type, len(clause.orderBy))
+		for i, item := range clause.orderBy {
+			if _, orderTypes[i], err = compileExpr(item.Expr, in, params); err != nil {
+				return nil, nil, false, nil, planner.Estimate{}, err
+			}
+		}
+		calls := make([]*windowCall, len(clause.calls))
+		for i, call := range clause.calls {
+			if calls[i], err = compileWindowCall(call, in, params, orderTypes); err != nil {
+				return nil, nil, false, nil, planner.Estimate{}, err
+			}
+			out.computed[keys.exprKey(call)] = len(out.columns)
+			out.columns = append(out.columns, catalog.Column{Name: keys.exprKey(call), Type: calls[i].typ})
+			out.tables = append(out.tables, "")
+		}
+
+		var sorter *explainNode
+		if len(items) > 0 {
+			estimate = planner.SortEstimate(estimate, planner.RowWidth(len(out.columns)), e.workMem, -1)
+			sorter = sortNode(x, items, node, estimate)
+			node = sorter
+		}
+		estimate = planner.WindowEstimate(estimate, len(calls))
+		windowEstimate := estimate
+		windowNode := x.node("WindowAgg", &windowEstimate, node)
+		node = windowNode
+		partitionKeys := len(clause.partitionBy)
+		stages = append(stages, func(rows RowIterator) RowIterator {
+			if len(sortKeys) > 0 {
+				rows = x.wrap(sorter, newSortOperator(ctx, e.bufferManager, rows, sortKeys, e.workMem, -1))
+			}
+			return x.wrap(windowNode, &windowAgg{ctx: ctx, child: rows, keys: sortKeys, partitionKeys: partitionKeys, calls: calls})
+		})
+	}
+	return func(rows RowIterator) RowIterator {
+		for _, stage := range stages {
+			rows = stage(rows)
+		}
+		return rows
+	}, out, sorted, node, estimate, nil
+}
+
+// sortedOn reports whether rows sorted on items come out in the order of
+// orderBy: whether orderBy is a prefix of items.
+func sortedOn(sc *scope, items, orderBy []parser.OrderByItem) bool {
+	if len(orderBy) > len(items) {
+		return false
+	}
+	for i, item := range orderBy {
+		other := items[i]
+		if item.Desc != other.Desc || nullsFirst(item) != nullsFirst(other) || sc.exprKey(item.Expr) != sc.exprKey(other.Expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// nullsFirst reports whether an ORDER BY item puts NULLs first. By default
+// they come last in ascending order and first in descending order.
+func nullsFirst(item parser.OrderByItem) bool {
+	switch item.Nulls {
+	case parser.NullsFirst:
+		return true
+	case parser.NullsLast:
+		return false
+	}
+	return item.Desc
+}
+
+// compileWindowCall compiles a window function call. orderTypes are the
+// types of the ORDER BY keys of its window.
+func compileWindowCall(call *parser.FuncCall, sc *scope, params []types.Value, orderTypes []types.Type) (*windowCall, error) {
+	w := &windowCall{name: call.Name}
+	for _, arg := range call.Args {
+		var nested bool
+		parser.WalkExpr(arg, func(e parser.Expr) bool {
+			if f, ok := e.(*parser.FuncCall); ok && f.Over != nil {
+				nested = true
+			}
+			return !nested
+		})
+		if nested {
+			return nil, errors.New("window function calls cannot be nested")
+		}
+	}
+	if call.Distinct {
+		return nil, errors.New("DISTINCT is not implemented for window functions")
+	}
+	var err error
+	if w.frame, err = compileFrame(call.Over, orderTypes, params); err != nil {
+		return nil, err
+	}
+	plain := *call
+	plain.Over = nil
+	if plain.IsAggregate() {
+		if w.agg, err = compileAggregate(&plain, sc, params); err != nil {
+			return nil, err
+		}
+		w.typ = w.agg.resultType()
+		return w, nil
+	}
+	if call.Func != nil || !parser.IsBuiltinWindow(call.Name) {
+		return nil, fmt.Errorf("OVER specified, but %s is not a window function nor an aggregate function", strings.ToLower(call.Name))
+	}
+	if call.Star {
+		return nil, fmt.Errorf("%s(*) is not supported", call.Name)
+	}
+	argTypes := make([]types.Type, len(call.Args))
+	w.args = make([]evaluator, len(call.Args))
+	for i, arg := range call.Args {
+		if w.args[i], argTypes[i], err = compileExpr(arg, sc, params); err != nil {
+			return nil, err
+		}
+	}
+	integer := func(i int) bool {
+		return argTypes[i] == types.TypeInteger || argTypes[i] == types.TypeNull
+	}
+	valid := false
+	switch call.Name {
+	case "ROW_NUMBER", "RANK", "DENSE_RANK":
+		valid, w.typ = len(argTypes) == 0, types.TypeInteger
+	case "PERCENT_RANK", "CUME_DIST":
+		valid, w.typ = len(argTypes) == 0, types.TypeReal
+	case "NTILE":
+		valid, w.typ = len(argTypes) == 1 && integer(0), types.TypeInteger
+	case "FIRST_VALUE", "LAST_VALUE":
+		valid = len(argTypes) == 1
+	case "NTH_VALUE":
+		valid = len(argTypes) == 2 && integer(1)
+	case "LAG", "LEAD":
+		valid = len(argTypes) >= 1 && len(argTypes) <= 3 && (len(argTypes) < 2 || integer(1))
+		if valid && len(argTypes) == 3 {
+			valid = argTypes[0] == types.TypeNull || argTypes[2] == types.TypeNull || types.CanCast(argTypes[2], argTypes[0])
+		}
+	}
+	if !valid {
+		return nil, signatureError(call.Name, argTypes)
+	}
+	if w.typ == types.TypeNull {
+		w.typ = argTypes[0]
+		if w.typ == types.TypeNull && len(argTypes) == 3 {
+			w.typ = argTypes[2]
+		}
+	}
+	return w, nil
+}
+
+// compileFrame evaluates the frame of a window. Without a frame clause the
+// frame is the whole partition, or with an ORDER BY the rows up to the last
+// peer of the current one. Offsets must be constants.
+func compileFrame(spec *parser.WindowSpec, orderTypes []types.Type, params []types.Value) (windowFrame, error) {
+	f := spec.Frame
+	if f == nil {
+		frame := windowFrame{mode: parser.FrameRange, start: parser.UnboundedPreceding, end: parser.CurrentRow}
+		if len(spec.OrderBy) == 0 {
+			frame.end = parser.UnboundedFollowing
+		}
+		return frame, nil
+	}
+	frame := windowFrame{mode: f.Mode, start: f.Start.Type, end: f.End.Type}
+	offset := func(bound parser.FrameBound, which string) (types.Value, error) {
+		if bound.Offset == nil {
+			return types.Null(), nil
+		}
+		eval, typ, err := compileExpr(bound.Offset, &scope{}, params)
+		if err != nil {
+			return types.Null(), err
+		}
+		value, err := eval(nil)
+		if err != nil {
+			return types.Null(), err
+		}
+		if value.IsNull() {
+			return types.Null(), fmt.Errorf("frame %s offset must not be null", which)
+		}
+		if f.Mode == parser.FrameRows {
+			if typ != types.TypeInteger && typ != types.TypeNull || value.Type != types.TypeInteger {
+				return types.Null(), fmt.Errorf("argument of ROWS must be type integer, not type %s", value.Type)
+			}
+			if value.Int < 0 {
+				return types.Null(), fmt.Errorf("frame %s offset must not be negative", which)
+			}
+			return value, nil
+		}
+		if len(orderTypes) != 1 {
+			return types.Null(), errors.New("RANGE with offset PRECEDING/FOLLOWING requires exactly one ORDER BY column")
+		}
+		if orderTypes[0] != types.TypeInteger && orderTypes[0] != types.TypeReal && orderTypes[0] != types.TypeNull {
+			return types.Null(), fmt.Errorf("RANGE with offset PRECEDING/FOLLOWING is not supported for column type %s", orderTypes[0])
+		}
+		if value.Type != types.TypeInteger && value.Type != types.TypeReal {
+			return types.Null(), fmt.Errorf("argument of RANGE must be a number, not type %s", value.Type)
+		}
+		if value.Type == types.TypeInteger && value.Int < 0 || value.Type == types.TypeReal && value.Float < 0 {
+			return types.Null(), errors.New("invalid preceding or following size in window function")
+		}
+		return value, nil
+	}
+	var err error
+	if frame.startOffset, err = offset(f.Start, "starting"); err != nil {
+		return frame, err
+	}
+	frame.endOffset, err = offset(f.End, "ending")
+	return frame, err
+}
+
+// windowAgg computes window functions over rows sorted on the partition
+// keys followed by the ORDER BY keys of their window. It reads one partition
+// at a time into memory and returns its rows with the results of the calls
+// appended.
+type windowAgg struct {
+	ctx           context.Context
+	child         RowIterator
+	keys          []sortKey
+	partitionKeys int
+	calls         []*windowCall
+
+	next    *sortEntry // first row of the next partition
+	done    bool
+	part    *windowPartition
+	results [][]types.Value // results[call][row] for the partition
+	pos     int
+}
+
+func (w *windowAgg) Next() (Row, error) {
+	for w.part == nil || w.pos == len(w.part.entries) {
+		if err := w.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if w.done {
+			return nil, nil
+		}
+		if err := w.load(); err != nil {
+			return nil, err
+		}
+	}
+	row := w.part.entries[w.pos].row
+	out := make(Row, len(row), len(row)+len(w.calls))
+	copy(out, row)
+	for _, values := range w.results {
+		out = append(out, values[w.pos])
+	}
+	w.pos++
+	return out, nil
+}
+
+// load reads the next partition and computes the window functions over it.
+func (w *windowAgg) load() error {
+	var entries []sortEntry
+	if w.next != nil {
+		entries = append(entries, *w.next)
+		w.next = nil
+	}
+	for {
+		row, err := w.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			w.done = true
+			break
+		}
+		entry, err := makeSortEntry(w.keys, row)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			cmp, err := compareEntries(w.keys[:w.partitionKeys], entries[0], entry)
+			if err != nil {
+				return err
+			}
+			if cmp != 0 {
+				w.next = &entry
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+	part, err := newWindowPartition(entries, w.keys[w.partitionKeys:], w.partitionKeys)
+	if err != nil {
+		return err
+	}
+	w.part, w.pos = part, 0
+	w.results = make([][]types.Value, len(w.calls))
+	for i, call := range w.calls {
+		if w.results[i], err = part.compute(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *windowAgg) Close() error {
+	w.part, w.results = nil, nil
+	return w.child.Close()
+}
+
+// windowPartition is the rows of one partition, in window order, with their
+// peer groups: the runs of rows with equal ORDER BY keys.
+type windowPartition struct {
+	entries   []sortEntry
+	orderKeys []sortKey
+	offset    int // position of the ORDER BY keys in the entries' keys
+	peerStart []int
+	peerEnd   []int
+	peerGroup []int // number of the peer group, from 0
+}
+
+func newWindowPartition(entries []sortEntry, orderKeys []sortKey, offset int) (*windowPartition, error) {
+	n := len(entries)
+	p := &windowPartition{
+		entries: entries, orderKeys: orderKeys, offset: offset,
+		peerStart: make([]int, n), peerEnd: make([]int, n), peerGroup: make([]int, n),
+	}
+	start, group := 0, 0
+	for i := 1; i <= n; i++ {
+		if i < n {
+			cmp, err := compareEntries(orderKeys, p.orderEntry(i-1), p.orderEntry(i))
+			if err != nil {
+				return nil, err
+			}
+			if cmp == 0 {
+				continue
+			}
+		}
+		for j := start; j < i; j++ {
+			p.peerStart[j], p.peerEnd[j], p.peerGroup[j] = start, i, group
+		}
+		start = i
+		group++
+	}
+	return p, nil
+}
+
+// orderEntry returns the ORDER BY keys of row i as a sort entry.
+func (p *windowPartition) orderEntry(i int) sortEntry {
+	return sortEntry{keys: p.entries[i].keys[p.offset:]}
+}
+
+// compute returns the values of a window function call for the rows of the
+// partition.
+func (p *windowPartition) compute(call *windowCall) ([]types.Value, error) {
+	n := len(p.entries)
+	values := make([]types.Value, n)
+	if call.agg != nil {
+		return values, p.aggregate(call, values)
+	}
+	arg := func(j, i int) (types.Value, error) {
+		return call.args[j](p.entries[i].row)
+	}
+	for i := range values {
+		var value types.Value
+		var err error
+		switch call.name {
+		case "ROW_NUMBER":
+			value = types.NewInteger(int64(i + 1))
+		case "RANK":
+			value = types.NewInteger(int64(p.peerStart[i] + 1))
+		case "DENSE_RANK":
+			value = types.NewInteger(int64(p.peerGroup[i] + 1))
+		case "PERCENT_RANK":
+			value = types.NewReal(0)
+			if n > 1 {
+				value = types.NewReal(float64(p.peerStart[i]) / float64(n-1))
+			}
+		case "CUME_DIST":
+			value = types.NewReal(float64(p.peerEnd[i]) / float64(n))
+		case "NTILE":
+			value, err = p.ntile(call, i)
+		case "LAG", "LEAD":
+			value, err = p.shifted(call, i)
+		default:
+			var start, end int
+			if start, end, err = p.frame(call.frame, i); err != nil {
+				return nil, err
+			}
+			value = types.Null()
+			switch call.name {
+			case "FIRST_VALUE":
+				if start < end {
+					value, err = arg(0, start)
+				}
+			case "LAST_VALUE":
+				if start < end {
+					value, err = arg(0, end-1)
+				}
+			case "NTH_VALUE":
+				var nth types.Value
+				if nth, err = arg(1, i); err != nil || nth.IsNull() {
+					break
+				}
+				if nth.Int <= 0 {
+					return nil, errors.New("argument of nth_value must be greater than zero")
+				}
+				if nth.Int <= int64(end-start) {
+					value, err = arg(0, start+int(nth.Int)-1)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// ntile returns the number of the bucket row i falls in when the partition
+// is split into as equal buckets as possible, the first ones taking the
+// remaining rows.
+func (p *windowPartition) ntile(call *windowCall, i int) (types.Value, error) {
+	buckets, err := call.args[0](p.entries[i].row)
+	if err != nil || buckets.IsNull() {
+		return types.Null(), err
+	}
+	if buckets.Int <= 0 {
+		return types.Null(), errors.New("argument of ntile must be greater than zero")
+	}
+	n, b := int64(len(p.entries)), buckets.Int
+	if b > n {
+		b = n
+	}
+	size, extra := n/b, n%b
+	k := int64(i)
+	if k < extra*(size+1) {
+		return types.NewInteger(k/(size+1) + 1), nil
+	}
+	return types.NewInteger((k-extra*(size+1))/size + extra + 1), nil
+}
+
+// shifted returns the value of LAG or LEAD for row i: the argument on the
+// row offset rows before or after it, or the default when there is none.
+func (p *windowPartition) shifted(call *windowCall, i int) (types.Value, error) {
+	row := p.entries[i].row
+	offset := int64(1)
+	if len(call.args) > 1 {
+		value, err := call.args[1](row)
+		if err != nil || value.IsNull() {
+			return types.Null(), err
+		}
+		offset = value.Int
+	}
+	if call.name == "LAG" {
+		offset = -offset
+	}
+	if j := int64(i) + offset; j >= 0 && j < int64(len(p.entries)) {
+		return call.args[0](p.entries[j].row)
+	}
+	if len(call.args) < 3 {
+		return types.Null(), nil
+	}
+	value, err := call.args[2](row)
+	if err != nil || value.IsNull() || call.typ == types.TypeNull || value.Type == call.typ {
+		return value, err
+	}
+	return value.Cast(call.typ)
+}
+
+// aggregate computes an aggregate over the frame of each row. While frames
+// keep their start and only grow at the end, as running totals do, rows are
+// added to one accumulator; otherwise the frame is aggregated anew.
+func (p *windowPartition) aggregate(call *windowCall, values []types.Value) error {
+	var acc accumulator
+	added, prevStart, prevEnd := 0, -1, -1
+	for i := range p.entries {
+		start, end, err := p.frame(call.frame, i)
+		if err != nil {
+			return err
+		}
+		if start == prevStart && end == prevEnd {
+			values[i] = values[i-1]
+			continue
+		}
+		if acc == nil || start != prevStart || end < added {
+			acc, added = call.agg.newAccumulator(), start
+		}
+		for ; added < end; added++ {
+			args := make([]types.Value, len(call.agg.args))
+			for j, arg := range call.agg.args {
+				if args[j], err = arg(p.entries[added].row); err != nil {
+					return err
+				}
+			}
+			if err := acc.add(args); err != nil {
+				return err
+			}
+		}
+		if values[i], err = acc.result(); err != nil {
+			return err
+		}
+		prevStart, prevEnd = start, end
+	}
+	return nil
+}
+
+// frame returns the rows [start, end) of the partition in the frame of row
+// i. A frame whose end comes before its start is empty.
+func (p *windowPartition) frame(f windowFrame, i int) (int, int, error) {
+	start, err := p.bound(f, f.start, f.startOffset, i, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := p.bound(f, f.end, f.endOffset, i, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		end = start
+	}
+	return start, end, nil
+}
+
+// bound returns the first row of the frame of row i when start is set, and
+// the row after its last row otherwise.
+func (p *windowPartition) bound(f windowFrame, typ parser.BoundType, offset types.Value, i int, start bool) (int, error) {
+	n := len(p.entries)
+	switch typ {
+	case parser.UnboundedPreceding:
+		return 0, nil
+	case parser.UnboundedFollowing:
+		return n, nil
+	case parser.CurrentRow:
+		switch {
+		case f.mode == parser.FrameRows && start:
+			return i, nil
+		case f.mode == parser.FrameRows:
+			return i + 1, nil
+		case start:
+			return p.peerStart[i], nil
+		}
+		return p.peerEnd[i], nil
+	}
+	if f.mode == parser.FrameRows {
+		k := n + 1
+		if offset.Int < int64(n) {
+			k = int(offset.Int)
+		}
+		pos := i + k
+		if typ == parser.OffsetPreceding {
+			pos = i - k
+		}
+		if !start {
+			pos++
+		}
+		if pos < 0 {
+			return 0, nil
+		}
+		if pos > n {
+			return n, nil
+		}
+		return pos, nil
+	}
+	return p.rangeBound(typ, offset, i, start)
+}
+
+// rangeBound returns a RANGE offset bound of the frame of row i: the rows
+// whose ORDER BY value is within offset of the current row's. A row with a
+// NULL value has its peers as frame.
+func (p *windowPartition) rangeBound(typ parser.BoundType, offset types.Value, i int, start bool) (int, error) {
+	key := p.orderKeys[0]
+	value := p.entries[i].keys[p.offset]
+	if value.IsNull() {
+		if start {
+			return p.peerStart[i], nil
+		}
+		return p.peerEnd[i], nil
+	}
+	op := "+"
+	if (typ == parser.OffsetPreceding) != key.desc {
+		op = "-"
+	}
+	target, err := types.Arithmetic(op, value, offset)
+	if err != nil {
+		return 0, err
+	}
+	// NULLs are sorted to one end of the partition; the search covers the
+	// rows between lo and hi, which aren't NULL.
+	lo, hi := 0, len(p.entries)
+	for lo < hi && p.entries[lo].keys[p.offset].IsNull() {
+		lo++
+	}
+	for hi > lo && p.entries[hi-1].keys[p.offset].IsNull() {
+		hi--
+	}
+	var searchErr error
+	pos := lo + sort.Search(hi-lo, func(j int) bool {
+		cmp, err := types.Compare(p.entries[lo+j].keys[p.offset], target)
+		if err != nil {
+			searchErr = err
+		}
+		if key.desc {
+			cmp = -cmp
+		}
+		if start {
+			return cmp >= 0
+		}
+		return cmp > 0
+	})
+	return pos, searchErr
+}
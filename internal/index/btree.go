@@ -0,0 +1,322 @@
+// Package index implements B+ tree indexes stored in the buffer pool.
+package index
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/roackb2/simple_db/internal/storage"
+)
+
+// BTree is a B+ tree mapping keys to record IDs. Keys may repeat; entries
+// with the same key are ordered by RID. The root stays on the same page for
+// the life of the tree, so only that page ID needs to be remembered.
+type BTree struct {
+	bp   *storage.BufferPool
+	root int64
+}
+
+// Create allocates an empty tree.
+func Create(bp *storage.BufferPool) (*BTree, error) {
+	pageID, page, err := bp.NewPage()
+	if err != nil {
+		return nil, err
+	}
+	root := &node{leaf: true, next: storage.InvalidPageID}
+	_, err = page.AddRecord(root.encode())
+	if unpinErr := bp.UnpinPage(pageID, true); unpinErr != nil {
+		return nil, unpinErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BTree{bp: bp, root: pageID}, nil
+}
+
+// Open opens the tree whose root is on rootPageID.
+func Open(bp *storage.BufferPool, rootPageID int64) *BTree {
+	return &BTree{bp: bp, root: rootPageID}
+}
+
+// RootPageID returns the page holding the root of the tree.
+func (t *BTree) RootPageID() int64 {
+	return t.root
+}
+
+func (t *BTree) readNode(pageID int64) (*node, error) {
+	page, err := t.fetch(pageID, false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := page.RetrieveRecord(0)
+	var n *node
+	if err == nil {
+		n, err = decodeNode(data)
+	}
+	if unpinErr := t.release(pageID, false, false); unpinErr != nil && err == nil {
+		err = unpinErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("index page %d: %w", pageID, err)
+	}
+	return n, nil
+}
+
+func (t *BTree) writeNode(pageID int64, n *node) error {
+	page, err := t.fetch(pageID, true)
+	if err != nil {
+		return err
+	}
+	err = page.UpdateRecord(0, n.encode())
+	if unpinErr := t.release(pageID, true, err == nil); unpinErr != nil && err == nil {
+		err = unpinErr
+	}
+	return err
+}
+
+func (t *BTree) newNode(n *node) (int64, error) {
+	pageID, page, err := t.bp.NewPage()
+	if err != nil {
+		return 0, err
+	}
+	if err := t.bp.LatchPage(pageID, true); err != nil {
+		t.bp.UnpinPage(pageID, true)
+		return 0, err
+	}
+	_, err = page.AddRecord(n.encode())
+	if unpinErr := t.release(pageID, true, true); unpinErr != nil && err == nil {
+		err = unpinErr
+	}
+	return pageID, err
+}
+
+// fetch pins and latches a node's page, exclusive to change it.
+func (t *BTree) fetch(pageID int64, exclusive bool) (*storage.Page, error) {
+	page, err := t.bp.FetchPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.bp.LatchPage(pageID, exclusive); err != nil {
+		t.bp.UnpinPage(pageID, false)
+		return nil, err
+	}
+	return page, nil
+}
+
+// release unlatches and unpins a page taken with fetch.
+func (t *BTree) release(pageID int64, exclusive, dirty bool) error {
+	if err := t.bp.UnlatchPage(pageID, exclusive); err != nil {
+		return err
+	}
+	return t.bp.UnpinPage(pageID, dirty)
+}
+
+// childFor returns the position of the child of an inner node that may hold
+// entries comparing >= target under cmp. Separators equal to the target are
+// not passed, since equal entries can also sit left of them.
+func childFor(n *node, cmp func(Entry) (int, error)) (int, error) {
+	var err error
+	i := sort.Search(len(n.entries), func(i int) bool {
+		c, cmpErr := cmp(n.entries[i])
+		if cmpErr != nil && err == nil {
+			err = cmpErr
+		}
+		return c >= 0
+	})
+	return i, err
+}
+
+// Insert adds an entry for key pointing at rid.
+func (t *BTree) Insert(key Key, rid storage.RID) error {
+	entry := Entry{Key: key, RID: rid}
+	if size := entrySize(entry); size > maxEntrySize {
+		return fmt.Errorf("index key of %d bytes exceeds the maximum of %d", size, maxEntrySize)
+	}
+	split, sep, right, err := t.insert(t.root, entry)
+	if err != nil || !split {
+		return err
+	}
+	// Grow the tree by moving the old root to a new page, keeping the root
+	// page in place.
+	root, err := t.readNode(t.root)
+	if err != nil {
+		return err
+	}
+	left, err := t.newNode(root)
+	if err != nil {
+		return err
+	}
+	return t.writeNode(t.root, &node{
+		next:     storage.InvalidPageID,
+		entries:  []Entry{sep},
+		children: []int64{left, right},
+	})
+}
+
+// insert adds entry below pageID. When the node had to split, it returns the
+// separator and the page of the new right sibling.
+func (t *BTree) insert(pageID int64, entry Entry) (bool, Entry, int64, error) {
+	n, err := t.readNode(pageID)
+	if err != nil {
+		return false, Entry{}, 0, err
+	}
+	pos, err := childFor(n, func(e Entry) (int, error) {
+		c, err := compareEntries(e, entry)
+		// Descend right of separators equal to the entry.
+		if c == 0 && !n.leaf {
+			c = -1
+		}
+		return c, err
+	})
+	if err != nil {
+		return false, Entry{}, 0, err
+	}
+	if n.leaf {
+		if pos < len(n.entries) {
+			if c, _ := compareEntries(n.entries[pos], entry); c == 0 {
+				return false, Entry{}, 0, nil
+			}
+		}
+		n.entries = append(n.entries, Entry{})
+		copy(n.entries[pos+1:], n.entries[pos:])
+		n.entries[pos] = entry
+	} else {
+		split, sep, right, err := t.insert(n.children[pos], entry)
+		if err != nil || !split {
+			return false, Entry{}, 0, err
+		}
+		n.entries = append(n.entries, Entry{})
+		copy(n.entries[pos+1:], n.entries[pos:])
+		n.entries[pos] = sep
+		n.children = append(n.children, 0)
+		copy(n.children[pos+2:], n.children[pos+1:])
+		n.children[pos+1] = right
+	}
+	if n.size() <= maxNodeSize {
+		return false, Entry{}, 0, t.writeNode(pageID, n)
+	}
+	return t.split(pageID, n)
+}
+
+// split moves the upper half of an overfull node to a new right sibling.
+func (t *BTree) split(pageID int64, n *node) (bool, Entry, int64, error) {
+	mid := n.splitPoint()
+	right := &node{leaf: n.leaf, next: storage.InvalidPageID}
+	var sep Entry
+	if n.leaf {
+		right.entries = append([]Entry(nil), n.entries[mid:]...)
+		right.next = n.next
+		n.entries = n.entries[:mid]
+		sep = right.entries[0]
+	} else {
+		sep = n.entries[mid]
+		right.entries = append([]Entry(nil), n.entries[mid+1:]...)
+		right.children = append([]int64(nil), n.children[mid+1:]...)
+		n.entries = n.entries[:mid]
+		n.children = n.children[:mid+1]
+	}
+	rightID, err := t.newNode(right)
+	if err != nil {
+		return false, Entry{}, 0, err
+	}
+	if n.leaf {
+		n.next = rightID
+	}
+	if err := t.writeNode(pageID, n); err != nil {
+		return false, Entry{}, 0, err
+	}
+	return true, sep, rightID, nil
+}
+
+// Delete removes the entry for key pointing at rid, if present. Nodes are not
+// merged when they become sparse; empty leaves stay in the chain and are
+// skipped by iterators.
+func (t *BTree) Delete(key Key, rid storage.RID) error {
+	entry := Entry{Key: key, RID: rid}
+	pageID := t.root
+	for {
+		n, err := t.readNode(pageID)
+		if err != nil {
+			return err
+		}
+		pos, err := childFor(n, func(e Entry) (int, error) {
+			c, err := compareEntries(e, entry)
+			if c == 0 && !n.leaf {
+				c = -1
+			}
+			return c, err
+		})
+		if err != nil {
+			return err
+		}
+		if !n.leaf {
+			pageID = n.children[pos]
+			continue
+		}
+		if pos == len(n.entries) {
+			return nil
+		}
+		if c, err := compareEntries(n.entries[pos], entry); err != nil || c != 0 {
+			return err
+		}
+		n.entries = append(n.entries[:pos], n.entries[pos+1:]...)
+		return t.writeNode(pageID, n)
+	}
+}
+
+// Seek returns an iterator positioned at the first entry whose key is >= key.
+// A key with fewer columns than the index matches on that prefix, and an
+// empty key positions at the first entry.
+func (t *BTree) Seek(key Key) (*Iterator, error) {
+	cmp := func(e Entry) (int, error) {
+		return CompareKeys(e.Key, key)
+	}
+	pageID := t.root
+	for {
+		n, err := t.readNode(pageID)
+		if err != nil {
+			return nil, err
+		}
+		pos, err := childFor(n, cmp)
+		if err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			return &Iterator{tree: t, pageID: pageID, node: n, pos: pos}, nil
+		}
+		pageID = n.children[pos]
+	}
+}
+
+// Iterator walks the entries of a tree in order along the leaf chain. Like
+// the heap iterator it holds no pins between calls; it keeps a copy of the
+// current leaf instead.
+type Iterator struct {
+	tree   *BTree
+	pageID int64
+	node   *node
+	pos    int
+}
+
+// Next returns the next entry, or false once the entries are exhausted.
+func (it *Iterator) Next() (Entry, bool, error) {
+	for it.node != nil {
+		if it.pos < len(it.node.entries) {
+			entry := it.node.entries[it.pos]
+			it.pos++
+			return entry, true, nil
+		}
+		it.pageID = it.node.next
+		it.node = nil
+		it.pos = 0
+		if it.pageID == storage.InvalidPageID {
+			break
+		}
+		n, err := it.tree.readNode(it.pageID)
+		if err != nil {
+			return Entry{}, false, err
+		}
+		it.node = n
+	}
+	return Entry{}, false, nil
+}
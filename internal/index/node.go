@@ -0,0 +1,213 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Key is the tuple of column values an index is ordered by.
+type Key []types.Value
+
+func (k Key) String() string {
+	s := "("
+	for i, value := range k {
+		if i > 0 {
+			s += ", "
+		}
+		s += value.String()
+	}
+	return s + ")"
+}
+
+// Entry is an index entry pointing at the record a key was taken from.
+type Entry struct {
+	Key Key
+	RID storage.RID
+}
+
+// CompareKeys orders keys column by column, comparing no more columns than the
+// shorter key has. NULL sorts before every other value.
+func CompareKeys(a, b Key) (int, error) {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		av, bv := a[i], b[i]
+		switch {
+		case av.IsNull() && bv.IsNull():
+			continue
+		case av.IsNull():
+			return -1, nil
+		case bv.IsNull():
+			return 1, nil
+		}
+		cmp, err := types.Compare(av, bv)
+		if err != nil {
+			return 0, err
+		}
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return 0, nil
+}
+
+// compareEntries orders entries by key and then by RID, which makes every
+// entry unique even when keys repeat.
+func compareEntries(a, b Entry) (int, error) {
+	cmp, err := CompareKeys(a.Key, b.Key)
+	if err != nil || cmp != 0 {
+		return cmp, err
+	}
+	switch {
+	case a.RID.PageID != b.RID.PageID:
+		if a.RID.PageID < b.RID.PageID {
+			return -1, nil
+		}
+		return 1, nil
+	case a.RID.Slot != b.RID.Slot:
+		if a.RID.Slot < b.RID.Slot {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// node is a B+ tree node stored as the only record of its page. Leaves hold
+// the entries and are chained through next. Inner nodes hold separators:
+// entries[i] is the smallest entry under children[i+1].
+type node struct {
+	leaf     bool
+	next     int64
+	entries  []Entry
+	children []int64
+}
+
+// maxNodeSize is the largest encoded node that fits on a page.
+var maxNodeSize = storage.MaxRecordSize()
+
+// maxEntrySize keeps entries small enough that a split node always fits.
+var maxEntrySize = maxNodeSize / 4
+
+const nodeHeaderSize = 1 + 8 + 2 // leaf flag, next leaf, entry count
+
+func entrySize(e Entry) int {
+	size := 2 + 8 + 2 // key column count, RID page and slot
+	for _, value := range e.Key {
+		size += 2 + len(value.Encode())
+	}
+	return size
+}
+
+func (n *node) size() int {
+	size := nodeHeaderSize + 8*len(n.children)
+	for _, e := range n.entries {
+		size += entrySize(e)
+	}
+	return size
+}
+
+func (n *node) encode() []byte {
+	buf := make([]byte, 0, n.size())
+	leaf := byte(0)
+	if n.leaf {
+		leaf = 1
+	}
+	buf = append(buf, leaf)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(n.next))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(n.entries)))
+	for _, e := range n.entries {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(e.Key)))
+		for _, value := range e.Key {
+			encoded := value.Encode()
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(len(encoded)))
+			buf = append(buf, encoded...)
+		}
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.RID.PageID))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(e.RID.Slot))
+	}
+	for _, child := range n.children {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(child))
+	}
+	return buf
+}
+
+var errCorruptNode = errors.New("corrupted index node")
+
+func decodeNode(data []byte) (*node, error) {
+	if len(data) < nodeHeaderSize {
+		return nil, errCorruptNode
+	}
+	n := &node{
+		leaf: data[0] == 1,
+		next: int64(binary.LittleEndian.Uint64(data[1:])),
+	}
+	count := int(binary.LittleEndian.Uint16(data[9:]))
+	pos := nodeHeaderSize
+	read := func(size int) ([]byte, error) {
+		if pos+size > len(data) {
+			return nil, errCorruptNode
+		}
+		b := data[pos : pos+size]
+		pos += size
+		return b, nil
+	}
+	n.entries = make([]Entry, count)
+	for i := range n.entries {
+		b, err := read(2)
+		if err != nil {
+			return nil, err
+		}
+		key := make(Key, binary.LittleEndian.Uint16(b))
+		for j := range key {
+			if b, err = read(2); err != nil {
+				return nil, err
+			}
+			if b, err = read(int(binary.LittleEndian.Uint16(b))); err != nil {
+				return nil, err
+			}
+			if key[j], err = types.Decode(b); err != nil {
+				return nil, fmt.Errorf("corrupted index key: %w", err)
+			}
+		}
+		if b, err = read(10); err != nil {
+			return nil, err
+		}
+		n.entries[i] = Entry{Key: key, RID: storage.RID{
+			PageID: int64(binary.LittleEndian.Uint64(b)),
+			Slot:   int(binary.LittleEndian.Uint16(b[8:])),
+		}}
+	}
+	if !n.leaf {
+		n.children = make([]int64, count+1)
+		for i := range n.children {
+			b, err := read(8)
+			if err != nil {
+				return nil, err
+			}
+			n.children[i] = int64(binary.LittleEndian.Uint64(b))
+		}
+	}
+	return n, nil
+}
+
+// splitPoint picks the entry position that divides the node's bytes evenly.
+func (n *node) splitPoint() int {
+	total := 0
+	for _, e := range n.entries {
+		total += entrySize(e)
+	}
+	half := 0
+	for i, e := range n.entries {
+		half += entrySize(e)
+		if half >= total/2 {
+			if i == 0 {
+				return 1
+			}
+			return i
+		}
+	}
+	return len(n.entries) / 2
+}
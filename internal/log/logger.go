@@ -2,7 +2,15 @@ package logger
 
 import "fmt"
 
-const DEBUG = true
+// DEBUG enables the lexer and parser debug output on stdout. It is off by
+// default; programs turn it on with SetDebug before running statements.
+var DEBUG = false
+
+// SetDebug turns debug output on or off. It is not safe to call while
+// statements run.
+func SetDebug(enabled bool) {
+	DEBUG = enabled
+}
 
 func Debug(msg string, args ...interface{}) {
 	if DEBUG {
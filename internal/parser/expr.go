@@ -0,0 +1,542 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Expr is a value appearing in a statement: a literal written in the query,
+// a parameter bound at execution time, a reference to a column or a
+// computation over other expressions.
+type Expr interface {
+	exprNode()
+	// String renders the expression as SQL. Equal expressions render the same.
+	String() string
+}
+
+// Literal is a constant value written in the query.
+type Literal struct {
+	Value types.Value
+}
+
+// Param is a bind parameter written as ? or $n. Index is 1-based.
+type Param struct {
+	Index int
+}
+
+// ColumnRef refers to a column of the rows being processed, optionally
+// qualified by the name or alias of its table.
+type ColumnRef struct {
+	Table  string
+	Column string
+}
+
+// UnaryExpr applies NOT or unary minus to its operand.
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+}
+
+// BinaryExpr is an arithmetic operator (+ - * / %), the concatenation
+// operator ||, a comparison or a logical AND/OR. Op is the upper-cased
+// operator as written, with != normalized to <>.
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// IsNullExpr tests an expression for NULL.
+type IsNullExpr struct {
+	Expr Expr
+	Not  bool
+}
+
+// InExpr tests whether an expression equals one of a list of values or,
+// when Subquery is set, one of the values a subquery returns.
+type InExpr struct {
+	Expr     Expr
+	List     []Expr
+	Subquery *SelectStatement
+	Not      bool
+}
+
+// SubqueryExpr is a subquery used as a value: the single column of the at
+// most one row it returns. Subqueries may refer to the columns of the
+// queries they are nested in.
+type SubqueryExpr struct {
+	Select *SelectStatement
+}
+
+// ExistsExpr tests whether a subquery returns any row.
+type ExistsExpr struct {
+	Select *SelectStatement
+}
+
+// LikeExpr matches text against a pattern in which % stands for any
+// sequence of characters and _ for any single character. ILIKE ignores case.
+type LikeExpr struct {
+	Expr            Expr
+	Pattern         Expr
+	Not             bool
+	CaseInsensitive bool
+}
+
+// CaseExpr picks the result of the first WHEN clause that holds, or Else.
+// With an Operand, the clauses compare their Cond with it for equality
+// instead of being conditions themselves.
+type CaseExpr struct {
+	Operand Expr
+	Whens   []WhenClause
+	Else    Expr
+}
+
+// WhenClause is a WHEN cond THEN result branch of a CASE expression.
+type WhenClause struct {
+	Cond   Expr
+	Result Expr
+}
+
+// CastExpr converts a value to a type, written as CAST(x AS type) or x::type.
+type CastExpr struct {
+	Expr Expr
+	Type types.Type
+}
+
+// FuncCall is a call of a scalar or aggregate function. Name is upper-cased.
+// Star is set for COUNT(*). Func is the definition of a function found by the
+// parser's FunctionResolver, which the parser carries for the executor
+// without looking into it; it is nil for built-in functions. Over is set for
+// a window function call.
+type FuncCall struct {
+	Name          string
+	Args          []Expr
+	Distinct      bool
+	Star          bool
+	Func          interface{}
+	UserAggregate bool // Func is an aggregate function
+	Over          *WindowSpec
+}
+
+// WindowSpec is the OVER clause of a window function call. The rows of the
+// query are split into partitions of equal PartitionBy values and ordered
+// within them by OrderBy; the function is computed for each row over the
+// rows of its frame. Frame is nil for the default frame: the whole partition
+// without ORDER BY, or the rows up to the last peer of the current one.
+type WindowSpec struct {
+	PartitionBy []Expr
+	OrderBy     []OrderByItem
+	Frame       *WindowFrame
+}
+
+// FrameMode tells whether the bounds of a frame count rows or compare the
+// values of the ORDER BY key.
+type FrameMode int
+
+const (
+	FrameRange FrameMode = iota
+	FrameRows
+)
+
+// WindowFrame is a ROWS or RANGE BETWEEN start AND end frame clause.
+type WindowFrame struct {
+	Mode  FrameMode
+	Start FrameBound
+	End   FrameBound
+}
+
+// BoundType is the kind of a frame bound.
+type BoundType int
+
+const (
+	UnboundedPreceding BoundType = iota
+	OffsetPreceding
+	CurrentRow
+	OffsetFollowing
+	UnboundedFollowing
+)
+
+// FrameBound is one end of a frame. Offset is set for OffsetPreceding and
+// OffsetFollowing: a number of rows for ROWS, a distance from the current
+// row's ORDER BY value for RANGE.
+type FrameBound struct {
+	Type   BoundType
+	Offset Expr
+}
+
+func (*Literal) exprNode()      {}
+func (*Param) exprNode()        {}
+func (*ColumnRef) exprNode()    {}
+func (*UnaryExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*IsNullExpr) exprNode()   {}
+func (*FuncCall) exprNode()     {}
+func (*InExpr) exprNode()       {}
+func (*LikeExpr) exprNode()     {}
+func (*CaseExpr) exprNode()     {}
+func (*CastExpr) exprNode()     {}
+func (*SubqueryExpr) exprNode() {}
+func (*ExistsExpr) exprNode()   {}
+
+func (e *Literal) String() string {
+	if e.Value.Type == types.TypeText {
+		return "'" + strings.ReplaceAll(e.Value.Str, "'", "''") + "'"
+	}
+	return e.Value.String()
+}
+
+func (e *Param) String() string {
+	return fmt.Sprintf("$%d", e.Index)
+}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return strings.ToLower(e.Table + "." + e.Column)
+	}
+	return strings.ToLower(e.Column)
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == "-" {
+		return "-" + e.Operand.String()
+	}
+	return e.Op + " " + e.Operand.String()
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op + " " + e.Right.String() + ")"
+}
+
+func (e *IsNullExpr) String() string {
+	if e.Not {
+		return e.Expr.String() + " IS NOT NULL"
+	}
+	return e.Expr.String() + " IS NULL"
+}
+
+func (e *FuncCall) String() string {
+	if e.Star {
+		return strings.ToLower(e.Name) + "(*)"
+	}
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	prefix := ""
+	if e.Distinct {
+		prefix = "DISTINCT "
+	}
+	s := strings.ToLower(e.Name) + "(" + prefix + strings.Join(args, ", ") + ")"
+	if e.Over != nil {
+		s += " OVER (" + e.Over.String() + ")"
+	}
+	return s
+}
+
+func (w *WindowSpec) String() string {
+	var parts []string
+	if len(w.PartitionBy) > 0 {
+		exprs := make([]string, len(w.PartitionBy))
+		for i, expr := range w.PartitionBy {
+			exprs[i] = expr.String()
+		}
+		parts = append(parts, "PARTITION BY "+strings.Join(exprs, ", "))
+	}
+	if len(w.OrderBy) > 0 {
+		parts = append(parts, "ORDER BY "+orderByString(w.OrderBy))
+	}
+	if w.Frame != nil {
+		parts = append(parts, w.Frame.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *WindowFrame) String() string {
+	mode := "RANGE"
+	if f.Mode == FrameRows {
+		mode = "ROWS"
+	}
+	return mode + " BETWEEN " + f.Start.String() + " AND " + f.End.String()
+}
+
+func (b FrameBound) String() string {
+	switch b.Type {
+	case UnboundedPreceding:
+		return "UNBOUNDED PRECEDING"
+	case OffsetPreceding:
+		return b.Offset.String() + " PRECEDING"
+	case OffsetFollowing:
+		return b.Offset.String() + " FOLLOWING"
+	case UnboundedFollowing:
+		return "UNBOUNDED FOLLOWING"
+	}
+	return "CURRENT ROW"
+}
+
+func (e *InExpr) String() string {
+	list := make([]string, len(e.List))
+	for i, item := range e.List {
+		list[i] = item.String()
+	}
+	if e.Subquery != nil {
+		list = []string{e.Subquery.String()}
+	}
+	op := " IN ("
+	if e.Not {
+		op = " NOT IN ("
+	}
+	return e.Expr.String() + op + strings.Join(list, ", ") + ")"
+}
+
+func (e *SubqueryExpr) String() string {
+	return "(" + e.Select.String() + ")"
+}
+
+func (e *ExistsExpr) String() string {
+	return "EXISTS (" + e.Select.String() + ")"
+}
+
+func (e *LikeExpr) String() string {
+	op := "LIKE"
+	if e.CaseInsensitive {
+		op = "ILIKE"
+	}
+	if e.Not {
+		op = "NOT " + op
+	}
+	return e.Expr.String() + " " + op + " " + e.Pattern.String()
+}
+
+func (e *CaseExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString("CASE")
+	if e.Operand != nil {
+		sb.WriteString(" " + e.Operand.String())
+	}
+	for _, when := range e.Whens {
+		sb.WriteString(" WHEN " + when.Cond.String() + " THEN " + when.Result.String())
+	}
+	if e.Else != nil {
+		sb.WriteString(" ELSE " + e.Else.String())
+	}
+	sb.WriteString(" END")
+	return sb.String()
+}
+
+func (e *CastExpr) String() string {
+	return "CAST(" + e.Expr.String() + " AS " + e.Type.String() + ")"
+}
+
+// aggregateFunctions are the built-in aggregate functions.
+var aggregateFunctions = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MIN":   true,
+	"MAX":   true,
+}
+
+// IsBuiltinAggregate reports whether name, upper-cased, is a built-in
+// aggregate function.
+func IsBuiltinAggregate(name string) bool {
+	return aggregateFunctions[name]
+}
+
+// windowFunctions are the built-in functions that may only be called as
+// window functions. Aggregates may be called as window functions too.
+var windowFunctions = map[string]bool{
+	"ROW_NUMBER":   true,
+	"RANK":         true,
+	"DENSE_RANK":   true,
+	"PERCENT_RANK": true,
+	"CUME_DIST":    true,
+	"NTILE":        true,
+	"LAG":          true,
+	"LEAD":         true,
+	"FIRST_VALUE":  true,
+	"LAST_VALUE":   true,
+	"NTH_VALUE":    true,
+}
+
+// IsBuiltinWindow reports whether name, upper-cased, is a built-in window
+// function.
+func IsBuiltinWindow(name string) bool {
+	return windowFunctions[name]
+}
+
+// IsAggregate reports whether the call is of an aggregate function, other
+// than as a window function.
+func (e *FuncCall) IsAggregate() bool {
+	if e.Over != nil {
+		return false
+	}
+	if e.Func != nil {
+		return e.UserAggregate
+	}
+	return aggregateFunctions[e.Name]
+}
+
+// Subquery returns the statement of a subquery expression: a SubqueryExpr,
+// an ExistsExpr or an InExpr over a subquery. It returns nil for other
+// expressions.
+func Subquery(expr Expr) *SelectStatement {
+	switch e := expr.(type) {
+	case *SubqueryExpr:
+		return e.Select
+	case *ExistsExpr:
+		return e.Select
+	case *InExpr:
+		return e.Subquery
+	}
+	return nil
+}
+
+// WalkExpr calls fn for expr and each of its subexpressions, parents first.
+// Returning false from fn skips the children of that expression. The
+// statements of subqueries are not entered: their expressions belong to
+// another query.
+func WalkExpr(expr Expr, fn func(Expr) bool) {
+	if expr == nil || !fn(expr) {
+		return
+	}
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		WalkExpr(e.Operand, fn)
+	case *BinaryExpr:
+		WalkExpr(e.Left, fn)
+		WalkExpr(e.Right, fn)
+	case *IsNullExpr:
+		WalkExpr(e.Expr, fn)
+	case *FuncCall:
+		for _, arg := range e.Args {
+			WalkExpr(arg, fn)
+		}
+		if e.Over != nil {
+			for _, expr := range e.Over.PartitionBy {
+				WalkExpr(expr, fn)
+			}
+			for _, item := range e.Over.OrderBy {
+				WalkExpr(item.Expr, fn)
+			}
+		}
+	case *InExpr:
+		WalkExpr(e.Expr, fn)
+		for _, item := range e.List {
+			WalkExpr(item, fn)
+		}
+	case *LikeExpr:
+		WalkExpr(e.Expr, fn)
+		WalkExpr(e.Pattern, fn)
+	case *CaseExpr:
+		WalkExpr(e.Operand, fn)
+		for _, when := range e.Whens {
+			WalkExpr(when.Cond, fn)
+			WalkExpr(when.Result, fn)
+		}
+		WalkExpr(e.Else, fn)
+	case *CastExpr:
+		WalkExpr(e.Expr, fn)
+	}
+}
+
+// RewriteExpr returns a copy of expr in which every subexpression for which
+// fn returns a non-nil replacement is replaced. Children are rewritten before
+// their parents see them. Like WalkExpr, it doesn't enter subqueries.
+func RewriteExpr(expr Expr, fn func(Expr) Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		copied := *e
+		copied.Operand = RewriteExpr(e.Operand, fn)
+		expr = &copied
+	case *BinaryExpr:
+		copied := *e
+		copied.Left = RewriteExpr(e.Left, fn)
+		copied.Right = RewriteExpr(e.Right, fn)
+		expr = &copied
+	case *IsNullExpr:
+		copied := *e
+		copied.Expr = RewriteExpr(e.Expr, fn)
+		expr = &copied
+	case *FuncCall:
+		copied := *e
+		copied.Args = make([]Expr, len(e.Args))
+		for i, arg := range e.Args {
+			copied.Args[i] = RewriteExpr(arg, fn)
+		}
+		if e.Over != nil {
+			over := *e.Over
+			over.PartitionBy = make([]Expr, len(e.Over.PartitionBy))
+			for i, expr := range e.Over.PartitionBy {
+				over.PartitionBy[i] = RewriteExpr(expr, fn)
+			}
+			over.OrderBy = make([]OrderByItem, len(e.Over.OrderBy))
+			for i, item := range e.Over.OrderBy {
+				item.Expr = RewriteExpr(item.Expr, fn)
+				over.OrderBy[i] = item
+			}
+			copied.Over = &over
+		}
+		expr = &copied
+	case *InExpr:
+		copied := *e
+		copied.Expr = RewriteExpr(e.Expr, fn)
+		if e.List != nil {
+			copied.List = make([]Expr, len(e.List))
+		}
+		for i, item := range e.List {
+			copied.List[i] = RewriteExpr(item, fn)
+		}
+		expr = &copied
+	case *LikeExpr:
+		copied := *e
+		copied.Expr = RewriteExpr(e.Expr, fn)
+		copied.Pattern = RewriteExpr(e.Pattern, fn)
+		expr = &copied
+	case *CaseExpr:
+		copied := *e
+		copied.Operand = RewriteExpr(e.Operand, fn)
+		copied.Whens = make([]WhenClause, len(e.Whens))
+		for i, when := range e.Whens {
+			copied.Whens[i] = WhenClause{Cond: RewriteExpr(when.Cond, fn), Result: RewriteExpr(when.Result, fn)}
+		}
+		copied.Else = RewriteExpr(e.Else, fn)
+		expr = &copied
+	case *CastExpr:
+		copied := *e
+		copied.Expr = RewriteExpr(e.Expr, fn)
+		expr = &copied
+	}
+	if replacement := fn(expr); replacement != nil {
+		return replacement
+	}
+	return expr
+}
+
+// Conjuncts splits a condition into the operands of its top-level ANDs.
+func Conjuncts(expr Expr) []Expr {
+	if expr == nil {
+		return nil
+	}
+	if b, ok := expr.(*BinaryExpr); ok && b.Op == "AND" {
+		return append(Conjuncts(b.Left), Conjuncts(b.Right)...)
+	}
+	return []Expr{expr}
+}
+
+// Conjoin combines conditions with AND. It returns nil for no conditions.
+func Conjoin(conds []Expr) Expr {
+	var result Expr
+	for _, cond := range conds {
+		if result == nil {
+			result = cond
+		} else {
+			result = &BinaryExpr{Op: "AND", Left: result, Right: cond}
+		}
+	}
+	return result
+}
@@ -42,6 +42,148 @@ func lookupIdent(ident string) TokenType {
 		return FROM
 	case "WHERE":
 		return WHERE
+	case "CREATE":
+		return CREATE
+	case "TABLE":
+		return TABLE
+	case "DROP":
+		return DROP
+	case "UPDATE":
+		return UPDATE
+	case "SET":
+		return SET
+	case "DELETE":
+		return DELETE
+	case "NOT":
+		return NOT
+	case "NULL":
+		return NULL
+	case "TRUE":
+		return TRUE
+	case "FALSE":
+		return FALSE
+	case "BEGIN":
+		return BEGIN
+	case "COMMIT":
+		return COMMIT
+	case "ROLLBACK":
+		return ROLLBACK
+	case "TRANSACTION":
+		return TRANSACTION
+	case "PREPARE":
+		return PREPARE
+	case "EXECUTE":
+		return EXECUTE
+	case "DEALLOCATE":
+		return DEALLOCATE
+	case "AS":
+		return AS
+	case "ORDER":
+		return ORDER
+	case "BY":
+		return BY
+	case "ASC":
+		return ASC
+	case "DESC":
+		return DESC
+	case "NULLS":
+		return NULLS
+	case "FIRST":
+		return FIRST
+	case "LAST":
+		return LAST
+	case "AND":
+		return AND
+	case "OR":
+		return OR
+	case "IS":
+		return IS
+	case "GROUP":
+		return GROUP
+	case "HAVING":
+		return HAVING
+	case "DISTINCT":
+		return DISTINCT
+	case "INDEX":
+		return INDEX
+	case "ON":
+		return ON
+	case "JOIN":
+		return JOIN
+	case "INNER":
+		return INNER
+	case "LEFT":
+		return LEFT
+	case "RIGHT":
+		return RIGHT
+	case "FULL":
+		return FULL
+	case "OUTER":
+		return OUTER
+	case "CROSS":
+		return CROSS
+	case "ANALYZE":
+		return ANALYZE
+	case "EXPLAIN":
+		return EXPLAIN
+	case "BETWEEN":
+		return BETWEEN
+	case "IN":
+		return IN
+	case "LIKE":
+		return LIKE
+	case "ILIKE":
+		return ILIKE
+	case "CASE":
+		return CASE
+	case "WHEN":
+		return WHEN
+	case "THEN":
+		return THEN
+	case "ELSE":
+		return ELSE
+	case "END":
+		return END
+	case "CAST":
+		return CAST
+	case "EXISTS":
+		return EXISTS
+	case "WITH":
+		return WITH
+	case "UNION":
+		return UNION
+	case "INTERSECT":
+		return INTERSECT
+	case "EXCEPT":
+		return EXCEPT
+	case "ALL":
+		return ALL
+	case "LIMIT":
+		return LIMIT
+	case "OFFSET":
+		return OFFSET
+	case "FETCH":
+		return FETCH
+	case "DECLARE":
+		return DECLARE
+	case "CLOSE":
+		return CLOSE
+	case "PRIMARY":
+		return PRIMARY
+	case "UNIQUE":
+		return UNIQUE
+	case "CHECK":
+		return CHECK
+	case "DEFAULT":
+		return DEFAULT
+	case "CONSTRAINT":
+		return CONSTRAINT
+	case "REFERENCES":
+		return REFERENCES
+	case "FOREIGN":
+		return FOREIGN
+	case "ALTER":
+		return ALTER
 	default:
 		return IDENTIFIER
 	}
@@ -57,6 +199,26 @@ func (lex *Lexer) readChar() {
 	lex.readPosition++
 }
 
+func (lex *Lexer) peekChar() byte {
+	if lex.readPosition >= len(lex.input) {
+		return 0
+	}
+	return lex.input[lex.readPosition]
+}
+
+// peekIsQualifiedStar reports whether the unread input continues with ".*",
+// which after an identifier selects every column of a table as in "t.*".
+func (lex *Lexer) peekIsQualifiedStar() bool {
+	if lex.position >= len(lex.input) {
+		return false
+	}
+	rest := strings.TrimLeft(lex.input[lex.position:], " \t\r\n")
+	if !strings.HasPrefix(rest, ".") {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimLeft(rest[1:], " \t\r\n"), "*")
+}
+
 func (lex *Lexer) skipWhitespace() {
 	for lex.ch == ' ' || lex.ch == '\t' || lex.ch == '\n' || lex.ch == '\r' {
 		lex.readChar()
@@ -65,7 +227,7 @@ func (lex *Lexer) skipWhitespace() {
 
 func (lex *Lexer) readIdentifier() string {
 	position := lex.position
-	for isLetter(lex.ch) {
+	for isLetter(lex.ch) || isDigit(lex.ch) {
 		lex.readChar()
 	}
 	return lex.input[position:lex.position]
@@ -76,20 +238,33 @@ func (lex *Lexer) readNumber() string {
 	for isDigit(lex.ch) {
 		lex.readChar()
 	}
+	if lex.ch == '.' && isDigit(lex.peekChar()) {
+		lex.readChar()
+		for isDigit(lex.ch) {
+			lex.readChar()
+		}
+	}
 	return lex.input[position:lex.position]
 }
 
 func (lex *Lexer) readString() string {
-	position := lex.position + 1 // Start after the opening quote.
+	var sb strings.Builder
 	for {
 		lex.readChar()
-		if lex.ch == '\'' || lex.ch == 0 {
+		if lex.ch == 0 {
 			break
 		}
+		if lex.ch == '\'' {
+			// Two consecutive quotes escape a single quote inside the string.
+			if lex.peekChar() != '\'' {
+				break
+			}
+			lex.readChar()
+		}
+		sb.WriteByte(lex.ch)
 	}
-	str := lex.input[position:lex.position] // Do not include the closing quote.
-	lex.readChar()                          // Move past the closing quote.
-	return str
+	lex.readChar() // Move past the closing quote.
+	return sb.String()
 }
 
 func (lex *Lexer) readToken(tokenType TokenType, ch byte) Token {
@@ -98,6 +273,14 @@ func (lex *Lexer) readToken(tokenType TokenType, ch byte) Token {
 	return tok
 }
 
+// readTwoCharToken consumes a two character operator such as <=.
+func (lex *Lexer) readTwoCharToken(tokenType TokenType) Token {
+	literal := string(lex.ch) + string(lex.peekChar())
+	lex.readChar()
+	lex.readChar()
+	return Token{Type: tokenType, Literal: literal}
+}
+
 func (lex *Lexer) nextToken() Token {
 	var tok Token
 
@@ -111,8 +294,68 @@ func (lex *Lexer) nextToken() Token {
 		tok = lex.readToken(CLOSE_PARENTHESIS, lex.ch)
 	case ',':
 		tok = lex.readToken(COMMA, lex.ch)
+	case ';':
+		tok = lex.readToken(SEMICOLON, lex.ch)
+	case '.':
+		tok = lex.readToken(DOT, lex.ch)
+	case '*':
+		tok = lex.readToken(ASTERISK, lex.ch)
+	case '-':
+		tok = lex.readToken(MINUS, lex.ch)
+	case '+':
+		tok = lex.readToken(PLUS, lex.ch)
+	case '/':
+		tok = lex.readToken(SLASH, lex.ch)
+	case '%':
+		tok = lex.readToken(PERCENT, lex.ch)
+	case '|':
+		if lex.peekChar() == '|' {
+			tok = lex.readTwoCharToken(CONCAT)
+		} else {
+			tok = lex.readToken(ILLEGAL, lex.ch)
+		}
+	case ':':
+		if lex.peekChar() == ':' {
+			tok = lex.readTwoCharToken(DOUBLE_COLON)
+		} else {
+			tok = lex.readToken(ILLEGAL, lex.ch)
+		}
+	case '=':
+		tok = lex.readToken(EQUALS, lex.ch)
+	case '?':
+		tok = lex.readToken(PARAMETER, lex.ch)
+	case '$':
+		if isDigit(lex.peekChar()) {
+			lex.readChar()
+			tok.Literal = "$" + lex.readNumber()
+			tok.Type = PARAMETER
+		} else {
+			tok = lex.readToken(ILLEGAL, lex.ch)
+		}
+	case '!':
+		if lex.peekChar() == '=' {
+			tok = lex.readTwoCharToken(NOT_EQUALS)
+		} else {
+			tok = lex.readToken(ILLEGAL, lex.ch)
+		}
+	case '<':
+		switch lex.peekChar() {
+		case '=':
+			tok = lex.readTwoCharToken(LESS_EQUALS)
+		case '>':
+			tok = lex.readTwoCharToken(NOT_EQUALS)
+		default:
+			tok = lex.readToken(LESS_THAN, lex.ch)
+		}
+	case '>':
+		if lex.peekChar() == '=' {
+			tok = lex.readTwoCharToken(GREATER_EQUALS)
+		} else {
+			tok = lex.readToken(GREATER_THAN, lex.ch)
+		}
 	case 0:
 		tok = lex.readToken(EOF, byte(0))
+		tok.Literal = ""
 	case '\'':
 		tok.Literal = lex.readString()
 		tok.Type = STRING
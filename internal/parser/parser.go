@@ -2,15 +2,36 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	logger "github.com/roackb2/simple_db/internal/log"
+	"github.com/roackb2/simple_db/internal/types"
 )
 
 type Parser struct {
-	lex       *Lexer
-	errors    []string
-	curToken  Token
-	peekToken Token
+	lex        *Lexer
+	errors     []string
+	curToken   Token
+	peekToken  Token
+	paramCount int  // highest parameter index seen so far
+	paramStyle byte // '?' or '$' once the first parameter is seen
+	functions  FunctionResolver
+	ctes       []*CommonTableExpr // queries of the WITH clauses in scope, innermost last
+}
+
+// FunctionResolver looks up functions defined outside the parser, such as
+// user-defined functions registered with the executor.
+type FunctionResolver interface {
+	// ResolveFunction returns the definition of the function with the
+	// upper-cased name and whether it is an aggregate function, or nil if
+	// there is no such function.
+	ResolveFunction(name string) (def interface{}, aggregate bool)
+}
+
+// SetFunctionResolver makes the parser resolve function calls against r.
+func (parser *Parser) SetFunctionResolver(r FunctionResolver) {
+	parser.functions = r
 }
 
 func NewParser(lex *Lexer) *Parser {
@@ -33,11 +54,23 @@ func (parser *Parser) nextToken() {
 	logger.Debug("after parser.nextToken, curToken: %s, peekToken: %s\n", parser.curToken.Literal, parser.peekToken.Literal)
 }
 
+// peekSecond returns the token after peekToken without advancing.
+func (parser *Parser) peekSecond() Token {
+	saved := *parser.lex
+	token := parser.lex.nextToken()
+	*parser.lex = saved
+	return token
+}
+
 func (parser *Parser) peekError(t TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, parser.peekToken.Literal)
 	parser.errors = append(parser.errors, msg)
 }
 
+func (parser *Parser) addError(format string, args ...interface{}) {
+	parser.errors = append(parser.errors, fmt.Sprintf(format, args...))
+}
+
 func (parser *Parser) expectPeek(tokenType TokenType) bool {
 	logger.Debug("expect peek token %s: %s\n", parser.peekToken.Literal, tokenType)
 	if parser.peekToken.Type == tokenType {
@@ -49,89 +82,2310 @@ func (parser *Parser) expectPeek(tokenType TokenType) bool {
 	}
 }
 
-func (parser *Parser) parseInsertStatement() *Statement {
-	insertStatement := &InsertStatement{}
-	// INTO
-	if !parser.expectPeek(INTO) {
-		return nil
-	}
-	// table name
-	if !parser.expectPeek(IDENTIFIER) {
-		return nil
-	}
-	insertStatement.TableName = parser.curToken.Literal
-	// column names
+// parseIdentifierList parses "(ident, ident, ...)" starting at the opening parenthesis in peekToken.
+func (parser *Parser) parseIdentifierList() ([]string, bool) {
 	if !parser.expectPeek(OPEN_PARENTHESIS) {
-		return nil
+		return nil, false
 	}
+	var idents []string
 	if !parser.expectPeek(IDENTIFIER) {
-		return nil
+		return nil, false
 	}
-	insertStatement.Columns = append(insertStatement.Columns, parser.curToken.Literal)
+	idents = append(idents, parser.curToken.Literal)
 	for parser.peekToken.Type == COMMA {
 		parser.nextToken()
-		parser.nextToken()
-		insertStatement.Columns = append(insertStatement.Columns, parser.curToken.Literal)
+		if !parser.expectPeek(IDENTIFIER) {
+			return nil, false
+		}
+		idents = append(idents, parser.curToken.Literal)
 	}
-	insertStatement.Columns = append(insertStatement.Columns, parser.curToken.Literal)
 	if !parser.expectPeek(CLOSE_PARENTHESIS) {
-		return nil
+		return nil, false
 	}
-	// VALUES
-	if !parser.expectPeek(VALUES) {
-		return nil
+	return idents, true
+}
+
+// parseValue parses the literal value in peekToken.
+func (parser *Parser) parseValue() (types.Value, bool) {
+	parser.nextToken()
+	negative := false
+	if parser.curToken.Type == MINUS {
+		negative = true
+		if !parser.expectPeek(NUMBER) {
+			return types.Null(), false
+		}
 	}
-	// column values
-	if !parser.expectPeek(OPEN_PARENTHESIS) {
-		return nil
+	switch parser.curToken.Type {
+	case STRING:
+		return types.NewText(parser.curToken.Literal), true
+	case NUMBER:
+		literal := parser.curToken.Literal
+		if negative {
+			literal = "-" + literal
+		}
+		return parser.parseNumber(literal)
+	case NULL:
+		return types.Null(), true
+	case TRUE:
+		return types.NewBoolean(true), true
+	case FALSE:
+		return types.NewBoolean(false), true
+	default:
+		parser.addError("expected a value, got %s instead", parser.curToken.Literal)
+		return types.Null(), false
 	}
-	if !parser.expectPeek(STRING) {
-		return nil
+}
+
+// parseNumber converts the text of a number to an INTEGER, or a REAL if it
+// has a decimal point.
+func (parser *Parser) parseNumber(literal string) (types.Value, bool) {
+	if strings.Contains(literal, ".") {
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			parser.addError("invalid number %s", literal)
+			return types.Null(), false
+		}
+		return types.NewReal(f), true
 	}
-	insertStatement.Values = append(insertStatement.Values, parser.curToken.Literal)
-	for parser.peekToken.Type == COMMA {
+	i, err := strconv.ParseInt(literal, 10, 64)
+	if err != nil {
+		parser.addError("invalid number %s", literal)
+		return types.Null(), false
+	}
+	return types.NewInteger(i), true
+}
+
+// parseParam parses the bind parameter in curToken. Positional ? and
+// numbered $n parameters cannot be mixed in one statement.
+func (parser *Parser) parseParam() (*Param, bool) {
+	literal := parser.curToken.Literal
+	style := literal[0]
+	if parser.paramStyle != 0 && parser.paramStyle != style {
+		parser.addError("cannot mix ? and $n parameters")
+		return nil, false
+	}
+	parser.paramStyle = style
+	if style == '?' {
+		parser.paramCount++
+		return &Param{Index: parser.paramCount}, true
+	}
+	index, err := strconv.Atoi(literal[1:])
+	if err != nil || index < 1 {
+		parser.addError("invalid parameter %s", literal)
+		return nil, false
+	}
+	if index > parser.paramCount {
+		parser.paramCount = index
+	}
+	return &Param{Index: index}, true
+}
+
+// parseOperand parses a literal value or a bind parameter in peekToken.
+func (parser *Parser) parseOperand() (Expr, bool) {
+	if parser.peekToken.Type == PARAMETER {
+		parser.nextToken()
+		return parser.parseParam()
+	}
+	value, ok := parser.parseValue()
+	if !ok {
+		return nil, false
+	}
+	return &Literal{Value: value}, true
+}
+
+// parseExpression parses the expression starting in peekToken. Operators
+// bind from loosest to tightest as OR, AND, NOT, comparisons (including IS,
+// BETWEEN, IN and LIKE), ||, + and -, * / and %, unary minus and ::.
+func (parser *Parser) parseExpression() (Expr, bool) {
+	return parser.parseOr()
+}
+
+func (parser *Parser) parseOr() (Expr, bool) {
+	left, ok := parser.parseAnd()
+	for ok && parser.peekToken.Type == OR {
+		parser.nextToken()
+		var right Expr
+		if right, ok = parser.parseAnd(); ok {
+			left = &BinaryExpr{Op: "OR", Left: left, Right: right}
+		}
+	}
+	return left, ok
+}
+
+func (parser *Parser) parseAnd() (Expr, bool) {
+	left, ok := parser.parseNot()
+	for ok && parser.peekToken.Type == AND {
+		parser.nextToken()
+		var right Expr
+		if right, ok = parser.parseNot(); ok {
+			left = &BinaryExpr{Op: "AND", Left: left, Right: right}
+		}
+	}
+	return left, ok
+}
+
+func (parser *Parser) parseNot() (Expr, bool) {
+	if parser.peekToken.Type == NOT {
+		parser.nextToken()
+		operand, ok := parser.parseNot()
+		if !ok {
+			return nil, false
+		}
+		return &UnaryExpr{Op: "NOT", Operand: operand}, true
+	}
+	return parser.parseComparison()
+}
+
+func (parser *Parser) parseComparison() (Expr, bool) {
+	left, ok := parser.parseConcat()
+	if !ok {
+		return nil, false
+	}
+	not := false
+	if parser.peekToken.Type == NOT {
+		parser.nextToken()
+		not = true
+		switch parser.peekToken.Type {
+		case BETWEEN, IN, LIKE, ILIKE:
+		default:
+			parser.addError("expected BETWEEN, IN, LIKE or ILIKE after NOT, got %s instead", parser.peekToken.Literal)
+			return nil, false
+		}
+	}
+	switch parser.peekToken.Type {
+	case EQUALS, NOT_EQUALS, LESS_THAN, LESS_EQUALS, GREATER_THAN, GREATER_EQUALS:
+		parser.nextToken()
+		op := parser.curToken.Literal
+		if op == "!=" {
+			op = "<>"
+		}
+		right, ok := parser.parseConcat()
+		if !ok {
+			return nil, false
+		}
+		return &BinaryExpr{Op: op, Left: left, Right: right}, true
+	case IS:
+		parser.nextToken()
+		isNull := &IsNullExpr{Expr: left}
+		if parser.peekToken.Type == NOT {
+			parser.nextToken()
+			isNull.Not = true
+		}
+		if !parser.expectPeek(NULL) {
+			return nil, false
+		}
+		return isNull, true
+	case BETWEEN:
+		// BETWEEN is shorthand for a pair of comparisons, which the planner
+		// can use to bound an index scan.
+		parser.nextToken()
+		low, ok := parser.parseConcat()
+		if !ok || !parser.expectPeek(AND) {
+			return nil, false
+		}
+		high, ok := parser.parseConcat()
+		if !ok {
+			return nil, false
+		}
+		if not {
+			return &BinaryExpr{
+				Op:    "OR",
+				Left:  &BinaryExpr{Op: "<", Left: left, Right: low},
+				Right: &BinaryExpr{Op: ">", Left: left, Right: high},
+			}, true
+		}
+		return &BinaryExpr{
+			Op:    "AND",
+			Left:  &BinaryExpr{Op: ">=", Left: left, Right: low},
+			Right: &BinaryExpr{Op: "<=", Left: left, Right: high},
+		}, true
+	case IN:
+		parser.nextToken()
+		if !parser.expectPeek(OPEN_PARENTHESIS) {
+			return nil, false
+		}
+		if parser.peekQuery() {
+			sub, ok := parser.parseSubquery()
+			if !ok {
+				return nil, false
+			}
+			return &InExpr{Expr: left, Subquery: sub, Not: not}, true
+		}
+		list, ok := parser.parseExpressionList()
+		if !ok || !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return nil, false
+		}
+		return &InExpr{Expr: left, List: list, Not: not}, true
+	case LIKE, ILIKE:
+		parser.nextToken()
+		like := &LikeExpr{Expr: left, Not: not, CaseInsensitive: parser.curToken.Type == ILIKE}
+		if like.Pattern, ok = parser.parseConcat(); !ok {
+			return nil, false
+		}
+		return like, true
+	}
+	return left, true
+}
+
+func (parser *Parser) parseConcat() (Expr, bool) {
+	return parser.parseBinary(parser.parseAdditive, CONCAT)
+}
+
+func (parser *Parser) parseAdditive() (Expr, bool) {
+	return parser.parseBinary(parser.parseMultiplicative, PLUS, MINUS)
+}
+
+func (parser *Parser) parseMultiplicative() (Expr, bool) {
+	return parser.parseBinary(parser.parseUnary, ASTERISK, SLASH, PERCENT)
+}
+
+// parseBinary parses operands produced by next joined by left-associative
+// operators of the same precedence.
+func (parser *Parser) parseBinary(next func() (Expr, bool), ops ...TokenType) (Expr, bool) {
+	left, ok := next()
+	for ok && containsToken(ops, parser.peekToken.Type) {
+		parser.nextToken()
+		op := parser.curToken.Literal
+		var right Expr
+		if right, ok = next(); ok {
+			left = &BinaryExpr{Op: op, Left: left, Right: right}
+		}
+	}
+	return left, ok
+}
+
+func containsToken(types []TokenType, t TokenType) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUnary parses an operand in peekToken with an optional sign. A minus
+// sign before a number is part of the literal.
+func (parser *Parser) parseUnary() (Expr, bool) {
+	if parser.peekToken.Type != MINUS && parser.peekToken.Type != PLUS {
+		return parser.parseCast()
+	}
+	parser.nextToken()
+	sign := parser.curToken.Type
+	if sign == MINUS && parser.peekToken.Type == NUMBER {
+		parser.nextToken()
+		value, ok := parser.parseNumber("-" + parser.curToken.Literal)
+		if !ok {
+			return nil, false
+		}
+		return parser.parseCastSuffix(&Literal{Value: value})
+	}
+	operand, ok := parser.parseUnary()
+	if !ok || sign == PLUS {
+		return operand, ok
+	}
+	return &UnaryExpr{Op: "-", Operand: operand}, true
+}
+
+// parseCast parses a primary expression followed by any number of ::type
+// casts.
+func (parser *Parser) parseCast() (Expr, bool) {
+	expr, ok := parser.parsePrimary()
+	if !ok {
+		return nil, false
+	}
+	return parser.parseCastSuffix(expr)
+}
+
+func (parser *Parser) parseCastSuffix(expr Expr) (Expr, bool) {
+	for parser.peekToken.Type == DOUBLE_COLON {
+		parser.nextToken()
+		typ, ok := parser.parseTypeName()
+		if !ok {
+			return nil, false
+		}
+		expr = &CastExpr{Expr: expr, Type: typ}
+	}
+	return expr, true
+}
+
+// parseTypeName parses the name of a type in peekToken.
+func (parser *Parser) parseTypeName() (types.Type, bool) {
+	if !parser.expectPeek(IDENTIFIER) {
+		return types.TypeNull, false
+	}
+	typ, err := types.ParseType(parser.curToken.Literal)
+	if err != nil {
+		parser.addError("%s", err)
+		return types.TypeNull, false
+	}
+	return typ, true
+}
+
+// niladicFunctions are the functions called without parentheses.
+var niladicFunctions = map[string]bool{
+	"CURRENT_DATE":      true,
+	"CURRENT_TIME":      true,
+	"CURRENT_TIMESTAMP": true,
+}
+
+// parsePrimary parses a value, a parameter, a column reference, a function
+// call, a CASE or CAST expression, a subquery, EXISTS or a parenthesized
+// expression in peekToken.
+func (parser *Parser) parsePrimary() (Expr, bool) {
+	switch parser.peekToken.Type {
+	case IDENTIFIER:
+		parser.nextToken()
+		name := parser.curToken.Literal
+		switch parser.peekToken.Type {
+		case OPEN_PARENTHESIS:
+			if strings.EqualFold(name, "EXTRACT") {
+				return parser.parseExtract()
+			}
+			return parser.parseFuncCall(name)
+		case DOT:
+			parser.nextToken()
+			if !parser.expectPeek(IDENTIFIER) {
+				return nil, false
+			}
+			return &ColumnRef{Table: name, Column: parser.curToken.Literal}, true
+		}
+		if niladicFunctions[strings.ToUpper(name)] {
+			return &FuncCall{Name: strings.ToUpper(name)}, true
+		}
+		return &ColumnRef{Column: name}, true
+	case LEFT, RIGHT:
+		// LEFT and RIGHT are also the string functions.
+		parser.nextToken()
+		name := parser.curToken.Literal
+		if parser.peekToken.Type != OPEN_PARENTHESIS {
+			parser.addError("unexpected %s", name)
+			return nil, false
+		}
+		return parser.parseFuncCall(name)
+	case CASE:
+		return parser.parseCase()
+	case CAST:
+		parser.nextToken()
+		if !parser.expectPeek(OPEN_PARENTHESIS) {
+			return nil, false
+		}
+		expr, ok := parser.parseExpression()
+		if !ok || !parser.expectPeek(AS) {
+			return nil, false
+		}
+		typ, ok := parser.parseTypeName()
+		if !ok || !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return nil, false
+		}
+		return &CastExpr{Expr: expr, Type: typ}, true
+	case EXISTS:
+		parser.nextToken()
+		if !parser.expectPeek(OPEN_PARENTHESIS) {
+			return nil, false
+		}
+		sub, ok := parser.parseSubquery()
+		if !ok {
+			return nil, false
+		}
+		return &ExistsExpr{Select: sub}, true
+	case OPEN_PARENTHESIS:
+		parser.nextToken()
+		if parser.peekQuery() {
+			sub, ok := parser.parseSubquery()
+			if !ok {
+				return nil, false
+			}
+			return &SubqueryExpr{Select: sub}, true
+		}
+		expr, ok := parser.parseExpression()
+		if !ok || !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return nil, false
+		}
+		return expr, true
+	default:
+		return parser.parseOperand()
+	}
+}
+
+// peekQuery reports whether peekToken starts a query: SELECT or WITH.
+func (parser *Parser) peekQuery() bool {
+	return parser.peekToken.Type == SELECT || parser.peekToken.Type == WITH
+}
+
+// parseSubquery parses a query in peekToken and the parenthesis closing it.
+func (parser *Parser) parseSubquery() (*SelectStatement, bool) {
+	if !parser.peekQuery() {
+		parser.peekError(SELECT)
+		return nil, false
+	}
+	parser.nextToken()
+	stmt := parser.parseSelectStatement()
+	if stmt == nil || !parser.expectPeek(CLOSE_PARENTHESIS) {
+		return nil, false
+	}
+	return stmt.SelectStmt, true
+}
+
+// parseCase parses a CASE expression starting at CASE in peekToken.
+func (parser *Parser) parseCase() (Expr, bool) {
+	parser.nextToken()
+	expr := &CaseExpr{}
+	var ok bool
+	if parser.peekToken.Type != WHEN {
+		if expr.Operand, ok = parser.parseExpression(); !ok {
+			return nil, false
+		}
+	}
+	for parser.peekToken.Type == WHEN {
 		parser.nextToken()
+		var when WhenClause
+		if when.Cond, ok = parser.parseExpression(); !ok || !parser.expectPeek(THEN) {
+			return nil, false
+		}
+		if when.Result, ok = parser.parseExpression(); !ok {
+			return nil, false
+		}
+		expr.Whens = append(expr.Whens, when)
+	}
+	if len(expr.Whens) == 0 {
+		parser.peekError(WHEN)
+		return nil, false
+	}
+	if parser.peekToken.Type == ELSE {
 		parser.nextToken()
-		insertStatement.Values = append(insertStatement.Values, parser.curToken.Literal)
+		if expr.Else, ok = parser.parseExpression(); !ok {
+			return nil, false
+		}
+	}
+	if !parser.expectPeek(END) {
+		return nil, false
+	}
+	return expr, true
+}
+
+// parseExtract parses EXTRACT(field FROM expr), starting at the opening
+// parenthesis in peekToken, as a call of DATE_PART.
+func (parser *Parser) parseExtract() (Expr, bool) {
+	parser.nextToken()
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil, false
+	}
+	field := &Literal{Value: types.NewText(strings.ToLower(parser.curToken.Literal))}
+	if !parser.expectPeek(FROM) {
+		return nil, false
+	}
+	expr, ok := parser.parseExpression()
+	if !ok || !parser.expectPeek(CLOSE_PARENTHESIS) {
+		return nil, false
 	}
-	insertStatement.Values = append(insertStatement.Values, parser.curToken.Literal)
+	return &FuncCall{Name: "DATE_PART", Args: []Expr{field, expr}}, true
+}
 
+// parseFuncCall parses the argument list of a call to the named function,
+// starting at the opening parenthesis in peekToken.
+func (parser *Parser) parseFuncCall(name string) (Expr, bool) {
+	parser.nextToken()
+	call := &FuncCall{Name: strings.ToUpper(name)}
+	if parser.functions != nil {
+		call.Func, call.UserAggregate = parser.functions.ResolveFunction(call.Name)
+	}
+	if parser.peekToken.Type == ASTERISK {
+		parser.nextToken()
+		call.Star = true
+	} else {
+		if parser.peekToken.Type == DISTINCT {
+			parser.nextToken()
+			call.Distinct = true
+		}
+		if parser.peekToken.Type != CLOSE_PARENTHESIS {
+			for {
+				arg, ok := parser.parseExpression()
+				if !ok {
+					return nil, false
+				}
+				call.Args = append(call.Args, arg)
+				if parser.peekToken.Type != COMMA {
+					break
+				}
+				parser.nextToken()
+			}
+		}
+	}
 	if !parser.expectPeek(CLOSE_PARENTHESIS) {
-		return nil
+		return nil, false
+	}
+	if parser.peekKeyword("OVER") {
+		parser.nextToken()
+		var ok bool
+		if call.Over, ok = parser.parseWindowSpec(); !ok {
+			return nil, false
+		}
 	}
-	return &Statement{PrepareSuccess, StatementInsert, "", insertStatement, nil}
+	return call, true
 }
 
-func (parser *Parser) parseSelectStatement() *Statement {
-	selectStmt := &SelectStatement{}
+// peekKeyword reports whether peekToken is the given word. Words that are
+// only keywords in some clauses, such as OVER or ROWS, are lexed as
+// identifiers so that they remain usable as names.
+func (parser *Parser) peekKeyword(word string) bool {
+	return parser.peekToken.Type == IDENTIFIER && strings.EqualFold(parser.peekToken.Literal, word)
+}
+
+// expectKeyword advances past the given word in peekToken, or records an
+// error.
+func (parser *Parser) expectKeyword(word string) bool {
+	if !parser.peekKeyword(word) {
+		parser.addError("expected %s, got %s instead", word, parser.peekToken.Literal)
+		return false
+	}
+	parser.nextToken()
+	return true
+}
 
-	// Logic to parse fields, table name, and WHERE clause
+// parseWindowSpec parses (PARTITION BY expr, ... ORDER BY ... frame)
+// following OVER, starting at the opening parenthesis in peekToken.
+func (parser *Parser) parseWindowSpec() (*WindowSpec, bool) {
+	if !parser.expectPeek(OPEN_PARENTHESIS) {
+		return nil, false
+	}
+	spec := &WindowSpec{}
+	var ok bool
+	if parser.peekKeyword("PARTITION") {
+		parser.nextToken()
+		if !parser.expectPeek(BY) {
+			return nil, false
+		}
+		if spec.PartitionBy, ok = parser.parseExpressionList(); !ok {
+			return nil, false
+		}
+	}
+	if parser.peekToken.Type == ORDER {
+		if spec.OrderBy, ok = parser.parseOrderBy(); !ok {
+			return nil, false
+		}
+	}
+	if parser.peekKeyword("ROWS") || parser.peekKeyword("RANGE") {
+		if spec.Frame, ok = parser.parseWindowFrame(); !ok {
+			return nil, false
+		}
+	}
+	if !parser.expectPeek(CLOSE_PARENTHESIS) {
+		return nil, false
+	}
+	return spec, true
+}
 
-	return &Statement{
-		PrepareRes:    PrepareSuccess,
-		StatementType: StatementSelect,
-		SelectStmt:    selectStmt,
+// parseWindowFrame parses ROWS|RANGE [BETWEEN start AND end] starting at
+// ROWS or RANGE in peekToken. A frame given by its start alone ends at the
+// current row.
+func (parser *Parser) parseWindowFrame() (*WindowFrame, bool) {
+	parser.nextToken()
+	frame := &WindowFrame{Mode: FrameRange, End: FrameBound{Type: CurrentRow}}
+	if strings.EqualFold(parser.curToken.Literal, "ROWS") {
+		frame.Mode = FrameRows
+	}
+	var ok bool
+	if parser.peekToken.Type == BETWEEN {
+		parser.nextToken()
+		if frame.Start, ok = parser.parseFrameBound(); !ok || !parser.expectPeek(AND) {
+			return nil, false
+		}
+		if frame.End, ok = parser.parseFrameBound(); !ok {
+			return nil, false
+		}
+	} else if frame.Start, ok = parser.parseFrameBound(); !ok {
+		return nil, false
+	}
+	switch {
+	case frame.Start.Type == UnboundedFollowing:
+		parser.addError("frame start cannot be UNBOUNDED FOLLOWING")
+	case frame.End.Type == UnboundedPreceding:
+		parser.addError("frame end cannot be UNBOUNDED PRECEDING")
+	case frame.Start.Type == CurrentRow && frame.End.Type == OffsetPreceding:
+		parser.addError("frame starting from current row cannot have preceding rows")
+	case frame.Start.Type == OffsetFollowing && frame.End.Type < OffsetFollowing:
+		parser.addError("frame starting from following row cannot have preceding rows")
+	default:
+		return frame, true
 	}
+	return nil, false
 }
 
-func PrintTokens(lexer *Lexer) {
+// parseFrameBound parses UNBOUNDED PRECEDING|FOLLOWING, CURRENT ROW or
+// offset PRECEDING|FOLLOWING in peekToken.
+func (parser *Parser) parseFrameBound() (FrameBound, bool) {
+	var bound FrameBound
+	switch {
+	case parser.peekKeyword("UNBOUNDED"):
+		parser.nextToken()
+		bound.Type = UnboundedPreceding
+		if parser.peekKeyword("FOLLOWING") {
+			bound.Type = UnboundedFollowing
+		} else if !parser.peekKeyword("PRECEDING") {
+			parser.addError("expected PRECEDING or FOLLOWING, got %s instead", parser.peekToken.Literal)
+			return bound, false
+		}
+		parser.nextToken()
+		return bound, true
+	case parser.peekKeyword("CURRENT"):
+		parser.nextToken()
+		bound.Type = CurrentRow
+		return bound, parser.expectKeyword("ROW")
+	}
+	offset, ok := parser.parseOperand()
+	if !ok {
+		return bound, false
+	}
+	bound.Offset = offset
+	switch {
+	case parser.peekKeyword("PRECEDING"):
+		bound.Type = OffsetPreceding
+	case parser.peekKeyword("FOLLOWING"):
+		bound.Type = OffsetFollowing
+	default:
+		parser.addError("expected PRECEDING or FOLLOWING, got %s instead", parser.peekToken.Literal)
+		return bound, false
+	}
+	parser.nextToken()
+	return bound, true
+}
+
+// parseExpressionList parses "expr, expr, ..." starting in peekToken.
+func (parser *Parser) parseExpressionList() ([]Expr, bool) {
+	var exprs []Expr
 	for {
-		tok := lexer.nextToken()
-		logger.Debug("%+v\n", tok)
-		if tok.Type == EOF {
-			break
+		expr, ok := parser.parseExpression()
+		if !ok {
+			return nil, false
 		}
+		exprs = append(exprs, expr)
+		if parser.peekToken.Type != COMMA {
+			return exprs, true
+		}
+		parser.nextToken()
 	}
 }
 
-func (parser *Parser) ParseStatement() *Statement {
-	logger.Debug("Statement starts with: %s\n", parser.curToken.Type)
-	switch parser.curToken.Type {
-	case INSERT:
-		return parser.parseInsertStatement()
-	case SELECT:
-		return parser.parseSelectStatement()
-	default:
-		return &Statement{PrepareFail, StatementUnknown, "", nil, nil}
+// parseWhereClause parses an optional WHERE condition in peekToken.
+func (parser *Parser) parseWhereClause() (Expr, bool) {
+	if parser.peekToken.Type != WHERE {
+		return nil, true
+	}
+	parser.nextToken()
+	return parser.parseExpression()
+}
+
+func (parser *Parser) parseInsertStatement() *Statement {
+	insertStatement := &InsertStatement{}
+	// INTO
+	if !parser.expectPeek(INTO) {
+		return nil
+	}
+	// table name
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	insertStatement.TableName = parser.curToken.Literal
+	// column names
+	columns, ok := parser.parseIdentifierList()
+	if !ok {
+		return nil
+	}
+	insertStatement.Columns = columns
+	// VALUES
+	if !parser.expectPeek(VALUES) {
+		return nil
+	}
+	// column values, one parenthesized list per row
+	for {
+		if !parser.expectPeek(OPEN_PARENTHESIS) {
+			return nil
+		}
+		values, ok := parser.parseExpressionList()
+		if !ok || !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return nil
+		}
+		if len(values) != len(insertStatement.Columns) {
+			parser.addError("expected %d values, got %d", len(insertStatement.Columns), len(values))
+			return nil
+		}
+		insertStatement.Values = append(insertStatement.Values, values)
+		if parser.peekToken.Type != COMMA {
+			break
+		}
+		parser.nextToken()
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementInsert, InsertStmt: insertStatement}
+}
+
+// parseSelectStatement parses a query starting at SELECT, WITH or an opening
+// parenthesis in curToken: a SELECT, or SELECTs combined by UNION, INTERSECT and EXCEPT, followed by
+// an ORDER BY that applies to the whole query.
+func (parser *Parser) parseSelectStatement() *Statement {
+	var with []*CommonTableExpr
+	if parser.curToken.Type == WITH {
+		// The names of the WITH clause are only in scope in the query.
+		defer func(ctes []*CommonTableExpr) { parser.ctes = ctes }(parser.ctes)
+		var ok bool
+		if with, ok = parser.parseWithClause(); !ok || !parser.expectPeek(SELECT) {
+			return nil
+		}
+	}
+	selectStmt, ok := parser.parseSetOperations()
+	if !ok {
+		return nil
+	}
+	if with != nil {
+		selectStmt.With = with
+	}
+	// ORDER BY clause
+	if parser.peekToken.Type == ORDER {
+		if len(selectStmt.OrderBy) > 0 {
+			parser.addError("multiple ORDER BY clauses not allowed")
+			return nil
+		}
+		if selectStmt.Limit != nil || selectStmt.Offset != nil {
+			parser.addError("ORDER BY must come before LIMIT and OFFSET")
+			return nil
+		}
+		orderBy, ok := parser.parseOrderBy()
+		if !ok {
+			return nil
+		}
+		selectStmt.OrderBy = orderBy
+	}
+	if !parser.parseLimit(selectStmt) {
+		return nil
+	}
+
+	return &Statement{
+		PrepareRes:    PrepareSuccess,
+		StatementType: StatementSelect,
+		SelectStmt:    selectStmt,
+	}
+}
+
+// parseLimit parses the LIMIT, OFFSET and FETCH FIRST clauses following a
+// query, in peekToken, in any order:
+//
+//	LIMIT {count | ALL}
+//	OFFSET start [ROW | ROWS]
+//	FETCH {FIRST | NEXT} [count] {ROW | ROWS} ONLY
+func (parser *Parser) parseLimit(selectStmt *SelectStatement) bool {
+	if selectStmt.Limit != nil || selectStmt.Offset != nil {
+		// A parenthesized query already has its own.
+		switch parser.peekToken.Type {
+		case LIMIT, OFFSET, FETCH:
+			parser.addError("multiple LIMIT or OFFSET clauses not allowed")
+			return false
+		}
+		return true
+	}
+	limited := false
+	for {
+		switch parser.peekToken.Type {
+		case LIMIT:
+			if limited {
+				parser.addError("multiple LIMIT clauses not allowed")
+				return false
+			}
+			parser.nextToken()
+			limited = true
+			if parser.peekToken.Type == ALL {
+				parser.nextToken()
+				continue
+			}
+			limit, ok := parser.parseExpression()
+			if !ok {
+				return false
+			}
+			selectStmt.Limit = limit
+		case OFFSET:
+			if selectStmt.Offset != nil {
+				parser.addError("multiple OFFSET clauses not allowed")
+				return false
+			}
+			parser.nextToken()
+			offset, ok := parser.parseExpression()
+			if !ok {
+				return false
+			}
+			selectStmt.Offset = offset
+			if parser.peekKeyword("ROW") || parser.peekKeyword("ROWS") {
+				parser.nextToken()
+			}
+		case FETCH:
+			if limited {
+				parser.addError("multiple LIMIT clauses not allowed")
+				return false
+			}
+			parser.nextToken()
+			limited = true
+			if parser.peekToken.Type == FIRST || parser.peekKeyword("NEXT") {
+				parser.nextToken()
+			} else {
+				parser.peekError(FIRST)
+				return false
+			}
+			selectStmt.Limit = &Literal{Value: types.NewInteger(1)}
+			if !parser.peekKeyword("ROW") && !parser.peekKeyword("ROWS") {
+				limit, ok := parser.parseExpression()
+				if !ok {
+					return false
+				}
+				selectStmt.Limit = limit
+			}
+			if !parser.peekKeyword("ROW") && !parser.expectKeyword("ROWS") {
+				return false
+			}
+			if parser.peekKeyword("ROW") {
+				parser.nextToken()
+			}
+			if !parser.expectKeyword("ONLY") {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// parseSetOperations parses queries combined by UNION and EXCEPT, starting
+// at SELECT in curToken. INTERSECT binds more tightly; all three associate
+// to the left.
+func (parser *Parser) parseSetOperations() (*SelectStatement, bool) {
+	left, ok := parser.parseIntersections()
+	for ok && (parser.peekToken.Type == UNION || parser.peekToken.Type == EXCEPT) {
+		var right *SelectStatement
+		set := &SetOperation{Op: SetUnion, Left: left}
+		if parser.peekToken.Type == EXCEPT {
+			set.Op = SetExcept
+		}
+		if set.All, ok = parser.parseSetQuantifier(); !ok {
+			return nil, false
+		}
+		if right, ok = parser.parseIntersections(); ok {
+			set.Right = right
+			left = &SelectStatement{Set: set}
+		}
+	}
+	return left, ok
+}
+
+// parseIntersections parses queries combined by INTERSECT, starting at
+// SELECT or an opening parenthesis in curToken.
+func (parser *Parser) parseIntersections() (*SelectStatement, bool) {
+	left, ok := parser.parseSetOperand()
+	for ok && parser.peekToken.Type == INTERSECT {
+		var right *SelectStatement
+		set := &SetOperation{Op: SetIntersect, Left: left}
+		if set.All, ok = parser.parseSetQuantifier(); !ok {
+			return nil, false
+		}
+		if right, ok = parser.parseSetOperand(); ok {
+			set.Right = right
+			left = &SelectStatement{Set: set}
+		}
+	}
+	return left, ok
+}
+
+// parseSetQuantifier parses the set operator in peekToken and the ALL or
+// DISTINCT following it, reporting whether it is ALL. It leaves curToken at
+// the start of the next query.
+func (parser *Parser) parseSetQuantifier() (bool, bool) {
+	parser.nextToken()
+	all := false
+	switch parser.peekToken.Type {
+	case ALL:
+		parser.nextToken()
+		all = true
+	case DISTINCT:
+		parser.nextToken()
+	}
+	if parser.peekToken.Type != SELECT && parser.peekToken.Type != OPEN_PARENTHESIS {
+		parser.peekError(SELECT)
+		return false, false
+	}
+	parser.nextToken()
+	return all, true
+}
+
+// parseSetOperand parses a SELECT without ORDER BY, or a parenthesized query,
+// starting at SELECT or the parenthesis in curToken.
+func (parser *Parser) parseSetOperand() (*SelectStatement, bool) {
+	if parser.curToken.Type == OPEN_PARENTHESIS {
+		return parser.parseSubquery()
+	}
+	return parser.parseSelectCore()
+}
+
+// parseSelectCore parses the select list, FROM, WHERE, GROUP BY and HAVING
+// clauses of a SELECT starting at SELECT in curToken.
+func (parser *Parser) parseSelectCore() (*SelectStatement, bool) {
+	selectStmt := &SelectStatement{}
+	switch parser.peekToken.Type {
+	case DISTINCT:
+		parser.nextToken()
+		selectStmt.Distinct = true
+	case ALL:
+		parser.nextToken()
+	}
+
+	// select list
+	fields, ok := parser.parseSelectList()
+	if !ok {
+		return nil, false
+	}
+	selectStmt.Fields = fields
+	// FROM clause
+	if !parser.expectPeek(FROM) {
+		return nil, false
+	}
+	from, ok := parser.parseFromClause()
+	if !ok {
+		return nil, false
+	}
+	selectStmt.From = from
+	// WHERE clause
+	where, ok := parser.parseWhereClause()
+	if !ok {
+		return nil, false
+	}
+	selectStmt.Where = where
+	// GROUP BY clause
+	if parser.peekToken.Type == GROUP {
+		parser.nextToken()
+		if !parser.expectPeek(BY) {
+			return nil, false
+		}
+		groupBy, ok := parser.parseExpressionList()
+		if !ok {
+			return nil, false
+		}
+		selectStmt.GroupBy = groupBy
+	}
+	// HAVING clause
+	if parser.peekToken.Type == HAVING {
+		parser.nextToken()
+		having, ok := parser.parseExpression()
+		if !ok {
+			return nil, false
+		}
+		selectStmt.Having = having
+	}
+	return selectStmt, true
+}
+
+// parseWithClause parses "WITH [RECURSIVE] name [(column, ...)] AS (query),
+// ..." starting at WITH in curToken, and puts the names in scope. Each query
+// may read those listed before it; in a recursive WITH clause, a query of
+// the form "query UNION [ALL] query" may also read itself in the second
+// query, its recursive term.
+func (parser *Parser) parseWithClause() ([]*CommonTableExpr, bool) {
+	recursive := false
+	if parser.peekKeyword("RECURSIVE") {
+		parser.nextToken()
+		recursive = true
+	}
+	var ctes []*CommonTableExpr
+	for {
+		if !parser.expectPeek(IDENTIFIER) {
+			return nil, false
+		}
+		cte := &CommonTableExpr{Name: parser.curToken.Literal}
+		for _, prev := range ctes {
+			if strings.EqualFold(prev.Name, cte.Name) {
+				parser.addError("WITH query name %s specified more than once", cte.Name)
+				return nil, false
+			}
+		}
+		if parser.peekToken.Type == OPEN_PARENTHESIS {
+			columns, ok := parser.parseIdentifierList()
+			if !ok {
+				return nil, false
+			}
+			cte.Columns = columns
+		}
+		if !parser.expectPeek(AS) || !parser.expectPeek(OPEN_PARENTHESIS) {
+			return nil, false
+		}
+		if recursive {
+			parser.ctes = append(parser.ctes, cte)
+		}
+		body, ok := parser.parseSubquery()
+		if !ok {
+			return nil, false
+		}
+		cte.Select = body
+		if recursive && References(body, cte) && body.Set != nil && body.Set.Op == SetUnion {
+			if len(body.OrderBy) > 0 || len(body.With) > 0 {
+				parser.addError("ORDER BY and WITH are not supported in the recursive query %s", cte.Name)
+				return nil, false
+			}
+			cte.Select, cte.Recursive, cte.UnionAll = body.Set.Left, body.Set.Right, body.Set.All
+		}
+		if !parser.checkRecursion(cte) {
+			return nil, false
+		}
+		if !recursive {
+			parser.ctes = append(parser.ctes, cte)
+		}
+		ctes = append(ctes, cte)
+		if parser.peekToken.Type != COMMA {
+			return ctes, true
+		}
+		parser.nextToken()
+	}
+}
+
+// checkRecursion checks that a query of a WITH clause only reads itself once,
+// in the FROM clause of its recursive term.
+func (parser *Parser) checkRecursion(cte *CommonTableExpr) bool {
+	if !References(cte.Select, cte) && (cte.Recursive == nil || !References(cte.Recursive, cte)) {
+		return true
+	}
+	if cte.Recursive == nil {
+		parser.addError("recursive query %s does not have the form non-recursive-term UNION [ALL] recursive-term", cte.Name)
+		return false
+	}
+	if References(cte.Select, cte) {
+		parser.addError("recursive reference to query %s must not appear within its non-recursive term", cte.Name)
+		return false
+	}
+	count := func(tables []*TableName) int {
+		n := 0
+		for _, table := range tables {
+			if table.CTE == cte {
+				n++
+			}
+		}
+		return n
+	}
+	if count(Tables(cte.Recursive.From)) != 1 || count(selectTables(cte.Recursive, true)) != 1 {
+		parser.addError("recursive reference to query %s must appear exactly once, in the FROM clause of its recursive term", cte.Name)
+		return false
+	}
+	return true
+}
+
+// parseFromClause parses the table references of a FROM clause in peekToken.
+// Comma separated tables are cross joined; joins associate to the left.
+func (parser *Parser) parseFromClause() (TableRef, bool) {
+	left, ok := parser.parseTableName()
+	if !ok {
+		return nil, false
+	}
+	for {
+		join := &Join{Left: left}
+		switch parser.peekToken.Type {
+		case COMMA:
+			parser.nextToken()
+			join.Type = JoinCross
+		case CROSS:
+			parser.nextToken()
+			if !parser.expectPeek(JOIN) {
+				return nil, false
+			}
+			join.Type = JoinCross
+		case JOIN, INNER, LEFT, RIGHT, FULL:
+			parser.nextToken()
+			switch parser.curToken.Type {
+			case LEFT:
+				join.Type = JoinLeft
+			case RIGHT:
+				join.Type = JoinRight
+			case FULL:
+				join.Type = JoinFull
+			}
+			if join.Type != JoinInner && parser.peekToken.Type == OUTER {
+				parser.nextToken()
+			}
+			if parser.curToken.Type != JOIN && !parser.expectPeek(JOIN) {
+				return nil, false
+			}
+		default:
+			return left, true
+		}
+		if join.Right, ok = parser.parseTableName(); !ok {
+			return nil, false
+		}
+		if join.Type != JoinCross {
+			if !parser.expectPeek(ON) {
+				return nil, false
+			}
+			if join.On, ok = parser.parseExpression(); !ok {
+				return nil, false
+			}
+		}
+		left = join
+	}
+}
+
+// parseTableName parses "table [[AS] alias]" or "(SELECT ...) [AS] alias"
+// in peekToken.
+func (parser *Parser) parseTableName() (TableRef, bool) {
+	if parser.peekToken.Type == OPEN_PARENTHESIS {
+		parser.nextToken()
+		sub, ok := parser.parseSubquery()
+		if !ok {
+			return nil, false
+		}
+		derived := &DerivedTable{Select: sub}
+		if derived.Alias, ok = parser.parseAlias(); !ok {
+			return nil, false
+		}
+		if derived.Alias == "" {
+			parser.addError("subquery in FROM must have an alias")
+			return nil, false
+		}
+		return derived, true
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil, false
+	}
+	table := &TableName{Name: parser.curToken.Literal}
+	for i := len(parser.ctes) - 1; i >= 0; i-- {
+		if strings.EqualFold(parser.ctes[i].Name, table.Name) {
+			table.CTE = parser.ctes[i]
+			break
+		}
+	}
+	var ok bool
+	if table.Alias, ok = parser.parseAlias(); !ok {
+		return nil, false
+	}
+	return table, true
+}
+
+// parseAlias parses an optional "[AS] alias" in peekToken.
+func (parser *Parser) parseAlias() (string, bool) {
+	if parser.peekToken.Type == AS {
+		parser.nextToken()
+		if !parser.expectPeek(IDENTIFIER) {
+			return "", false
+		}
+		return parser.curToken.Literal, true
+	}
+	if parser.peekToken.Type == IDENTIFIER {
+		parser.nextToken()
+		return parser.curToken.Literal, true
+	}
+	return "", true
+}
+
+// parseSelectList parses the comma separated select items in peekToken. A
+// "*" selects every column; other items may be given a name with AS.
+func (parser *Parser) parseSelectList() ([]SelectItem, bool) {
+	var items []SelectItem
+	for {
+		var item SelectItem
+		if parser.peekToken.Type == ASTERISK {
+			parser.nextToken()
+			item.Star = true
+		} else if parser.peekToken.Type == IDENTIFIER && parser.lex.peekIsQualifiedStar() {
+			parser.nextToken()
+			item.Table = parser.curToken.Literal
+			item.Star = true
+			parser.nextToken()
+			parser.nextToken()
+		} else {
+			expr, ok := parser.parseExpression()
+			if !ok {
+				return nil, false
+			}
+			item.Expr = expr
+			if parser.peekToken.Type == AS {
+				parser.nextToken()
+				if !parser.expectPeek(IDENTIFIER) {
+					return nil, false
+				}
+				item.Alias = parser.curToken.Literal
+			}
+		}
+		items = append(items, item)
+		if parser.peekToken.Type != COMMA {
+			return items, true
+		}
+		parser.nextToken()
+	}
+}
+
+// parseOrderBy parses ORDER BY item [ASC|DESC] [NULLS FIRST|LAST], ...
+// starting at the ORDER keyword in peekToken.
+func (parser *Parser) parseOrderBy() ([]OrderByItem, bool) {
+	parser.nextToken()
+	if !parser.expectPeek(BY) {
+		return nil, false
+	}
+	var items []OrderByItem
+	for {
+		var item OrderByItem
+		expr, ok := parser.parseExpression()
+		if !ok {
+			return nil, false
+		}
+		item.Expr = expr
+		switch parser.peekToken.Type {
+		case ASC:
+			parser.nextToken()
+		case DESC:
+			parser.nextToken()
+			item.Desc = true
+		}
+		if parser.peekToken.Type == NULLS {
+			parser.nextToken()
+			switch parser.peekToken.Type {
+			case FIRST:
+				item.Nulls = NullsFirst
+			case LAST:
+				item.Nulls = NullsLast
+			default:
+				parser.addError("expected FIRST or LAST after NULLS, got %s instead", parser.peekToken.Literal)
+				return nil, false
+			}
+			parser.nextToken()
+		}
+		items = append(items, item)
+		if parser.peekToken.Type != COMMA {
+			return items, true
+		}
+		parser.nextToken()
+	}
+}
+
+func (parser *Parser) parseCreateTableStatement() *Statement {
+	createStmt := &CreateTableStatement{}
+	if !parser.expectPeek(TABLE) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	createStmt.TableName = parser.curToken.Literal
+	if !parser.expectPeek(OPEN_PARENTHESIS) {
+		return nil
+	}
+	for {
+		switch parser.peekToken.Type {
+		case CONSTRAINT, PRIMARY, UNIQUE, CHECK, FOREIGN:
+			constraint, ok := parser.parseConstraint("")
+			if !ok {
+				return nil
+			}
+			createStmt.Constraints = append(createStmt.Constraints, constraint)
+		default:
+			if !parser.parseColumnDefinition(createStmt) {
+				return nil
+			}
+		}
+		if parser.peekToken.Type != COMMA {
+			break
+		}
+		parser.nextToken()
+	}
+	if !parser.expectPeek(CLOSE_PARENTHESIS) {
+		return nil
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementCreateTable, CreateStmt: createStmt}
+}
+
+// parseColumnDefinition parses a column of CREATE TABLE, starting at its
+// name in peekToken, followed by its type and constraints:
+//
+//	name type [NOT NULL | NULL | DEFAULT expr | GENERATED ... AS IDENTITY | AUTOINCREMENT |
+//	    [CONSTRAINT name] (PRIMARY KEY | UNIQUE | CHECK (expr) | REFERENCES ...)] ...
+func (parser *Parser) parseColumnDefinition(createStmt *CreateTableStatement) bool {
+	if !parser.expectPeek(IDENTIFIER) {
+		return false
+	}
+	column := ColumnDefinition{Name: parser.curToken.Literal}
+	if !parser.expectPeek(IDENTIFIER) {
+		return false
+	}
+	column.TypeName = parser.curToken.Literal
+	// Length modifiers such as VARCHAR(255) are accepted and ignored.
+	if parser.peekToken.Type == OPEN_PARENTHESIS {
+		parser.nextToken()
+		if !parser.expectPeek(NUMBER) {
+			return false
+		}
+		if !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return false
+		}
+	}
+	for {
+		switch parser.peekToken.Type {
+		case NOT:
+			parser.nextToken()
+			if !parser.expectPeek(NULL) {
+				return false
+			}
+			column.NotNull = true
+		case NULL:
+			parser.nextToken()
+		case DEFAULT:
+			if column.Default != nil {
+				parser.addError("multiple default values specified for column %s", column.Name)
+				return false
+			}
+			parser.nextToken()
+			// Comparisons and boolean operators would swallow a NOT NULL
+			// that follows, so a default is a simple expression.
+			value, ok := parser.parseConcat()
+			if !ok {
+				return false
+			}
+			column.Default = value
+		case CONSTRAINT, PRIMARY, UNIQUE, CHECK, REFERENCES:
+			constraint, ok := parser.parseConstraint(column.Name)
+			if !ok {
+				return false
+			}
+			createStmt.Constraints = append(createStmt.Constraints, constraint)
+		case IDENTIFIER:
+			switch {
+			case parser.peekKeyword("GENERATED"):
+				parser.nextToken()
+				if !parser.parseIdentity(&column) {
+					return false
+				}
+			case parser.peekKeyword("AUTOINCREMENT"):
+				parser.nextToken()
+				column.AutoIncrement = true
+			default:
+				createStmt.Columns = append(createStmt.Columns, column)
+				return true
+			}
+		default:
+			createStmt.Columns = append(createStmt.Columns, column)
+			return true
+		}
+	}
+}
+
+// parseConstraint parses a constraint starting at CONSTRAINT, PRIMARY,
+// UNIQUE, CHECK, FOREIGN or REFERENCES in peekToken. After a column, given by
+// column, PRIMARY KEY, UNIQUE and REFERENCES apply to it; otherwise they list
+// their columns:
+//
+//	[CONSTRAINT name] PRIMARY KEY [(column, ...)]
+//	[CONSTRAINT name] UNIQUE [(column, ...)]
+//	[CONSTRAINT name] CHECK (expr)
+//	[CONSTRAINT name] FOREIGN KEY (column, ...) REFERENCES ...
+//	[CONSTRAINT name] REFERENCES ...
+func (parser *Parser) parseConstraint(column string) (ConstraintDefinition, bool) {
+	constraint := ConstraintDefinition{Column: column != ""}
+	if parser.peekToken.Type == CONSTRAINT {
+		parser.nextToken()
+		if !parser.expectPeek(IDENTIFIER) {
+			return constraint, false
+		}
+		constraint.Name = parser.curToken.Literal
+	}
+	parser.nextToken()
+	switch parser.curToken.Type {
+	case PRIMARY, UNIQUE:
+		constraint.Type = ConstraintUnique
+		if parser.curToken.Type == PRIMARY {
+			constraint.Type = ConstraintPrimaryKey
+			if !parser.expectKeyword("KEY") {
+				return constraint, false
+			}
+		}
+		if column != "" {
+			constraint.Columns = []string{column}
+			return constraint, true
+		}
+		columns, ok := parser.parseIdentifierList()
+		if !ok {
+			return constraint, false
+		}
+		constraint.Columns = columns
+	case CHECK:
+		constraint.Type = ConstraintCheck
+		if !parser.expectPeek(OPEN_PARENTHESIS) {
+			return constraint, false
+		}
+		check, ok := parser.parseExpression()
+		if !ok || !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return constraint, false
+		}
+		constraint.Check = check
+		if column != "" {
+			constraint.Columns = []string{column}
+		}
+	case FOREIGN, REFERENCES:
+		constraint.Type = ConstraintForeignKey
+		if parser.curToken.Type == FOREIGN {
+			if column != "" {
+				parser.addError("syntax error at or near FOREIGN")
+				return constraint, false
+			}
+			if !parser.expectKeyword("KEY") {
+				return constraint, false
+			}
+			columns, ok := parser.parseIdentifierList()
+			if !ok || !parser.expectPeek(REFERENCES) {
+				return constraint, false
+			}
+			constraint.Columns = columns
+		} else if column != "" {
+			constraint.Columns = []string{column}
+		} else {
+			parser.addError("expected PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY, got REFERENCES instead")
+			return constraint, false
+		}
+		if !parser.parseReferences(&constraint) {
+			return constraint, false
+		}
+	default:
+		parser.addError("expected PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY, got %s instead", parser.curToken.Literal)
+		return constraint, false
+	}
+	return constraint, true
+}
+
+// parseReferences parses what follows REFERENCES in a foreign key:
+//
+//	table [(column, ...)] [ON DELETE action] [ON UPDATE action]
+//	[[NOT] DEFERRABLE] [INITIALLY DEFERRED | INITIALLY IMMEDIATE]
+//
+// where action is NO ACTION, RESTRICT, CASCADE or SET NULL.
+func (parser *Parser) parseReferences(constraint *ConstraintDefinition) bool {
+	if !parser.expectPeek(IDENTIFIER) {
+		return false
+	}
+	constraint.RefTable = parser.curToken.Literal
+	if parser.peekToken.Type == OPEN_PARENTHESIS {
+		columns, ok := parser.parseIdentifierList()
+		if !ok {
+			return false
+		}
+		constraint.RefColumns = columns
+	}
+	onDelete, onUpdate, deferrable := false, false, false
+	for {
+		switch {
+		case parser.peekToken.Type == ON:
+			parser.nextToken()
+			parser.nextToken()
+			var seen *bool
+			var action *ReferentialAction
+			switch parser.curToken.Type {
+			case DELETE:
+				seen, action = &onDelete, &constraint.OnDelete
+			case UPDATE:
+				seen, action = &onUpdate, &constraint.OnUpdate
+			default:
+				parser.addError("expected DELETE or UPDATE after ON, got %s instead", parser.curToken.Literal)
+				return false
+			}
+			if *seen {
+				parser.addError("multiple ON %s clauses not allowed", strings.ToUpper(parser.curToken.Literal))
+				return false
+			}
+			*seen = true
+			switch {
+			case parser.peekKeyword("NO"):
+				parser.nextToken()
+				if !parser.expectKeyword("ACTION") {
+					return false
+				}
+				*action = ActionNoAction
+			case parser.peekKeyword("RESTRICT"):
+				parser.nextToken()
+				*action = ActionRestrict
+			case parser.peekKeyword("CASCADE"):
+				parser.nextToken()
+				*action = ActionCascade
+			case parser.peekToken.Type == SET:
+				parser.nextToken()
+				if !parser.expectPeek(NULL) {
+					return false
+				}
+				*action = ActionSetNull
+			default:
+				parser.addError("expected NO ACTION, RESTRICT, CASCADE or SET NULL, got %s instead", parser.peekToken.Literal)
+				return false
+			}
+		case parser.peekKeyword("DEFERRABLE"),
+			// NOT NULL may follow REFERENCES after a column.
+			parser.peekToken.Type == NOT && strings.EqualFold(parser.peekSecond().Literal, "DEFERRABLE"):
+			if deferrable {
+				parser.addError("multiple DEFERRABLE/NOT DEFERRABLE clauses not allowed")
+				return false
+			}
+			deferrable = true
+			if parser.peekToken.Type == NOT {
+				parser.nextToken()
+				if !parser.expectKeyword("DEFERRABLE") {
+					return false
+				}
+				if constraint.InitiallyDeferred {
+					parser.addError("constraint declared INITIALLY DEFERRED must be DEFERRABLE")
+					return false
+				}
+				continue
+			}
+			parser.nextToken()
+			constraint.Deferrable = true
+		case parser.peekKeyword("INITIALLY"):
+			parser.nextToken()
+			switch {
+			case parser.peekKeyword("DEFERRED"):
+				constraint.InitiallyDeferred = true
+			case parser.peekKeyword("IMMEDIATE"):
+				constraint.InitiallyDeferred = false
+			default:
+				parser.addError("expected DEFERRED or IMMEDIATE after INITIALLY, got %s instead", parser.peekToken.Literal)
+				return false
+			}
+			parser.nextToken()
+			if constraint.InitiallyDeferred {
+				if deferrable && !constraint.Deferrable {
+					parser.addError("constraint declared INITIALLY DEFERRED must be DEFERRABLE")
+					return false
+				}
+				// INITIALLY DEFERRED implies DEFERRABLE.
+				constraint.Deferrable = true
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// parseSetConstraintsStatement parses
+//
+//	SET CONSTRAINTS ALL | name, ... DEFERRED | IMMEDIATE
+func (parser *Parser) parseSetConstraintsStatement() *Statement {
+	if !parser.expectKeyword("CONSTRAINTS") {
+		return nil
+	}
+	setStmt := &SetConstraintsStatement{}
+	if parser.peekToken.Type == ALL {
+		parser.nextToken()
+	} else {
+		for {
+			if !parser.expectPeek(IDENTIFIER) {
+				return nil
+			}
+			setStmt.Names = append(setStmt.Names, parser.curToken.Literal)
+			if parser.peekToken.Type != COMMA {
+				break
+			}
+			parser.nextToken()
+		}
+	}
+	switch {
+	case parser.peekKeyword("DEFERRED"):
+		setStmt.Deferred = true
+	case parser.peekKeyword("IMMEDIATE"):
+	default:
+		parser.addError("expected DEFERRED or IMMEDIATE, got %s instead", parser.peekToken.Literal)
+		return nil
+	}
+	parser.nextToken()
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementSetConstraints, SetConsStmt: setStmt}
+}
+
+// parseAlterTableStatement parses ALTER TABLE; see AlterTableStatement for
+// the forms it takes.
+func (parser *Parser) parseAlterTableStatement() *Statement {
+	if !parser.expectPeek(TABLE) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	alterStmt := &AlterTableStatement{TableName: parser.curToken.Literal}
+	// skipColumn advances past the optional COLUMN keyword.
+	skipColumn := func() {
+		if parser.peekKeyword("COLUMN") {
+			parser.nextToken()
+		}
+	}
+	switch {
+	case parser.peekKeyword("ADD"):
+		parser.nextToken()
+		skipColumn()
+		alterStmt.Action = AlterAddColumn
+		createStmt := &CreateTableStatement{TableName: alterStmt.TableName}
+		if !parser.parseColumnDefinition(createStmt) {
+			return nil
+		}
+		alterStmt.Column = createStmt.Columns[0]
+		alterStmt.Constraints = createStmt.Constraints
+	case parser.peekToken.Type == DROP:
+		parser.nextToken()
+		skipColumn()
+		if !parser.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		alterStmt.Action = AlterDropColumn
+		alterStmt.ColumnName = parser.curToken.Literal
+	case parser.peekKeyword("RENAME"):
+		parser.nextToken()
+		alterStmt.Action = AlterRenameTable
+		if !parser.peekKeyword("TO") {
+			skipColumn()
+			if !parser.expectPeek(IDENTIFIER) {
+				return nil
+			}
+			alterStmt.Action = AlterRenameColumn
+			alterStmt.ColumnName = parser.curToken.Literal
+		}
+		if !parser.expectKeyword("TO") || !parser.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		alterStmt.NewName = parser.curToken.Literal
+	case parser.peekToken.Type == ALTER:
+		parser.nextToken()
+		skipColumn()
+		if !parser.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		alterStmt.Action = AlterColumnType
+		alterStmt.ColumnName = parser.curToken.Literal
+		if parser.peekToken.Type == SET {
+			parser.nextToken()
+			if !parser.expectKeyword("DATA") {
+				return nil
+			}
+		}
+		if !parser.expectKeyword("TYPE") || !parser.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		alterStmt.TypeName = parser.curToken.Literal
+		if parser.peekToken.Type == OPEN_PARENTHESIS {
+			parser.nextToken()
+			if !parser.expectPeek(NUMBER) || !parser.expectPeek(CLOSE_PARENTHESIS) {
+				return nil
+			}
+		}
+		if parser.peekKeyword("USING") {
+			parser.nextToken()
+			using, ok := parser.parseExpression()
+			if !ok {
+				return nil
+			}
+			alterStmt.Using = using
+		}
+	default:
+		parser.addError("expected ADD, DROP, RENAME or ALTER, got %s instead", parser.peekToken.Literal)
+		return nil
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementAlterTable, AlterStmt: alterStmt}
+}
+
+func (parser *Parser) parseDropTableStatement() *Statement {
+	if !parser.expectPeek(TABLE) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	dropStmt := &DropTableStatement{TableName: parser.curToken.Literal}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementDropTable, DropStmt: dropStmt}
+}
+
+// parseCreateIndexStatement parses CREATE INDEX name ON table (column, ...).
+func (parser *Parser) parseCreateIndexStatement() *Statement {
+	if !parser.expectPeek(INDEX) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	createIdx := &CreateIndexStatement{IndexName: parser.curToken.Literal}
+	if !parser.expectPeek(ON) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	createIdx.TableName = parser.curToken.Literal
+	columns, ok := parser.parseIdentifierList()
+	if !ok {
+		return nil
+	}
+	createIdx.Columns = columns
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementCreateIndex, CreateIdxStmt: createIdx}
+}
+
+// parseDropIndexStatement parses DROP INDEX name.
+func (parser *Parser) parseDropIndexStatement() *Statement {
+	if !parser.expectPeek(INDEX) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	dropIdx := &DropIndexStatement{IndexName: parser.curToken.Literal}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementDropIndex, DropIdxStmt: dropIdx}
+}
+
+// parseIdentity parses ALWAYS | BY DEFAULT AS IDENTITY after GENERATED.
+func (parser *Parser) parseIdentity(column *ColumnDefinition) bool {
+	if column.Identity != "" {
+		parser.addError("multiple identity specifications for column %s", column.Name)
+		return false
+	}
+	switch {
+	case parser.peekKeyword("ALWAYS"):
+		parser.nextToken()
+		column.Identity = "ALWAYS"
+	case parser.peekToken.Type == BY:
+		parser.nextToken()
+		if !parser.expectPeek(DEFAULT) {
+			return false
+		}
+		column.Identity = "BY DEFAULT"
+	default:
+		parser.addError("expected ALWAYS or BY DEFAULT after GENERATED, got %s instead", parser.peekToken.Literal)
+		return false
+	}
+	return parser.expectPeek(AS) && parser.expectKeyword("IDENTITY")
+}
+
+// parseCreateSequenceStatement parses CREATE SEQUENCE; see
+// CreateSequenceStatement for its options, which may come in any order.
+func (parser *Parser) parseCreateSequenceStatement() *Statement {
+	if !parser.expectKeyword("SEQUENCE") || !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	createSeq := &CreateSequenceStatement{Name: parser.curToken.Literal}
+	seen := make(map[string]bool)
+	for {
+		var option string
+		var value **int64
+		switch {
+		case parser.peekKeyword("INCREMENT"):
+			parser.nextToken()
+			if parser.peekToken.Type == BY {
+				parser.nextToken()
+			}
+			option, value = "INCREMENT", &createSeq.Increment
+		case parser.peekKeyword("MINVALUE"):
+			parser.nextToken()
+			option, value = "MINVALUE", &createSeq.MinValue
+		case parser.peekKeyword("MAXVALUE"):
+			parser.nextToken()
+			option, value = "MAXVALUE", &createSeq.MaxValue
+		case parser.peekKeyword("START"):
+			parser.nextToken()
+			if parser.peekToken.Type == WITH {
+				parser.nextToken()
+			}
+			option, value = "START", &createSeq.Start
+		case parser.peekKeyword("CYCLE"):
+			parser.nextToken()
+			option = "CYCLE"
+			createSeq.Cycle = true
+		case parser.peekKeyword("NO"):
+			parser.nextToken()
+			switch {
+			case parser.peekKeyword("MINVALUE"), parser.peekKeyword("MAXVALUE"), parser.peekKeyword("CYCLE"):
+				option = strings.ToUpper(parser.peekToken.Literal)
+				parser.nextToken()
+			default:
+				parser.addError("expected MINVALUE, MAXVALUE or CYCLE after NO, got %s instead", parser.peekToken.Literal)
+				return nil
+			}
+		default:
+			return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementCreateSequence, CreateSeqStmt: createSeq}
+		}
+		if seen[option] {
+			parser.addError("conflicting or redundant options")
+			return nil
+		}
+		seen[option] = true
+		if value != nil {
+			n, ok := parser.parseSignedInteger()
+			if !ok {
+				return nil
+			}
+			*value = &n
+		}
+	}
+}
+
+// parseSignedInteger parses an integer with an optional minus sign.
+func (parser *Parser) parseSignedInteger() (int64, bool) {
+	sign := ""
+	if parser.peekToken.Type == MINUS {
+		parser.nextToken()
+		sign = "-"
+	}
+	if !parser.expectPeek(NUMBER) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(sign+parser.curToken.Literal, 10, 64)
+	if err != nil {
+		parser.addError("invalid integer %s%s", sign, parser.curToken.Literal)
+		return 0, false
+	}
+	return n, true
+}
+
+// parseDropSequenceStatement parses DROP SEQUENCE name.
+func (parser *Parser) parseDropSequenceStatement() *Statement {
+	if !parser.expectKeyword("SEQUENCE") || !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	dropSeq := &DropSequenceStatement{Name: parser.curToken.Literal}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementDropSequence, DropSeqStmt: dropSeq}
+}
+
+// parseAnalyzeStatement parses ANALYZE [table].
+func (parser *Parser) parseAnalyzeStatement() *Statement {
+	analyzeStmt := &AnalyzeStatement{}
+	if parser.peekToken.Type == IDENTIFIER {
+		parser.nextToken()
+		analyzeStmt.TableName = parser.curToken.Literal
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementAnalyze, AnalyzeStmt: analyzeStmt}
+}
+
+// parseVacuumStatement parses VACUUM [table] statement.
+func (parser *Parser) parseVacuumStatement() *Statement {
+	vacuumStmt := &VacuumStatement{}
+	if parser.peekToken.Type == IDENTIFIER {
+		parser.nextToken()
+		vacuumStmt.TableName = parser.curToken.Literal
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementVacuum, VacuumStmt: vacuumStmt}
+}
+
+// parseSetTransactionStatement parses SET TRANSACTION ISOLATION LEVEL level
+// statement.
+func (parser *Parser) parseSetTransactionStatement() *Statement {
+	parser.nextToken()
+	if !parser.expectKeyword("ISOLATION") || !parser.expectKeyword("LEVEL") {
+		return nil
+	}
+	setStmt := &SetTransactionStatement{}
+	switch {
+	case parser.peekKeyword("READ"):
+		parser.nextToken()
+		if !parser.expectKeyword("COMMITTED") {
+			return nil
+		}
+		setStmt.Isolation = IsolationReadCommitted
+	case parser.peekKeyword("REPEATABLE"):
+		parser.nextToken()
+		if !parser.expectKeyword("READ") {
+			return nil
+		}
+		setStmt.Isolation = IsolationRepeatableRead
+	case parser.peekKeyword("SERIALIZABLE"):
+		parser.nextToken()
+		setStmt.Isolation = IsolationSerializable
+	default:
+		parser.addError("expected READ COMMITTED, REPEATABLE READ or SERIALIZABLE, got %s instead", parser.peekToken.Literal)
+		return nil
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementSetTransaction, SetTxnStmt: setStmt}
+}
+
+// parseExplainStatement parses EXPLAIN [ANALYZE] [FORMAT TEXT|JSON] statement
+// and EXPLAIN (option, ...) statement, where an option is ANALYZE [TRUE|FALSE]
+// or FORMAT TEXT|JSON.
+func (parser *Parser) parseExplainStatement() *Statement {
+	explainStmt := &ExplainStatement{}
+	if parser.peekToken.Type == OPEN_PARENTHESIS {
+		parser.nextToken()
+		for {
+			parser.nextToken()
+			switch {
+			case parser.curToken.Type == ANALYZE:
+				explainStmt.Analyze = true
+				switch parser.peekToken.Type {
+				case TRUE:
+					parser.nextToken()
+				case FALSE:
+					parser.nextToken()
+					explainStmt.Analyze = false
+				}
+			case parser.curToken.Type == IDENTIFIER && strings.EqualFold(parser.curToken.Literal, "FORMAT"):
+				if !parser.parseExplainFormat(explainStmt) {
+					return nil
+				}
+			default:
+				parser.addError("unrecognized EXPLAIN option %s", parser.curToken.Literal)
+				return nil
+			}
+			if parser.peekToken.Type != COMMA {
+				break
+			}
+			parser.nextToken()
+		}
+		if !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return nil
+		}
+	} else {
+		if parser.peekToken.Type == ANALYZE {
+			parser.nextToken()
+			explainStmt.Analyze = true
+		}
+		if parser.peekToken.Type == IDENTIFIER && strings.EqualFold(parser.peekToken.Literal, "FORMAT") {
+			parser.nextToken()
+			if !parser.parseExplainFormat(explainStmt) {
+				return nil
+			}
+		}
+	}
+	parser.nextToken()
+	switch parser.curToken.Type {
+	case SELECT, WITH, OPEN_PARENTHESIS, INSERT, UPDATE, DELETE:
+	default:
+		parser.addError("cannot explain %s", parser.curToken.Literal)
+		return nil
+	}
+	inner := parser.parseStatementBody()
+	if inner == nil {
+		return nil
+	}
+	explainStmt.Statement = inner
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementExplain, ExplainStmt: explainStmt}
+}
+
+// parseExplainFormat parses the TEXT or JSON following FORMAT in curToken.
+func (parser *Parser) parseExplainFormat(explainStmt *ExplainStatement) bool {
+	if !parser.expectPeek(IDENTIFIER) {
+		return false
+	}
+	switch strings.ToUpper(parser.curToken.Literal) {
+	case "TEXT":
+		explainStmt.Format = ExplainText
+	case "JSON":
+		explainStmt.Format = ExplainJSON
+	default:
+		parser.addError("unrecognized EXPLAIN format %s", parser.curToken.Literal)
+		return false
+	}
+	return true
+}
+
+func (parser *Parser) parseUpdateStatement() *Statement {
+	updateStmt := &UpdateStatement{}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	updateStmt.TableName = parser.curToken.Literal
+	if !parser.expectPeek(SET) {
+		return nil
+	}
+	for {
+		if !parser.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		assignment := Assignment{Column: parser.curToken.Literal}
+		if !parser.expectPeek(EQUALS) {
+			return nil
+		}
+		value, ok := parser.parseExpression()
+		if !ok {
+			return nil
+		}
+		assignment.Value = value
+		updateStmt.Assignments = append(updateStmt.Assignments, assignment)
+		if parser.peekToken.Type != COMMA {
+			break
+		}
+		parser.nextToken()
+	}
+	where, ok := parser.parseWhereClause()
+	if !ok {
+		return nil
+	}
+	updateStmt.Where = where
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementUpdate, UpdateStmt: updateStmt}
+}
+
+func (parser *Parser) parseDeleteStatement() *Statement {
+	if !parser.expectPeek(FROM) {
+		return nil
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	deleteStmt := &DeleteStatement{TableName: parser.curToken.Literal}
+	where, ok := parser.parseWhereClause()
+	if !ok {
+		return nil
+	}
+	deleteStmt.Where = where
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementDelete, DeleteStmt: deleteStmt}
+}
+
+// parseTransactionStatement parses BEGIN, COMMIT and ROLLBACK, each optionally
+// followed by TRANSACTION, and ROLLBACK [TRANSACTION] TO [SAVEPOINT] name.
+func (parser *Parser) parseTransactionStatement(stmtType StatementTypeCode) *Statement {
+	if parser.peekToken.Type == TRANSACTION {
+		parser.nextToken()
+	}
+	if stmtType == StatementRollback && parser.peekKeyword("TO") {
+		parser.nextToken()
+		return parser.parseSavepointStatement(StatementRollbackTo)
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: stmtType}
+}
+
+// parseSavepointStatement parses the savepoint name of SAVEPOINT name, and
+// of ROLLBACK TO [SAVEPOINT] name and RELEASE [SAVEPOINT] name once past
+// their first words.
+func (parser *Parser) parseSavepointStatement(stmtType StatementTypeCode) *Statement {
+	if stmtType != StatementSavepoint && parser.peekKeyword("SAVEPOINT") {
+		parser.nextToken()
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	savepointStmt := &SavepointStatement{Name: parser.curToken.Literal}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: stmtType, SavepointStmt: savepointStmt}
+}
+
+// parsePrepareStatement parses PREPARE name AS statement. The parameters of
+// the inner statement are counted separately from the PREPARE itself.
+func (parser *Parser) parsePrepareStatement() *Statement {
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	prepareStmt := &PrepareSQLStatement{Name: parser.curToken.Literal}
+	if !parser.expectPeek(AS) {
+		return nil
+	}
+	parser.nextToken()
+	switch parser.curToken.Type {
+	case PREPARE, EXECUTE, DEALLOCATE, BEGIN, COMMIT, ROLLBACK:
+		parser.addError("cannot prepare %s", parser.curToken.Literal)
+		return nil
+	case IDENTIFIER:
+		switch strings.ToUpper(parser.curToken.Literal) {
+		case "SAVEPOINT", "RELEASE", "CHECKPOINT":
+			parser.addError("cannot prepare %s", parser.curToken.Literal)
+			return nil
+		}
+	}
+	inner := parser.parseStatementBody()
+	if inner == nil {
+		return nil
+	}
+	inner.NumParams = parser.paramCount
+	parser.paramCount = 0
+	parser.paramStyle = 0
+	prepareStmt.Statement = inner
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementPrepare, PrepareStmt: prepareStmt}
+}
+
+// parseExecuteStatement parses EXECUTE name [(value, ...)].
+func (parser *Parser) parseExecuteStatement() *Statement {
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	executeStmt := &ExecuteStatement{Name: parser.curToken.Literal}
+	if parser.peekToken.Type == OPEN_PARENTHESIS {
+		parser.nextToken()
+		for {
+			value, ok := parser.parseValue()
+			if !ok {
+				return nil
+			}
+			executeStmt.Params = append(executeStmt.Params, value)
+			if parser.peekToken.Type != COMMA {
+				break
+			}
+			parser.nextToken()
+		}
+		if !parser.expectPeek(CLOSE_PARENTHESIS) {
+			return nil
+		}
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementExecute, ExecuteStmt: executeStmt}
+}
+
+// parseDeclareStatement parses DECLARE name CURSOR FOR query.
+func (parser *Parser) parseDeclareStatement() *Statement {
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	declareStmt := &DeclareStatement{Name: parser.curToken.Literal}
+	if !parser.expectKeyword("CURSOR") || !parser.expectKeyword("FOR") {
+		return nil
+	}
+	if !parser.peekQuery() && parser.peekToken.Type != OPEN_PARENTHESIS {
+		parser.peekError(SELECT)
+		return nil
+	}
+	parser.nextToken()
+	query := parser.parseSelectStatement()
+	if query == nil {
+		return nil
+	}
+	declareStmt.Select = query.SelectStmt
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementDeclare, DeclareStmt: declareStmt}
+}
+
+// parseFetchStatement parses FETCH [NEXT | count | ALL | FORWARD [count |
+// ALL]] [FROM | IN] name, where count is a non-negative integer.
+func (parser *Parser) parseFetchStatement() *Statement {
+	fetchStmt := &FetchStatement{Count: 1}
+	if parser.peekKeyword("NEXT") {
+		parser.nextToken()
+	} else {
+		if parser.peekKeyword("FORWARD") {
+			parser.nextToken()
+		}
+		switch parser.peekToken.Type {
+		case ALL:
+			parser.nextToken()
+			fetchStmt.Count = -1
+		case NUMBER:
+			parser.nextToken()
+			count, err := strconv.ParseInt(parser.curToken.Literal, 10, 64)
+			if err != nil {
+				parser.addError("invalid FETCH count %s", parser.curToken.Literal)
+				return nil
+			}
+			fetchStmt.Count = count
+		}
+	}
+	if parser.peekToken.Type == FROM || parser.peekToken.Type == IN {
+		parser.nextToken()
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	fetchStmt.Name = parser.curToken.Literal
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementFetch, FetchStmt: fetchStmt}
+}
+
+// parseCloseStatement parses CLOSE {name | ALL}.
+func (parser *Parser) parseCloseStatement() *Statement {
+	closeStmt := &CloseStatement{}
+	if parser.peekToken.Type == ALL {
+		parser.nextToken()
+	} else {
+		if !parser.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		closeStmt.Name = parser.curToken.Literal
+	}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementClose, CloseStmt: closeStmt}
+}
+
+// parseDeallocateStatement parses DEALLOCATE [PREPARE] name.
+func (parser *Parser) parseDeallocateStatement() *Statement {
+	if parser.peekToken.Type == PREPARE {
+		parser.nextToken()
+	}
+	if !parser.expectPeek(IDENTIFIER) {
+		return nil
+	}
+	deallocStmt := &DeallocateStatement{Name: parser.curToken.Literal}
+	return &Statement{PrepareRes: PrepareSuccess, StatementType: StatementDeallocate, DeallocStmt: deallocStmt}
+}
+
+func PrintTokens(lexer *Lexer) {
+	for {
+		tok := lexer.nextToken()
+		logger.Debug("%+v\n", tok)
+		if tok.Type == EOF {
+			break
+		}
+	}
+}
+
+func (parser *Parser) ParseStatement() *Statement {
+	logger.Debug("Statement starts with: %s\n", parser.curToken.Type)
+	stmt := parser.parseStatementBody()
+	if stmt == nil {
+		return &Statement{PrepareRes: PrepareFail, StatementType: StatementUnknown}
+	}
+	// Only an optional semicolon may follow the statement.
+	if parser.peekToken.Type == SEMICOLON {
+		parser.nextToken()
+	}
+	if parser.peekToken.Type != EOF {
+		parser.addError("unexpected %s after end of statement", parser.peekToken.Literal)
+		return &Statement{PrepareRes: PrepareFail, StatementType: StatementUnknown}
+	}
+	stmt.NumParams = parser.paramCount
+	return stmt
+}
+
+// parseStatementBody parses the statement starting at curToken, returning nil
+// if it is malformed or unrecognized.
+func (parser *Parser) parseStatementBody() *Statement {
+	var stmt *Statement
+	switch parser.curToken.Type {
+	case INSERT:
+		stmt = parser.parseInsertStatement()
+	case SELECT, WITH, OPEN_PARENTHESIS:
+		stmt = parser.parseSelectStatement()
+	case CREATE:
+		if parser.peekToken.Type == INDEX {
+			stmt = parser.parseCreateIndexStatement()
+		} else if parser.peekKeyword("SEQUENCE") {
+			stmt = parser.parseCreateSequenceStatement()
+		} else {
+			stmt = parser.parseCreateTableStatement()
+		}
+	case DROP:
+		if parser.peekToken.Type == INDEX {
+			stmt = parser.parseDropIndexStatement()
+		} else if parser.peekKeyword("SEQUENCE") {
+			stmt = parser.parseDropSequenceStatement()
+		} else {
+			stmt = parser.parseDropTableStatement()
+		}
+	case UPDATE:
+		stmt = parser.parseUpdateStatement()
+	case DELETE:
+		stmt = parser.parseDeleteStatement()
+	case BEGIN:
+		stmt = parser.parseTransactionStatement(StatementBegin)
+	case COMMIT:
+		stmt = parser.parseTransactionStatement(StatementCommit)
+	case ROLLBACK:
+		stmt = parser.parseTransactionStatement(StatementRollback)
+	case PREPARE:
+		stmt = parser.parsePrepareStatement()
+	case EXECUTE:
+		stmt = parser.parseExecuteStatement()
+	case DEALLOCATE:
+		stmt = parser.parseDeallocateStatement()
+	case DECLARE:
+		stmt = parser.parseDeclareStatement()
+	case FETCH:
+		stmt = parser.parseFetchStatement()
+	case CLOSE:
+		stmt = parser.parseCloseStatement()
+	case ANALYZE:
+		stmt = parser.parseAnalyzeStatement()
+	case SET:
+		if parser.peekToken.Type == TRANSACTION {
+			stmt = parser.parseSetTransactionStatement()
+		} else {
+			stmt = parser.parseSetConstraintsStatement()
+		}
+	case ALTER:
+		stmt = parser.parseAlterTableStatement()
+	case EXPLAIN:
+		stmt = parser.parseExplainStatement()
+	case IDENTIFIER:
+		switch strings.ToUpper(parser.curToken.Literal) {
+		case "VACUUM":
+			stmt = parser.parseVacuumStatement()
+		case "SAVEPOINT":
+			stmt = parser.parseSavepointStatement(StatementSavepoint)
+		case "RELEASE":
+			stmt = parser.parseSavepointStatement(StatementRelease)
+		case "CHECKPOINT":
+			stmt = &Statement{PrepareRes: PrepareSuccess, StatementType: StatementCheckpoint}
+		}
 	}
+	return stmt
 }
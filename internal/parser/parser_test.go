@@ -0,0 +1,97 @@
+package parser
+
+import "testing"
+
+func TestParseExpr(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"2 * (3 + 4) / 5", "((2 * (3 + 4)) / 5)"},
+		{"a || b || 'c'", "((a || b) || 'c')"},
+		{"a OR b AND NOT c", "(a OR (b AND NOT c))"},
+		{"NOT a = b", "NOT (a = b)"},
+		{"a = 1 AND b <> 2 OR c >= 3", "(((a = 1) AND (b <> 2)) OR (c >= 3))"},
+		{"x BETWEEN 1 AND 2 AND y", "(((x >= 1) AND (x <= 2)) AND y)"},
+		{"x NOT IN (1, 2)", "x NOT IN (1, 2)"},
+		{"x IS NOT NULL", "x IS NOT NULL"},
+		{"x LIKE 'a%'", "x LIKE 'a%'"},
+		{"x::TEXT", "CAST(x AS TEXT)"},
+		{"CAST(x AS INTEGER)", "CAST(x AS INTEGER)"},
+		{"CASE WHEN a THEN 1 ELSE 2 END", "CASE WHEN a THEN 1 ELSE 2 END"},
+		{"COUNT(*)", "count(*)"},
+		{"COUNT(DISTINCT x)", "count(DISTINCT x)"},
+		{"EXTRACT(year FROM d)", "date_part('year', d)"},
+		{"t.x + $1", "(t.x + $1)"},
+		{"? + ?", "($1 + $2)"},
+	} {
+		expr, err := ParseExpr(test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if got := expr.String(); got != test.want {
+			t.Errorf("%s parsed as %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	for _, test := range []struct {
+		input     string
+		want      StatementTypeCode
+		numParams int
+	}{
+		{"SELECT a, COUNT(*) FROM t WHERE b > ? GROUP BY a HAVING COUNT(*) > 1 ORDER BY a DESC LIMIT 10", StatementSelect, 1},
+		{"SELECT * FROM t JOIN u ON t.id = u.t_id LEFT JOIN v ON v.id = t.id", StatementSelect, 0},
+		{"WITH RECURSIVE r (n) AS (SELECT a FROM t UNION ALL SELECT n + 1 FROM r WHERE n < 5) SELECT n FROM r", StatementSelect, 0},
+		{"SELECT a FROM t UNION SELECT a FROM u EXCEPT SELECT a FROM v", StatementSelect, 0},
+		{"SELECT ROW_NUMBER() OVER (PARTITION BY a ORDER BY b) FROM t", StatementSelect, 0},
+		{"SELECT a FROM t WHERE a IN (SELECT b FROM u) AND EXISTS (SELECT 1 FROM v WHERE v.c = t.a)", StatementSelect, 0},
+		{"INSERT INTO t (a, b) VALUES ($1, $2), (3, 'x')", StatementInsert, 2},
+		{"UPDATE t SET a = a + 1 WHERE b = ?", StatementUpdate, 1},
+		{"DELETE FROM t WHERE a IS NULL", StatementDelete, 0},
+		{"CREATE TABLE t (id SERIAL PRIMARY KEY, a TEXT NOT NULL UNIQUE, b INTEGER DEFAULT 0 CHECK (b >= 0), c INTEGER REFERENCES u (id) ON DELETE CASCADE)", StatementCreateTable, 0},
+		{"CREATE INDEX t_a ON t (a, b)", StatementCreateIndex, 0},
+		{"ALTER TABLE t ADD COLUMN d REAL", StatementAlterTable, 0},
+		{"CREATE SEQUENCE s START WITH 10 INCREMENT BY 5", StatementCreateSequence, 0},
+		{"EXPLAIN ANALYZE SELECT * FROM t", StatementExplain, 0},
+		{"DECLARE c CURSOR FOR SELECT * FROM t", StatementDeclare, 0},
+		{"FETCH 5 FROM c", StatementFetch, 0},
+		{"SAVEPOINT s", StatementSavepoint, 0},
+		{"ROLLBACK TO SAVEPOINT s", StatementRollbackTo, 0},
+		{"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ", StatementSetTransaction, 0},
+		{"VACUUM t", StatementVacuum, 0},
+		{"CHECKPOINT", StatementCheckpoint, 0},
+	} {
+		stmt, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if stmt.StatementType != test.want || stmt.NumParams != test.numParams {
+			t.Errorf("%s: got type %d with %d parameters, want type %d with %d", test.input, stmt.StatementType, stmt.NumParams, test.want, test.numParams)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"SELEC * FROM t",
+		"SELECT FROM t",
+		"SELECT a FROM t WHERE",
+		"SELECT (a FROM t",
+		"SELECT a FROM t ORDER a",
+		"INSERT INTO t VALUES (1)",
+		"INSERT INTO t (a) VALUES (1",
+		"UPDATE t a = 1",
+		"SELECT $1, ?",
+		"SELECT 'unterminated",
+	} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("%s: parsed, want an error", input)
+		}
+	}
+}
@@ -1,22 +1,59 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 )
 
 func PrepareStatement(input string) *Statement {
 	fmt.Println("Preparing statement", input)
+	statement, err := Parse(input)
+	if err != nil {
+		fmt.Println("Parser errors encountered:")
+		fmt.Println("\t", err)
+	} else {
+		fmt.Printf("Parsed Statement: %#v\n", statement)
+	}
+	return statement
+}
+
+// Parse lexes and parses a single SQL statement without printing anything.
+// The returned statement has PrepareFail set when err is not nil.
+func Parse(input string) (*Statement, error) {
+	return ParseWith(input, nil)
+}
+
+// ParseWith is like Parse but resolves calls of functions that aren't
+// built in against functions.
+func ParseWith(input string, functions FunctionResolver) (*Statement, error) {
 	lexer := NewLexer(input)
 	parser := NewParser(lexer)
+	parser.SetFunctionResolver(functions)
 	statement := parser.ParseStatement()
+	statement.Raw = input
 
 	if len(parser.Errors()) > 0 {
-		fmt.Println("Parser errors encountered:")
-		for _, err := range parser.Errors() {
-			fmt.Println("\t", err)
-		}
-	} else {
-		fmt.Printf("Parsed Statement: %#v\n", statement)
+		return statement, errors.New(strings.Join(parser.Errors(), "; "))
 	}
-	return statement
+	if statement.PrepareRes == PrepareFail {
+		return statement, fmt.Errorf("unrecognized keyword at start of %s", strings.TrimSpace(input))
+	}
+	return statement, nil
+}
+
+// ParseExpr parses a single expression, such as the text of a DEFAULT or
+// CHECK expression kept in the catalog.
+func ParseExpr(input string) (Expr, error) {
+	parser := &Parser{lex: NewLexer(input), errors: []string{}}
+	// The expression starts in peekToken, as it does after a keyword.
+	parser.nextToken()
+	expr, ok := parser.parseExpression()
+	if ok && parser.peekToken.Type != EOF {
+		parser.addError("unexpected %s after end of expression", parser.peekToken.Literal)
+	}
+	if len(parser.Errors()) > 0 {
+		return nil, errors.New(strings.Join(parser.Errors(), "; "))
+	}
+	return expr, nil
 }
@@ -1,5 +1,11 @@
 package parser
 
+import (
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/types"
+)
+
 type PrepareResultCode int64
 type StatementTypeCode int64
 
@@ -9,27 +15,742 @@ const (
 )
 
 const (
-	StatementUnknown StatementTypeCode = 0
-	StatementSelect  StatementTypeCode = 1
-	StatementInsert  StatementTypeCode = 2
+	StatementUnknown        StatementTypeCode = 0
+	StatementSelect         StatementTypeCode = 1
+	StatementInsert         StatementTypeCode = 2
+	StatementCreateTable    StatementTypeCode = 3
+	StatementDropTable      StatementTypeCode = 4
+	StatementUpdate         StatementTypeCode = 5
+	StatementDelete         StatementTypeCode = 6
+	StatementBegin          StatementTypeCode = 7
+	StatementCommit         StatementTypeCode = 8
+	StatementRollback       StatementTypeCode = 9
+	StatementPrepare        StatementTypeCode = 10
+	StatementExecute        StatementTypeCode = 11
+	StatementDeallocate     StatementTypeCode = 12
+	StatementCreateIndex    StatementTypeCode = 13
+	StatementDropIndex      StatementTypeCode = 14
+	StatementAnalyze        StatementTypeCode = 15
+	StatementExplain        StatementTypeCode = 16
+	StatementDeclare        StatementTypeCode = 17
+	StatementFetch          StatementTypeCode = 18
+	StatementClose          StatementTypeCode = 19
+	StatementSetConstraints StatementTypeCode = 20
+	StatementAlterTable     StatementTypeCode = 21
+	StatementCreateSequence StatementTypeCode = 22
+	StatementDropSequence   StatementTypeCode = 23
+	StatementSetTransaction StatementTypeCode = 24
+	StatementVacuum         StatementTypeCode = 25
+	StatementSavepoint      StatementTypeCode = 26
+	StatementRollbackTo     StatementTypeCode = 27
+	StatementRelease        StatementTypeCode = 28
+	StatementCheckpoint     StatementTypeCode = 29
 )
 
-type WhereClause struct {
-	Column   string
-	Operator string
-	Value    string
+type NullsOrder int64
+
+const (
+	NullsDefault NullsOrder = 0 // NULLS LAST for ascending, NULLS FIRST for descending order
+	NullsFirst   NullsOrder = 1
+	NullsLast    NullsOrder = 2
+)
+
+// OrderByItem is one sort key of an ORDER BY clause. An integer literal refers
+// to a position in the select list and a bare name may refer to an alias.
+type OrderByItem struct {
+	Expr  Expr
+	Desc  bool
+	Nulls NullsOrder
+}
+
+// SelectItem is one entry of a select list: either "*", optionally qualified
+// by Table, or an expression with an optional alias.
+type SelectItem struct {
+	Expr  Expr
+	Alias string
+	Star  bool
+	Table string
+}
+
+// TableRef is an entry of a FROM clause: a table, a subquery or a join of
+// two TableRefs.
+type TableRef interface {
+	tableRefNode()
+}
+
+// TableName is a table in a FROM clause. Alias, when set, replaces the table
+// name as the qualifier of its columns. CTE is set when the name refers to a
+// query of a WITH clause rather than to a table of the catalog.
+type TableName struct {
+	Name  string
+	Alias string
+	CTE   *CommonTableExpr
+}
+
+// RefName returns the name the table's columns are qualified with.
+func (t *TableName) RefName() string {
+	if t.Alias != "" {
+		return t.Alias
+	}
+	return t.Name
+}
+
+// DerivedTable is a subquery in a FROM clause. Its columns are qualified
+// with Alias, which is required, and named after its select list.
+type DerivedTable struct {
+	Select *SelectStatement
+	Alias  string
+}
+
+// RefName returns the name the subquery's columns are qualified with.
+func (t *DerivedTable) RefName() string {
+	return t.Alias
+}
+
+type JoinType int64
+
+const (
+	JoinInner JoinType = 0
+	JoinLeft  JoinType = 1
+	JoinRight JoinType = 2
+	JoinFull  JoinType = 3
+	JoinCross JoinType = 4 // CROSS JOIN and comma joins, without a condition
+	// Semi and anti joins are only planned, for IN and EXISTS subqueries:
+	// they return the left rows with a match, or with no match, on the right.
+	JoinSemi JoinType = 5
+	JoinAnti JoinType = 6
+)
+
+func (t JoinType) String() string {
+	switch t {
+	case JoinLeft:
+		return "LEFT"
+	case JoinRight:
+		return "RIGHT"
+	case JoinFull:
+		return "FULL"
+	case JoinCross:
+		return "CROSS"
+	case JoinSemi:
+		return "SEMI"
+	case JoinAnti:
+		return "ANTI"
+	}
+	return "INNER"
+}
+
+// Join combines the rows of two TableRefs. On is nil for cross joins.
+type Join struct {
+	Type  JoinType
+	Left  TableRef
+	Right TableRef
+	On    Expr
+}
+
+func (*TableName) tableRefNode()    {}
+func (*DerivedTable) tableRefNode() {}
+func (*Join) tableRefNode()         {}
+
+// Tables returns the tables of a FROM clause from left to right, leaving out
+// subqueries.
+func Tables(ref TableRef) []*TableName {
+	switch r := ref.(type) {
+	case *TableName:
+		return []*TableName{r}
+	case *Join:
+		return append(Tables(r.Left), Tables(r.Right)...)
+	}
+	return nil
+}
+
+// CommonTableExpr is a query named in a WITH clause. Columns, when set,
+// renames its columns. A recursive one adds to the rows of Select those
+// Recursive derives from the rows found so far, until it finds no new ones;
+// the rows are combined with UNION, or UNION ALL when UnionAll is set.
+type CommonTableExpr struct {
+	Name      string
+	Columns   []string
+	Select    *SelectStatement
+	Recursive *SelectStatement
+	UnionAll  bool
+}
+
+// References reports whether a statement reads the common table expression,
+// in its FROM clause or in any of its subqueries.
+func References(s *SelectStatement, cte *CommonTableExpr) bool {
+	for _, table := range selectTables(s, true) {
+		if table.CTE == cte {
+			return true
+		}
+	}
+	return false
 }
 
 type SelectStatement struct {
-	Fields    []string
-	TableName string
-	Where     *WhereClause
+	With     []*CommonTableExpr
+	Distinct bool
+	Fields   []SelectItem
+	From     TableRef
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	OrderBy  []OrderByItem
+	// Limit and Offset, when set, are expressions without columns giving
+	// the number of rows to return and to skip first. LIMIT ALL leaves
+	// Limit nil.
+	Limit  Expr
+	Offset Expr
+	// Set is set for a compound query, which combines the rows of two
+	// queries. Of the other fields, only With, OrderBy, Limit and Offset
+	// are used: they apply to the combined rows.
+	Set *SetOperation
+}
+
+// SetOperator is the way a compound query combines the rows of its queries.
+type SetOperator int
+
+const (
+	SetUnion SetOperator = iota
+	SetIntersect
+	SetExcept
+)
+
+func (op SetOperator) String() string {
+	switch op {
+	case SetIntersect:
+		return "INTERSECT"
+	case SetExcept:
+		return "EXCEPT"
+	}
+	return "UNION"
+}
+
+// SetOperation is the UNION, INTERSECT or EXCEPT of two queries. Without
+// All, duplicate rows are removed from the result.
+type SetOperation struct {
+	Op    SetOperator
+	All   bool
+	Left  *SelectStatement
+	Right *SelectStatement
+}
+
+// Exprs returns the expressions of the statement itself: those of its select
+// list, join conditions, WHERE, GROUP BY, HAVING and ORDER BY clauses, but
+// not those of the subqueries in its FROM clause. A compound query only has
+// those of its ORDER BY.
+func (s *SelectStatement) Exprs() []Expr {
+	var exprs []Expr
+	for _, field := range s.Fields {
+		if field.Expr != nil {
+			exprs = append(exprs, field.Expr)
+		}
+	}
+	var joins func(ref TableRef)
+	joins = func(ref TableRef) {
+		if join, ok := ref.(*Join); ok {
+			joins(join.Left)
+			joins(join.Right)
+			if join.On != nil {
+				exprs = append(exprs, join.On)
+			}
+		}
+	}
+	joins(s.From)
+	if s.Where != nil {
+		exprs = append(exprs, s.Where)
+	}
+	exprs = append(exprs, s.GroupBy...)
+	if s.Having != nil {
+		exprs = append(exprs, s.Having)
+	}
+	for _, item := range s.OrderBy {
+		exprs = append(exprs, item.Expr)
+	}
+	return exprs
+}
+
+// WalkSelect calls WalkExpr with fn for every expression of the statement and
+// of the subqueries nested in it, in expressions, in the FROM clause, in its
+// WITH clause or combined by it.
+func WalkSelect(s *SelectStatement, fn func(Expr) bool) {
+	var walk func(Expr) bool
+	walk = func(expr Expr) bool {
+		if !fn(expr) {
+			return false
+		}
+		if sub := Subquery(expr); sub != nil {
+			WalkSelect(sub, fn)
+		}
+		return true
+	}
+	var derived func(ref TableRef)
+	derived = func(ref TableRef) {
+		switch r := ref.(type) {
+		case *Join:
+			derived(r.Left)
+			derived(r.Right)
+		case *DerivedTable:
+			WalkSelect(r.Select, fn)
+		}
+	}
+	for _, cte := range s.With {
+		WalkSelect(cte.Select, fn)
+		if cte.Recursive != nil {
+			WalkSelect(cte.Recursive, fn)
+		}
+	}
+	if s.Set != nil {
+		WalkSelect(s.Set.Left, fn)
+		WalkSelect(s.Set.Right, fn)
+	}
+	derived(s.From)
+	for _, expr := range s.Exprs() {
+		WalkExpr(expr, walk)
+	}
+}
+
+// SelectTables returns the tables the statement reads, including those read
+// by its subqueries and the queries of its WITH clause.
+func SelectTables(s *SelectStatement) []*TableName {
+	return selectTables(s, false)
+}
+
+// selectTables returns the tables a statement reads and, with ctes set, the
+// references to queries of WITH clauses too.
+func selectTables(s *SelectStatement, ctes bool) []*TableName {
+	var tables []*TableName
+	var query func(s *SelectStatement)
+	var from func(ref TableRef)
+	from = func(ref TableRef) {
+		switch r := ref.(type) {
+		case *TableName:
+			if r.CTE == nil || ctes {
+				tables = append(tables, r)
+			}
+		case *Join:
+			from(r.Left)
+			from(r.Right)
+		case *DerivedTable:
+			query(r.Select)
+		}
+	}
+	query = func(s *SelectStatement) {
+		for _, cte := range s.With {
+			query(cte.Select)
+			if cte.Recursive != nil {
+				query(cte.Recursive)
+			}
+		}
+		if s.Set != nil {
+			query(s.Set.Left)
+			query(s.Set.Right)
+		}
+		from(s.From)
+		for _, expr := range s.Exprs() {
+			WalkExpr(expr, func(e Expr) bool {
+				if sub := Subquery(e); sub != nil {
+					query(sub)
+				}
+				return true
+			})
+		}
+	}
+	query(s)
+	return tables
+}
+
+// ExprTables returns the tables read by the subqueries of an expression.
+func ExprTables(expr Expr) []*TableName {
+	var tables []*TableName
+	WalkExpr(expr, func(e Expr) bool {
+		if sub := Subquery(e); sub != nil {
+			tables = append(tables, SelectTables(sub)...)
+		}
+		return true
+	})
+	return tables
+}
+
+// String renders the statement as SQL, for subqueries in the text of
+// expressions.
+func (s *SelectStatement) String() string {
+	var sb strings.Builder
+	for i, cte := range s.With {
+		if i == 0 {
+			sb.WriteString("WITH ")
+			for _, cte := range s.With {
+				if cte.Recursive != nil {
+					sb.WriteString("RECURSIVE ")
+					break
+				}
+			}
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(strings.ToLower(cte.Name))
+		if len(cte.Columns) > 0 {
+			sb.WriteString("(" + strings.ToLower(strings.Join(cte.Columns, ", ")) + ")")
+		}
+		sb.WriteString(" AS (" + cte.Select.String())
+		if cte.Recursive != nil {
+			sb.WriteString(" UNION ")
+			if cte.UnionAll {
+				sb.WriteString("ALL ")
+			}
+			sb.WriteString(cte.Recursive.String())
+		}
+		sb.WriteString(") ")
+	}
+	if s.Set != nil {
+		sb.WriteString(setOperandString(s.Set.Left) + " " + s.Set.Op.String() + " ")
+		if s.Set.All {
+			sb.WriteString("ALL ")
+		}
+		sb.WriteString(setOperandString(s.Set.Right))
+		sb.WriteString(s.tailString())
+		return sb.String()
+	}
+	sb.WriteString("SELECT ")
+	if s.Distinct {
+		sb.WriteString("DISTINCT ")
+	}
+	for i, field := range s.Fields {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		switch {
+		case field.Star && field.Table != "":
+			sb.WriteString(strings.ToLower(field.Table) + ".*")
+		case field.Star:
+			sb.WriteString("*")
+		default:
+			sb.WriteString(field.Expr.String())
+			if field.Alias != "" {
+				sb.WriteString(" AS " + strings.ToLower(field.Alias))
+			}
+		}
+	}
+	sb.WriteString(" FROM " + tableRefString(s.From))
+	if s.Where != nil {
+		sb.WriteString(" WHERE " + s.Where.String())
+	}
+	for i, expr := range s.GroupBy {
+		if i == 0 {
+			sb.WriteString(" GROUP BY ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(expr.String())
+	}
+	if s.Having != nil {
+		sb.WriteString(" HAVING " + s.Having.String())
+	}
+	sb.WriteString(s.tailString())
+	return sb.String()
+}
+
+// tailString renders the ORDER BY, LIMIT and OFFSET clauses of a query.
+func (s *SelectStatement) tailString() string {
+	var sb strings.Builder
+	if len(s.OrderBy) > 0 {
+		sb.WriteString(" ORDER BY " + orderByString(s.OrderBy))
+	}
+	if s.Limit != nil {
+		sb.WriteString(" LIMIT " + s.Limit.String())
+	}
+	if s.Offset != nil {
+		sb.WriteString(" OFFSET " + s.Offset.String())
+	}
+	return sb.String()
+}
+
+// setOperandString renders a query combined by a compound query, in
+// parentheses unless it is a plain SELECT.
+func setOperandString(s *SelectStatement) string {
+	if s.Set != nil || len(s.With) > 0 || len(s.OrderBy) > 0 || s.Limit != nil || s.Offset != nil {
+		return "(" + s.String() + ")"
+	}
+	return s.String()
+}
+
+// orderByString renders the items of an ORDER BY clause.
+func orderByString(items []OrderByItem) string {
+	var sb strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(item.Expr.String())
+		if item.Desc {
+			sb.WriteString(" DESC")
+		}
+		switch item.Nulls {
+		case NullsFirst:
+			sb.WriteString(" NULLS FIRST")
+		case NullsLast:
+			sb.WriteString(" NULLS LAST")
+		}
+	}
+	return sb.String()
+}
+
+func tableRefString(ref TableRef) string {
+	switch r := ref.(type) {
+	case *TableName:
+		if r.Alias != "" {
+			return strings.ToLower(r.Name + " " + r.Alias)
+		}
+		return strings.ToLower(r.Name)
+	case *DerivedTable:
+		return "(" + r.Select.String() + ") " + strings.ToLower(r.Alias)
+	case *Join:
+		s := tableRefString(r.Left) + " " + r.Type.String() + " JOIN " + tableRefString(r.Right)
+		if r.On != nil {
+			s += " ON " + r.On.String()
+		}
+		return s
+	}
+	return ""
 }
 
 type InsertStatement struct {
 	TableName string
 	Columns   []string
-	Values    []string
+	Values    [][]Expr // One entry per inserted row
+}
+
+// ColumnDefinition is a column of CREATE TABLE. Default is nil when the
+// column defaults to NULL. Identity is "ALWAYS" or "BY DEFAULT" for a column
+// GENERATED ... AS IDENTITY, and AutoIncrement is set for INTEGER PRIMARY KEY
+// AUTOINCREMENT.
+type ColumnDefinition struct {
+	Name          string
+	TypeName      string
+	NotNull       bool
+	Default       Expr
+	Identity      string
+	AutoIncrement bool
+}
+
+// ConstraintType is the kind of a constraint of CREATE TABLE.
+type ConstraintType int
+
+const (
+	ConstraintPrimaryKey ConstraintType = iota
+	ConstraintUnique
+	ConstraintCheck
+	ConstraintForeignKey
+)
+
+// ReferentialAction is what ON DELETE or ON UPDATE of a foreign key does to
+// the rows referencing a key that goes away.
+type ReferentialAction int
+
+const (
+	ActionNoAction ReferentialAction = iota
+	ActionRestrict
+	ActionCascade
+	ActionSetNull
+)
+
+// ConstraintDefinition is a constraint of CREATE TABLE, written after a
+// column or as an element of the table. Name is empty when it isn't named
+// with CONSTRAINT. Columns lists the key of PRIMARY KEY and UNIQUE, and
+// Check the condition of CHECK. A FOREIGN KEY, or REFERENCES after a column,
+// refers to RefColumns of RefTable, which are left empty for its primary key.
+type ConstraintDefinition struct {
+	Name    string
+	Type    ConstraintType
+	Columns []string
+	Check   Expr
+	Column  bool // written after a column, which is then Columns[0]
+
+	RefTable          string
+	RefColumns        []string
+	OnDelete          ReferentialAction
+	OnUpdate          ReferentialAction
+	Deferrable        bool
+	InitiallyDeferred bool
+}
+
+// CreateTableStatement is CREATE TABLE name (column, ..., constraint, ...).
+// The constraints written after columns are listed in Constraints along
+// with those of the table, in the order they appear.
+type CreateTableStatement struct {
+	TableName   string
+	Columns     []ColumnDefinition
+	Constraints []ConstraintDefinition
+}
+
+// AlterAction is the change an ALTER TABLE statement makes.
+type AlterAction int
+
+const (
+	AlterAddColumn AlterAction = iota
+	AlterDropColumn
+	AlterRenameColumn
+	AlterRenameTable
+	AlterColumnType
+)
+
+// AlterTableStatement is one of
+//
+//	ALTER TABLE name ADD [COLUMN] column_definition
+//	ALTER TABLE name DROP [COLUMN] column
+//	ALTER TABLE name RENAME [COLUMN] column TO new_name
+//	ALTER TABLE name RENAME TO new_name
+//	ALTER TABLE name ALTER [COLUMN] column [SET DATA] TYPE type [USING expr]
+//
+// Column is the column added, whose constraints are in Constraints, and
+// ColumnName the column dropped, renamed or changed.
+type AlterTableStatement struct {
+	TableName   string
+	Action      AlterAction
+	Column      ColumnDefinition
+	Constraints []ConstraintDefinition
+	ColumnName  string
+	NewName     string
+	TypeName    string
+	Using       Expr
+}
+
+type DropTableStatement struct {
+	TableName string
+}
+
+// CreateIndexStatement is CREATE INDEX name ON table (column, ...).
+type CreateIndexStatement struct {
+	IndexName string
+	TableName string
+	Columns   []string
+}
+
+// SetConstraintsStatement is SET CONSTRAINTS ALL | name, ... DEFERRED |
+// IMMEDIATE. Names is nil for ALL.
+type SetConstraintsStatement struct {
+	Names    []string
+	Deferred bool
+}
+
+// DropIndexStatement is DROP INDEX name.
+type DropIndexStatement struct {
+	IndexName string
+}
+
+// CreateSequenceStatement is
+//
+//	CREATE SEQUENCE name [INCREMENT [BY] n] [MINVALUE n | NO MINVALUE]
+//	    [MAXVALUE n | NO MAXVALUE] [START [WITH] n] [[NO] CYCLE]
+//
+// Options left out are nil.
+type CreateSequenceStatement struct {
+	Name      string
+	Increment *int64
+	MinValue  *int64
+	MaxValue  *int64
+	Start     *int64
+	Cycle     bool
+}
+
+// DropSequenceStatement is DROP SEQUENCE name.
+type DropSequenceStatement struct {
+	Name string
+}
+
+// AnalyzeStatement is ANALYZE [table]. An empty TableName analyzes every table.
+type AnalyzeStatement struct {
+	TableName string
+}
+
+// VacuumStatement is VACUUM [table]. An empty TableName vacuums every table.
+type VacuumStatement struct {
+	TableName string
+}
+
+type IsolationLevel int64
+
+const (
+	IsolationReadCommitted  IsolationLevel = 0
+	IsolationRepeatableRead IsolationLevel = 1
+	IsolationSerializable   IsolationLevel = 2
+)
+
+// SetTransactionStatement is SET TRANSACTION ISOLATION LEVEL READ COMMITTED |
+// REPEATABLE READ | SERIALIZABLE.
+type SetTransactionStatement struct {
+	Isolation IsolationLevel
+}
+
+// SavepointStatement is SAVEPOINT name, ROLLBACK [TRANSACTION] TO
+// [SAVEPOINT] name or RELEASE [SAVEPOINT] name.
+type SavepointStatement struct {
+	Name string
+}
+
+type ExplainFormat int64
+
+const (
+	ExplainText ExplainFormat = 0
+	ExplainJSON ExplainFormat = 1
+)
+
+// ExplainStatement is EXPLAIN [ANALYZE] statement. With Analyze set the
+// statement is run and the plan reports what each operator actually did.
+type ExplainStatement struct {
+	Analyze   bool
+	Format    ExplainFormat
+	Statement *Statement
+}
+
+type Assignment struct {
+	Column string
+	Value  Expr
+}
+
+type UpdateStatement struct {
+	TableName   string
+	Assignments []Assignment
+	Where       Expr
+}
+
+type DeleteStatement struct {
+	TableName string
+	Where     Expr
+}
+
+// PrepareSQLStatement is PREPARE name AS statement.
+type PrepareSQLStatement struct {
+	Name      string
+	Statement *Statement
+}
+
+// ExecuteStatement is EXECUTE name [(value, ...)].
+type ExecuteStatement struct {
+	Name   string
+	Params []types.Value
+}
+
+// DeallocateStatement is DEALLOCATE [PREPARE] name.
+type DeallocateStatement struct {
+	Name string
+}
+
+// DeclareStatement is DECLARE name CURSOR FOR query.
+type DeclareStatement struct {
+	Name   string
+	Select *SelectStatement
+}
+
+// FetchStatement is FETCH [NEXT | count | ALL | FORWARD [count | ALL]]
+// [FROM | IN] name. Count is -1 for ALL.
+type FetchStatement struct {
+	Name  string
+	Count int64
+}
+
+// CloseStatement is CLOSE name or CLOSE ALL, which leaves Name empty.
+type CloseStatement struct {
+	Name string
 }
 
 type Statement struct {
@@ -38,4 +759,26 @@ type Statement struct {
 	Raw           string
 	InsertStmt    *InsertStatement
 	SelectStmt    *SelectStatement
+	CreateStmt    *CreateTableStatement
+	DropStmt      *DropTableStatement
+	CreateIdxStmt *CreateIndexStatement
+	DropIdxStmt   *DropIndexStatement
+	UpdateStmt    *UpdateStatement
+	DeleteStmt    *DeleteStatement
+	PrepareStmt   *PrepareSQLStatement
+	ExecuteStmt   *ExecuteStatement
+	DeallocStmt   *DeallocateStatement
+	AnalyzeStmt   *AnalyzeStatement
+	ExplainStmt   *ExplainStatement
+	DeclareStmt   *DeclareStatement
+	FetchStmt     *FetchStatement
+	CloseStmt     *CloseStatement
+	SetConsStmt   *SetConstraintsStatement
+	AlterStmt     *AlterTableStatement
+	CreateSeqStmt *CreateSequenceStatement
+	DropSeqStmt   *DropSequenceStatement
+	SetTxnStmt    *SetTransactionStatement
+	VacuumStmt    *VacuumStatement
+	SavepointStmt *SavepointStatement
+	NumParams     int // Number of bind parameters the statement expects
 }
@@ -15,11 +15,97 @@ const (
 	CLOSE_PARENTHESIS = "CLOSE_PARENTHESIS"
 	SINGLE_QUOTE      = "SINGLE_QUOTE"
 	STRING            = "STRING" // string values
-	NUMBER            = "NUMBER" // integer values
+	NUMBER            = "NUMBER" // integer and decimal values
 	SELECT            = "SELECT"
 	FROM              = "FROM"
 	WHERE             = "WHERE"
 	EQUALS            = "EQUALS"
+	NOT_EQUALS        = "NOT_EQUALS"
+	LESS_THAN         = "LESS_THAN"
+	LESS_EQUALS       = "LESS_EQUALS"
+	GREATER_THAN      = "GREATER_THAN"
+	GREATER_EQUALS    = "GREATER_EQUALS"
+	ASTERISK          = "ASTERISK"
+	MINUS             = "MINUS"
+	PLUS              = "PLUS"
+	SLASH             = "SLASH"
+	PERCENT           = "PERCENT"
+	CONCAT            = "CONCAT"       // ||
+	DOUBLE_COLON      = "DOUBLE_COLON" // ::
+	SEMICOLON         = "SEMICOLON"
+	CREATE            = "CREATE"
+	TABLE             = "TABLE"
+	DROP              = "DROP"
+	UPDATE            = "UPDATE"
+	SET               = "SET"
+	DELETE            = "DELETE"
+	NOT               = "NOT"
+	NULL              = "NULL"
+	TRUE              = "TRUE"
+	FALSE             = "FALSE"
+	BEGIN             = "BEGIN"
+	COMMIT            = "COMMIT"
+	ROLLBACK          = "ROLLBACK"
+	TRANSACTION       = "TRANSACTION"
+	PARAMETER         = "PARAMETER" // bind parameters written as ? or $n
+	PREPARE           = "PREPARE"
+	EXECUTE           = "EXECUTE"
+	DEALLOCATE        = "DEALLOCATE"
+	AS                = "AS"
+	ORDER             = "ORDER"
+	BY                = "BY"
+	ASC               = "ASC"
+	DESC              = "DESC"
+	NULLS             = "NULLS"
+	FIRST             = "FIRST"
+	LAST              = "LAST"
+	AND               = "AND"
+	OR                = "OR"
+	IS                = "IS"
+	GROUP             = "GROUP"
+	HAVING            = "HAVING"
+	DISTINCT          = "DISTINCT"
+	DOT               = "DOT"
+	INDEX             = "INDEX"
+	ON                = "ON"
+	JOIN              = "JOIN"
+	INNER             = "INNER"
+	LEFT              = "LEFT"
+	RIGHT             = "RIGHT"
+	FULL              = "FULL"
+	OUTER             = "OUTER"
+	CROSS             = "CROSS"
+	ANALYZE           = "ANALYZE"
+	EXPLAIN           = "EXPLAIN"
+	BETWEEN           = "BETWEEN"
+	IN                = "IN"
+	LIKE              = "LIKE"
+	ILIKE             = "ILIKE"
+	CASE              = "CASE"
+	WHEN              = "WHEN"
+	THEN              = "THEN"
+	ELSE              = "ELSE"
+	END               = "END"
+	CAST              = "CAST"
+	EXISTS            = "EXISTS"
+	WITH              = "WITH"
+	UNION             = "UNION"
+	ALL               = "ALL"
+	INTERSECT         = "INTERSECT"
+	EXCEPT            = "EXCEPT"
+	LIMIT             = "LIMIT"
+	OFFSET            = "OFFSET"
+	FETCH             = "FETCH"
+	DECLARE           = "DECLARE"
+	CLOSE             = "CLOSE"
+	PRIMARY           = "PRIMARY"
+	UNIQUE            = "UNIQUE"
+	CHECK             = "CHECK"
+	DEFAULT           = "DEFAULT"
+	CONSTRAINT        = "CONSTRAINT"
+	REFERENCES        = "REFERENCES"
+	FOREIGN           = "FOREIGN"
+	ALTER             = "ALTER"
 )
 
 type Token struct {
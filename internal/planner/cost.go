@@ -0,0 +1,184 @@
+package planner
+
+import (
+	"math"
+
+	"github.com/roackb2/simple_db/internal/storage"
+)
+
+// Cost units, relative to reading one page sequentially. They follow
+// PostgreSQL's defaults.
+const (
+	seqPageCost     = 1.0
+	randomPageCost  = 4.0
+	cpuTupleCost    = 0.01
+	cpuOperatorCost = 0.0025
+)
+
+// columnWidth is the assumed average encoded size of a value, in bytes.
+const columnWidth = 16
+
+// indexFanout is the assumed number of entries per B+ tree node.
+const indexFanout = 100
+
+// clampRows rounds a row estimate to a whole number of at least one row, so
+// that misestimated empty inputs don't make every plan above them free.
+func clampRows(rows float64) float64 {
+	if rows < 1 {
+		return 1
+	}
+	return math.Ceil(rows)
+}
+
+// rowWidth estimates the size of the rows of a plan in bytes.
+func rowWidth(plan Plan) float64 {
+	width := 8.0
+	for _, rel := range plan.Relations() {
+		width += float64(len(rel.Table.Columns) * columnWidth)
+	}
+	return width
+}
+
+// spillPages is the number of pages rows occupy when written to disk.
+func spillPages(rows, width float64) float64 {
+	return math.Ceil(rows * width / storage.PageSize)
+}
+
+func seqScanCost(rows, pages float64, conds int) float64 {
+	return pages*seqPageCost + rows*(cpuTupleCost+float64(conds)*cpuOperatorCost)
+}
+
+// indexHeight estimates the number of levels of an index over rows entries.
+func indexHeight(rows float64) float64 {
+	if rows <= indexFanout {
+		return 1
+	}
+	return math.Ceil(math.Log(rows) / math.Log(indexFanout))
+}
+
+// indexScanCost is the cost of descending an index, reading the leaves
+// holding matched entries and fetching each matched row from the heap.
+// Rows on the same page are assumed to be fetched once.
+func indexScanCost(rows, pages, matched float64, conds int) float64 {
+	fetches := math.Min(matched, pages)
+	leaves := math.Ceil(matched / indexFanout)
+	return indexHeight(rows)*randomPageCost + leaves*seqPageCost + fetches*randomPageCost +
+		matched*(cpuTupleCost+float64(conds)*cpuOperatorCost)
+}
+
+// sortCost is the cost of sorting rows within workMem bytes, writing and
+// reading them back once when they don't fit.
+func sortCost(rows, width float64, workMem int) float64 {
+	cost := 2 * cpuOperatorCost * rows * math.Log2(math.Max(rows, 2))
+	if rows*width > float64(workMem) {
+		cost += 2 * spillPages(rows, width) * seqPageCost
+	}
+	return cost
+}
+
+func nestedLoopCost(left, right Plan, out float64, conds int, workMem int) float64 {
+	l, r := left.Estimated(), right.Estimated()
+	// The inner input is read again for every block of outer rows.
+	blocks := math.Max(1, math.Ceil(l.Rows*rowWidth(left)/float64(workMem)))
+	return l.Cost + blocks*r.Cost + l.Rows*r.Rows*float64(conds)*cpuOperatorCost + out*cpuTupleCost
+}
+
+func hashJoinCost(left, right Plan, out float64, workMem int) float64 {
+	l, r := left.Estimated(), right.Estimated()
+	cost := l.Cost + r.Cost + r.Rows*(cpuTupleCost+cpuOperatorCost) + l.Rows*cpuOperatorCost + out*cpuTupleCost
+	if r.Rows*rowWidth(right) > float64(workMem) {
+		// Both inputs are partitioned to disk and read back.
+		cost += 2 * (spillPages(l.Rows, rowWidth(left)) + spillPages(r.Rows, rowWidth(right))) * seqPageCost
+	}
+	return cost
+}
+
+func mergeJoinCost(left, right Plan, out float64, workMem int) float64 {
+	l, r := left.Estimated(), right.Estimated()
+	return l.Cost + r.Cost + sortCost(l.Rows, rowWidth(left), workMem) + sortCost(r.Rows, rowWidth(right), workMem) +
+		(l.Rows+r.Rows)*cpuOperatorCost + out*cpuTupleCost
+}
+
+// indexNestedLoopCost is the cost of probing an index over innerRows rows
+// once per outer row, each probe matching matches rows. The upper levels of
+// the index are assumed to stay in the buffer pool.
+func indexNestedLoopCost(left Plan, innerRows, matches, out float64) float64 {
+	l := left.Estimated()
+	probe := randomPageCost + indexHeight(innerRows)*cpuOperatorCost + matches*(randomPageCost+cpuTupleCost)
+	return l.Cost + l.Rows*probe + out*cpuTupleCost
+}
+
+// The operators the executor puts over the plan of a FROM clause are costed
+// with the functions below, so that EXPLAIN shows an estimate for each.
+
+// RowWidth estimates the size in bytes of rows of the given number of
+// columns.
+func RowWidth(columns int) float64 {
+	return 8 + float64(columns*columnWidth)
+}
+
+// Width estimates the size in bytes of the rows of a plan.
+func Width(plan Plan) float64 {
+	return rowWidth(plan)
+}
+
+// SortEstimate is the estimate of sorting the rows of input, width bytes
+// each, within workMem bytes. A sort keeping only its first bound rows,
+// unless bound is -1, never spills.
+func SortEstimate(input Estimate, width float64, workMem int, bound int) Estimate {
+	if bound >= 0 && float64(bound) < input.Rows {
+		cost := 2 * cpuOperatorCost * input.Rows * math.Log2(math.Max(float64(bound), 2))
+		return Estimate{Rows: input.Rows, Cost: input.Cost + cost}
+	}
+	return Estimate{Rows: input.Rows, Cost: input.Cost + sortCost(input.Rows, width, workMem)}
+}
+
+// AggregateEstimate is the estimate of grouping the rows of input on keys
+// expressions, one group when there are none, and computing calls
+// aggregates over each group. Without statistics on the expressions, each
+// key is assumed to have defaultDistinct values.
+func AggregateEstimate(input Estimate, keys, calls int) Estimate {
+	groups := 1.0
+	if keys > 0 {
+		groups = clampRows(math.Min(input.Rows, math.Pow(defaultDistinct, float64(keys))))
+	}
+	cost := input.Rows*float64(keys+calls)*cpuOperatorCost + groups*cpuTupleCost
+	return Estimate{Rows: groups, Cost: input.Cost + cost}
+}
+
+// WindowEstimate is the estimate of computing calls window functions over
+// the rows of input, sorted on their partitions already.
+func WindowEstimate(input Estimate, calls int) Estimate {
+	return Estimate{Rows: input.Rows, Cost: input.Cost + input.Rows*(float64(calls)*cpuOperatorCost+cpuTupleCost)}
+}
+
+// LimitEstimate is the estimate of skipping the first offset rows of input
+// and returning at most limit of the others, all of them if limit is -1.
+// Reading input stops once they are returned, which inputs that need all
+// of their rows first, like sorts, don't benefit from: the input is
+// assumed to cost the same either way.
+func LimitEstimate(input Estimate, limit, offset int64) Estimate {
+	rows := math.Max(input.Rows-float64(offset), 0)
+	if limit >= 0 {
+		rows = math.Min(rows, float64(limit))
+	}
+	return Estimate{Rows: rows, Cost: input.Cost + rows*cpuTupleCost}
+}
+
+// AppendEstimate is the estimate of returning the rows of each of inputs in
+// turn.
+func AppendEstimate(inputs ...Estimate) Estimate {
+	var e Estimate
+	for _, input := range inputs {
+		e.Rows += input.Rows
+		e.Cost += input.Cost
+	}
+	e.Cost += e.Rows * cpuTupleCost
+	return e
+}
+
+// ModifyEstimate is the estimate of writing the rows of input to a table
+// with indexes indexes: each row goes to a heap page and to every index.
+func ModifyEstimate(input Estimate, indexes int) Estimate {
+	return Estimate{Rows: input.Rows, Cost: input.Cost + input.Rows*float64(1+indexes)*cpuTupleCost}
+}
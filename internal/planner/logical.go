@@ -0,0 +1,285 @@
+// Package planner turns the FROM and WHERE clauses of a query into a physical
+// plan. The clauses are first translated into a logical plan of scans, joins
+// and filters, which rules rewrite into a simpler equivalent: constants are
+// folded, outer joins are turned into inner joins where the WHERE clause
+// allows and conditions are pushed down towards the scans. The logical plan
+// is then planned bottom-up: each table gets the cheapest
+// access path, and the join order of each group of inner joins is chosen by
+// dynamic programming over a cost model fed by the statistics ANALYZE stores
+// in the catalog.
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+)
+
+// MaxRelations is the largest number of tables a FROM clause may list.
+const MaxRelations = 64
+
+// Relation is a table or a subquery of the FROM clause.
+type Relation struct {
+	ID      int // position in the FROM clause
+	Table   *catalog.Table
+	Name    string // name or alias the columns are qualified with
+	Columns []bool // columns the query reads, set by Query.Project; nil reads all
+	// Subquery is set for a subquery or WITH query of the FROM clause.
+	// Table then only describes its columns.
+	Subquery *Subquery
+	// Merged relations come from IN and EXISTS subqueries turned into
+	// joins. They follow the relations of the FROM clause, and references
+	// to their columns are always qualified.
+	Merged bool
+}
+
+// Subquery is a subquery or WITH query of the FROM clause, planned by the
+// caller: the columns of its rows, what producing them is expected to take
+// and the plan itself, which the planner carries without looking into it.
+type Subquery struct {
+	Estimate
+	Columns []catalog.Column
+	Plan    interface{}
+}
+
+// relSet is a set of relations, as a bit mask of their IDs.
+type relSet uint64
+
+func (s relSet) has(id int) bool        { return s&(1<<uint(id)) != 0 }
+func (s relSet) subsetOf(o relSet) bool { return s&^o == 0 }
+
+func (s relSet) count() int {
+	n := 0
+	for ; s != 0; s &= s - 1 {
+		n++
+	}
+	return n
+}
+
+// Logical is a node of a logical plan.
+type Logical interface {
+	logicalNode()
+}
+
+// Scan reads the rows of a relation.
+type Scan struct {
+	Relation *Relation
+}
+
+// LogicalJoin combines the rows of its inputs. Right joins are turned into
+// left joins with the inputs swapped, and cross joins into inner joins
+// without a condition.
+type LogicalJoin struct {
+	Type  parser.JoinType
+	Left  Logical
+	Right Logical
+	Cond  []parser.Expr // conjuncts of the ON condition
+}
+
+// Selection keeps the rows of its input for which every condition is true.
+type Selection struct {
+	Input Logical
+	Conds []parser.Expr
+}
+
+func (*Scan) logicalNode()        {}
+func (*LogicalJoin) logicalNode() {}
+func (*Selection) logicalNode()   {}
+
+// Query is the logical plan of the FROM and WHERE clauses of a query.
+type Query struct {
+	Relations []*Relation
+	Root      Logical
+}
+
+// Build translates a FROM clause and an optional WHERE condition into a
+// logical plan, looking the tables up in the catalog and the subqueries and
+// WITH queries of the FROM clause up in derived, and rewrites it. IN and EXISTS subqueries
+// of the WHERE clause become joins where they can.
+func Build(cat *catalog.Catalog, from parser.TableRef, where parser.Expr, derived map[parser.TableRef]*Subquery) (*Query, error) {
+	return build(cat, from, where, derived, true)
+}
+
+// BuildTable is Build for the rows of one table, as UPDATE and DELETE read
+// them: subqueries stay in the condition, so that every row comes straight
+// from the table.
+func BuildTable(cat *catalog.Catalog, table string, where parser.Expr) (*Query, error) {
+	return build(cat, &parser.TableName{Name: table}, where, nil, false)
+}
+
+func build(cat *catalog.Catalog, from parser.TableRef, where parser.Expr, derived map[parser.TableRef]*Subquery, merge bool) (*Query, error) {
+	q := &Query{}
+	root, err := q.build(cat, from, derived)
+	if err != nil {
+		return nil, err
+	}
+	var conds []parser.Expr
+	for _, cond := range parser.Conjuncts(where) {
+		if merge {
+			if join := q.decorrelate(cat, root, cond); join != nil {
+				root = join
+				continue
+			}
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) > 0 {
+		root = &Selection{Input: root, Conds: conds}
+	}
+	q.Root = q.rewrite(root)
+	return q, nil
+}
+
+func (q *Query) build(cat *catalog.Catalog, ref parser.TableRef, derived map[parser.TableRef]*Subquery) (Logical, error) {
+	switch r := ref.(type) {
+	case *parser.TableName:
+		if r.CTE != nil {
+			return q.buildSubquery(r, r.Name, r.RefName(), derived)
+		}
+		table, err := cat.GetTable(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := q.addRelation(table, r.RefName())
+		if err != nil {
+			return nil, err
+		}
+		return &Scan{Relation: rel}, nil
+	case *parser.DerivedTable:
+		return q.buildSubquery(r, r.Alias, r.Alias, derived)
+	case *parser.Join:
+		left, err := q.build(cat, r.Left, derived)
+		if err != nil {
+			return nil, err
+		}
+		right, err := q.build(cat, r.Right, derived)
+		if err != nil {
+			return nil, err
+		}
+		join := &LogicalJoin{Type: r.Type, Left: left, Right: right, Cond: parser.Conjuncts(r.On)}
+		switch r.Type {
+		case parser.JoinRight:
+			join.Type, join.Left, join.Right = parser.JoinLeft, right, left
+		case parser.JoinCross:
+			join.Type = parser.JoinInner
+		}
+		return join, nil
+	}
+	return nil, fmt.Errorf("unsupported FROM clause %T", ref)
+}
+
+// buildSubquery adds a relation reading the rows of a subquery or WITH query
+// of the FROM clause, as a table of the given name.
+func (q *Query) buildSubquery(ref parser.TableRef, table, name string, derived map[parser.TableRef]*Subquery) (Logical, error) {
+	sub := derived[ref]
+	if sub == nil {
+		return nil, fmt.Errorf("subquery %s is not planned", name)
+	}
+	rel, err := q.addRelation(&catalog.Table{Name: strings.ToLower(table), Columns: sub.Columns}, name)
+	if err != nil {
+		return nil, err
+	}
+	rel.Subquery = sub
+	return &Scan{Relation: rel}, nil
+}
+
+// addRelation adds a relation reading table under name.
+func (q *Query) addRelation(table *catalog.Table, name string) (*Relation, error) {
+	for _, rel := range q.Relations {
+		if strings.EqualFold(rel.Name, name) {
+			return nil, fmt.Errorf("table name %s specified more than once", name)
+		}
+	}
+	if len(q.Relations) == MaxRelations {
+		return nil, fmt.Errorf("at most %d tables may be listed in a FROM clause", MaxRelations)
+	}
+	rel := &Relation{ID: len(q.Relations), Table: table, Name: strings.ToLower(name)}
+	q.Relations = append(q.Relations, rel)
+	return rel, nil
+}
+
+// relations returns the relations a column reference may belong to: the
+// qualified relation, or every relation having a column of that name.
+func (q *Query) relations(ref *parser.ColumnRef) []*Relation {
+	var matches []*Relation
+	for _, rel := range q.Relations {
+		if ref.Table != "" && !strings.EqualFold(rel.Name, ref.Table) || ref.Table == "" && rel.Merged {
+			continue
+		}
+		if rel.Table.ColumnIndex(ref.Column) != -1 {
+			matches = append(matches, rel)
+		}
+	}
+	return matches
+}
+
+// column resolves a column reference to its relation and column position.
+// It returns nil for references that don't resolve to exactly one column;
+// the executor reports those when it compiles the expression.
+func (q *Query) column(ref *parser.ColumnRef) (*Relation, int) {
+	matches := q.relations(ref)
+	if len(matches) != 1 {
+		return nil, -1
+	}
+	return matches[0], matches[0].Table.ColumnIndex(ref.Column)
+}
+
+// references returns the relations an expression refers to. ok is false if
+// a column reference doesn't resolve. A subquery counts as referring to
+// every relation its column references might belong to.
+func (q *Query) references(expr parser.Expr) (set relSet, ok bool) {
+	ok = true
+	parser.WalkExpr(expr, func(e parser.Expr) bool {
+		if ref, isRef := e.(*parser.ColumnRef); isRef {
+			rel, _ := q.column(ref)
+			if rel == nil {
+				ok = false
+			} else {
+				set |= 1 << uint(rel.ID)
+			}
+		}
+		if sub := parser.Subquery(e); sub != nil {
+			for _, rel := range q.outerRelations(sub) {
+				set |= 1 << uint(rel.ID)
+			}
+		}
+		return ok
+	})
+	return set, ok
+}
+
+// outerRelations returns the relations the column references of a subquery
+// might belong to. References to the subquery's own tables are mistaken for
+// references to the query's when they name the same column, which only costs
+// the chance to evaluate the subquery earlier.
+func (q *Query) outerRelations(sub *parser.SelectStatement) []*Relation {
+	var rels []*Relation
+	seen := make(map[int]bool)
+	parser.WalkSelect(sub, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			for _, rel := range q.relations(ref) {
+				if !seen[rel.ID] {
+					seen[rel.ID] = true
+					rels = append(rels, rel)
+				}
+			}
+		}
+		return true
+	})
+	return rels
+}
+
+// relationsOf returns the relations scanned below a logical node.
+func relationsOf(node Logical) relSet {
+	switch n := node.(type) {
+	case *Scan:
+		return 1 << uint(n.Relation.ID)
+	case *LogicalJoin:
+		return relationsOf(n.Left) | relationsOf(n.Right)
+	case *Selection:
+		return relationsOf(n.Input)
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package planner
+
+import (
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+)
+
+// JoinMethod is the algorithm executing a join.
+type JoinMethod int64
+
+const (
+	// JoinAuto lets the planner choose the cheapest applicable method.
+	JoinAuto            JoinMethod = 0
+	JoinNestedLoop      JoinMethod = 1
+	JoinIndexNestedLoop JoinMethod = 2
+	JoinHash            JoinMethod = 3
+	JoinMerge           JoinMethod = 4
+)
+
+func (m JoinMethod) String() string {
+	switch m {
+	case JoinNestedLoop:
+		return "Nested Loop"
+	case JoinIndexNestedLoop:
+		return "Index Nested Loop"
+	case JoinHash:
+		return "Hash Join"
+	case JoinMerge:
+		return "Merge Join"
+	}
+	return "Auto"
+}
+
+// Estimate is what the planner expects of a plan node: the number of rows
+// it produces and the total cost of producing them, in units of one
+// sequential page read.
+type Estimate struct {
+	Rows float64
+	Cost float64
+}
+
+// Plan is a node of a physical plan. Its rows are made of the columns of
+// its relations, in the order Relations lists them.
+type Plan interface {
+	Estimated() Estimate
+	Relations() []*Relation
+}
+
+// SeqScan reads every row of a relation and keeps those satisfying Filter.
+type SeqScan struct {
+	Estimate
+	Relation *Relation
+	Filter   parser.Expr
+}
+
+// SubqueryScan reads the rows of a subquery of the FROM clause and keeps
+// those satisfying Filter.
+type SubqueryScan struct {
+	Estimate
+	Relation *Relation
+	Filter   parser.Expr
+}
+
+// Bound limits an index scan. Value is a literal or a parameter.
+type Bound struct {
+	Value     parser.Expr
+	Inclusive bool
+}
+
+// IndexScan reads the rows of a relation whose leading index column lies
+// between the bounds, in index order, and keeps those satisfying Filter.
+// A nil bound leaves that side open. Filter includes IndexCond, the
+// conditions the bounds were taken from.
+type IndexScan struct {
+	Estimate
+	Relation  *Relation
+	Index     *catalog.Index
+	Lower     *Bound
+	Upper     *Bound
+	IndexCond parser.Expr
+	Filter    parser.Expr
+}
+
+// Filter keeps the rows of its input satisfying Cond.
+type Filter struct {
+	Estimate
+	Input Plan
+	Cond  parser.Expr
+}
+
+// EquiKey is an equality between an expression over the left input of a
+// join and one over its right input.
+type EquiKey struct {
+	Left  parser.Expr
+	Right parser.Expr
+}
+
+// Join combines the rows of two inputs with the given method. Type is
+// inner, left, full, semi or anti. Rows match when every key is equal and
+// Residual is true. Semi and anti joins return the left rows with and
+// without a match, and only the columns of the left input. An index nested loop join looks up the rows of Right, always a
+// SeqScan without filter, through Index on the right side of Keys[0].
+type Join struct {
+	Estimate
+	Method   JoinMethod
+	Type     parser.JoinType
+	Left     Plan
+	Right    Plan
+	Keys     []EquiKey
+	Residual parser.Expr
+	Index    *catalog.Index
+}
+
+func (p *SeqScan) Estimated() Estimate      { return p.Estimate }
+func (p *SubqueryScan) Estimated() Estimate { return p.Estimate }
+func (p *IndexScan) Estimated() Estimate    { return p.Estimate }
+func (p *Filter) Estimated() Estimate       { return p.Estimate }
+func (p *Join) Estimated() Estimate         { return p.Estimate }
+
+func (p *SeqScan) Relations() []*Relation      { return []*Relation{p.Relation} }
+func (p *SubqueryScan) Relations() []*Relation { return []*Relation{p.Relation} }
+func (p *IndexScan) Relations() []*Relation    { return []*Relation{p.Relation} }
+func (p *Filter) Relations() []*Relation       { return p.Input.Relations() }
+func (p *Join) Relations() []*Relation {
+	if p.Type == parser.JoinSemi || p.Type == parser.JoinAnti {
+		return p.Left.Relations()
+	}
+	return append(p.Left.Relations(), p.Right.Relations()...)
+}
+
+// Condition returns the whole join condition: the keys and the residual.
+func (p *Join) Condition() parser.Expr {
+	conds := make([]parser.Expr, 0, len(p.Keys)+1)
+	for _, key := range p.Keys {
+		conds = append(conds, &parser.BinaryExpr{Op: "=", Left: key.Left, Right: key.Right})
+	}
+	if p.Residual != nil {
+		conds = append(conds, p.Residual)
+	}
+	return parser.Conjoin(conds)
+}
@@ -0,0 +1,553 @@
+package planner
+
+import (
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// maxDPInputs bounds the number of inputs whose join order is chosen by
+// dynamic programming. Larger groups of joins are ordered greedily.
+const maxDPInputs = 10
+
+// Options tune the physical plans the planner produces.
+type Options struct {
+	// JoinMethod forces joins to use a method where it applies. Joins it
+	// can't execute fall back to the cheapest method.
+	JoinMethod JoinMethod
+	// WorkMem is the memory, in bytes, sorts and hash tables may use before
+	// spilling to disk.
+	WorkMem int
+}
+
+type planner struct {
+	query  *Query
+	params []types.Value
+	opts   Options
+}
+
+// Optimize chooses a physical plan for a logical plan. params are the values
+// bound to the query's parameters, used to estimate the conditions they
+// appear in.
+func Optimize(q *Query, params []types.Value, opts Options) Plan {
+	p := &planner{query: q, params: params, opts: opts}
+	return p.plan(q.Root)
+}
+
+// cond is a conjunct of a condition with the relations it refers to. known
+// is false when a column reference doesn't resolve; such conditions are
+// evaluated as late as possible so that the executor reports the error.
+type cond struct {
+	expr  parser.Expr
+	rels  relSet
+	known bool
+}
+
+func (p *planner) conds(exprs []parser.Expr) []cond {
+	conds := make([]cond, len(exprs))
+	for i, expr := range exprs {
+		rels, known := p.query.references(expr)
+		conds[i] = cond{expr: expr, rels: rels, known: known}
+	}
+	return conds
+}
+
+func exprs(conds []cond) []parser.Expr {
+	out := make([]parser.Expr, len(conds))
+	for i, c := range conds {
+		out[i] = c.expr
+	}
+	return out
+}
+
+// input is an input of a join: its best plan, the relations it covers and,
+// for a single relation, the relation and the filters applied to its scan.
+type input struct {
+	plan    Plan
+	rels    relSet
+	base    *Relation
+	filters []cond
+}
+
+// plan plans a logical node. Consecutive inner joins and the selections
+// above them form a group whose conditions are pooled and whose join order
+// is chosen freely; outer, semi and anti joins are planned in the order
+// written.
+func (p *planner) plan(node Logical) Plan {
+	if join, ok := node.(*LogicalJoin); ok && join.Type != parser.JoinInner {
+		return p.planOuterJoin(join)
+	}
+	var leaves []Logical
+	var conds []parser.Expr
+	p.flatten(node, &leaves, &conds)
+	return p.planGroup(leaves, p.conds(conds))
+}
+
+// flatten collects the inputs and conditions of a group of inner joins.
+func (p *planner) flatten(node Logical, leaves *[]Logical, conds *[]parser.Expr) {
+	switch n := node.(type) {
+	case *Selection:
+		p.flatten(n.Input, leaves, conds)
+		*conds = append(*conds, n.Conds...)
+		return
+	case *LogicalJoin:
+		if n.Type == parser.JoinInner {
+			p.flatten(n.Left, leaves, conds)
+			p.flatten(n.Right, leaves, conds)
+			*conds = append(*conds, n.Cond...)
+			return
+		}
+	}
+	*leaves = append(*leaves, node)
+}
+
+// planGroup joins the inputs of a group of inner joins, applying each
+// condition as early as the relations it refers to allow.
+func (p *planner) planGroup(leaves []Logical, conds []cond) Plan {
+	inputs := make([]*input, len(leaves))
+	for i, leaf := range leaves {
+		inputs[i] = &input{rels: relationsOf(leaf)}
+		if scan, ok := leaf.(*Scan); ok {
+			inputs[i].base = scan.Relation
+		}
+	}
+	var joinConds, late []cond
+	for _, c := range conds {
+		if !c.known || c.rels == 0 {
+			late = append(late, c)
+			continue
+		}
+		placed := false
+		for _, in := range inputs {
+			if c.rels.subsetOf(in.rels) {
+				in.filters = append(in.filters, c)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			joinConds = append(joinConds, c)
+		}
+	}
+	for i, in := range inputs {
+		if in.base != nil {
+			in.plan = p.accessPath(in.base, exprs(in.filters))
+		} else {
+			in.plan = p.filter(p.plan(leaves[i]), exprs(in.filters))
+		}
+	}
+
+	var result *input
+	if len(inputs) <= maxDPInputs {
+		result = p.orderJoins(inputs, joinConds)
+	} else {
+		result = p.orderJoinsGreedily(inputs, joinConds)
+	}
+	return p.filter(result.plan, exprs(late))
+}
+
+// filter applies conditions to the rows of a plan.
+func (p *planner) filter(plan Plan, conds []parser.Expr) Plan {
+	if len(conds) == 0 {
+		return plan
+	}
+	est := plan.Estimated()
+	cond := parser.Conjoin(conds)
+	return &Filter{
+		Estimate: Estimate{
+			Rows: clampRows(est.Rows * p.selectivity(cond)),
+			Cost: est.Cost + est.Rows*float64(len(conds))*cpuOperatorCost,
+		},
+		Input: plan,
+		Cond:  cond,
+	}
+}
+
+// spanning returns the conditions that can be evaluated on the join of left
+// and right but on neither of them alone.
+func spanning(conds []cond, left, right relSet) []cond {
+	var out []cond
+	for _, c := range conds {
+		if c.rels.subsetOf(left|right) && !c.rels.subsetOf(left) && !c.rels.subsetOf(right) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// orderJoins finds the cheapest way to join the inputs by dynamic
+// programming over the subsets of inputs: the best plan of a subset is the
+// cheapest join of the best plans of two complementary subsets. Joins
+// without a condition between them are only considered for subsets that
+// can't be joined otherwise.
+func (p *planner) orderJoins(inputs []*input, conds []cond) *input {
+	best := make(map[uint32]*input, 1<<uint(len(inputs)))
+	for i, in := range inputs {
+		best[1<<uint(i)] = in
+	}
+	full := uint32(1)<<uint(len(inputs)) - 1
+	for size := 2; size <= len(inputs); size++ {
+		for set := uint32(1); set <= full; set++ {
+			if bits.OnesCount32(set) != size {
+				continue
+			}
+			var chosen *input
+			connected := false
+			for sub := (set - 1) & set; sub > 0; sub = (sub - 1) & set {
+				left, right := best[sub], best[set^sub]
+				if left == nil || right == nil {
+					continue
+				}
+				applied := spanning(conds, left.rels, right.rels)
+				if connected && len(applied) == 0 {
+					continue
+				}
+				candidate := p.join(parser.JoinInner, left, right, applied)
+				if len(applied) > 0 && !connected {
+					connected = true
+					chosen = nil
+				}
+				if chosen == nil || candidate.Estimated().Cost < chosen.plan.Estimated().Cost {
+					chosen = &input{plan: candidate, rels: left.rels | right.rels}
+				}
+			}
+			best[set] = chosen
+		}
+	}
+	return best[full]
+}
+
+// orderJoinsGreedily repeatedly joins the pair of inputs whose join is the
+// cheapest, preferring pairs with a condition between them.
+func (p *planner) orderJoinsGreedily(inputs []*input, conds []cond) *input {
+	inputs = append([]*input(nil), inputs...)
+	for len(inputs) > 1 {
+		var chosen *input
+		var chosenLeft, chosenRight int
+		connected := false
+		for i, left := range inputs {
+			for j, right := range inputs {
+				if i == j {
+					continue
+				}
+				applied := spanning(conds, left.rels, right.rels)
+				if connected && len(applied) == 0 {
+					continue
+				}
+				candidate := p.join(parser.JoinInner, left, right, applied)
+				if len(applied) > 0 && !connected {
+					connected = true
+					chosen = nil
+				}
+				if chosen == nil || candidate.Estimated().Cost < chosen.plan.Estimated().Cost {
+					chosen = &input{plan: candidate, rels: left.rels | right.rels}
+					chosenLeft, chosenRight = i, j
+				}
+			}
+		}
+		rest := []*input{chosen}
+		for i, in := range inputs {
+			if i != chosenLeft && i != chosenRight {
+				rest = append(rest, in)
+			}
+		}
+		inputs = rest
+	}
+	return inputs[0]
+}
+
+// planOuterJoin plans a left, full, semi or anti join with its inputs in
+// place.
+func (p *planner) planOuterJoin(join *LogicalJoin) Plan {
+	left := &input{plan: p.plan(join.Left), rels: relationsOf(join.Left)}
+	right := &input{plan: p.plan(join.Right), rels: relationsOf(join.Right)}
+	switch r := join.Right.(type) {
+	case *Scan:
+		right.base = r.Relation
+	case *Selection:
+		// ON conditions pushed down to the scan.
+		if scan, ok := r.Input.(*Scan); ok {
+			right.base = scan.Relation
+			right.filters = p.conds(r.Conds)
+		}
+	}
+	return p.join(join.Type, left, right, p.conds(join.Cond))
+}
+
+// join returns the cheapest plan joining two inputs on conditions, or the
+// plan using the method forced by the options when it applies.
+func (p *planner) join(joinType parser.JoinType, left, right *input, conds []cond) Plan {
+	var keys []EquiKey
+	var rest []parser.Expr
+	for _, c := range conds {
+		if key, ok := p.equiKey(c, left.rels, right.rels); ok {
+			keys = append(keys, key)
+		} else {
+			rest = append(rest, c.expr)
+		}
+	}
+	residual := parser.Conjoin(rest)
+	l, r := left.plan.Estimated(), right.plan.Estimated()
+	sel := p.selectivity(parser.Conjoin(exprs(conds)))
+	rows := l.Rows * r.Rows * sel
+	switch joinType {
+	case parser.JoinLeft:
+		rows = maxFloat(rows, l.Rows)
+	case parser.JoinFull:
+		rows = maxFloat(rows, l.Rows, r.Rows)
+	case parser.JoinSemi, parser.JoinAnti:
+		// A left row is taken to have a match when it is expected to
+		// match at least one right row.
+		matched := math.Min(1, r.Rows*sel)
+		if joinType == parser.JoinAnti {
+			matched = 1 - matched
+		}
+		rows = l.Rows * matched
+	}
+	rows = clampRows(rows)
+
+	newJoin := func(method JoinMethod, cost float64) *Join {
+		return &Join{
+			Estimate: Estimate{Rows: rows, Cost: cost},
+			Method:   method, Type: joinType, Left: left.plan, Right: right.plan,
+			Keys: keys, Residual: residual,
+		}
+	}
+	numConds := maxInt(len(conds), 1)
+	candidates := []*Join{newJoin(JoinNestedLoop, nestedLoopCost(left.plan, right.plan, rows, numConds, p.workMem()))}
+	candidates[0].Keys, candidates[0].Residual = nil, parser.Conjoin(exprs(conds))
+	if len(keys) > 0 {
+		if p.hashable(keys) {
+			candidates = append(candidates, newJoin(JoinHash, hashJoinCost(left.plan, right.plan, rows, p.workMem())))
+		}
+		if joinType != parser.JoinSemi && joinType != parser.JoinAnti {
+			candidates = append(candidates, newJoin(JoinMerge, mergeJoinCost(left.plan, right.plan, rows, p.workMem())))
+		}
+	}
+	if inl := p.indexJoin(joinType, left, right, keys, rest, rows); inl != nil {
+		candidates = append(candidates, inl)
+	}
+
+	chosen := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.Cost < chosen.Cost {
+			chosen = candidate
+		}
+	}
+	for _, candidate := range candidates {
+		if candidate.Method == p.opts.JoinMethod {
+			chosen = candidate
+		}
+	}
+	return chosen
+}
+
+// equiKey returns a condition as a join key when it equates an expression
+// over the left input with one over the right input.
+func (p *planner) equiKey(c cond, left, right relSet) (EquiKey, bool) {
+	cmp, ok := c.expr.(*parser.BinaryExpr)
+	if !ok || cmp.Op != "=" || !c.known {
+		return EquiKey{}, false
+	}
+	lrels, _ := p.query.references(cmp.Left)
+	rrels, _ := p.query.references(cmp.Right)
+	if lrels == 0 || rrels == 0 {
+		return EquiKey{}, false
+	}
+	if lrels.subsetOf(left) && rrels.subsetOf(right) {
+		return EquiKey{Left: cmp.Left, Right: cmp.Right}, true
+	}
+	if lrels.subsetOf(right) && rrels.subsetOf(left) {
+		return EquiKey{Left: cmp.Right, Right: cmp.Left}, true
+	}
+	return EquiKey{}, false
+}
+
+// hashable reports whether equal keys have equal encodings, which hash
+// joins rely on. Keys whose types aren't known aren't hashed.
+func (p *planner) hashable(keys []EquiKey) bool {
+	for _, key := range keys {
+		lt, lok := p.exprType(key.Left)
+		rt, rok := p.exprType(key.Right)
+		if !lok || !rok {
+			return false
+		}
+		numeric := func(t types.Type) bool { return t == types.TypeInteger || t == types.TypeReal }
+		if lt != rt && !(numeric(lt) && numeric(rt)) && lt != types.TypeNull && rt != types.TypeNull {
+			return false
+		}
+	}
+	return true
+}
+
+// exprType returns the type of a column reference or a constant.
+func (p *planner) exprType(expr parser.Expr) (types.Type, bool) {
+	if ref, ok := expr.(*parser.ColumnRef); ok {
+		rel, idx := p.query.column(ref)
+		if rel == nil {
+			return types.TypeNull, false
+		}
+		return rel.Table.Columns[idx].Type, true
+	}
+	if value, ok := p.constant(expr); ok {
+		return value.Type, true
+	}
+	return types.TypeNull, false
+}
+
+// indexJoin returns an index nested loop join when the right input is a
+// relation with an index whose leading column is a join key.
+func (p *planner) indexJoin(joinType parser.JoinType, left, right *input, keys []EquiKey, rest []parser.Expr, rows float64) *Join {
+	if right.base == nil || joinType == parser.JoinFull {
+		return nil
+	}
+	rel := right.base
+	for i, key := range keys {
+		ref, ok := key.Right.(*parser.ColumnRef)
+		if !ok {
+			continue
+		}
+		if keyRel, idx := p.query.column(ref); keyRel != rel || idx == -1 {
+			continue
+		}
+		def := rel.Table.IndexOn(ref.Column)
+		if def == nil {
+			continue
+		}
+		ordered := append([]EquiKey{key}, keys[:i]...)
+		ordered = append(ordered, keys[i+1:]...)
+		// The scan's own filters are checked on the rows the index returns.
+		residual := parser.Conjoin(append(append([]parser.Expr(nil), rest...), exprs(right.filters)...))
+		innerRows, innerPages := tableSize(rel)
+		matches := innerRows / p.distinct(ref)
+		return &Join{
+			Estimate: Estimate{Rows: rows, Cost: indexNestedLoopCost(left.plan, innerRows, matches, rows)},
+			Method:   JoinIndexNestedLoop,
+			Type:     joinType,
+			Left:     left.plan,
+			Right: &SeqScan{
+				Estimate: Estimate{Rows: clampRows(innerRows), Cost: seqScanCost(innerRows, innerPages, 0)},
+				Relation: rel,
+			},
+			Keys:     ordered,
+			Residual: residual,
+			Index:    def,
+		}
+	}
+	return nil
+}
+
+// accessPath returns the cheapest way to read the rows of a relation that
+// satisfy the filters: a sequential scan, or an index scan over an index
+// whose leading column the filters bound.
+func (p *planner) accessPath(rel *Relation, filters []parser.Expr) Plan {
+	rows, pages := tableSize(rel)
+	filter := parser.Conjoin(filters)
+	out := rows
+	if filter != nil {
+		out = rows * p.selectivity(filter)
+	}
+	if rel.Subquery != nil {
+		return &SubqueryScan{
+			Estimate: Estimate{
+				Rows: clampRows(out),
+				Cost: rel.Subquery.Cost + rows*(cpuTupleCost+float64(len(filters))*cpuOperatorCost),
+			},
+			Relation: rel,
+			Filter:   filter,
+		}
+	}
+	var best Plan = &SeqScan{
+		Estimate: Estimate{Rows: clampRows(out), Cost: seqScanCost(rows, pages, len(filters))},
+		Relation: rel,
+		Filter:   filter,
+	}
+	for _, def := range rel.Table.Indexes {
+		lower, upper, bounding := p.bounds(rel, def.Columns[0], filters)
+		if lower == nil && upper == nil {
+			continue
+		}
+		matched := rows * p.selectivity(parser.Conjoin(bounding))
+		cost := indexScanCost(rows, pages, matched, len(filters))
+		if cost < best.Estimated().Cost {
+			best = &IndexScan{
+				Estimate:  Estimate{Rows: clampRows(out), Cost: cost},
+				Relation:  rel,
+				Index:     def,
+				Lower:     lower,
+				Upper:     upper,
+				IndexCond: parser.Conjoin(bounding),
+				Filter:    filter,
+			}
+		}
+	}
+	return best
+}
+
+// bounds finds the comparisons of a column with constants among the filters
+// and turns them into the bounds of an index scan. It also returns the
+// comparisons the bounds were taken from.
+func (p *planner) bounds(rel *Relation, column string, filters []parser.Expr) (lower, upper *Bound, bounding []parser.Expr) {
+	for _, filter := range filters {
+		cmp, ok := filter.(*parser.BinaryExpr)
+		if !ok {
+			continue
+		}
+		op, ref, value := cmp.Op, cmp.Left, cmp.Right
+		if !isConstant(value) {
+			op, ref, value = flipped(cmp.Op), cmp.Right, cmp.Left
+		}
+		colRef, ok := ref.(*parser.ColumnRef)
+		if !ok || !isConstant(value) {
+			continue
+		}
+		if keyRel, idx := p.query.column(colRef); keyRel != rel || !strings.EqualFold(rel.Table.Columns[idx].Name, column) {
+			continue
+		}
+		switch op {
+		case "=":
+			if lower == nil || upper == nil || lower != upper {
+				bound := &Bound{Value: value, Inclusive: true}
+				lower, upper = bound, bound
+				bounding = append(bounding, filter)
+			}
+		case ">", ">=":
+			if lower == nil {
+				lower = &Bound{Value: value, Inclusive: op == ">="}
+				bounding = append(bounding, filter)
+			}
+		case "<", "<=":
+			if upper == nil {
+				upper = &Bound{Value: value, Inclusive: op == "<="}
+				bounding = append(bounding, filter)
+			}
+		}
+	}
+	return lower, upper, bounding
+}
+
+func (p *planner) workMem() int {
+	if p.opts.WorkMem <= 0 {
+		return 4 << 20
+	}
+	return p.opts.WorkMem
+}
+
+func maxFloat(first float64, rest ...float64) float64 {
+	for _, f := range rest {
+		if f > first {
+			first = f
+		}
+	}
+	return first
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
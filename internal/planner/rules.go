@@ -0,0 +1,374 @@
+package planner
+
+import (
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// rewrite applies the rules that improve a logical plan whatever the
+// statistics say: conditions are folded and simplified, outer joins whose
+// NULL-extended rows the conditions above them reject become inner joins,
+// and every condition moves as close to the scans as the joins allow.
+func (q *Query) rewrite(node Logical) Logical {
+	return q.pushDown(node, nil)
+}
+
+// pushDown returns node with conds, conditions on its rows, applied as
+// early as possible, pushing them and the node's own conditions down into
+// its inputs where that doesn't change the result.
+func (q *Query) pushDown(node Logical, conds []parser.Expr) Logical {
+	switch n := node.(type) {
+	case *Selection:
+		return q.pushDown(n.Input, append(q.simplify(n.Conds), conds...))
+	case *LogicalJoin:
+		join := *n
+		join.Cond = q.simplify(n.Cond)
+		left, right := relationsOf(n.Left), relationsOf(n.Right)
+		switch join.Type {
+		case parser.JoinLeft:
+			if q.rejectsNulls(conds, right) {
+				join.Type = parser.JoinInner
+			}
+		case parser.JoinFull:
+			// Rejecting the NULLs of one side removes the rows only the
+			// other side has.
+			keepLeft, keepRight := !q.rejectsNulls(conds, right), !q.rejectsNulls(conds, left)
+			switch {
+			case keepLeft && !keepRight:
+				join.Type = parser.JoinLeft
+			case keepRight && !keepLeft:
+				join.Type, join.Left, join.Right = parser.JoinLeft, n.Right, n.Left
+				left, right = right, left
+			case !keepLeft && !keepRight:
+				join.Type = parser.JoinInner
+			}
+		}
+
+		var above, toLeft, toRight, on []parser.Expr
+		// Conditions on the join's rows filter the preserved side of an
+		// outer join before it is joined; all of an inner join's conditions
+		// may move down.
+		for _, cond := range conds {
+			rels, known := q.references(cond)
+			switch {
+			case !known || rels == 0:
+				above = append(above, cond)
+			case join.Type != parser.JoinFull && rels.subsetOf(left):
+				toLeft = append(toLeft, cond)
+			case join.Type == parser.JoinInner && rels.subsetOf(right):
+				toRight = append(toRight, cond)
+			case join.Type == parser.JoinInner:
+				on = append(on, cond)
+			default:
+				above = append(above, cond)
+			}
+		}
+		// ON conditions on the NULL-extended side of an outer join only
+		// decide which of its rows match, so they can filter its rows first.
+		for _, cond := range join.Cond {
+			rels, known := q.references(cond)
+			switch {
+			case known && rels != 0 && join.Type != parser.JoinFull && rels.subsetOf(right):
+				toRight = append(toRight, cond)
+			case known && rels != 0 && (join.Type == parser.JoinInner || join.Type == parser.JoinSemi) && rels.subsetOf(left):
+				// A left row failing the condition has no match, which
+				// drops it from a semi join but keeps it in an anti join.
+				toLeft = append(toLeft, cond)
+			default:
+				on = append(on, cond)
+			}
+		}
+		join.Cond = on
+		join.Left = q.pushDown(join.Left, toLeft)
+		join.Right = q.pushDown(join.Right, toRight)
+		return selection(&join, above)
+	}
+	return selection(node, conds)
+}
+
+// selection returns node filtered by conds, if there are any.
+func selection(node Logical, conds []parser.Expr) Logical {
+	if len(conds) == 0 {
+		return node
+	}
+	return &Selection{Input: node, Conds: conds}
+}
+
+// simplify folds conditions that must all hold and splits them into
+// conjuncts, dropping those that are always true and repeated ones. A
+// conjunct that is never true replaces the others, except those whose
+// column references don't resolve, which are kept for the executor to
+// report.
+func (q *Query) simplify(conds []parser.Expr) []parser.Expr {
+	var out []parser.Expr
+	seen := make(map[string]bool)
+	never := false
+	for _, cond := range conds {
+		for _, c := range parser.Conjuncts(q.fold(cond)) {
+			if lit, ok := c.(*parser.Literal); ok && isBoolean(lit.Value) {
+				if lit.Value.IsNull() || !lit.Value.Bool {
+					never = true
+				}
+				continue
+			}
+			if key := c.String(); !seen[key] {
+				seen[key] = true
+				out = append(out, c)
+			}
+		}
+	}
+	if !never {
+		return out
+	}
+	kept := []parser.Expr{&parser.Literal{Value: types.NewBoolean(false)}}
+	for _, c := range out {
+		if _, known := q.references(c); !known {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+func isBoolean(v types.Value) bool {
+	return v.Type == types.TypeBoolean || v.Type == types.TypeNull
+}
+
+// fold evaluates the parts of an expression that don't depend on the rows,
+// following the executor's semantics. Parameters are left alone, as are
+// operations that would fail, so that the executor reports the error.
+func (q *Query) fold(expr parser.Expr) parser.Expr {
+	return parser.RewriteExpr(expr, func(e parser.Expr) parser.Expr {
+		switch e := e.(type) {
+		case *parser.UnaryExpr:
+			return q.foldUnary(e)
+		case *parser.BinaryExpr:
+			return q.foldBinary(e)
+		case *parser.IsNullExpr:
+			if lit, ok := e.Expr.(*parser.Literal); ok {
+				return boolLiteral(lit.Value.IsNull() != e.Not)
+			}
+		case *parser.CastExpr:
+			if lit, ok := e.Expr.(*parser.Literal); ok {
+				if value, err := lit.Value.Cast(e.Type); err == nil {
+					return &parser.Literal{Value: value}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func boolLiteral(b bool) *parser.Literal {
+	return &parser.Literal{Value: types.NewBoolean(b)}
+}
+
+func nullLiteral() *parser.Literal {
+	return &parser.Literal{Value: types.Null()}
+}
+
+// negated maps comparisons to their negation. Both yield NULL on NULL
+// operands, so NOT can be replaced by the negated comparison.
+var negated = map[string]string{
+	"=": "<>", "<>": "=", "!=": "=",
+	"<": ">=", ">=": "<", ">": "<=", "<=": ">",
+}
+
+func (q *Query) foldUnary(e *parser.UnaryExpr) parser.Expr {
+	lit, isLit := e.Operand.(*parser.Literal)
+	switch e.Op {
+	case "-":
+		if !isLit {
+			return nil
+		}
+		switch lit.Value.Type {
+		case types.TypeNull:
+			return lit
+		case types.TypeInteger:
+			if value, err := types.Arithmetic("-", types.NewInteger(0), lit.Value); err == nil {
+				return &parser.Literal{Value: value}
+			}
+		case types.TypeReal:
+			return &parser.Literal{Value: types.NewReal(-lit.Value.Float)}
+		}
+	case "NOT":
+		if isLit && isBoolean(lit.Value) {
+			if lit.Value.IsNull() {
+				return lit
+			}
+			return boolLiteral(!lit.Value.Bool)
+		}
+		switch operand := e.Operand.(type) {
+		case *parser.BinaryExpr:
+			if op, ok := negated[operand.Op]; ok {
+				return &parser.BinaryExpr{Op: op, Left: operand.Left, Right: operand.Right}
+			}
+		case *parser.IsNullExpr:
+			return &parser.IsNullExpr{Expr: operand.Expr, Not: !operand.Not}
+		}
+	}
+	return nil
+}
+
+func (q *Query) foldBinary(e *parser.BinaryExpr) parser.Expr {
+	left, leftLit := e.Left.(*parser.Literal)
+	right, rightLit := e.Right.(*parser.Literal)
+	switch e.Op {
+	case "AND", "OR":
+		isAnd := e.Op == "AND"
+		// A side that decides the result on its own makes the other
+		// irrelevant, and a side that never does leaves only the other.
+		for _, side := range []struct {
+			lit   *parser.Literal
+			ok    bool
+			other parser.Expr
+		}{{left, leftLit, e.Right}, {right, rightLit, e.Left}} {
+			if !side.ok || !isBoolean(side.lit.Value) || side.lit.Value.IsNull() {
+				continue
+			}
+			if side.lit.Value.Bool != isAnd {
+				if _, known := q.references(side.other); known {
+					return side.lit
+				}
+				continue
+			}
+			return side.other
+		}
+		if leftLit && rightLit && left.Value.IsNull() && right.Value.IsNull() {
+			return left
+		}
+	case "+", "-", "*", "/", "%":
+		if leftLit && rightLit {
+			if value, err := types.Arithmetic(e.Op, left.Value, right.Value); err == nil {
+				return &parser.Literal{Value: value}
+			}
+		}
+	case "||":
+		if leftLit && rightLit {
+			return &parser.Literal{Value: types.Concat(left.Value, right.Value)}
+		}
+	case "=", "<>", "!=", "<", "<=", ">", ">=":
+		if !leftLit || !rightLit {
+			return nil
+		}
+		if left.Value.IsNull() || right.Value.IsNull() {
+			return nullLiteral()
+		}
+		cmp, err := types.Compare(left.Value, right.Value)
+		if err != nil {
+			return nil
+		}
+		switch e.Op {
+		case "=":
+			return boolLiteral(cmp == 0)
+		case "<>", "!=":
+			return boolLiteral(cmp != 0)
+		case "<":
+			return boolLiteral(cmp < 0)
+		case "<=":
+			return boolLiteral(cmp <= 0)
+		case ">":
+			return boolLiteral(cmp > 0)
+		case ">=":
+			return boolLiteral(cmp >= 0)
+		}
+	}
+	return nil
+}
+
+// rejectsNulls reports whether one of the conditions can't be true when
+// every column of the relations in rels is NULL.
+func (q *Query) rejectsNulls(conds []parser.Expr, rels relSet) bool {
+	for _, cond := range conds {
+		if q.nullRejecting(cond, rels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Query) nullRejecting(expr parser.Expr, rels relSet) bool {
+	switch e := expr.(type) {
+	case *parser.BinaryExpr:
+		switch e.Op {
+		case "AND":
+			return q.nullRejecting(e.Left, rels) || q.nullRejecting(e.Right, rels)
+		case "OR":
+			return q.nullRejecting(e.Left, rels) && q.nullRejecting(e.Right, rels)
+		}
+	case *parser.IsNullExpr:
+		return e.Not && q.nullsOut(e.Expr, rels)
+	}
+	return q.nullsOut(expr, rels)
+}
+
+// nullsOut reports whether an expression is NULL when every column of the
+// relations in rels is NULL.
+func (q *Query) nullsOut(expr parser.Expr, rels relSet) bool {
+	switch e := expr.(type) {
+	case *parser.ColumnRef:
+		rel, _ := q.column(e)
+		return rel != nil && rels.has(rel.ID)
+	case *parser.UnaryExpr:
+		return q.nullsOut(e.Operand, rels)
+	case *parser.CastExpr:
+		return q.nullsOut(e.Expr, rels)
+	case *parser.InExpr:
+		// IN over a subquery returning no rows is FALSE even for NULL.
+		return e.Subquery == nil && q.nullsOut(e.Expr, rels)
+	case *parser.LikeExpr:
+		return q.nullsOut(e.Expr, rels) || q.nullsOut(e.Pattern, rels)
+	case *parser.BinaryExpr:
+		if e.Op == "AND" || e.Op == "OR" {
+			return q.nullsOut(e.Left, rels) && q.nullsOut(e.Right, rels)
+		}
+		return q.nullsOut(e.Left, rels) || q.nullsOut(e.Right, rels)
+	}
+	return false
+}
+
+// Project limits the columns read from each relation to those the given
+// expressions, evaluated on the rows of the plan, and the query's own
+// conditions refer to. Without it every column is read. References that
+// don't resolve to a single relation count for every relation they might
+// belong to, including the references in subqueries.
+func (q *Query) Project(exprs []parser.Expr) {
+	for _, rel := range q.Relations {
+		rel.Columns = make([]bool, len(rel.Table.Columns))
+	}
+	markRef := func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			for _, rel := range q.relations(ref) {
+				rel.Columns[rel.Table.ColumnIndex(ref.Column)] = true
+			}
+		}
+		return true
+	}
+	mark := func(expr parser.Expr) {
+		parser.WalkExpr(expr, func(e parser.Expr) bool {
+			if sub := parser.Subquery(e); sub != nil {
+				parser.WalkSelect(sub, markRef)
+			}
+			return markRef(e)
+		})
+	}
+	for _, expr := range exprs {
+		mark(expr)
+	}
+	var walk func(node Logical)
+	walk = func(node Logical) {
+		switch n := node.(type) {
+		case *Selection:
+			for _, cond := range n.Conds {
+				mark(cond)
+			}
+			walk(n.Input)
+		case *LogicalJoin:
+			for _, cond := range n.Cond {
+				mark(cond)
+			}
+			walk(n.Left)
+			walk(n.Right)
+		}
+	}
+	walk(q.Root)
+}
@@ -0,0 +1,392 @@
+package planner
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Selectivities assumed when statistics can't tell, the same as PostgreSQL's.
+const (
+	defaultEqSel    = 0.005
+	defaultIneqSel  = 1.0 / 3
+	defaultSel      = 0.5
+	defaultDistinct = 200
+)
+
+// Sizes assumed for tables that haven't been analyzed.
+const (
+	defaultPageCount = 100
+	defaultRowCount  = 5000
+)
+
+// tableSize returns the estimated number of rows and pages of a relation.
+func tableSize(rel *Relation) (rows, pages float64) {
+	if rel.Subquery != nil {
+		return rel.Subquery.Rows, 0
+	}
+	stats := rel.Table.Stats
+	if stats == nil {
+		return defaultRowCount, defaultPageCount
+	}
+	rows, pages = float64(stats.RowCount), float64(stats.PageCount)
+	if pages < 1 {
+		pages = 1
+	}
+	return rows, pages
+}
+
+// columnStats returns the statistics of the column a reference resolves to.
+func (p *planner) columnStats(ref *parser.ColumnRef) (*Relation, *catalog.ColumnStats) {
+	rel, idx := p.query.column(ref)
+	if rel == nil {
+		return nil, nil
+	}
+	return rel, rel.Table.Stats.Column(rel.Table.Columns[idx].Name)
+}
+
+// nullFraction is the fraction of a relation's rows where a column is NULL.
+func nullFraction(rel *Relation, stats *catalog.ColumnStats) float64 {
+	if stats == nil || rel.Table.Stats.RowCount == 0 {
+		return 0
+	}
+	return float64(stats.NullCount) / float64(rel.Table.Stats.RowCount)
+}
+
+// distinct is the estimated number of distinct non-NULL values of a column.
+func (p *planner) distinct(ref *parser.ColumnRef) float64 {
+	rel, stats := p.columnStats(ref)
+	if stats != nil {
+		if stats.DistinctCount < 1 {
+			return 1
+		}
+		return float64(stats.DistinctCount)
+	}
+	if rel != nil {
+		if rows, _ := tableSize(rel); rows < defaultDistinct {
+			return clampRows(rows)
+		}
+	}
+	return defaultDistinct
+}
+
+// constant returns the value of a literal or a bound parameter.
+func (p *planner) constant(expr parser.Expr) (types.Value, bool) {
+	switch e := expr.(type) {
+	case *parser.Literal:
+		return e.Value, true
+	case *parser.Param:
+		if e.Index >= 1 && e.Index <= len(p.params) {
+			return p.params[e.Index-1], true
+		}
+	case *parser.UnaryExpr:
+		if e.Op == "-" {
+			if v, ok := p.constant(e.Operand); ok {
+				switch v.Type {
+				case types.TypeInteger:
+					return types.NewInteger(-v.Int), true
+				case types.TypeReal:
+					return types.NewReal(-v.Float), true
+				}
+			}
+		}
+	}
+	return types.Value{}, false
+}
+
+// isConstant reports whether an expression is a literal or a parameter,
+// which can bound an index scan.
+func isConstant(expr parser.Expr) bool {
+	switch e := expr.(type) {
+	case *parser.Literal, *parser.Param:
+		return true
+	case *parser.UnaryExpr:
+		return e.Op == "-" && isConstant(e.Operand)
+	}
+	return false
+}
+
+// flipped returns the operator giving the same result with swapped operands.
+func flipped(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+// selectivity estimates the fraction of rows for which a condition is true.
+func (p *planner) selectivity(expr parser.Expr) float64 {
+	switch e := expr.(type) {
+	case *parser.BinaryExpr:
+		switch e.Op {
+		case "AND":
+			return p.conjunctionSelectivity(parser.Conjuncts(e))
+		case "OR":
+			left, right := p.selectivity(e.Left), p.selectivity(e.Right)
+			return left + right - left*right
+		case "=", "<>", "<", "<=", ">", ">=":
+			return p.comparisonSelectivity(e)
+		}
+	case *parser.UnaryExpr:
+		if e.Op == "NOT" {
+			return 1 - p.selectivity(e.Operand)
+		}
+	case *parser.IsNullExpr:
+		sel := defaultEqSel
+		if ref, ok := e.Expr.(*parser.ColumnRef); ok {
+			if rel, stats := p.columnStats(ref); stats != nil {
+				sel = nullFraction(rel, stats)
+			}
+		}
+		if e.Not {
+			return 1 - sel
+		}
+		return sel
+	case *parser.InExpr:
+		// Each item selects its share of the rows, assuming they differ.
+		sel := 0.0
+		for _, item := range e.List {
+			sel += p.comparisonSelectivity(&parser.BinaryExpr{Op: "=", Left: e.Expr, Right: item})
+		}
+		sel = clampFraction(sel)
+		if e.Not {
+			return 1 - sel
+		}
+		return sel
+	case *parser.Literal:
+		if e.Value.Type == types.TypeBoolean && e.Value.Bool {
+			return 1
+		}
+		return 0
+	}
+	return defaultSel
+}
+
+// conjunctionSelectivity estimates the selectivity of conditions that must
+// all hold, assuming they are independent. A lower and an upper bound on the
+// same column are combined into a range instead, which selects fewer rows
+// than the product of the two suggests.
+func (p *planner) conjunctionSelectivity(conds []parser.Expr) float64 {
+	type bounds struct {
+		lower, upper       float64
+		hasLower, hasUpper bool
+		distinct           float64
+	}
+	ranges := make(map[string]*bounds)
+	var order []string
+	sel := 1.0
+	for _, cond := range conds {
+		ref, lower, ok := p.rangeComparison(cond)
+		if !ok {
+			sel *= p.selectivity(cond)
+			continue
+		}
+		rel, idx := p.query.column(ref)
+		key := rel.Name + "." + strings.ToLower(rel.Table.Columns[idx].Name)
+		b, seen := ranges[key]
+		if !seen {
+			b = &bounds{lower: 1, upper: 1, distinct: p.distinct(ref)}
+			ranges[key] = b
+			order = append(order, key)
+		}
+		s := p.selectivity(cond)
+		if lower {
+			b.lower, b.hasLower = math.Min(b.lower, s), true
+		} else {
+			b.upper, b.hasUpper = math.Min(b.upper, s), true
+		}
+	}
+	for _, key := range order {
+		b := ranges[key]
+		if !b.hasLower || !b.hasUpper {
+			sel *= b.lower * b.upper
+			continue
+		}
+		// Both bounds count the rows inside the range; the rows outside one
+		// bound are inside the other.
+		s := b.lower + b.upper - 1
+		if s < 1/b.distinct {
+			s = 1 / b.distinct
+		}
+		sel *= s
+	}
+	return sel
+}
+
+// rangeComparison reports whether a condition compares a column with a
+// constant using <, <=, > or >=, and whether it bounds the column from below.
+func (p *planner) rangeComparison(expr parser.Expr) (*parser.ColumnRef, bool, bool) {
+	cmp, ok := expr.(*parser.BinaryExpr)
+	if !ok {
+		return nil, false, false
+	}
+	op, left, right := cmp.Op, cmp.Left, cmp.Right
+	if isConstant(left) {
+		op, left, right = flipped(op), right, left
+	}
+	ref, ok := left.(*parser.ColumnRef)
+	if !ok || !isConstant(right) {
+		return nil, false, false
+	}
+	if rel, _ := p.query.column(ref); rel == nil {
+		return nil, false, false
+	}
+	switch op {
+	case ">", ">=":
+		return ref, true, true
+	case "<", "<=":
+		return ref, false, true
+	}
+	return nil, false, false
+}
+
+// comparisonSelectivity estimates the selectivity of a comparison.
+func (p *planner) comparisonSelectivity(e *parser.BinaryExpr) float64 {
+	ref, isRef := e.Left.(*parser.ColumnRef)
+	op, other := e.Op, e.Right
+	if !isRef {
+		ref, isRef = e.Right.(*parser.ColumnRef)
+		op, other = flipped(e.Op), e.Left
+	}
+	if !isRef {
+		return defaultComparisonSel(op)
+	}
+	if otherRef, ok := other.(*parser.ColumnRef); ok {
+		// A join condition: each value matches its share of the other side.
+		if op == "=" {
+			d := p.distinct(ref)
+			if od := p.distinct(otherRef); od > d {
+				d = od
+			}
+			return 1 / d
+		}
+		return defaultComparisonSel(op)
+	}
+	value, ok := p.constant(other)
+	if !ok {
+		return defaultComparisonSel(op)
+	}
+	if value.IsNull() {
+		return 0
+	}
+	rel, stats := p.columnStats(ref)
+	if stats == nil {
+		return defaultComparisonSel(op)
+	}
+	notNull := 1 - nullFraction(rel, stats)
+	eq := 1 / p.distinct(ref)
+	if len(stats.Histogram) > 0 {
+		if out, err := outside(stats.Histogram, value); err == nil && out && op == "=" {
+			// Values outside the histogram are rare or absent.
+			return 0
+		}
+		if below, ok := histogramFraction(stats.Histogram, value); ok {
+			switch op {
+			case "<":
+				return notNull * below
+			case "<=":
+				return notNull * clampFraction(below+eq)
+			case ">":
+				return notNull * clampFraction(1-below-eq)
+			case ">=":
+				return notNull * (1 - below)
+			}
+		}
+	}
+	switch op {
+	case "=":
+		return notNull * eq
+	case "<>":
+		return notNull * (1 - eq)
+	}
+	return notNull * defaultIneqSel
+}
+
+func defaultComparisonSel(op string) float64 {
+	switch op {
+	case "=":
+		return defaultEqSel
+	case "<>":
+		return 1 - defaultEqSel
+	}
+	return defaultIneqSel
+}
+
+func clampFraction(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// outside reports whether a value lies outside the range of a histogram.
+func outside(bounds []types.Value, value types.Value) (bool, error) {
+	low, err := types.Compare(value, bounds[0])
+	if err != nil {
+		return false, err
+	}
+	high, err := types.Compare(value, bounds[len(bounds)-1])
+	if err != nil {
+		return false, err
+	}
+	return low < 0 || high > 0, nil
+}
+
+// histogramFraction estimates the fraction of the values of an equi-depth
+// histogram that are less than value, interpolating linearly inside the
+// bucket holding numeric values.
+func histogramFraction(bounds []types.Value, value types.Value) (float64, bool) {
+	var cmpErr error
+	compare := func(i int) int {
+		cmp, err := types.Compare(bounds[i], value)
+		if err != nil {
+			cmpErr = err
+		}
+		return cmp
+	}
+	// The first bound that is not less than the value.
+	pos := sort.Search(len(bounds), func(i int) bool { return compare(i) >= 0 })
+	if cmpErr != nil {
+		return 0, false
+	}
+	buckets := float64(len(bounds) - 1)
+	if pos == 0 {
+		return 0, true
+	}
+	if pos == len(bounds) || buckets == 0 {
+		return 1, true
+	}
+	fraction := 0.5
+	low, high := bounds[pos-1], bounds[pos]
+	lf, lok := numeric(low)
+	hf, hok := numeric(high)
+	vf, vok := numeric(value)
+	if lok && hok && vok && hf > lf {
+		fraction = clampFraction((vf - lf) / (hf - lf))
+	}
+	return (float64(pos-1) + fraction) / buckets, true
+}
+
+func numeric(v types.Value) (float64, bool) {
+	switch v.Type {
+	case types.TypeInteger:
+		return float64(v.Int), true
+	case types.TypeReal:
+		return v.Float, true
+	}
+	return 0, false
+}
@@ -0,0 +1,147 @@
+package planner
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+const (
+	// SampleSize is the number of rows ANALYZE keeps to build histograms and
+	// estimate distinct counts. Row and NULL counts are exact.
+	SampleSize = 30000
+	// HistogramBuckets is the number of buckets of each histogram.
+	HistogramBuckets = 32
+)
+
+// Collector gathers the statistics of a table from its rows. The rows are
+// sampled with reservoir sampling, so any number of them can be added.
+type Collector struct {
+	table     *catalog.Table
+	rows      int64
+	pages     int64
+	lastPage  int64
+	nulls     []int64
+	sample    [][]types.Value
+	generator *rand.Rand
+}
+
+// NewCollector returns a collector for the rows of a table.
+func NewCollector(table *catalog.Table) *Collector {
+	return &Collector{
+		table:     table,
+		lastPage:  -1,
+		nulls:     make([]int64, len(table.Columns)),
+		generator: rand.New(rand.NewSource(1)),
+	}
+}
+
+// Add records a row stored on pageID. Rows must be added in heap order.
+func (c *Collector) Add(pageID int64, row []types.Value) {
+	if pageID != c.lastPage {
+		c.pages++
+		c.lastPage = pageID
+	}
+	c.rows++
+	for i, value := range row {
+		if value.IsNull() {
+			c.nulls[i]++
+		}
+	}
+	if len(c.sample) < SampleSize {
+		c.sample = append(c.sample, row)
+	} else if j := c.generator.Int63n(c.rows); j < SampleSize {
+		c.sample[j] = row
+	}
+}
+
+// Stats returns the statistics of the rows added so far.
+func (c *Collector) Stats() *catalog.TableStats {
+	stats := &catalog.TableStats{
+		RowCount:  c.rows,
+		PageCount: c.pages,
+		Columns:   make(map[string]*catalog.ColumnStats, len(c.table.Columns)),
+	}
+	for i, col := range c.table.Columns {
+		var values []types.Value
+		for _, row := range c.sample {
+			if !row[i].IsNull() {
+				values = append(values, row[i])
+			}
+		}
+		sort.SliceStable(values, func(a, b int) bool {
+			cmp, err := types.Compare(values[a], values[b])
+			return err == nil && cmp < 0
+		})
+		stats.Columns[strings.ToLower(col.Name)] = &catalog.ColumnStats{
+			NullCount:     c.nulls[i],
+			DistinctCount: estimateDistinct(values, c.rows-c.nulls[i]),
+			Histogram:     histogram(values),
+		}
+	}
+	return stats
+}
+
+// estimateDistinct estimates the number of distinct values of a column from
+// the sorted non-NULL values of the sample, given the total number of
+// non-NULL values. It uses the estimator of Haas and Stokes that PostgreSQL
+// also uses: values seen once in the sample are assumed to be rare in the
+// table, values seen more often are assumed to all appear in the sample.
+func estimateDistinct(sorted []types.Value, total int64) int64 {
+	n := int64(len(sorted))
+	if n == 0 {
+		return 0
+	}
+	var distinct, singletons int64
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for j < len(sorted) {
+			if cmp, err := types.Compare(sorted[i], sorted[j]); err != nil || cmp != 0 {
+				break
+			}
+			j++
+		}
+		distinct++
+		if j-i == 1 {
+			singletons++
+		}
+		i = j
+	}
+	if n == total || singletons == 0 {
+		return distinct
+	}
+	if singletons == n {
+		// Every sampled value is unique: assume the column is too.
+		return total
+	}
+	estimate := float64(n*distinct) / (float64(n-singletons) + float64(singletons)*float64(n)/float64(total))
+	if estimate < float64(distinct) {
+		estimate = float64(distinct)
+	}
+	if estimate > float64(total) {
+		estimate = float64(total)
+	}
+	return int64(estimate + 0.5)
+}
+
+// histogram returns the bounds of equi-depth buckets over sorted values.
+func histogram(sorted []types.Value) []types.Value {
+	if len(sorted) == 0 {
+		return nil
+	}
+	buckets := HistogramBuckets
+	if len(sorted) <= buckets {
+		buckets = len(sorted) - 1
+	}
+	if buckets == 0 {
+		return []types.Value{sorted[0], sorted[0]}
+	}
+	bounds := make([]types.Value, buckets+1)
+	for i := range bounds {
+		bounds[i] = sorted[i*(len(sorted)-1)/buckets]
+	}
+	return bounds
+}
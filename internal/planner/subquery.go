@@ -0,0 +1,223 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/parser"
+)
+
+// decorrelate turns a conjunct of the WHERE clause testing a subquery into a
+// semi or anti join of root with the subquery's tables, returning nil when
+// it can't. x IN (SELECT y ...) holds for the rows of root with a match in
+// the subquery where x = y, EXISTS and NOT EXISTS for those with and without
+// a row where the subquery's condition holds. The join evaluates the
+// subquery once rather than for every row, and lets the planner pick any
+// join method.
+//
+// Only subqueries over tables, without grouping, aggregates or subqueries
+// of their own, are merged, and EXISTS only when it is correlated: otherwise
+// it is cheaper to run once. NOT IN isn't merged, since it is NULL rather
+// than TRUE when the subquery returns a NULL, which an anti join can't tell.
+func (q *Query) decorrelate(cat *catalog.Catalog, root Logical, cond parser.Expr) Logical {
+	joinType := parser.JoinSemi
+	var sub *parser.SelectStatement
+	var key parser.Expr
+	switch e := cond.(type) {
+	case *parser.InExpr:
+		if e.Subquery == nil || e.Not {
+			return nil
+		}
+		sub, key = e.Subquery, e.Expr
+	case *parser.ExistsExpr:
+		sub = e.Select
+	case *parser.UnaryExpr:
+		exists, ok := e.Operand.(*parser.ExistsExpr)
+		if !ok || e.Op != "NOT" {
+			return nil
+		}
+		sub, joinType = exists.Select, parser.JoinAnti
+	default:
+		return nil
+	}
+	if !mergeable(sub) || key != nil && (len(sub.Fields) != 1 || sub.Fields[0].Star) {
+		return nil
+	}
+
+	// The subquery's tables are renamed where their names are taken, and
+	// every column reference in it is qualified, so that its references
+	// keep their meaning among the relations of the query.
+	tables := parser.Tables(sub.From)
+	if len(q.Relations)+len(tables) > MaxRelations {
+		return nil
+	}
+	names := make(map[string]string, len(tables))
+	inner := make(map[string]*catalog.Table, len(tables))
+	for _, table := range tables {
+		def, err := cat.GetTable(table.Name)
+		if err != nil {
+			return nil
+		}
+		refName := strings.ToLower(table.RefName())
+		if _, dup := names[refName]; dup {
+			return nil
+		}
+		names[refName] = q.freeName(refName, names)
+		inner[refName] = def
+	}
+	correlated, failed := false, false
+	qualify := func(expr parser.Expr) parser.Expr {
+		return parser.RewriteExpr(expr, func(e parser.Expr) parser.Expr {
+			ref, ok := e.(*parser.ColumnRef)
+			if !ok || failed {
+				return nil
+			}
+			var matches []string
+			for refName, def := range inner {
+				if (ref.Table == "" || strings.EqualFold(ref.Table, refName)) && def.ColumnIndex(ref.Column) != -1 {
+					matches = append(matches, refName)
+				}
+			}
+			switch {
+			case len(matches) == 1:
+				return &parser.ColumnRef{Table: names[matches[0]], Column: ref.Column}
+			case len(matches) > 1 || ref.Table != "" && inner[strings.ToLower(ref.Table)] != nil:
+				failed = true
+				return nil
+			}
+			rel, _ := q.column(ref)
+			if rel == nil || rel.Merged {
+				failed = true
+				return nil
+			}
+			correlated = true
+			return &parser.ColumnRef{Table: rel.Name, Column: ref.Column}
+		})
+	}
+	from := renameTables(sub.From, names, qualify)
+	var conds []parser.Expr
+	if sub.Where != nil {
+		conds = parser.Conjuncts(qualify(sub.Where))
+	}
+	if key != nil {
+		// The key is qualified too, as the join evaluates it on rows that
+		// include the subquery's columns.
+		outer, ok := q.qualify(key)
+		if !ok {
+			return nil
+		}
+		conds = append(conds, &parser.BinaryExpr{Op: "=", Left: outer, Right: qualify(sub.Fields[0].Expr)})
+	}
+	if failed || key == nil && !correlated {
+		return nil
+	}
+
+	right, err := q.build(cat, from, nil)
+	if err != nil {
+		return nil
+	}
+	merged := relationsOf(right)
+	for _, rel := range q.Relations {
+		if merged.has(rel.ID) {
+			rel.Merged = true
+		}
+	}
+	return &LogicalJoin{Type: joinType, Left: root, Right: right, Cond: conds}
+}
+
+// qualify rewrites the column references of an expression over the query's
+// relations to name their relation. ok is false if one doesn't resolve.
+func (q *Query) qualify(expr parser.Expr) (qualified parser.Expr, ok bool) {
+	ok = true
+	qualified = parser.RewriteExpr(expr, func(e parser.Expr) parser.Expr {
+		ref, isRef := e.(*parser.ColumnRef)
+		if !isRef {
+			if parser.Subquery(e) != nil {
+				ok = false
+			}
+			return nil
+		}
+		rel, _ := q.column(ref)
+		if rel == nil {
+			ok = false
+			return nil
+		}
+		return &parser.ColumnRef{Table: rel.Name, Column: ref.Column}
+	})
+	return qualified, ok
+}
+
+// mergeable reports whether a subquery is simple enough to become a join:
+// it is a single SELECT reading tables of the catalog joined by inner joins
+// only, and has no WITH clause, grouping, aggregates, window functions,
+// LIMIT, OFFSET or subqueries.
+func mergeable(sub *parser.SelectStatement) bool {
+	var plain func(ref parser.TableRef) bool
+	plain = func(ref parser.TableRef) bool {
+		switch r := ref.(type) {
+		case *parser.TableName:
+			return r.CTE == nil
+		case *parser.Join:
+			return (r.Type == parser.JoinInner || r.Type == parser.JoinCross) && plain(r.Left) && plain(r.Right)
+		}
+		return false
+	}
+	if len(sub.With) > 0 || sub.Set != nil || sub.Limit != nil || sub.Offset != nil || !plain(sub.From) || len(sub.GroupBy) > 0 || sub.Having != nil {
+		return false
+	}
+	ok := true
+	for _, expr := range sub.Exprs() {
+		parser.WalkExpr(expr, func(e parser.Expr) bool {
+			if call, isCall := e.(*parser.FuncCall); isCall && (call.IsAggregate() || call.Over != nil) || parser.Subquery(e) != nil {
+				ok = false
+			}
+			return ok
+		})
+	}
+	return ok
+}
+
+// freeName returns name, or name followed by a number when a relation of the
+// query or one of the names already taken in renames uses it.
+func (q *Query) freeName(name string, renames map[string]string) string {
+	taken := func(candidate string) bool {
+		for _, rel := range q.Relations {
+			if rel.Name == candidate {
+				return true
+			}
+		}
+		for _, renamed := range renames {
+			if renamed == candidate {
+				return true
+			}
+		}
+		return false
+	}
+	candidate := name
+	for i := 1; taken(candidate); i++ {
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+	return candidate
+}
+
+// renameTables copies a FROM clause of tables and inner joins, giving each
+// table the alias names maps its name or alias to and qualifying the join
+// conditions.
+func renameTables(ref parser.TableRef, names map[string]string, qualify func(parser.Expr) parser.Expr) parser.TableRef {
+	switch r := ref.(type) {
+	case *parser.TableName:
+		return &parser.TableName{Name: r.Name, Alias: names[strings.ToLower(r.RefName())]}
+	case *parser.Join:
+		join := &parser.Join{
+			Type:  r.Type,
+			Left:  renameTables(r.Left, names, qualify),
+			Right: renameTables(r.Right, names, qualify),
+		}
+		if r.On != nil {
+			join.On = qualify(r.On)
+		}
+		return join
+	}
+	return ref
+}
@@ -0,0 +1,38 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PrintTable prints query results as an aligned text table.
+func PrintTable(columns []string, rows [][]string) {
+	widths := make([]int, len(columns))
+	for i, col := range columns {
+		widths[i] = len(col)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	printRow := func(cells []string) {
+		padded := make([]string, len(cells))
+		for i, cell := range cells {
+			padded[i] = cell + strings.Repeat(" ", widths[i]-len(cell))
+		}
+		fmt.Println(" " + strings.Join(padded, " | "))
+	}
+	printRow(columns)
+	separators := make([]string, len(columns))
+	for i, width := range widths {
+		separators[i] = strings.Repeat("-", width+2)
+	}
+	fmt.Println(strings.Join(separators, "+"))
+	for _, row := range rows {
+		printRow(row)
+	}
+	fmt.Printf("(%d rows)\n", len(rows))
+}
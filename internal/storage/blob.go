@@ -0,0 +1,110 @@
+package storage
+
+// A blob is an arbitrarily large byte string split into chunks, one record per
+// page, across a chain of pages. It is used for metadata such as the catalog.
+
+// ReadBlob reassembles the blob stored in the page chain starting at firstPageID.
+func ReadBlob(bp *BufferPool, firstPageID int64) ([]byte, error) {
+	var blob []byte
+	pageID := firstPageID
+	for pageID != InvalidPageID {
+		page, err := bp.FetchPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		if page.NumSlots() > 0 && !page.IsDeleted(0) {
+			chunk, err := page.RetrieveRecord(0)
+			if err != nil {
+				bp.UnpinPage(pageID, false)
+				return nil, err
+			}
+			blob = append(blob, chunk...)
+		}
+		next := page.NextPageID
+		if err := bp.UnpinPage(pageID, false); err != nil {
+			return nil, err
+		}
+		pageID = next
+	}
+	return blob, nil
+}
+
+// WriteBlob overwrites the blob stored in the page chain starting at
+// firstPageID, extending the chain with new pages when needed. Pages left over
+// from a previously longer blob are kept in the chain but emptied.
+func WriteBlob(bp *BufferPool, firstPageID int64, blob []byte) error {
+	chunkSize := MaxRecordSize()
+	pageID := firstPageID
+	for pageID != InvalidPageID {
+		page, err := bp.FetchPage(pageID)
+		if err != nil {
+			return err
+		}
+		// The page is latched while changed, so that no copy written back
+		// holds it half changed.
+		if err := bp.LatchPage(pageID, true); err != nil {
+			bp.UnpinPage(pageID, false)
+			return err
+		}
+		page.Reset()
+		if len(blob) > 0 {
+			n := chunkSize
+			if len(blob) < n {
+				n = len(blob)
+			}
+			if _, err := page.AddRecord(blob[:n]); err != nil {
+				bp.UnlatchPage(pageID, true)
+				bp.UnpinPage(pageID, true)
+				return err
+			}
+			blob = blob[n:]
+		}
+		next := page.NextPageID
+		if next == InvalidPageID && len(blob) > 0 {
+			newPageID, _, err := bp.NewPage()
+			if err != nil {
+				bp.UnlatchPage(pageID, true)
+				bp.UnpinPage(pageID, true)
+				return err
+			}
+			if err := bp.UnpinPage(newPageID, true); err != nil {
+				bp.UnlatchPage(pageID, true)
+				bp.UnpinPage(pageID, true)
+				return err
+			}
+			page.NextPageID = newPageID
+			next = newPageID
+		}
+		if err := bp.UnlatchPage(pageID, true); err != nil {
+			bp.UnpinPage(pageID, true)
+			return err
+		}
+		if err := bp.UnpinPage(pageID, true); err != nil {
+			return err
+		}
+		pageID = next
+	}
+	return nil
+}
+
+// FlushBlob writes the pages of the blob stored in the page chain starting at
+// firstPageID back to disk and syncs the file, making the blob durable
+// without flushing the rest of the buffer pool.
+func FlushBlob(bp *BufferPool, firstPageID int64) error {
+	pageID := firstPageID
+	for pageID != InvalidPageID {
+		page, err := bp.FetchPage(pageID)
+		if err != nil {
+			return err
+		}
+		next := page.NextPageID
+		if err := bp.UnpinPage(pageID, false); err != nil {
+			return err
+		}
+		if err := bp.FlushPage(pageID); err != nil {
+			return err
+		}
+		pageID = next
+	}
+	return bp.Sync()
+}
@@ -3,142 +3,889 @@ package storage
 import (
 	"errors"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // BufferPage wraps around the logical Page to include buffer-specific metadata.
 type BufferPage struct {
 	PageID   int64 // Unique identifier for the page
 	PageData *Page // The logical Page structure, defined in page.go
-	IsDirty  bool  // Indicates if the page has been modified
-	IsPinned bool  // Indicates if the page is currently being used
+
+	pins       atomic.Int32  // Number of users currently holding the page
+	dirty      atomic.Bool   // Indicates if the page has been modified
+	changes    atomic.Uint64 // Counts the unpins that dirtied the page
+	referenced atomic.Bool   // Set by hits, which leave the LRU order alone
+	latch      sync.RWMutex  // Taken with LatchPage, apart from pins
+	holders    atomic.Int32  // Running transactions that changed the page, see SetTransaction
+
+	// Disk I/O on a page runs without holding its shard's lock. io is set
+	// while the page is read in or written back, and closed when done.
+	// These fields change under the shard's exclusive lock.
+	io       chan struct{}
+	loading  bool // PageData is being read from disk
+	evicting bool // the page is written back to be evicted
 }
 
-// BufferPool holds the buffered pages in memory.
-type BufferPool struct {
+// PinCount returns the number of users currently holding the page.
+func (p *BufferPage) PinCount() int {
+	return int(p.pins.Load())
+}
+
+// IsDirty reports whether the page was modified since it was last written.
+func (p *BufferPage) IsDirty() bool {
+	return p.dirty.Load()
+}
+
+// TempPageFlag is set in the IDs of temporary pages. Temporary pages hold
+// intermediate results such as sorted runs; they live in a scratch file that
+// is discarded when the buffer pool is closed.
+const TempPageFlag int64 = 1 << 40
+
+// IsTempPage reports whether pageID refers to a temporary page.
+func IsTempPage(pageID int64) bool {
+	return pageID >= 0 && pageID&TempPageFlag != 0
+}
+
+// numShards is the number of partitions of the page table.
+const numShards = 16
+
+// poolShard is a partition of the page table, with its own lock and
+// replacement policy. Hits only take the read lock; adding, evicting and
+// removing pages take the write lock.
+type poolShard struct {
 	mu                sync.RWMutex
-	pool              map[int64]*BufferPage
-	capacity          int
-	diskFile          *os.File          // The file descriptor for the database file on disk
-	replacementPolicy ReplacementPolicy // Interface for the page replacement policy
+	pages             map[int64]*BufferPage
+	replacementPolicy ReplacementPolicy
+}
+
+// BufferPool holds the buffered pages in memory. Pages are spread over
+// shards by ID, so that fetches of different pages seldom wait for each
+// other; the capacity is shared by all shards.
+//
+// Pages changed by a running transaction are held in memory until it
+// releases them: there is no log to undo their changes after a crash, so
+// they must not reach the disk before the transaction commits. When held
+// and pinned pages fill the pool, it grows past its capacity.
+type BufferPool struct {
+	shards        [numShards]poolShard
+	capacity      int
+	frames        atomic.Int64  // Pages buffered, not counting those being evicted
+	hand          atomic.Uint32 // Shard the next eviction starts looking in
+	txn           atomic.Uint64 // Transaction holding the pages changed now, see SetTransaction
+	numHeld       atomic.Int64  // Pages held by running transactions
+	numPages      atomic.Int64  // Number of pages allocated in the database file
+	diskFile      *os.File      // The file descriptor for the database file on disk
+	numTempPages  atomic.Int64  // Number of pages allocated in the scratch file
+	stats         bufferCounters
+	sync          groupSync     // Shares fsyncs between concurrent callers of Sync
+	mu            sync.Mutex    // Guards the fields below
+	tempFile      *os.File      // Scratch file backing temporary pages, created on first use
+	freeTempPages []int64       // Temporary pages released and available for reuse
+	stopWriter    chan struct{} // Closed to stop the background writer
+	writerDone    chan struct{} // Closed once the background writer stopped
+
+	// held lists the pages held by each running transaction, by ID. It is
+	// guarded by mu as well.
+	held map[uint64]map[int64]*BufferPage
 }
 
-// NewBufferPool initializes a new BufferPool.
+// BufferStats counts the page accesses of a buffer pool. A fetch is a hit
+// when the page is already buffered and a miss otherwise; each miss reads
+// the page from disk. Writes counts dirty pages written back.
+type BufferStats struct {
+	Hits   int64
+	Misses int64
+	Reads  int64
+	Writes int64
+}
+
+// bufferCounters holds the counters of BufferStats, updated without locks.
+type bufferCounters struct {
+	hits, misses, reads, writes atomic.Int64
+}
+
+// Sub returns the accesses counted in s but not in earlier.
+func (s BufferStats) Sub(earlier BufferStats) BufferStats {
+	return BufferStats{
+		Hits:   s.Hits - earlier.Hits,
+		Misses: s.Misses - earlier.Misses,
+		Reads:  s.Reads - earlier.Reads,
+		Writes: s.Writes - earlier.Writes,
+	}
+}
+
+// Add returns the sum of the accesses counted in s and other.
+func (s BufferStats) Add(other BufferStats) BufferStats {
+	return BufferStats{
+		Hits:   s.Hits + other.Hits,
+		Misses: s.Misses + other.Misses,
+		Reads:  s.Reads + other.Reads,
+		Writes: s.Writes + other.Writes,
+	}
+}
+
+// NewBufferPool initializes a new BufferPool, creating the database file if needed.
 func NewBufferPool(diskFilePath string, capacity int) (*BufferPool, error) {
-	file, err := os.OpenFile(diskFilePath, os.O_RDWR, 0666)
+	if capacity <= 0 {
+		return nil, errors.New("buffer pool capacity must be positive")
+	}
+	file, err := os.OpenFile(diskFilePath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	return &BufferPool{
-		pool:              make(map[int64]*BufferPage),
-		capacity:          capacity,
-		diskFile:          file,
-		replacementPolicy: NewLRUPolicy(), // Initialize LRU or any other policy
-	}, nil
+	bp := &BufferPool{
+		capacity: capacity,
+		diskFile: file,
+		held:     make(map[uint64]map[int64]*BufferPage),
+	}
+	for i := range bp.shards {
+		bp.shards[i].pages = make(map[int64]*BufferPage)
+		bp.shards[i].replacementPolicy = NewLRUPolicy() // Initialize LRU or any other policy
+	}
+	bp.numPages.Store(info.Size() / PageSize)
+	bp.sync.cond = sync.NewCond(&bp.sync.mu)
+	return bp, nil
 }
 
-// FetchPage retrieves a page from the buffer pool or disk.
-func (bp *BufferPool) FetchPage(pageID int64) (*Page, error) {
+// NumPages returns the number of pages allocated in the database file.
+func (bp *BufferPool) NumPages() int64 {
+	return bp.numPages.Load()
+}
+
+// NumTempPages returns the number of temporary pages allocated so far, in
+// use or freed for reuse.
+func (bp *BufferPool) NumTempPages() int64 {
+	return bp.numTempPages.Load()
+}
+
+// TempPagesInUse returns the number of temporary pages allocated and not
+// freed.
+func (bp *BufferPool) TempPagesInUse() int64 {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
+	return bp.numTempPages.Load() - int64(len(bp.freeTempPages))
+}
+
+// Stats returns the page accesses counted since the pool was opened.
+func (bp *BufferPool) Stats() BufferStats {
+	return BufferStats{
+		Hits:   bp.stats.hits.Load(),
+		Misses: bp.stats.misses.Load(),
+		Reads:  bp.stats.reads.Load(),
+		Writes: bp.stats.writes.Load(),
+	}
+}
 
-	// If page is in pool, return it
-	if page, exists := bp.pool[pageID]; exists {
-		page.IsPinned = true // Pin the page, indicating it is in use
+// shardFor returns the shard holding pageID.
+func (bp *BufferPool) shardFor(pageID int64) *poolShard {
+	return &bp.shards[uint64(pageID)%numShards]
+}
+
+// FetchPage retrieves a page from the buffer pool or disk and pins it.
+// Every successful FetchPage must be paired with an UnpinPage. A hit only
+// takes the read lock of the page's shard. A miss reads the page from disk
+// without holding any lock; concurrent fetches of the same page wait for
+// the read.
+func (bp *BufferPool) FetchPage(pageID int64) (*Page, error) {
+	shard := bp.shardFor(pageID)
+	shard.mu.RLock()
+	if page, exists := shard.pages[pageID]; exists && !page.loading && !page.evicting {
+		page.pins.Add(1) // Pin the page, indicating it is in use
+		page.referenced.Store(true)
+		shard.mu.RUnlock()
+		bp.stats.hits.Add(1)
 		return page.PageData, nil
 	}
+	shard.mu.RUnlock()
 
-	// If not, and if the pool is full, evict a page
-	if len(bp.pool) >= bp.capacity {
-		err := bp.evictPage()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	reserved := false
+	for {
+		if page, exists := shard.pages[pageID]; exists {
+			if page.loading || page.evicting {
+				shard.waitIO(page)
+				continue
+			}
+			if reserved {
+				bp.frames.Add(-1)
+			}
+			page.pins.Add(1)
+			page.referenced.Store(true)
+			bp.stats.hits.Add(1)
+			return page.PageData, nil
+		}
+		if !bp.pageExists(pageID) {
+			if reserved {
+				bp.frames.Add(-1)
+			}
+			return nil, errors.New("page does not exist")
+		}
+		if reserved || bp.reserveFrame() {
+			break
+		}
+		// If not, and if the pool is full, evict a page. Another fetch
+		// may read the page in meanwhile, so look again.
+		shard.mu.Unlock()
+		err := bp.takeFrame()
+		shard.mu.Lock()
 		if err != nil {
 			return nil, err
 		}
+		reserved = true
 	}
+	bp.stats.misses.Add(1)
 
-	// Read page from disk
-	pageData, err := bp.readPageFromDisk(pageID)
+	// Read page from disk into a frame that other fetches wait on
+	page := shard.addToPool(pageID, nil, false)
+	page.loading = true
+	page.io = make(chan struct{})
+	file, offset := bp.fileFor(pageID)
+	shard.mu.Unlock()
+	pageData, err := readPage(file, offset)
+	shard.mu.Lock()
+	close(page.io)
+	page.io = nil
+	page.loading = false
 	if err != nil {
+		shard.removeFromPool(pageID)
+		bp.frames.Add(-1)
 		return nil, err
 	}
+	bp.stats.reads.Add(1)
+	page.PageData = pageData
+	return pageData, nil
+}
+
+// NewPage allocates a fresh page at the end of the database file and pins it.
+// The empty page is written at once, so that it is on disk before any page
+// linking to it and the file has no holes.
+func (bp *BufferPool) NewPage() (int64, *Page, error) {
+	if err := bp.takeFrame(); err != nil {
+		return InvalidPageID, nil, err
+	}
+	pageID := bp.numPages.Add(1) - 1
+	pageData := NewPage()
+	if _, err := bp.diskFile.WriteAt(pageData.Serialize(), pageID*int64(PageSize)); err != nil {
+		bp.frames.Add(-1)
+		return InvalidPageID, nil, err
+	}
+	shard := bp.shardFor(pageID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.addToPool(pageID, pageData, false)
+	return pageID, pageData, nil
+}
 
-	// Add the page to the pool
-	bufferPage := &BufferPage{
+// NewTempPage allocates a temporary page, reusing a released one if possible,
+// and pins it.
+func (bp *BufferPool) NewTempPage() (int64, *Page, error) {
+	bp.mu.Lock()
+	if bp.tempFile == nil {
+		file, err := os.CreateTemp("", "simpledb-*.tmp")
+		if err != nil {
+			bp.mu.Unlock()
+			return InvalidPageID, nil, err
+		}
+		bp.tempFile = file
+	}
+	bp.mu.Unlock()
+	if err := bp.takeFrame(); err != nil {
+		return InvalidPageID, nil, err
+	}
+
+	var pageID int64
+	bp.mu.Lock()
+	if n := len(bp.freeTempPages); n > 0 {
+		pageID = bp.freeTempPages[n-1]
+		bp.freeTempPages = bp.freeTempPages[:n-1]
+	} else {
+		pageID = TempPageFlag | (bp.numTempPages.Add(1) - 1)
+	}
+	bp.mu.Unlock()
+	pageData := NewPage()
+	shard := bp.shardFor(pageID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.addToPool(pageID, pageData, true)
+	return pageID, pageData, nil
+}
+
+// FreeTempPage releases a temporary page for reuse. Its contents are discarded
+// without being written back.
+func (bp *BufferPool) FreeTempPage(pageID int64) error {
+	if !IsTempPage(pageID) {
+		return errors.New("page is not a temporary page")
+	}
+	shard := bp.shardFor(pageID)
+	shard.mu.Lock()
+	// A write still under way could land after those of the page's next use.
+	page, exists := shard.pages[pageID]
+	for exists && page.io != nil {
+		shard.waitIO(page)
+		page, exists = shard.pages[pageID]
+	}
+	if exists {
+		if page.PinCount() > 0 {
+			shard.mu.Unlock()
+			return errors.New("cannot free a pinned page")
+		}
+		shard.removeFromPool(pageID)
+		bp.frames.Add(-1)
+	}
+	shard.mu.Unlock()
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.freeTempPages = append(bp.freeTempPages, pageID)
+	return nil
+}
+
+// pageExists reports whether pageID has been allocated.
+func (bp *BufferPool) pageExists(pageID int64) bool {
+	if IsTempPage(pageID) {
+		return pageID&^TempPageFlag < bp.numTempPages.Load()
+	}
+	return pageID >= 0 && pageID < bp.numPages.Load()
+}
+
+// addToPool adds a pinned page to the shard. The caller must hold s.mu and
+// have reserved a frame for it.
+func (s *poolShard) addToPool(pageID int64, pageData *Page, dirty bool) *BufferPage {
+	page := &BufferPage{
 		PageID:   pageID,
 		PageData: pageData,
-		IsPinned: true,
 	}
-	bp.pool[pageID] = bufferPage
-	return pageData, nil
+	page.pins.Store(1)
+	page.dirty.Store(dirty)
+	s.pages[pageID] = page
+	s.replacementPolicy.PageAccessed(pageID)
+	return page
+}
+
+// removeFromPool removes a page from the shard. The caller must hold s.mu.
+func (s *poolShard) removeFromPool(pageID int64) {
+	delete(s.pages, pageID)
+	s.replacementPolicy.PageRemoved(pageID)
+}
+
+// waitIO waits for the disk I/O under way on a page. The caller must hold
+// s.mu, which is released while waiting.
+func (s *poolShard) waitIO(page *BufferPage) {
+	done := page.io
+	s.mu.Unlock()
+	<-done
+	s.mu.Lock()
+}
+
+// reserveFrame takes a frame for a page about to be added, unless the pool
+// is full.
+func (bp *BufferPool) reserveFrame() bool {
+	for {
+		n := bp.frames.Load()
+		if n >= int64(bp.capacity) {
+			return false
+		}
+		if bp.frames.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// takeFrame reserves a frame for a page about to be added, evicting pages
+// until one is free. If every other page is pinned or held by a running
+// transaction, and some are held, the pool grows past its capacity instead.
+// The caller must hold no shard's lock.
+func (bp *BufferPool) takeFrame() error {
+	for !bp.reserveFrame() {
+		err := bp.evictPage()
+		if err == errNoVictim && bp.numHeld.Load() > 0 {
+			bp.frames.Add(1)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetTransaction makes the pages changed from now on, until it is called
+// again, held by transaction id; 0 holds them for nobody. A held page is
+// neither evicted nor written back by the background writer or WritePages
+// until the transaction releases it with ReleaseTransaction. The caller
+// must see to it that one transaction changes pages at a time. Temporary
+// pages are never held.
+func (bp *BufferPool) SetTransaction(id uint64) {
+	bp.txn.Store(id)
+}
+
+// hold makes the transaction set with SetTransaction, if any, hold a page
+// it is changing.
+func (bp *BufferPool) hold(page *BufferPage) {
+	id := bp.txn.Load()
+	if id == 0 || IsTempPage(page.PageID) {
+		return
+	}
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	pages := bp.held[id]
+	if pages == nil {
+		pages = make(map[int64]*BufferPage)
+		bp.held[id] = pages
+	}
+	if _, ok := pages[page.PageID]; ok {
+		return
+	}
+	pages[page.PageID] = page
+	if page.holders.Add(1) == 1 {
+		bp.numHeld.Add(1)
+	}
+}
+
+// WriteTransaction writes back the dirty pages held by transaction id,
+// without syncing the file. They stay held.
+func (bp *BufferPool) WriteTransaction(id uint64) error {
+	bp.mu.Lock()
+	pageIDs := make([]int64, 0, len(bp.held[id]))
+	for pageID := range bp.held[id] {
+		pageIDs = append(pageIDs, pageID)
+	}
+	bp.mu.Unlock()
+	sort.Slice(pageIDs, func(i, j int) bool { return pageIDs[i] < pageIDs[j] })
+	for _, pageID := range pageIDs {
+		if err := bp.FlushPage(pageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseTransaction lets the pages held by transaction id be written back
+// like any other, once it committed and they were written, or once it
+// rolled back and its changes were undone.
+func (bp *BufferPool) ReleaseTransaction(id uint64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for _, page := range bp.held[id] {
+		if page.holders.Add(-1) == 0 {
+			bp.numHeld.Add(-1)
+		}
+	}
+	delete(bp.held, id)
+}
+
+// Holds reports whether transaction id holds any page, that is, whether it
+// changed any since it started.
+func (bp *BufferPool) Holds(id uint64) bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return len(bp.held[id]) > 0
+}
+
+// UnpinPage releases a pin taken by FetchPage or NewPage, marking the page
+// dirty if the caller modified it.
+func (bp *BufferPool) UnpinPage(pageID int64, isDirty bool) error {
+	shard := bp.shardFor(pageID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	page, exists := shard.pages[pageID]
+	if !exists {
+		return errors.New("page not found in buffer pool")
+	}
+	if isDirty {
+		bp.hold(page)
+		page.changes.Add(1)
+		page.dirty.Store(true)
+	}
+	for {
+		pins := page.pins.Load()
+		if pins <= 0 {
+			return errors.New("page is not pinned")
+		}
+		if page.pins.CompareAndSwap(pins, pins-1) {
+			return nil
+		}
+	}
+}
+
+// LatchPage latches a page the caller has pinned: shared to read it, or
+// exclusive to change it. Pins only keep a page in the pool; latches keep
+// readers, and the copies written back to disk, from seeing it half
+// changed. Every LatchPage must be paired with an UnlatchPage before the
+// page is unpinned. An exclusive latch makes the page held by the
+// transaction set with SetTransaction before it is taken, so that no copy
+// of the change is written back until the page is released.
+func (bp *BufferPool) LatchPage(pageID int64, exclusive bool) error {
+	page, err := bp.pinnedPage(pageID)
+	if err != nil {
+		return err
+	}
+	if exclusive {
+		bp.hold(page)
+		page.latch.Lock()
+	} else {
+		page.latch.RLock()
+	}
+	return nil
+}
+
+// UnlatchPage releases a latch taken by LatchPage.
+func (bp *BufferPool) UnlatchPage(pageID int64, exclusive bool) error {
+	page, err := bp.pinnedPage(pageID)
+	if err != nil {
+		return err
+	}
+	if exclusive {
+		page.latch.Unlock()
+	} else {
+		page.latch.RUnlock()
+	}
+	return nil
+}
+
+// pinnedPage returns the buffered page of pageID, which must be pinned.
+func (bp *BufferPool) pinnedPage(pageID int64) (*BufferPage, error) {
+	shard := bp.shardFor(pageID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	page, exists := shard.pages[pageID]
+	if !exists || page.PinCount() == 0 {
+		return nil, errors.New("page is not pinned")
+	}
+	return page, nil
 }
 
 // GetBufferPage retrieves a buffered page by its page ID.
 func (bp *BufferPool) GetBufferPage(pageID int64) (*BufferPage, error) {
-	bp.mu.RLock()
-	defer bp.mu.RUnlock()
+	shard := bp.shardFor(pageID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	bufferPage, exists := bp.pool[pageID]
+	bufferPage, exists := shard.pages[pageID]
 	if !exists {
 		return nil, errors.New("page not found in buffer pool")
 	}
 	return bufferPage, nil
 }
 
-// FlushPage writes a page back to disk if it's dirty.
+// FlushPage writes a page back to disk if it's dirty, even if a running
+// transaction holds it.
 func (bp *BufferPool) FlushPage(pageID int64) error {
-	bp.mu.Lock()
-	defer bp.mu.Unlock()
+	shard := bp.shardFor(pageID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	page, exists := bp.pool[pageID]
-	if !exists {
-		return errors.New("page not found in buffer pool")
+	return bp.flushPage(shard, pageID, true)
+}
+
+// FlushAllPages writes every dirty page not held by a running transaction
+// back to disk and syncs the file.
+func (bp *BufferPool) FlushAllPages() error {
+	if err := bp.WritePages(); err != nil {
+		return err
 	}
+	return bp.Sync()
+}
 
-	if page.IsDirty {
-		err := bp.writePageToDisk(page.PageID, page.PageData)
-		if err != nil {
+// WritePages writes every page dirty when it is called, and not held by a
+// running transaction, back to disk, without syncing the file.
+func (bp *BufferPool) WritePages() error {
+	for i := range bp.shards {
+		if err := bp.writeShard(&bp.shards[i]); err != nil {
 			return err
 		}
-		page.IsDirty = false
 	}
+	return nil
+}
+
+// writeShard writes the dirty pages of a shard back to disk.
+func (bp *BufferPool) writeShard(s *poolShard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	var pageIDs []int64
+	for pageID, page := range s.pages {
+		// Temporary pages never need to survive a crash.
+		if (page.IsDirty() || page.io != nil) && !IsTempPage(pageID) && page.holders.Load() == 0 {
+			pageIDs = append(pageIDs, pageID)
+		}
+	}
+	for _, pageID := range pageIDs {
+		if _, exists := s.pages[pageID]; !exists {
+			// Evicted, and so written, since.
+			continue
+		}
+		if err := bp.flushPage(s, pageID, false); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// writePageToDisk writes a given page to disk.
-func (bp *BufferPool) writePageToDisk(pageID int64, page *Page) error {
-	// Serialize the page data
-	pageData := page.Serialize()
+// Sync makes the pages written to the database file so far durable.
+// Concurrent callers share fsyncs: one arriving while an fsync runs waits
+// for the next, which a single caller runs for all of those that arrived
+// meanwhile. This is how concurrent commits are grouped.
+func (bp *BufferPool) Sync() error {
+	return bp.sync.run(bp.diskFile.Sync)
+}
+
+// groupSync runs fsyncs on behalf of concurrent callers.
+type groupSync struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	running bool
+	started uint64 // fsyncs started
+	done    uint64 // fsyncs finished
+	err     error  // error of the last finished fsync
+}
+
+// run returns once an fsync that started after it was called has finished,
+// running it with fsync unless another caller does.
+func (g *groupSync) run(fsync func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ticket := g.started + 1
+	for g.done < ticket {
+		if g.running {
+			g.cond.Wait()
+			continue
+		}
+		g.running = true
+		g.started++
+		n := g.started
+		g.mu.Unlock()
+		err := fsync()
+		g.mu.Lock()
+		g.running = false
+		g.done = n
+		g.err = err
+		g.cond.Broadcast()
+	}
+	return g.err
+}
+
+// StartWriter starts a background writer that, every delay, writes back the
+// dirty pages among the next to be evicted, up to an eighth of the pool, so
+// that evictions seldom have to wait for a write. Close stops it.
+func (bp *BufferPool) StartWriter(delay time.Duration) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.stopWriter != nil {
+		return
+	}
+	bp.stopWriter = make(chan struct{})
+	bp.writerDone = make(chan struct{})
+	go bp.runWriter(delay, bp.stopWriter, bp.writerDone)
+}
+
+func (bp *BufferPool) runWriter(delay time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for i := range bp.shards {
+				bp.writeAhead(&bp.shards[i], bp.capacity/(8*numShards)+1)
+			}
+		}
+	}
+}
+
+// writeAhead writes back the dirty, unpinned pages among the next n of a
+// shard to be evicted, which running transactions don't hold. A failed write leaves its page dirty for the
+// eviction or flush that will report the error.
+func (bp *BufferPool) writeAhead(s *poolShard, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pageID := range s.replacementPolicy.NextVictims(s.pages, n) {
+		page, exists := s.pages[pageID]
+		// Temporary pages are often freed before they need writing.
+		if !exists || !page.IsDirty() || page.PinCount() > 0 || page.io != nil || IsTempPage(pageID) {
+			continue
+		}
+		if bp.writeBack(s, page, false) != nil {
+			return
+		}
+	}
+}
 
-	// Write to disk at the correct offset
-	_, err := bp.diskFile.WriteAt(pageData, pageID*int64(PageSize))
+// Close stops the background writer, flushes all dirty pages, closes the
+// database file and removes the scratch file. The changes of transactions
+// still holding pages are lost.
+func (bp *BufferPool) Close() error {
+	bp.mu.Lock()
+	stop, done := bp.stopWriter, bp.writerDone
+	bp.stopWriter = nil
+	bp.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	err := bp.FlushAllPages()
+	if bp.tempFile != nil {
+		bp.tempFile.Close()
+		os.Remove(bp.tempFile.Name())
+	}
+	if closeErr := bp.diskFile.Close(); err == nil {
+		err = closeErr
+	}
 	return err
 }
 
-// readPageFromDisk reads a page from disk.
-func (bp *BufferPool) readPageFromDisk(pageID int64) (*Page, error) {
+// fileFor returns the file and byte offset backing a page.
+func (bp *BufferPool) fileFor(pageID int64) (*os.File, int64) {
+	if IsTempPage(pageID) {
+		return bp.tempFile, (pageID &^ TempPageFlag) * int64(PageSize)
+	}
+	return bp.diskFile, pageID * int64(PageSize)
+}
+
+// flushPage writes a page back to disk if it's dirty, once the I/O under way
+// on it is done. held says whether to write it if a running transaction
+// holds it. The caller must hold s.mu, which is released during I/O.
+func (bp *BufferPool) flushPage(s *poolShard, pageID int64, held bool) error {
+	page, exists := s.pages[pageID]
+	if !exists {
+		return errors.New("page not found in buffer pool")
+	}
+	// A write under way may have copied the page before its latest
+	// changes, so it is written again once done.
+	for page.io != nil {
+		s.waitIO(page)
+		if s.pages[pageID] != page {
+			// Evicted, and so written back, meanwhile.
+			return nil
+		}
+	}
+	if !page.IsDirty() {
+		return nil
+	}
+	return bp.writeBack(s, page, held)
+}
+
+// writeBack writes a copy of a page to disk, marking it clean unless it was
+// changed during the write. The copy is made under a shared latch, so that
+// it holds no change half made. Unless held is set, a page a running
+// transaction held by the time the latch is taken is left dirty instead.
+// The caller must hold s.mu, which is released during the write; the page
+// must have no I/O under way.
+func (bp *BufferPool) writeBack(s *poolShard, page *BufferPage, held bool) error {
+	file, offset := bp.fileFor(page.PageID)
+	page.io = make(chan struct{})
+	s.mu.Unlock()
+	page.latch.RLock()
+	if !held && page.holders.Load() > 0 {
+		page.latch.RUnlock()
+		s.mu.Lock()
+		close(page.io)
+		page.io = nil
+		return nil
+	}
+	changes := page.changes.Load()
+	pageData := page.PageData.Serialize()
+	page.latch.RUnlock()
+	_, err := file.WriteAt(pageData, offset)
+	s.mu.Lock()
+	close(page.io)
+	page.io = nil
+	if err != nil {
+		return err
+	}
+	bp.stats.writes.Add(1)
+	if page.changes.Load() == changes {
+		page.dirty.Store(false)
+	}
+	return nil
+}
+
+// readPage reads the page stored at offset in file.
+func readPage(file *os.File, offset int64) (*Page, error) {
 	pageData := make([]byte, PageSize)
-	_, err := bp.diskFile.ReadAt(pageData, pageID*int64(PageSize))
+	_, err := file.ReadAt(pageData, offset)
 	if err != nil {
 		return nil, err
 	}
-	// Assuming DeserializePage is a function defined in page.go that converts bytes to a Page structure
 	return DeserializePage(pageData)
 }
 
-// evictPage selects and evicts a page from the buffer pool based on the replacement policy.
+// errNoVictim is returned by evictPage when every page is pinned or held.
+var errNoVictim = errors.New("no page to evict")
+
+// evictPage evicts a page chosen by the replacement policy of a shard,
+// trying each shard in turn from one that changes at every eviction. If
+// every unpinned page has I/O under way, it waits for one to be done and
+// tries again. The caller must hold no shard's lock.
 func (bp *BufferPool) evictPage() error {
-	evictPageID := bp.replacementPolicy.ChoosePageToEvict(bp.pool)
+	for {
+		var busy chan struct{}
+		start := bp.hand.Add(1)
+		for i := uint32(0); i < numShards; i++ {
+			shard := &bp.shards[(start+i)%numShards]
+			shard.mu.Lock()
+			evicted, io, err := bp.evictFrom(shard)
+			shard.mu.Unlock()
+			if evicted || err != nil {
+				return err
+			}
+			if busy == nil {
+				busy = io
+			}
+		}
+		if busy == nil {
+			return errNoVictim
+		}
+		<-busy
+	}
+}
+
+// evictFrom evicts a page of a shard, reporting whether it found one. A
+// dirty page is written back first, releasing s.mu meanwhile; fetches of
+// the page wait until it is gone and then read it again. If the shard has
+// no page to evict, the I/O of an unpinned page, if any, is returned. The
+// caller must hold s.mu.
+func (bp *BufferPool) evictFrom(s *poolShard) (bool, chan struct{}, error) {
+	evictPageID := s.replacementPolicy.ChoosePageToEvict(s.pages)
 	if evictPageID == -1 {
-		return errors.New("no page to evict")
+		for _, page := range s.pages {
+			if page.io != nil && page.PinCount() == 0 && page.holders.Load() == 0 {
+				return false, page.io, nil
+			}
+		}
+		return false, nil, nil
 	}
 
-	err := bp.FlushPage(evictPageID)
-	if err != nil {
-		return err
+	// The frame is free for other pages as soon as the victim is chosen.
+	bp.frames.Add(-1)
+	page := s.pages[evictPageID]
+	if page.IsDirty() {
+		// Evicting keeps the page from being fetched, and so from being
+		// held, until it is gone.
+		page.evicting = true
+		err := bp.writeBack(s, page, true)
+		page.evicting = false
+		if err != nil {
+			bp.frames.Add(1)
+			s.replacementPolicy.PageAccessed(evictPageID)
+			return false, nil, err
+		}
 	}
 
-	delete(bp.pool, evictPageID)
-	return nil
+	s.removeFromPool(evictPageID)
+	return true, nil, nil
 }
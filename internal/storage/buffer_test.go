@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createFile creates a database file of the given number of pages, each
+// holding an 8-byte counter at zero in its first record.
+func createFile(t testing.TB, pages int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	bp, err := NewBufferPool(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < pages; i++ {
+		pageID, page, err := bp.NewPage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := page.AddRecord(make([]byte, 8)); err != nil {
+			t.Fatal(err)
+		}
+		if err := bp.UnpinPage(pageID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// increment adds one to the counter of a page under an exclusive latch.
+func increment(bp *BufferPool, pageID int64) error {
+	page, err := bp.FetchPage(pageID)
+	if err != nil {
+		return err
+	}
+	if err := bp.LatchPage(pageID, true); err != nil {
+		bp.UnpinPage(pageID, false)
+		return err
+	}
+	record, err := page.RetrieveRecord(0)
+	if err == nil {
+		binary.LittleEndian.PutUint64(record, binary.LittleEndian.Uint64(record)+1)
+	}
+	if unlatchErr := bp.UnlatchPage(pageID, true); err == nil {
+		err = unlatchErr
+	}
+	if unpinErr := bp.UnpinPage(pageID, true); err == nil {
+		err = unpinErr
+	}
+	return err
+}
+
+// counter reads the counter of a page.
+func counter(bp *BufferPool, pageID int64) (uint64, error) {
+	page, err := bp.FetchPage(pageID)
+	if err != nil {
+		return 0, err
+	}
+	defer bp.UnpinPage(pageID, false)
+	record, err := page.RetrieveRecord(0)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(record), nil
+}
+
+// Concurrent changes to pages that keep being evicted and written back by
+// the background writer must all reach the disk.
+func TestConcurrentUpdatesUnderEviction(t *testing.T) {
+	const pages, workers, increments = 64, 8, 2000
+	path := createFile(t, pages)
+	bp, err := NewBufferPool(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.StartWriter(time.Millisecond)
+
+	var want [pages]atomic.Uint64
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < increments; i++ {
+				pageID := rng.Int63n(pages)
+				if err := increment(bp, pageID); err != nil {
+					errs <- err
+					return
+				}
+				want[pageID].Add(1)
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bp, err = NewBufferPool(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bp.Close()
+	for pageID := int64(0); pageID < pages; pageID++ {
+		got, err := counter(bp, pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want[pageID].Load() {
+			t.Errorf("page %d: counter is %d, want %d", pageID, got, want[pageID].Load())
+		}
+	}
+}
+
+// benchmarkFetchPage fetches and unpins random pages of a file of pages
+// pages from parallel goroutines through a pool of capacity frames, taking
+// a shared latch on each if latch is set.
+func benchmarkFetchPage(b *testing.B, pages, capacity int, latch bool) {
+	path := createFile(b, pages)
+	bp, err := NewBufferPool(path, capacity)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bp.Close()
+	// Warm the pool so that hits are measured from the start.
+	for pageID := int64(0); pageID < int64(capacity) && pageID < int64(pages); pageID++ {
+		if _, err := bp.FetchPage(pageID); err != nil {
+			b.Fatal(err)
+		}
+		if err := bp.UnpinPage(pageID, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var seed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewSource(seed.Add(1)))
+		for pb.Next() {
+			pageID := rng.Int63n(int64(pages))
+			if _, err := bp.FetchPage(pageID); err != nil {
+				b.Error(err)
+				return
+			}
+			if latch {
+				if err := bp.LatchPage(pageID, false); err != nil {
+					b.Error(err)
+				}
+				if err := bp.UnlatchPage(pageID, false); err != nil {
+					b.Error(err)
+				}
+			}
+			if err := bp.UnpinPage(pageID, false); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// Every page fits in the pool, so fetches only pin and unpin.
+func BenchmarkFetchPageHit(b *testing.B) {
+	benchmarkFetchPage(b, 1024, 1024, false)
+}
+
+func BenchmarkFetchPageHitLatched(b *testing.B) {
+	benchmarkFetchPage(b, 1024, 1024, true)
+}
+
+// The pool holds an eighth of the pages, so most fetches evict a page and
+// read another from disk.
+func BenchmarkFetchPageMiss(b *testing.B) {
+	benchmarkFetchPage(b, 1024, 1024/8+1, false)
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RID identifies a record by the page it lives on and its slot in that page.
+type RID struct {
+	PageID int64
+	Slot   int
+}
+
+// Int64 packs the RID into a single integer, used as the row ID.
+func (rid RID) Int64() int64 {
+	return rid.PageID<<16 | int64(rid.Slot)
+}
+
+func (rid RID) String() string {
+	return fmt.Sprintf("(%d,%d)", rid.PageID, rid.Slot)
+}
+
+// TableHeap stores the records of a table in a chain of pages linked through
+// Page.NextPageID, starting at FirstPageID.
+type TableHeap struct {
+	bp          *BufferPool
+	FirstPageID int64
+	lastPageID  int64
+	temp        bool // pages are temporary pages, see CreateTempHeap
+}
+
+// CreateTableHeap allocates the first page of a new heap.
+func CreateTableHeap(bp *BufferPool) (*TableHeap, error) {
+	pageID, _, err := bp.NewPage()
+	if err != nil {
+		return nil, err
+	}
+	if err := bp.UnpinPage(pageID, true); err != nil {
+		return nil, err
+	}
+	return &TableHeap{bp: bp, FirstPageID: pageID, lastPageID: pageID}, nil
+}
+
+// CreateTempHeap allocates a heap on temporary pages, used to spill
+// intermediate results to disk. It must be released with Free.
+func CreateTempHeap(bp *BufferPool) (*TableHeap, error) {
+	pageID, _, err := bp.NewTempPage()
+	if err != nil {
+		return nil, err
+	}
+	if err := bp.UnpinPage(pageID, true); err != nil {
+		return nil, err
+	}
+	return &TableHeap{bp: bp, FirstPageID: pageID, lastPageID: pageID, temp: true}, nil
+}
+
+// OpenTableHeap opens an existing heap starting at firstPageID.
+func OpenTableHeap(bp *BufferPool, firstPageID int64) *TableHeap {
+	return &TableHeap{bp: bp, FirstPageID: firstPageID, lastPageID: InvalidPageID}
+}
+
+func (h *TableHeap) newPage() (int64, *Page, error) {
+	if h.temp {
+		return h.bp.NewTempPage()
+	}
+	return h.bp.NewPage()
+}
+
+// fetch pins and latches a page of the heap, exclusive to change it.
+func (h *TableHeap) fetch(pageID int64, exclusive bool) (*Page, error) {
+	page, err := h.bp.FetchPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.bp.LatchPage(pageID, exclusive); err != nil {
+		h.bp.UnpinPage(pageID, false)
+		return nil, err
+	}
+	return page, nil
+}
+
+// release unlatches and unpins a page taken with fetch.
+func (h *TableHeap) release(pageID int64, exclusive, dirty bool) error {
+	if err := h.bp.UnlatchPage(pageID, exclusive); err != nil {
+		return err
+	}
+	return h.bp.UnpinPage(pageID, dirty)
+}
+
+// Free releases every page of a temporary heap.
+func (h *TableHeap) Free() error {
+	if !h.temp {
+		return errors.New("only temporary heaps can be freed")
+	}
+	pageID := h.FirstPageID
+	for pageID != InvalidPageID {
+		page, err := h.fetch(pageID, false)
+		if err != nil {
+			return err
+		}
+		next := page.NextPageID
+		if err := h.release(pageID, false, false); err != nil {
+			return err
+		}
+		if err := h.bp.FreeTempPage(pageID); err != nil {
+			return err
+		}
+		pageID = next
+	}
+	h.FirstPageID = InvalidPageID
+	h.lastPageID = InvalidPageID
+	return nil
+}
+
+// findLastPage walks the page chain to find its tail.
+func (h *TableHeap) findLastPage() (int64, error) {
+	if h.lastPageID != InvalidPageID {
+		return h.lastPageID, nil
+	}
+	pageID := h.FirstPageID
+	for {
+		page, err := h.fetch(pageID, false)
+		if err != nil {
+			return InvalidPageID, err
+		}
+		next := page.NextPageID
+		if err := h.release(pageID, false, false); err != nil {
+			return InvalidPageID, err
+		}
+		if next == InvalidPageID {
+			h.lastPageID = pageID
+			return pageID, nil
+		}
+		pageID = next
+	}
+}
+
+// Insert appends a record to the heap, allocating a new page when the last one is full.
+func (h *TableHeap) Insert(recordData []byte) (RID, error) {
+	if len(recordData) > MaxRecordSize() {
+		return RID{}, errors.New("record too large for a page")
+	}
+	lastPageID, err := h.findLastPage()
+	if err != nil {
+		return RID{}, err
+	}
+	page, err := h.fetch(lastPageID, true)
+	if err != nil {
+		return RID{}, err
+	}
+	if page.HasSpaceFor(len(recordData)) {
+		slot, err := page.AddRecord(recordData)
+		if unpinErr := h.release(lastPageID, true, err == nil); unpinErr != nil {
+			return RID{}, unpinErr
+		}
+		if err != nil {
+			return RID{}, err
+		}
+		return RID{PageID: lastPageID, Slot: slot}, nil
+	}
+
+	// The last page is full, chain a new page after it.
+	newPageID, newPage, err := h.newPage()
+	if err != nil {
+		h.release(lastPageID, true, false)
+		return RID{}, err
+	}
+	page.NextPageID = newPageID
+	if err := h.release(lastPageID, true, true); err != nil {
+		return RID{}, err
+	}
+	h.lastPageID = newPageID
+	if err := h.bp.LatchPage(newPageID, true); err != nil {
+		h.bp.UnpinPage(newPageID, true)
+		return RID{}, err
+	}
+	slot, err := newPage.AddRecord(recordData)
+	if unpinErr := h.release(newPageID, true, true); unpinErr != nil {
+		return RID{}, unpinErr
+	}
+	if err != nil {
+		return RID{}, err
+	}
+	return RID{PageID: newPageID, Slot: slot}, nil
+}
+
+// Get returns a copy of the record stored at rid.
+func (h *TableHeap) Get(rid RID) ([]byte, error) {
+	page, err := h.fetch(rid.PageID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer h.release(rid.PageID, false, false)
+	data, err := page.RetrieveRecord(rid.Slot)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	copy(out, data)
+	return out, nil
+}
+
+// Update replaces the record at rid. If the new version no longer fits on its
+// page the record is moved and the new RID is returned.
+func (h *TableHeap) Update(rid RID, recordData []byte) (RID, error) {
+	page, err := h.fetch(rid.PageID, true)
+	if err != nil {
+		return RID{}, err
+	}
+	err = page.UpdateRecord(rid.Slot, recordData)
+	if err == nil {
+		return rid, h.release(rid.PageID, true, true)
+	}
+	if page.IsDeleted(rid.Slot) {
+		h.release(rid.PageID, true, false)
+		return RID{}, err
+	}
+	// Not enough room on this page: move the record elsewhere.
+	if err := page.DeleteRecord(rid.Slot); err != nil {
+		h.release(rid.PageID, true, false)
+		return RID{}, err
+	}
+	if err := h.release(rid.PageID, true, true); err != nil {
+		return RID{}, err
+	}
+	return h.Insert(recordData)
+}
+
+// Delete removes the record at rid.
+func (h *TableHeap) Delete(rid RID) error {
+	page, err := h.fetch(rid.PageID, true)
+	if err != nil {
+		return err
+	}
+	if page.IsDeleted(rid.Slot) {
+		h.release(rid.PageID, true, false)
+		return ErrRecordDeleted
+	}
+	err = page.DeleteRecord(rid.Slot)
+	if unpinErr := h.release(rid.PageID, true, err == nil); unpinErr != nil {
+		return unpinErr
+	}
+	return err
+}
+
+// Restore puts a previously deleted record back at rid.
+func (h *TableHeap) Restore(rid RID, recordData []byte) error {
+	page, err := h.fetch(rid.PageID, true)
+	if err != nil {
+		return err
+	}
+	err = page.RestoreRecord(rid.Slot, recordData)
+	if unpinErr := h.release(rid.PageID, true, err == nil); unpinErr != nil {
+		return unpinErr
+	}
+	return err
+}
+
+// Iterator returns an iterator positioned before the first record of the heap.
+func (h *TableHeap) Iterator() *HeapIterator {
+	return &HeapIterator{heap: h, pageID: h.FirstPageID, slot: -1}
+}
+
+// HeapIterator walks every live record of a heap in page order. It only keeps
+// its position between calls, so no page stays pinned while iteration is paused.
+type HeapIterator struct {
+	heap   *TableHeap
+	pageID int64
+	slot   int
+}
+
+// Next advances to the next live record and returns a copy of it.
+// It returns a nil slice once the end of the heap is reached.
+func (it *HeapIterator) Next() (RID, []byte, error) {
+	h := it.heap
+	for it.pageID != InvalidPageID {
+		page, err := h.fetch(it.pageID, false)
+		if err != nil {
+			return RID{}, nil, err
+		}
+		for it.slot+1 < page.NumSlots() {
+			it.slot++
+			if page.IsDeleted(it.slot) {
+				continue
+			}
+			data, err := page.RetrieveRecord(it.slot)
+			if err != nil {
+				h.release(it.pageID, false, false)
+				return RID{}, nil, err
+			}
+			out := make([]byte, len(data))
+			copy(out, data)
+			rid := RID{PageID: it.pageID, Slot: it.slot}
+			return rid, out, h.release(it.pageID, false, false)
+		}
+		next := page.NextPageID
+		if err := h.release(it.pageID, false, false); err != nil {
+			return RID{}, nil, err
+		}
+		it.pageID = next
+		it.slot = -1
+	}
+	return RID{}, nil, nil
+}
+
+// Position returns the RID of the last record returned by Next.
+func (it *HeapIterator) Position() RID {
+	return RID{PageID: it.pageID, Slot: it.slot}
+}
@@ -7,10 +7,16 @@ import (
 
 const (
 	PageSize       = 4096
-	SlotSize       = 6 // 2 bytes for offset, 4 bytes for length
-	PageHeaderSize = 4 // Assume 4 bytes for free space pointer
+	SlotSize       = 6  // 2 bytes for offset, 4 bytes for length
+	PageHeaderSize = 12 // 2 bytes free space pointer, 2 bytes slot count, 8 bytes next page ID
 )
 
+// InvalidPageID marks the absence of a page, e.g. the end of a page chain.
+const InvalidPageID int64 = -1
+
+// ErrRecordDeleted is returned when reading or changing an empty slot.
+var ErrRecordDeleted = errors.New("record has been deleted")
+
 // SlotDescriptor describes the location and size of a record on the page.
 type SlotDescriptor struct {
 	Offset int16 // using int16 to allow for -1 sentinel value
@@ -18,9 +24,12 @@ type SlotDescriptor struct {
 }
 
 // Page represents a database page that manages variable-length records.
+// Records grow from the header towards the end of the page while the slot
+// directory grows from the end of the page towards the records.
 type Page struct {
 	Data              []byte
 	FreeSpacePointer  int16
+	NextPageID        int64 // Links pages of the same heap or blob together
 	RecordDescriptors []SlotDescriptor
 }
 
@@ -32,27 +41,72 @@ func NewPage() *Page {
 	return &Page{
 		Data:              pageData,
 		FreeSpacePointer:  PageHeaderSize,
+		NextPageID:        InvalidPageID,
 		RecordDescriptors: []SlotDescriptor{},
 	}
 }
 
+// Reset clears all records from the page while keeping its chain pointer.
+func (p *Page) Reset() {
+	p.Data = make([]byte, PageSize)
+	p.FreeSpacePointer = PageHeaderSize
+	p.RecordDescriptors = []SlotDescriptor{}
+}
+
+// MaxRecordSize is the largest record that fits on an empty page.
+func MaxRecordSize() int {
+	return PageSize - PageHeaderSize - SlotSize
+}
+
+// freeSpace returns the number of bytes between the records and the slot directory.
+func (p *Page) freeSpace() int {
+	return PageSize - len(p.RecordDescriptors)*SlotSize - int(p.FreeSpacePointer)
+}
+
+// reclaimableSpace returns the bytes that would be available after compaction.
+func (p *Page) reclaimableSpace() int {
+	used := PageHeaderSize
+	for _, slot := range p.RecordDescriptors {
+		if slot.Offset != -1 {
+			used += int(slot.Length)
+		}
+	}
+	return PageSize - len(p.RecordDescriptors)*SlotSize - used
+}
+
+// HasSpaceFor reports whether a record of the given size can be added to the page.
+func (p *Page) HasSpaceFor(recordSize int) bool {
+	needed := recordSize
+	if p.findEmptySlot() == -1 {
+		needed += SlotSize
+	}
+	return p.reclaimableSpace() >= needed
+}
+
+func (p *Page) findEmptySlot() int {
+	for i, slot := range p.RecordDescriptors {
+		if slot.Offset == -1 { // Deleted record slot
+			return i
+		}
+	}
+	return -1
+}
+
 // AddRecord adds a new record to the page.
 func (p *Page) AddRecord(recordData []byte) (int, error) {
 	recordSize := uint32(len(recordData))
-	neededSpace := int(recordSize) + SlotSize
-
-	// Check if there's enough space for the record and the slot descriptor
-	if int(p.FreeSpacePointer)+neededSpace > PageSize {
+	if !p.HasSpaceFor(len(recordData)) {
 		return -1, errors.New("not enough space on the page")
 	}
 
 	// Find an empty slot or create a new one
-	slotIndex := -1
-	for i, slot := range p.RecordDescriptors {
-		if slot.Offset == -1 { // Deleted record slot
-			slotIndex = i
-			break
-		}
+	slotIndex := p.findEmptySlot()
+	neededSpace := int(recordSize)
+	if slotIndex == -1 {
+		neededSpace += SlotSize
+	}
+	if p.freeSpace() < neededSpace {
+		p.CompactPage()
 	}
 	if slotIndex == -1 { // No empty slot found, create a new one
 		slotIndex = len(p.RecordDescriptors)
@@ -69,11 +123,79 @@ func (p *Page) AddRecord(recordData []byte) (int, error) {
 	}
 
 	// Update the free space pointer
-	p.FreeSpacePointer += int16(neededSpace)
+	p.FreeSpacePointer += int16(recordSize)
 
 	return slotIndex, nil
 }
 
+// UpdateRecord replaces the record in the given slot. The slot index stays the
+// same so that record IDs remain valid.
+func (p *Page) UpdateRecord(slotIndex int, recordData []byte) error {
+	if slotIndex < 0 || slotIndex >= len(p.RecordDescriptors) {
+		return errors.New("slot index out of range")
+	}
+	slot := p.RecordDescriptors[slotIndex]
+	if slot.Offset == -1 {
+		return ErrRecordDeleted
+	}
+
+	recordSize := uint32(len(recordData))
+	// Shrinking or same-size records are overwritten in place.
+	if recordSize <= slot.Length {
+		copy(p.Data[slot.Offset:], recordData)
+		p.RecordDescriptors[slotIndex].Length = recordSize
+		return nil
+	}
+
+	if p.reclaimableSpace()+int(slot.Length) < int(recordSize) {
+		return errors.New("not enough space on the page")
+	}
+	p.RecordDescriptors[slotIndex].Offset = -1
+	p.RecordDescriptors[slotIndex].Length = 0
+	if p.freeSpace() < int(recordSize) {
+		p.CompactPage()
+	}
+	copy(p.Data[p.FreeSpacePointer:], recordData)
+	p.RecordDescriptors[slotIndex] = SlotDescriptor{
+		Offset: int16(p.FreeSpacePointer),
+		Length: recordSize,
+	}
+	p.FreeSpacePointer += int16(recordSize)
+	return nil
+}
+
+// RestoreRecord puts a record back into a specific empty slot, extending the
+// slot directory if needed. It is used to undo deletions without changing RIDs.
+func (p *Page) RestoreRecord(slotIndex int, recordData []byte) error {
+	if slotIndex < 0 {
+		return errors.New("slot index out of range")
+	}
+	if slotIndex < len(p.RecordDescriptors) && p.RecordDescriptors[slotIndex].Offset != -1 {
+		return errors.New("slot is already in use")
+	}
+	newSlots := 0
+	if slotIndex >= len(p.RecordDescriptors) {
+		newSlots = slotIndex + 1 - len(p.RecordDescriptors)
+	}
+	needed := len(recordData) + newSlots*SlotSize
+	if p.reclaimableSpace() < needed {
+		return errors.New("not enough space on the page")
+	}
+	if p.freeSpace() < needed {
+		p.CompactPage()
+	}
+	for len(p.RecordDescriptors) <= slotIndex {
+		p.RecordDescriptors = append(p.RecordDescriptors, SlotDescriptor{Offset: -1})
+	}
+	copy(p.Data[p.FreeSpacePointer:], recordData)
+	p.RecordDescriptors[slotIndex] = SlotDescriptor{
+		Offset: int16(p.FreeSpacePointer),
+		Length: uint32(len(recordData)),
+	}
+	p.FreeSpacePointer += int16(len(recordData))
+	return nil
+}
+
 // RetrieveRecord retrieves a record from the page by its slot index.
 func (p *Page) RetrieveRecord(slotIndex int) ([]byte, error) {
 	if slotIndex < 0 || slotIndex >= len(p.RecordDescriptors) {
@@ -82,14 +204,24 @@ func (p *Page) RetrieveRecord(slotIndex int) ([]byte, error) {
 
 	slot := p.RecordDescriptors[slotIndex]
 	if slot.Offset == -1 {
-		return nil, errors.New("record has been deleted")
+		return nil, ErrRecordDeleted
 	}
 
 	// Extract the record data
-	recordData := p.Data[slot.Offset : slot.Offset+int16(slot.Length)]
+	recordData := p.Data[slot.Offset : int(slot.Offset)+int(slot.Length)]
 	return recordData, nil
 }
 
+// IsDeleted reports whether the slot is empty.
+func (p *Page) IsDeleted(slotIndex int) bool {
+	return slotIndex < 0 || slotIndex >= len(p.RecordDescriptors) || p.RecordDescriptors[slotIndex].Offset == -1
+}
+
+// NumSlots returns the number of slots in the slot directory, including deleted ones.
+func (p *Page) NumSlots() int {
+	return len(p.RecordDescriptors)
+}
+
 // DeleteRecord marks a record as deleted by setting its offset to -1.
 func (p *Page) DeleteRecord(slotIndex int) error {
 	if slotIndex < 0 || slotIndex >= len(p.RecordDescriptors) {
@@ -103,31 +235,26 @@ func (p *Page) DeleteRecord(slotIndex int) error {
 }
 
 // CompactPage compacts the page by removing gaps left by deleted records.
+// Deleted slots are kept in the directory so slot indexes do not change.
 func (p *Page) CompactPage() {
 	compactedData := make([]byte, PageSize)
 	copy(compactedData, p.Data[:PageHeaderSize]) // Copy the header
-	var newDescriptors []SlotDescriptor
 
 	compactPointer := PageHeaderSize
-	for _, descriptor := range p.RecordDescriptors {
+	for i, descriptor := range p.RecordDescriptors {
 		if descriptor.Offset == -1 {
 			// Skip deleted records
 			continue
 		}
-		recordData := p.Data[descriptor.Offset : descriptor.Offset+int16(descriptor.Length)]
+		recordData := p.Data[descriptor.Offset : int(descriptor.Offset)+int(descriptor.Length)]
 		copy(compactedData[compactPointer:], recordData)
 
-		newDescriptor := SlotDescriptor{
-			Offset: int16(compactPointer),
-			Length: descriptor.Length,
-		}
-		newDescriptors = append(newDescriptors, newDescriptor)
-		compactPointer += int(descriptor.Length) + SlotSize
+		p.RecordDescriptors[i].Offset = int16(compactPointer)
+		compactPointer += int(descriptor.Length)
 	}
 
-	// Update the page's data and descriptors
+	// Update the page's data
 	p.Data = compactedData
-	p.RecordDescriptors = newDescriptors
 	p.FreeSpacePointer = int16(compactPointer)
 }
 
@@ -135,8 +262,10 @@ func (p *Page) CompactPage() {
 func (p *Page) Serialize() []byte {
 	buf := make([]byte, PageSize)
 
-	// Write the free space pointer at the beginning
+	// Write the header at the beginning
 	binary.LittleEndian.PutUint16(buf, uint16(p.FreeSpacePointer))
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(p.RecordDescriptors)))
+	binary.LittleEndian.PutUint64(buf[4:], uint64(p.NextPageID))
 
 	// Write the records based on the descriptor information
 	for _, descriptor := range p.RecordDescriptors {
@@ -163,29 +292,31 @@ func DeserializePage(buf []byte) (*Page, error) {
 		return nil, errors.New("incorrect buffer size for page")
 	}
 
-	// Read the free space pointer from the beginning
+	// Read the header from the beginning
 	freeSpacePointer := binary.LittleEndian.Uint16(buf)
+	slotCount := int(binary.LittleEndian.Uint16(buf[2:]))
+	nextPageID := int64(binary.LittleEndian.Uint64(buf[4:]))
+	if int(freeSpacePointer) < PageHeaderSize || PageHeaderSize+slotCount*SlotSize > PageSize {
+		return nil, errors.New("corrupted page header")
+	}
 
 	// Initialize an empty Page structure
 	p := &Page{
 		Data:              make([]byte, PageSize),
 		FreeSpacePointer:  int16(freeSpacePointer),
-		RecordDescriptors: make([]SlotDescriptor, 0),
+		NextPageID:        nextPageID,
+		RecordDescriptors: make([]SlotDescriptor, 0, slotCount),
 	}
 
 	// Read the slot directory from the end of the page
-	for i := 0; i < (PageSize-PageHeaderSize)/SlotSize; i++ {
+	for i := 0; i < slotCount; i++ {
 		offset := PageSize - (i+1)*SlotSize
 		recordOffset := int16(binary.LittleEndian.Uint16(buf[offset:]))
 		recordLength := binary.LittleEndian.Uint32(buf[offset+2:])
-
-		// Add the descriptor if the record isn't deleted
-		if recordOffset != -1 {
-			p.RecordDescriptors = append(p.RecordDescriptors, SlotDescriptor{
-				Offset: recordOffset,
-				Length: recordLength,
-			})
-		}
+		p.RecordDescriptors = append(p.RecordDescriptors, SlotDescriptor{
+			Offset: recordOffset,
+			Length: recordLength,
+		})
 	}
 
 	// Copy the record data into the Page structure
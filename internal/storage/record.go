@@ -55,6 +55,26 @@ func DeserializeRecord(data []byte) (*Record, error) {
 	return &Record{Fields: fields}, nil
 }
 
+// ReadFields calls fn with the position and contents of each field of a
+// serialized record, without copying them: the contents alias data.
+func ReadFields(data []byte, fn func(index int, field []byte) error) error {
+	for i := 0; len(data) > 0; i++ {
+		if len(data) < 4 {
+			return errors.New("truncated record")
+		}
+		fieldSize := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(fieldSize) {
+			return errors.New("truncated record")
+		}
+		if err := fn(i, data[:fieldSize]); err != nil {
+			return err
+		}
+		data = data[fieldSize:]
+	}
+	return nil
+}
+
 func (r *Record) Size() int {
 	size := 0
 	for _, field := range r.Fields {
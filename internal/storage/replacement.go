@@ -7,6 +7,9 @@ import (
 // ReplacementPolicy is an interface for page replacement algorithms.
 type ReplacementPolicy interface {
 	ChoosePageToEvict(pool map[int64]*BufferPage) int64
+	NextVictims(pool map[int64]*BufferPage, n int) []int64
+	PageAccessed(pageID int64)
+	PageRemoved(pageID int64)
 }
 
 // LRUPolicy implements the ReplacementPolicy interface using LRU logic.
@@ -28,28 +31,56 @@ func NewLRUPolicy() *LRUPolicy {
 	}
 }
 
-// ChoosePageToEvict selects the least recently used page for eviction.
+// evictable reports whether a page may be evicted: it is unpinned, not held
+// by a running transaction and has no disk I/O under way.
+func evictable(page *BufferPage) bool {
+	return page.PinCount() == 0 && page.holders.Load() == 0 && page.io == nil
+}
+
+// ChoosePageToEvict selects the least recently used unpinned page for eviction.
+// Hits only mark pages referenced, since they do not hold the lock needed to
+// reorder the list; a referenced page gets a second chance, being moved to
+// the front instead of evicted.
 func (l *LRUPolicy) ChoosePageToEvict(pool map[int64]*BufferPage) int64 {
-	for {
-		if l.evictList.Len() == 0 {
-			return -1
-		}
-		// Fetch the oldest accessed page from the back of the evictList
-		elem := l.evictList.Back()
-		if elem == nil {
-			return -1
-		}
+	// Walk from the oldest accessed page at the back of the evictList
+	for elem := l.evictList.Back(); elem != nil; {
 		entry := elem.Value.(*lruEntry)
-		if _, ok := pool[entry.key]; ok && !pool[entry.key].IsPinned {
+		prev := elem.Prev()
+		page, ok := pool[entry.key]
+		if !ok {
+			// Stale entry for a page that is no longer buffered
+			l.evictList.Remove(elem)
+			delete(l.entries, entry.key)
+		} else if evictable(page) && page.referenced.Swap(false) {
+			l.evictList.MoveToFront(elem)
+			if prev == nil {
+				// The page was the front one: give it its chance now.
+				prev = elem
+			}
+		} else if evictable(page) {
 			// If the page is not pinned, return it for eviction
 			l.evictList.Remove(elem)
 			delete(l.entries, entry.key)
 			return entry.key
 		}
 		// If the page is pinned, move to the next oldest page
-		l.evictList.Remove(elem)
-		delete(l.entries, entry.key)
+		elem = prev
+	}
+	return -1
+}
+
+// NextVictims returns up to n of the pages that would be evicted next,
+// least recently used first, without removing them. Referenced pages,
+// which would get a second chance, are skipped.
+func (l *LRUPolicy) NextVictims(pool map[int64]*BufferPage, n int) []int64 {
+	var victims []int64
+	for elem := l.evictList.Back(); elem != nil && len(victims) < n; elem = elem.Prev() {
+		key := elem.Value.(*lruEntry).key
+		if page, ok := pool[key]; ok && evictable(page) && !page.referenced.Load() {
+			victims = append(victims, key)
+		}
 	}
+	return victims
 }
 
 // PageAccessed updates the LRU policy when a page is accessed.
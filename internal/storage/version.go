@@ -0,0 +1,83 @@
+package storage
+
+import "encoding/binary"
+
+// The records of a table are row versions. Each starts with a header naming
+// the transaction that created the version (xmin) and the one that deleted
+// it (xmax), zero while nobody has, followed by the commands of those
+// transactions that did (cmin and cmax), which tell the statements of a
+// transaction that started before a change of its own from those that
+// started after. Updating a row deletes its version and
+// inserts a new one, so that transactions reading through an older snapshot
+// still find the version they see; VACUUM removes the versions nobody can
+// see anymore.
+
+// VersionHeaderSize is the size of the header before the record of a row version.
+const VersionHeaderSize = 24
+
+// NewVersion prefixes a record with the header of a version created by
+// command cmin of transaction xmin.
+func NewVersion(xmin uint64, cmin uint32, record []byte) []byte {
+	data := make([]byte, VersionHeaderSize+len(record))
+	binary.LittleEndian.PutUint64(data, xmin)
+	binary.LittleEndian.PutUint32(data[16:], cmin)
+	copy(data[VersionHeaderSize:], record)
+	return data
+}
+
+// VersionXmin returns the transaction that created a version.
+func VersionXmin(data []byte) uint64 {
+	return binary.LittleEndian.Uint64(data)
+}
+
+// VersionXmax returns the transaction that deleted a version, or 0.
+func VersionXmax(data []byte) uint64 {
+	return binary.LittleEndian.Uint64(data[8:])
+}
+
+// VersionCmin returns the command of xmin that created a version.
+func VersionCmin(data []byte) uint32 {
+	return binary.LittleEndian.Uint32(data[16:])
+}
+
+// VersionCmax returns the command of xmax that deleted a version.
+func VersionCmax(data []byte) uint32 {
+	return binary.LittleEndian.Uint32(data[20:])
+}
+
+// VersionRecord returns the record of a version, without its header.
+func VersionRecord(data []byte) []byte {
+	return data[VersionHeaderSize:]
+}
+
+// Xmax returns the transaction that deleted the version stored at rid, or 0.
+func (h *TableHeap) Xmax(rid RID) (uint64, error) {
+	page, err := h.fetch(rid.PageID, false)
+	if err != nil {
+		return 0, err
+	}
+	defer h.release(rid.PageID, false, false)
+	data, err := page.RetrieveRecord(rid.Slot)
+	if err != nil {
+		return 0, err
+	}
+	return VersionXmax(data), nil
+}
+
+// SetXmax records command cmax of transaction xmax as the one that deleted
+// the version stored at rid, in place. An xmax of 0 makes the version live
+// again.
+func (h *TableHeap) SetXmax(rid RID, xmax uint64, cmax uint32) error {
+	page, err := h.fetch(rid.PageID, true)
+	if err != nil {
+		return err
+	}
+	data, err := page.RetrieveRecord(rid.Slot)
+	if err != nil {
+		h.release(rid.PageID, true, false)
+		return err
+	}
+	binary.LittleEndian.PutUint64(data[8:], xmax)
+	binary.LittleEndian.PutUint32(data[20:], cmax)
+	return h.release(rid.PageID, true, true)
+}
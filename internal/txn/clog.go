@@ -0,0 +1,163 @@
+package txn
+
+import (
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/storage"
+)
+
+// CommitLog records which transactions committed, one bit per transaction
+// ID, in a chain of pages holding one record each. While the database runs
+// it isn't needed: a rolled back transaction has its changes undone before
+// it finishes. It tells, after a crash, the transactions that committed from
+// those that were running or crashed halfway through their commit, whose
+// changes may have reached the disk.
+//
+// A CommitLog is not safe for concurrent use.
+type CommitLog struct {
+	bp      *storage.BufferPool
+	base    uint64  // transactions before base committed before the log existed
+	pageIDs []int64 // the pages of the chain, in order
+}
+
+// clogPageIDs is the number of transactions each page of the log covers.
+func clogPageIDs() uint64 {
+	return uint64(storage.MaxRecordSize()) * 8
+}
+
+// LoadCommitLog opens the commit log of the database, creating it when the
+// catalog names none. A new log is recorded in the catalog, which the caller
+// must sync.
+func LoadCommitLog(bp *storage.BufferPool, cat *catalog.Catalog) (*CommitLog, error) {
+	if cat.CommitLog == 0 {
+		pageID, err := newClogPage(bp)
+		if err != nil {
+			return nil, err
+		}
+		base := cat.NextTxnID
+		if base == 0 {
+			base = 1
+		}
+		cat.CommitLog, cat.CommitLogBase = pageID, base
+		return &CommitLog{bp: bp, base: base, pageIDs: []int64{pageID}}, nil
+	}
+	l := &CommitLog{bp: bp, base: cat.CommitLogBase}
+	pageID := cat.CommitLog
+	for pageID != storage.InvalidPageID {
+		page, err := bp.FetchPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		next := page.NextPageID
+		if err := bp.UnpinPage(pageID, false); err != nil {
+			return nil, err
+		}
+		l.pageIDs = append(l.pageIDs, pageID)
+		pageID = next
+	}
+	return l, nil
+}
+
+// newClogPage allocates a page of the log with every bit cleared and writes
+// it out, so that it is on disk before any page links to it.
+func newClogPage(bp *storage.BufferPool) (int64, error) {
+	pageID, page, err := bp.NewPage()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := page.AddRecord(make([]byte, storage.MaxRecordSize())); err != nil {
+		bp.UnpinPage(pageID, false)
+		return 0, err
+	}
+	if err := bp.UnpinPage(pageID, true); err != nil {
+		return 0, err
+	}
+	return pageID, bp.FlushPage(pageID)
+}
+
+// Committed reports whether transaction id committed.
+func (l *CommitLog) Committed(id uint64) (bool, error) {
+	if id < l.base {
+		return true, nil
+	}
+	n := id - l.base
+	i := n / clogPageIDs()
+	if i >= uint64(len(l.pageIDs)) {
+		return false, nil
+	}
+	pageID := l.pageIDs[i]
+	page, err := l.bp.FetchPage(pageID)
+	if err != nil {
+		return false, err
+	}
+	defer l.bp.UnpinPage(pageID, false)
+	bits, err := page.RetrieveRecord(0)
+	if err != nil {
+		return false, err
+	}
+	bit := n % clogPageIDs()
+	return bits[bit/8]&(1<<(bit%8)) != 0, nil
+}
+
+// Commit records that transaction id committed and writes the page holding
+// its bit back to disk, without syncing the file.
+func (l *CommitLog) Commit(id uint64) error {
+	n := id - l.base
+	i := n / clogPageIDs()
+	for i >= uint64(len(l.pageIDs)) {
+		if err := l.extend(); err != nil {
+			return err
+		}
+	}
+	pageID := l.pageIDs[i]
+	page, err := l.bp.FetchPage(pageID)
+	if err != nil {
+		return err
+	}
+	if err := l.bp.LatchPage(pageID, true); err != nil {
+		l.bp.UnpinPage(pageID, false)
+		return err
+	}
+	bits, err := page.RetrieveRecord(0)
+	if err == nil {
+		bit := n % clogPageIDs()
+		bits[bit/8] |= 1 << (bit % 8)
+	}
+	if unlatchErr := l.bp.UnlatchPage(pageID, true); unlatchErr != nil {
+		l.bp.UnpinPage(pageID, err == nil)
+		return unlatchErr
+	}
+	if unpinErr := l.bp.UnpinPage(pageID, err == nil); unpinErr != nil {
+		return unpinErr
+	}
+	if err != nil {
+		return err
+	}
+	return l.bp.FlushPage(pageID)
+}
+
+// extend appends a page to the chain.
+func (l *CommitLog) extend() error {
+	newPageID, err := newClogPage(l.bp)
+	if err != nil {
+		return err
+	}
+	lastPageID := l.pageIDs[len(l.pageIDs)-1]
+	last, err := l.bp.FetchPage(lastPageID)
+	if err != nil {
+		return err
+	}
+	if err := l.bp.LatchPage(lastPageID, true); err != nil {
+		l.bp.UnpinPage(lastPageID, false)
+		return err
+	}
+	last.NextPageID = newPageID
+	if err := l.bp.UnlatchPage(lastPageID, true); err != nil {
+		l.bp.UnpinPage(lastPageID, true)
+		return err
+	}
+	if err := l.bp.UnpinPage(lastPageID, true); err != nil {
+		return err
+	}
+	l.pageIDs = append(l.pageIDs, newPageID)
+	return l.bp.FlushPage(lastPageID)
+}
@@ -0,0 +1,248 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LockMode is the mode a resource is locked in.
+type LockMode int64
+
+const (
+	LockShared    LockMode = 1
+	LockExclusive LockMode = 2
+)
+
+var (
+	ErrDeadlock    = errors.New("deadlock detected")
+	ErrLockTimeout = errors.New("lock wait timeout exceeded")
+)
+
+// lockEntry tracks the holders of a single resource. A transaction holding
+// the exclusive lock may also appear in shared.
+type lockEntry struct {
+	shared    map[uint64]bool
+	exclusive uint64 // 0 when no transaction holds the exclusive lock
+}
+
+// LockManager implements strict two-phase locking on named resources such as
+// tables. Locks are held until the owning transaction ends.
+type LockManager struct {
+	mu       sync.Mutex
+	locks    map[string]*lockEntry
+	held     map[uint64]map[string]LockMode // locks held by each transaction
+	waitsFor map[uint64]string              // resource each blocked transaction waits on
+	released chan struct{}                  // closed and replaced whenever locks are released
+	timeout  time.Duration
+}
+
+// NewLockManager creates a lock manager. A zero timeout waits until the
+// context is done or a deadlock is detected.
+func NewLockManager(timeout time.Duration) *LockManager {
+	return &LockManager{
+		locks:    make(map[string]*lockEntry),
+		held:     make(map[uint64]map[string]LockMode),
+		waitsFor: make(map[uint64]string),
+		released: make(chan struct{}),
+		timeout:  timeout,
+	}
+}
+
+// Lock acquires resource in the given mode for txnID, blocking while
+// conflicting locks are held by other transactions.
+func (lm *LockManager) Lock(ctx context.Context, txnID uint64, resource string, mode LockMode) error {
+	var timer <-chan time.Time
+	if lm.timeout > 0 {
+		t := time.NewTimer(lm.timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	lm.mu.Lock()
+	for {
+		entry := lm.entry(resource)
+		if lm.compatible(entry, txnID, mode) {
+			lm.grant(entry, txnID, resource, mode)
+			delete(lm.waitsFor, txnID)
+			lm.mu.Unlock()
+			return nil
+		}
+		lm.waitsFor[txnID] = resource
+		if lm.hasCycle(txnID) {
+			delete(lm.waitsFor, txnID)
+			lm.mu.Unlock()
+			return ErrDeadlock
+		}
+		released := lm.released
+		lm.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timer:
+			lm.stopWaiting(txnID)
+			return ErrLockTimeout
+		case <-ctx.Done():
+			lm.stopWaiting(txnID)
+			return ctx.Err()
+		}
+		lm.mu.Lock()
+	}
+}
+
+func (lm *LockManager) stopWaiting(txnID uint64) {
+	lm.mu.Lock()
+	delete(lm.waitsFor, txnID)
+	lm.mu.Unlock()
+}
+
+func (lm *LockManager) entry(resource string) *lockEntry {
+	entry, ok := lm.locks[resource]
+	if !ok {
+		entry = &lockEntry{shared: make(map[uint64]bool)}
+		lm.locks[resource] = entry
+	}
+	return entry
+}
+
+func (lm *LockManager) compatible(entry *lockEntry, txnID uint64, mode LockMode) bool {
+	if entry.exclusive != 0 && entry.exclusive != txnID {
+		return false
+	}
+	if mode == LockShared {
+		return true
+	}
+	for holder := range entry.shared {
+		if holder != txnID {
+			return false
+		}
+	}
+	return true
+}
+
+func (lm *LockManager) grant(entry *lockEntry, txnID uint64, resource string, mode LockMode) {
+	held, ok := lm.held[txnID]
+	if !ok {
+		held = make(map[string]LockMode)
+		lm.held[txnID] = held
+	}
+	entry.shared[txnID] = true
+	if mode == LockExclusive {
+		entry.exclusive = txnID
+	}
+	if held[resource] < mode {
+		held[resource] = mode
+	}
+}
+
+// holders returns the transactions other than txnID holding resource.
+func (lm *LockManager) holders(resource string, txnID uint64) []uint64 {
+	entry, ok := lm.locks[resource]
+	if !ok {
+		return nil
+	}
+	var ids []uint64
+	for holder := range entry.shared {
+		if holder != txnID {
+			ids = append(ids, holder)
+		}
+	}
+	return ids
+}
+
+// hasCycle reports whether the wait-for graph contains a cycle through txnID.
+func (lm *LockManager) hasCycle(txnID uint64) bool {
+	visited := make(map[uint64]bool)
+	var visit func(id uint64) bool
+	visit = func(id uint64) bool {
+		resource, waiting := lm.waitsFor[id]
+		if !waiting {
+			return false
+		}
+		for _, holder := range lm.holders(resource, id) {
+			if holder == txnID {
+				return true
+			}
+			if !visited[holder] {
+				visited[holder] = true
+				if visit(holder) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(txnID)
+}
+
+// Unlock releases a single resource held by txnID.
+func (lm *LockManager) Unlock(txnID uint64, resource string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.release(txnID, resource)
+	lm.wakeWaiters()
+}
+
+// UnlockAll releases every lock held by txnID.
+func (lm *LockManager) UnlockAll(txnID uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for resource := range lm.held[txnID] {
+		lm.release(txnID, resource)
+	}
+	delete(lm.held, txnID)
+	delete(lm.waitsFor, txnID)
+	lm.wakeWaiters()
+}
+
+// Restore returns txnID to holding the locks of held, a copy made earlier by
+// HeldLocks: locks acquired since are released and those upgraded to
+// exclusive since are downgraded to shared.
+func (lm *LockManager) Restore(txnID uint64, held map[string]LockMode) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for resource, mode := range lm.held[txnID] {
+		switch {
+		case held[resource] == 0:
+			lm.release(txnID, resource)
+		case held[resource] < mode:
+			if entry, ok := lm.locks[resource]; ok && entry.exclusive == txnID {
+				entry.exclusive = 0
+			}
+			lm.held[txnID][resource] = held[resource]
+		}
+	}
+	lm.wakeWaiters()
+}
+
+func (lm *LockManager) release(txnID uint64, resource string) {
+	if entry, ok := lm.locks[resource]; ok {
+		delete(entry.shared, txnID)
+		if entry.exclusive == txnID {
+			entry.exclusive = 0
+		}
+		if len(entry.shared) == 0 {
+			delete(lm.locks, resource)
+		}
+	}
+	if held, ok := lm.held[txnID]; ok {
+		delete(held, resource)
+	}
+}
+
+func (lm *LockManager) wakeWaiters() {
+	close(lm.released)
+	lm.released = make(chan struct{})
+}
+
+// HeldLocks returns a copy of the locks held by txnID.
+func (lm *LockManager) HeldLocks(txnID uint64) map[string]LockMode {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	out := make(map[string]LockMode, len(lm.held[txnID]))
+	for resource, mode := range lm.held[txnID] {
+		out[resource] = mode
+	}
+	return out
+}
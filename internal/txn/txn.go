@@ -0,0 +1,481 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// State is the lifecycle state of a transaction.
+type State int64
+
+const (
+	StateActive    State = 0
+	StateCommitted State = 1
+	StateAborted   State = 2
+)
+
+var (
+	ErrReadOnly      = errors.New("cannot modify data in a read-only transaction")
+	ErrNotActive     = errors.New("transaction is no longer active")
+	ErrSerialization = errors.New("could not serialize access due to concurrent update")
+)
+
+// IsolationLevel is how much a transaction sees of the changes other
+// transactions commit while it runs.
+type IsolationLevel int64
+
+const (
+	// ReadCommitted reads each statement through a snapshot of the changes
+	// committed when it starts.
+	ReadCommitted IsolationLevel = 0
+	// RepeatableRead reads every statement through the snapshot taken when
+	// the first one started. Changing a row another transaction changed
+	// since fails with ErrSerialization.
+	RepeatableRead IsolationLevel = 1
+	// Serializable also takes shared locks on the tables it reads, held
+	// until it finishes, so that it runs as if alone.
+	Serializable IsolationLevel = 2
+)
+
+func (level IsolationLevel) String() string {
+	switch level {
+	case RepeatableRead:
+		return "REPEATABLE READ"
+	case Serializable:
+		return "SERIALIZABLE"
+	default:
+		return "READ COMMITTED"
+	}
+}
+
+// UndoKind identifies the change an undo record reverts.
+type UndoKind int64
+
+const (
+	UndoInsert         UndoKind = 1  // Revert by deleting RID
+	UndoDelete         UndoKind = 2  // Revert by clearing the xmax of RID
+	UndoUpdate         UndoKind = 3  // Revert by deleting NewRID and clearing the xmax of RID
+	UndoCreateTable    UndoKind = 4  // Revert by dropping Table
+	UndoDropTable      UndoKind = 5  // Revert by re-registering TableDef
+	UndoCreateIndex    UndoKind = 6  // Revert by dropping IndexDef
+	UndoDropIndex      UndoKind = 7  // Revert by re-registering IndexDef on Table
+	UndoAlterTable     UndoKind = 8  // Revert by replacing Table with TableDef
+	UndoCreateSequence UndoKind = 9  // Revert by dropping SequenceDef
+	UndoDropSequence   UndoKind = 10 // Revert by re-registering SequenceDef
+)
+
+// UndoRecord describes how to revert a single change made by a transaction.
+type UndoRecord struct {
+	Kind     UndoKind
+	Table    string
+	RID      storage.RID
+	NewRID   storage.RID
+	TableDef *catalog.Table
+	IndexDef *catalog.Index
+
+	SequenceDef *catalog.Sequence
+}
+
+// DeferredCheck is a check of a foreign key put off until the transaction
+// commits: rows of Table must not reference Key unless the referenced table
+// has it. Parent is set when the check follows a change of the referenced
+// table rather than of Table.
+type DeferredCheck struct {
+	Table      string
+	Constraint string
+	Key        []types.Value
+	Parent     bool
+}
+
+// Transaction is a unit of work. Changes are logged to an in-memory undo log
+// so they can be reverted on rollback.
+type Transaction struct {
+	ID         uint64
+	ReadOnly   bool
+	Isolation  IsolationLevel
+	state      State
+	committing bool // set by Manager.Committing
+	undoLog    []UndoRecord
+	snapshot   *Snapshot // the snapshot of the current statement
+	command    uint32    // the command ID of the current statement, counted from 1
+	xmin       uint64    // Xmin of the first snapshot, which cursors may still read through
+	savepoints []savepoint
+
+	deferred    []DeferredCheck
+	deferAll    *bool           // set by SET CONSTRAINTS ALL
+	deferByName map[string]bool // set by SET CONSTRAINTS name, keyed by lower-cased name
+}
+
+// State returns the transaction's current state.
+func (t *Transaction) State() State {
+	return t.state
+}
+
+// Snapshot returns the snapshot the current statement reads through, or nil
+// before the first statement.
+func (t *Transaction) Snapshot() *Snapshot {
+	return t.snapshot
+}
+
+// Command returns the command ID of the current statement, which the row
+// versions it creates and deletes are marked with.
+func (t *Transaction) Command() uint32 {
+	return t.command
+}
+
+// AddUndo appends an undo record for a change made by the transaction.
+func (t *Transaction) AddUndo(rec UndoRecord) {
+	t.undoLog = append(t.undoLog, rec)
+}
+
+// UndoMark returns a position in the undo log that can later be rolled back to.
+func (t *Transaction) UndoMark() int {
+	return len(t.undoLog)
+}
+
+// TakeUndoSince removes the undo records logged after mark and returns them
+// newest first, in the order they must be applied.
+func (t *Transaction) TakeUndoSince(mark int) []UndoRecord {
+	if mark < 0 || mark > len(t.undoLog) {
+		mark = len(t.undoLog)
+	}
+	records := make([]UndoRecord, 0, len(t.undoLog)-mark)
+	for i := len(t.undoLog) - 1; i >= mark; i-- {
+		records = append(records, t.undoLog[i])
+	}
+	t.undoLog = t.undoLog[:mark]
+	return records
+}
+
+// SetConstraintsDeferred sets, for the rest of the transaction, whether the
+// checks of the named deferrable constraints, or of all of them when names is
+// empty, wait until commit.
+func (t *Transaction) SetConstraintsDeferred(names []string, deferred bool) {
+	if len(names) == 0 {
+		t.deferAll = &deferred
+		t.deferByName = nil
+		return
+	}
+	if t.deferByName == nil {
+		t.deferByName = make(map[string]bool)
+	}
+	for _, name := range names {
+		t.deferByName[strings.ToLower(name)] = deferred
+	}
+}
+
+// ConstraintDeferred reports whether the checks of a deferrable constraint
+// wait until commit, given whether it was declared INITIALLY DEFERRED.
+func (t *Transaction) ConstraintDeferred(name string, initially bool) bool {
+	if deferred, ok := t.deferByName[strings.ToLower(name)]; ok {
+		return deferred
+	}
+	if t.deferAll != nil {
+		return *t.deferAll
+	}
+	return initially
+}
+
+// Defer records a check to run when the transaction commits.
+func (t *Transaction) Defer(check DeferredCheck) {
+	t.deferred = append(t.deferred, check)
+}
+
+// TakeDeferred removes the deferred checks of the named constraints, or all
+// of them when names is empty, and returns them in the order they were
+// recorded.
+func (t *Transaction) TakeDeferred(names []string) []DeferredCheck {
+	if len(names) == 0 {
+		checks := t.deferred
+		t.deferred = nil
+		return checks
+	}
+	var taken, kept []DeferredCheck
+	for _, check := range t.deferred {
+		matched := false
+		for _, name := range names {
+			if strings.EqualFold(check.Constraint, name) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			taken = append(taken, check)
+		} else {
+			kept = append(kept, check)
+		}
+	}
+	t.deferred = kept
+	return taken
+}
+
+// savepoint is a point of a transaction that it can roll back to.
+type savepoint struct {
+	name  string
+	mark  int                 // the length of the undo log when it was set
+	locks map[string]LockMode // the locks held when it was set
+}
+
+// Savepoints returns how many savepoints the transaction has set.
+func (t *Transaction) Savepoints() int {
+	return len(t.savepoints)
+}
+
+// findSavepoint returns the position of the newest savepoint named name.
+func (t *Transaction) findSavepoint(name string) (int, error) {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if strings.EqualFold(t.savepoints[i].name, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("savepoint %s does not exist", name)
+}
+
+// Snapshot is the set of changes a statement sees: those of the
+// transactions that committed before it was taken, and those its own
+// transaction made in earlier statements. Transactions from Xmax on had not
+// started yet, and those in Active were still running.
+type Snapshot struct {
+	TxnID   uint64
+	Command uint32 // the command ID of the statement in its transaction
+	Xmin    uint64 // the oldest transaction running when the snapshot was taken
+	Xmax    uint64
+	Active  map[uint64]bool
+	// Horizon is the oldest Xmin of the snapshots in use when this one was
+	// taken. No snapshot sees what a transaction before it deleted.
+	Horizon uint64
+}
+
+// sees reports whether the changes command cid of transaction id made are
+// visible. Those of the snapshot's own statement are, so that a statement
+// doesn't find again the rows it deleted.
+func (s *Snapshot) sees(id uint64, cid uint32) bool {
+	if id == s.TxnID {
+		return cid <= s.Command
+	}
+	return id < s.Xmax && !s.Active[id]
+}
+
+// Visible reports whether a row version created by command cmin of xmin and
+// deleted by command cmax of xmax, 0 if nobody did, is visible through the
+// snapshot. A version its own statement created isn't, so that the
+// statement doesn't change its rows again. Rolled back transactions leave no
+// versions behind, and neither do those that were running in an earlier run
+// that crashed, once Open removed their changes through the CommitLog, so any
+// transaction that isn't running anymore committed.
+func (s *Snapshot) Visible(xmin uint64, cmin uint32, xmax uint64, cmax uint32) bool {
+	if xmin == s.TxnID && cmin == s.Command {
+		return false
+	}
+	return s.sees(xmin, cmin) && (xmax == 0 || !s.sees(xmax, cmax))
+}
+
+// Dead reports whether a version deleted by xmax is invisible to every
+// snapshot in use or to come.
+func (s *Snapshot) Dead(xmax uint64) bool {
+	return xmax != 0 && xmax < s.Horizon
+}
+
+// txnIDReservation is how many transaction IDs the manager reserves at a time.
+const txnIDReservation = 1024
+
+// Manager hands out transactions and owns the lock manager. Transaction IDs
+// keep increasing across restarts, as they are stored in row versions: they
+// are reserved in batches through the reserve function, which persists the
+// end of each batch.
+type Manager struct {
+	mu      sync.Mutex
+	nextID  uint64
+	limit   uint64 // first ID past the current reservation
+	reserve func(n uint64) (uint64, error)
+	active  map[uint64]*Transaction
+	locks   *LockManager
+}
+
+// NewManager creates a transaction manager whose lock waits give up after
+// lockTimeout. reserve returns the first of n transaction IDs no transaction
+// used before.
+func NewManager(lockTimeout time.Duration, reserve func(n uint64) (uint64, error)) *Manager {
+	return &Manager{
+		reserve: reserve,
+		active:  make(map[uint64]*Transaction),
+		locks:   NewLockManager(lockTimeout),
+	}
+}
+
+// Begin starts a new transaction.
+func (m *Manager) Begin(readOnly bool, isolation IsolationLevel) (*Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nextID == m.limit {
+		first, err := m.reserve(txnIDReservation)
+		if err != nil {
+			return nil, err
+		}
+		m.nextID, m.limit = first, first+txnIDReservation
+	}
+	t := &Transaction{ID: m.nextID, ReadOnly: readOnly, Isolation: isolation, state: StateActive}
+	m.nextID++
+	m.active[t.ID] = t
+	return t, nil
+}
+
+// SetIsolation changes the isolation level of a transaction that has not
+// run a statement yet.
+func (m *Manager) SetIsolation(t *Transaction, isolation IsolationLevel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.snapshot != nil {
+		return errors.New("SET TRANSACTION ISOLATION LEVEL must be called before any query")
+	}
+	t.Isolation = isolation
+	return nil
+}
+
+// TakeSnapshot starts the next statement of t and gives it the snapshot it
+// reads through. READ COMMITTED and SERIALIZABLE transactions take a new one
+// for each statement, after acquiring its locks; REPEATABLE READ ones keep
+// the transactions of their first. Each statement sees the changes of the
+// statements of t before it, and not those of the statements after it,
+// while its rows are still being read.
+func (m *Manager) TakeSnapshot(t *Transaction) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.command == math.MaxUint32 {
+		return nil, errors.New("cannot have more than 2^32-1 statements in a transaction")
+	}
+	t.command++
+	if t.snapshot != nil && t.Isolation == RepeatableRead {
+		s := *t.snapshot
+		s.Command = t.command
+		t.snapshot = &s
+		return t.snapshot, nil
+	}
+	s := &Snapshot{TxnID: t.ID, Command: t.command, Xmin: m.nextID, Xmax: m.nextID, Active: make(map[uint64]bool), Horizon: m.nextID}
+	for id, other := range m.active {
+		if id < s.Xmin {
+			s.Xmin = id
+		}
+		if id != t.ID {
+			s.Active[id] = true
+		}
+		if other.xmin != 0 && other.xmin < s.Horizon {
+			s.Horizon = other.xmin
+		}
+		if id < s.Horizon {
+			s.Horizon = id
+		}
+	}
+	if t.xmin == 0 {
+		t.xmin = s.Xmin
+	}
+	t.snapshot = s
+	return s, nil
+}
+
+// Savepoint sets a savepoint named name at the current point of t. It hides
+// older savepoints of the same name until it is released.
+func (m *Manager) Savepoint(t *Transaction, name string) error {
+	if t.state != StateActive {
+		return ErrNotActive
+	}
+	t.savepoints = append(t.savepoints, savepoint{name: name, mark: t.UndoMark(), locks: m.locks.HeldLocks(t.ID)})
+	return nil
+}
+
+// RollbackToSavepoint removes the undo records t logged since the savepoint
+// named name and passes them, newest first, to undo. Once they are applied,
+// it releases the locks t acquired since, which other transactions may take
+// at once. The savepoint stays set; those set after it are released. When
+// undo fails, t keeps its locks.
+func (m *Manager) RollbackToSavepoint(t *Transaction, name string, undo func([]UndoRecord) error) error {
+	if t.state != StateActive {
+		return ErrNotActive
+	}
+	i, err := t.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	sp := t.savepoints[i]
+	t.savepoints = t.savepoints[:i+1]
+	if err := undo(t.TakeUndoSince(sp.mark)); err != nil {
+		return err
+	}
+	m.locks.Restore(t.ID, sp.locks)
+	return nil
+}
+
+// ReleaseSavepoint forgets the savepoint named name and those set after it,
+// keeping the changes made since.
+func (m *Manager) ReleaseSavepoint(t *Transaction, name string) error {
+	if t.state != StateActive {
+		return ErrNotActive
+	}
+	i, err := t.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	t.savepoints = t.savepoints[:i]
+	return nil
+}
+
+// Lock acquires a lock on resource for the transaction.
+func (m *Manager) Lock(ctx context.Context, t *Transaction, resource string, mode LockMode) error {
+	if t.state != StateActive {
+		return ErrNotActive
+	}
+	if mode == LockExclusive && t.ReadOnly {
+		return ErrReadOnly
+	}
+	return m.locks.Lock(ctx, t.ID, resource, mode)
+}
+
+// Finish ends the transaction in the given state and releases its locks.
+// Undo records must already have been applied for aborted transactions.
+func (m *Manager) Finish(t *Transaction, state State) {
+	m.mu.Lock()
+	delete(m.active, t.ID)
+	m.mu.Unlock()
+	t.state = state
+	t.undoLog = nil
+	t.deferred = nil
+	t.snapshot = nil
+	t.savepoints = nil
+	m.locks.UnlockAll(t.ID)
+}
+
+// Committing marks t as committing: Active leaves it out from then on,
+// while snapshots still see it running until it finishes.
+func (m *Manager) Committing(t *Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.committing = true
+}
+
+// Active returns the transactions that have not finished yet, nor started
+// committing.
+func (m *Manager) Active() []*Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := make([]*Transaction, 0, len(m.active))
+	for _, t := range m.active {
+		if !t.committing {
+			active = append(active, t)
+		}
+	}
+	return active
+}
+
+// LockManager returns the lock manager used by the transactions.
+func (m *Manager) LockManager() *LockManager {
+	return m.locks
+}
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrDivisionByZero is returned when / or % has a zero divisor.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// ErrIntegerOutOfRange is returned when integer arithmetic overflows.
+var ErrIntegerOutOfRange = errors.New("integer out of range")
+
+// ArithmeticType returns the type of the result of an arithmetic operator
+// applied to values of types a and b. Integers yield integers and a real
+// operand makes the result real. TypeNull stands for a type only known at
+// run time.
+func ArithmeticType(op string, a, b Type) (Type, error) {
+	switch {
+	case a == TypeNull:
+		return b, checkNumeric(op, a, b)
+	case b == TypeNull:
+		return a, checkNumeric(op, a, b)
+	case a == TypeInteger && b == TypeInteger:
+		return TypeInteger, nil
+	}
+	return TypeReal, checkNumeric(op, a, b)
+}
+
+func checkNumeric(op string, a, b Type) error {
+	if (a == TypeNull || isNumeric(a)) && (b == TypeNull || isNumeric(b)) {
+		return nil
+	}
+	return fmt.Errorf("operator does not exist: %s %s %s", a, op, b)
+}
+
+// Arithmetic applies +, -, *, / or % to two values. NULL operands give NULL.
+// Integer division truncates toward zero. Overflowing the range of an
+// integer and dividing by zero are errors.
+func Arithmetic(op string, a, b Value) (Value, error) {
+	if a.IsNull() || b.IsNull() {
+		return Null(), nil
+	}
+	if err := checkNumeric(op, a.Type, b.Type); err != nil {
+		return Null(), err
+	}
+	if a.Type == TypeInteger && b.Type == TypeInteger {
+		return integerArithmetic(op, a.Int, b.Int)
+	}
+	x, y := toFloat(a), toFloat(b)
+	switch op {
+	case "+":
+		return NewReal(x + y), nil
+	case "-":
+		return NewReal(x - y), nil
+	case "*":
+		return NewReal(x * y), nil
+	case "/":
+		if y == 0 {
+			return Null(), ErrDivisionByZero
+		}
+		return NewReal(x / y), nil
+	case "%":
+		if y == 0 {
+			return Null(), ErrDivisionByZero
+		}
+		return NewReal(math.Mod(x, y)), nil
+	}
+	return Null(), fmt.Errorf("unsupported operator %s", op)
+}
+
+func integerArithmetic(op string, x, y int64) (Value, error) {
+	switch op {
+	case "+":
+		r := x + y
+		if (r > x) != (y > 0) {
+			return Null(), ErrIntegerOutOfRange
+		}
+		return NewInteger(r), nil
+	case "-":
+		r := x - y
+		if (r < x) != (y > 0) {
+			return Null(), ErrIntegerOutOfRange
+		}
+		return NewInteger(r), nil
+	case "*":
+		r := x * y
+		if x != 0 && (r/x != y || (x == -1 && y == math.MinInt64)) {
+			return Null(), ErrIntegerOutOfRange
+		}
+		return NewInteger(r), nil
+	case "/":
+		if y == 0 {
+			return Null(), ErrDivisionByZero
+		}
+		if x == math.MinInt64 && y == -1 {
+			return Null(), ErrIntegerOutOfRange
+		}
+		return NewInteger(x / y), nil
+	case "%":
+		if y == 0 {
+			return Null(), ErrDivisionByZero
+		}
+		return NewInteger(x % y), nil
+	}
+	return Null(), fmt.Errorf("unsupported operator %s", op)
+}
+
+// Concat joins the text forms of two values, giving NULL if either is NULL.
+func Concat(a, b Value) Value {
+	if a.IsNull() || b.IsNull() {
+		return Null()
+	}
+	return NewText(a.String() + b.String())
+}
@@ -0,0 +1,50 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type identifies the SQL type of a column or value.
+type Type int64
+
+const (
+	TypeNull    Type = 0
+	TypeInteger Type = 1
+	TypeReal    Type = 2
+	TypeText    Type = 3
+	TypeBoolean Type = 4
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeNull:
+		return "NULL"
+	case TypeInteger:
+		return "INTEGER"
+	case TypeReal:
+		return "REAL"
+	case TypeText:
+		return "TEXT"
+	case TypeBoolean:
+		return "BOOLEAN"
+	default:
+		return fmt.Sprintf("Type(%d)", int64(t))
+	}
+}
+
+// ParseType maps a SQL type name, as written in CREATE TABLE, to a Type.
+func ParseType(name string) (Type, error) {
+	switch strings.ToUpper(name) {
+	case "INT", "INTEGER", "BIGINT", "SMALLINT":
+		return TypeInteger, nil
+	case "REAL", "FLOAT", "DOUBLE", "NUMERIC", "DECIMAL":
+		return TypeReal, nil
+	case "TEXT", "VARCHAR", "CHAR", "STRING":
+		return TypeText, nil
+	case "BOOL", "BOOLEAN":
+		return TypeBoolean, nil
+	default:
+		return TypeNull, fmt.Errorf("unknown type %s", name)
+	}
+}
@@ -0,0 +1,313 @@
+package types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a single typed SQL value. Only the field matching Type is meaningful.
+type Value struct {
+	Type  Type
+	Int   int64
+	Float float64
+	Str   string
+	Bool  bool
+}
+
+func Null() Value {
+	return Value{Type: TypeNull}
+}
+
+func NewInteger(i int64) Value {
+	return Value{Type: TypeInteger, Int: i}
+}
+
+func NewReal(f float64) Value {
+	return Value{Type: TypeReal, Float: f}
+}
+
+func NewText(s string) Value {
+	return Value{Type: TypeText, Str: s}
+}
+
+func NewBoolean(b bool) Value {
+	return Value{Type: TypeBoolean, Bool: b}
+}
+
+func (v Value) IsNull() bool {
+	return v.Type == TypeNull
+}
+
+// Interface returns the value as a plain Go value (nil, int64, float64, string or bool).
+func (v Value) Interface() interface{} {
+	switch v.Type {
+	case TypeInteger:
+		return v.Int
+	case TypeReal:
+		return v.Float
+	case TypeText:
+		return v.Str
+	case TypeBoolean:
+		return v.Bool
+	default:
+		return nil
+	}
+}
+
+func (v Value) String() string {
+	switch v.Type {
+	case TypeInteger:
+		return strconv.FormatInt(v.Int, 10)
+	case TypeReal:
+		return strconv.FormatFloat(v.Float, 'g', -1, 64)
+	case TypeText:
+		return v.Str
+	case TypeBoolean:
+		if v.Bool {
+			return "true"
+		}
+		return "false"
+	default:
+		return "NULL"
+	}
+}
+
+// FromInterface converts a Go value into a Value.
+func FromInterface(i interface{}) (Value, error) {
+	switch x := i.(type) {
+	case nil:
+		return Null(), nil
+	case Value:
+		return x, nil
+	case int:
+		return NewInteger(int64(x)), nil
+	case int8:
+		return NewInteger(int64(x)), nil
+	case int16:
+		return NewInteger(int64(x)), nil
+	case int32:
+		return NewInteger(int64(x)), nil
+	case int64:
+		return NewInteger(x), nil
+	case uint8:
+		return NewInteger(int64(x)), nil
+	case uint16:
+		return NewInteger(int64(x)), nil
+	case uint32:
+		return NewInteger(int64(x)), nil
+	case float32:
+		return NewReal(float64(x)), nil
+	case float64:
+		return NewReal(x), nil
+	case string:
+		return NewText(x), nil
+	case []byte:
+		return NewText(string(x)), nil
+	case bool:
+		return NewBoolean(x), nil
+	default:
+		return Null(), fmt.Errorf("unsupported value of type %T", i)
+	}
+}
+
+// Cast converts the value to the given type. NULL casts to NULL of any type.
+func (v Value) Cast(t Type) (Value, error) {
+	if v.Type == t || v.IsNull() || t == TypeNull {
+		return v, nil
+	}
+	switch t {
+	case TypeInteger:
+		switch v.Type {
+		case TypeReal:
+			return NewInteger(int64(v.Float)), nil
+		case TypeBoolean:
+			if v.Bool {
+				return NewInteger(1), nil
+			}
+			return NewInteger(0), nil
+		case TypeText:
+			i, err := strconv.ParseInt(strings.TrimSpace(v.Str), 10, 64)
+			if err != nil {
+				return Null(), fmt.Errorf("cannot convert %q to INTEGER", v.Str)
+			}
+			return NewInteger(i), nil
+		}
+	case TypeReal:
+		switch v.Type {
+		case TypeInteger:
+			return NewReal(float64(v.Int)), nil
+		case TypeText:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v.Str), 64)
+			if err != nil {
+				return Null(), fmt.Errorf("cannot convert %q to REAL", v.Str)
+			}
+			return NewReal(f), nil
+		}
+	case TypeText:
+		return NewText(v.String()), nil
+	case TypeBoolean:
+		switch v.Type {
+		case TypeInteger:
+			return NewBoolean(v.Int != 0), nil
+		case TypeText:
+			b, err := strconv.ParseBool(strings.TrimSpace(v.Str))
+			if err != nil {
+				return Null(), fmt.Errorf("cannot convert %q to BOOLEAN", v.Str)
+			}
+			return NewBoolean(b), nil
+		}
+	}
+	return Null(), fmt.Errorf("cannot convert %s to %s", v.Type, t)
+}
+
+// CanCast reports whether values of type from may be cast to type to. A cast
+// from TEXT may still fail for a particular value.
+func CanCast(from, to Type) bool {
+	if from == to || from == TypeNull || to == TypeNull || from == TypeText || to == TypeText {
+		return true
+	}
+	switch to {
+	case TypeInteger:
+		return from == TypeReal || from == TypeBoolean
+	case TypeReal, TypeBoolean:
+		return from == TypeInteger
+	}
+	return false
+}
+
+// Compare orders two non-NULL values. Integers and reals compare numerically,
+// any other pair of differing types is an error.
+func Compare(a, b Value) (int, error) {
+	if a.IsNull() || b.IsNull() {
+		return 0, errors.New("cannot compare NULL values")
+	}
+	if a.Type != b.Type {
+		if isNumeric(a.Type) && isNumeric(b.Type) {
+			return compareFloat(toFloat(a), toFloat(b)), nil
+		}
+		// Text literals compared against typed columns are coerced to the other side.
+		if a.Type == TypeText {
+			converted, err := a.Cast(b.Type)
+			if err != nil {
+				return 0, err
+			}
+			return Compare(converted, b)
+		}
+		if b.Type == TypeText {
+			converted, err := b.Cast(a.Type)
+			if err != nil {
+				return 0, err
+			}
+			return Compare(a, converted)
+		}
+		return 0, fmt.Errorf("cannot compare %s with %s", a.Type, b.Type)
+	}
+	switch a.Type {
+	case TypeInteger:
+		switch {
+		case a.Int < b.Int:
+			return -1, nil
+		case a.Int > b.Int:
+			return 1, nil
+		}
+		return 0, nil
+	case TypeReal:
+		return compareFloat(a.Float, b.Float), nil
+	case TypeText:
+		return strings.Compare(a.Str, b.Str), nil
+	case TypeBoolean:
+		switch {
+		case a.Bool == b.Bool:
+			return 0, nil
+		case !a.Bool:
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("cannot compare values of type %s", a.Type)
+}
+
+func isNumeric(t Type) bool {
+	return t == TypeInteger || t == TypeReal
+}
+
+func toFloat(v Value) float64 {
+	if v.Type == TypeInteger {
+		return float64(v.Int)
+	}
+	return v.Float
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Encode serializes the value into a byte slice suitable for a record field.
+// The first byte is the type tag followed by the payload.
+func (v Value) Encode() []byte {
+	switch v.Type {
+	case TypeInteger:
+		buf := make([]byte, 9)
+		buf[0] = byte(TypeInteger)
+		binary.LittleEndian.PutUint64(buf[1:], uint64(v.Int))
+		return buf
+	case TypeReal:
+		buf := make([]byte, 9)
+		buf[0] = byte(TypeReal)
+		binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(v.Float))
+		return buf
+	case TypeText:
+		buf := make([]byte, 1+len(v.Str))
+		buf[0] = byte(TypeText)
+		copy(buf[1:], v.Str)
+		return buf
+	case TypeBoolean:
+		buf := []byte{byte(TypeBoolean), 0}
+		if v.Bool {
+			buf[1] = 1
+		}
+		return buf
+	default:
+		return []byte{byte(TypeNull)}
+	}
+}
+
+// Decode deserializes a value produced by Encode.
+func Decode(data []byte) (Value, error) {
+	if len(data) == 0 {
+		return Null(), errors.New("empty value encoding")
+	}
+	switch Type(data[0]) {
+	case TypeNull:
+		return Null(), nil
+	case TypeInteger:
+		if len(data) != 9 {
+			return Null(), errors.New("invalid integer encoding")
+		}
+		return NewInteger(int64(binary.LittleEndian.Uint64(data[1:]))), nil
+	case TypeReal:
+		if len(data) != 9 {
+			return Null(), errors.New("invalid real encoding")
+		}
+		return NewReal(math.Float64frombits(binary.LittleEndian.Uint64(data[1:]))), nil
+	case TypeText:
+		return NewText(string(data[1:])), nil
+	case TypeBoolean:
+		if len(data) != 2 {
+			return Null(), errors.New("invalid boolean encoding")
+		}
+		return NewBoolean(data[1] == 1), nil
+	default:
+		return Null(), fmt.Errorf("unknown type tag %d", data[0])
+	}
+}
@@ -0,0 +1,380 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/roackb2/simple_db/internal/executor"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// Conn is a single session with the database. Statements outside of a
+// transaction run in their own implicit transaction. Transactions started with
+// BEGIN, statements prepared with PREPARE and cursors declared with DECLARE
+// belong to the connection. A Conn must not be used from multiple goroutines
+// at once.
+type Conn struct {
+	db             *DB
+	mu             sync.Mutex
+	txn            *txn.Transaction                       // explicit transaction, nil in autocommit mode
+	prepared       map[string]*executor.PreparedStatement // statements named with PREPARE
+	cursors        map[string]*cursor                     // cursors of the transaction, named with DECLARE
+	session        *executor.Session                      // values of nextval for currval, made on first use
+	autocommitOnly bool                                   // set for the throwaway connections behind DB.Exec and DB.Query
+	closed         bool
+}
+
+// Close rolls back any open transaction and releases the connection.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.prepared = nil
+	if c.txn != nil {
+		t := c.txn
+		c.txn = nil
+		err := c.closeCursors()
+		if rollbackErr := c.db.rollback(t); rollbackErr != nil {
+			err = rollbackErr
+		}
+		return err
+	}
+	return nil
+}
+
+// BeginTx starts a transaction on the connection.
+func (c *Conn) BeginTx(ctx context.Context, opts *TxOptions) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.db.isClosed() {
+		return nil, ErrClosed
+	}
+	if c.txn != nil {
+		return nil, errors.New("simpledb: a transaction is already in progress")
+	}
+	var o TxOptions
+	if opts != nil {
+		o = *opts
+	}
+	t, err := c.db.begin(o.ReadOnly, o.Isolation)
+	if err != nil {
+		return nil, err
+	}
+	c.txn = t
+	return &Tx{ctx: ctx, conn: c, txn: t}, nil
+}
+
+// Prepare parses a statement once so it can be executed many times on the
+// connection with different arguments.
+func (c *Conn) Prepare(query string) (*Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext parses a statement once so it can be executed many times on
+// the connection with different arguments.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	stmt.conn = c
+	return stmt, nil
+}
+
+// Exec runs a statement that doesn't return rows.
+func (c *Conn) Exec(query string, args ...interface{}) (Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext runs a statement that doesn't return rows.
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (Result, error) {
+	p, err := c.db.prepare(query)
+	if err != nil {
+		return Result{}, err
+	}
+	return c.execPrepared(ctx, p, args)
+}
+
+// Query runs a statement that returns rows.
+func (c *Conn) Query(query string, args ...interface{}) (*Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext runs a statement that returns rows. In autocommit mode the
+// implicit transaction ends when the rows are closed.
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	p, err := c.db.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return c.queryPrepared(ctx, p, args)
+}
+
+func (c *Conn) execPrepared(ctx context.Context, p *executor.PreparedStatement, args []interface{}) (Result, error) {
+	res, finish, err := c.statement(ctx, p, args)
+	if err != nil {
+		return Result{}, err
+	}
+	if res.Rows != nil {
+		// Drain queries passed to Exec so they behave like any other statement.
+		err = c.drain(res.Rows)
+	}
+	if finishErr := finish(err); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{rowsAffected: res.RowsAffected, lastInsertID: res.LastInsertID}, nil
+}
+
+func (c *Conn) queryPrepared(ctx context.Context, p *executor.PreparedStatement, args []interface{}) (*Rows, error) {
+	res, finish, err := c.statement(ctx, p, args)
+	if err != nil {
+		return nil, err
+	}
+	if res.Rows == nil {
+		if err := finish(nil); err != nil {
+			return nil, err
+		}
+		return newRows(ctx, c.db, res, nil), nil
+	}
+	return newRows(ctx, c.db, res, finish), nil
+}
+
+func (c *Conn) drain(rows executor.RowIterator) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	defer rows.Close()
+	for {
+		row, err := rows.Next()
+		if err != nil || row == nil {
+			return err
+		}
+	}
+}
+
+func noFinish(error) error {
+	return nil
+}
+
+// statement runs a statement in the connection's transaction, starting an
+// implicit one in autocommit mode. The returned finish function must be called
+// once the statement's rows are consumed; it commits or rolls back the
+// implicit transaction depending on whether the statement failed.
+func (c *Conn) statement(ctx context.Context, p *executor.PreparedStatement, args []interface{}) (*executor.Result, func(error) error, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+	if c.session == nil {
+		c.session = executor.NewSession()
+	}
+	ctx = executor.WithSession(ctx, c.session)
+
+	if executor.IsSessionStatement(p.Statement) {
+		if c.autocommitOnly {
+			return nil, nil, errors.New("simpledb: use Begin, Prepare or a dedicated Conn for session statements")
+		}
+		switch p.Statement.StatementType {
+		case parser.StatementExecute:
+			return c.executeNamed(ctx, p.Statement.ExecuteStmt)
+		case parser.StatementFetch:
+			return c.fetch(ctx, p.Statement.FetchStmt)
+		case parser.StatementDeclare:
+			if err := c.declare(ctx, p.Statement, args); err != nil {
+				return nil, nil, err
+			}
+			return &executor.Result{}, noFinish, nil
+		}
+		if err := c.sessionStatement(p.Statement); err != nil {
+			return nil, nil, err
+		}
+		return &executor.Result{}, noFinish, nil
+	}
+	return c.runStatement(ctx, p, args)
+}
+
+// runStatement runs a data statement. The caller must hold c.mu.
+func (c *Conn) runStatement(ctx context.Context, p *executor.PreparedStatement, args []interface{}) (*executor.Result, func(error) error, error) {
+	if c.txn != nil {
+		res, err := c.db.run(ctx, c.txn, p, args)
+		return res, noFinish, err
+	}
+
+	t, err := c.db.begin(false, txn.ReadCommitted)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := c.db.run(ctx, t, p, args)
+	if err != nil {
+		c.db.rollback(t)
+		return nil, nil, err
+	}
+	finish := func(err error) error {
+		if err != nil {
+			return c.db.rollback(t)
+		}
+		return c.db.commit(t)
+	}
+	return res, finish, nil
+}
+
+// executeNamed runs a statement prepared with PREPARE. The caller must hold c.mu.
+func (c *Conn) executeNamed(ctx context.Context, executeStmt *parser.ExecuteStatement) (*executor.Result, func(error) error, error) {
+	p, ok := c.prepared[strings.ToLower(executeStmt.Name)]
+	if !ok {
+		return nil, nil, fmt.Errorf("simpledb: prepared statement %s does not exist", executeStmt.Name)
+	}
+	args := make([]interface{}, len(executeStmt.Params))
+	for i, value := range executeStmt.Params {
+		args[i] = value
+	}
+	return c.runStatement(ctx, p, args)
+}
+
+// sessionStatement handles transaction control, savepoints, SET
+// TRANSACTION, CHECKPOINT, named prepared statements and closing cursors. The caller must hold c.mu.
+func (c *Conn) sessionStatement(stmt *parser.Statement) error {
+	switch stmt.StatementType {
+	case parser.StatementBegin:
+		if c.txn != nil {
+			return errors.New("simpledb: a transaction is already in progress")
+		}
+		t, err := c.db.begin(false, txn.ReadCommitted)
+		if err != nil {
+			return err
+		}
+		c.txn = t
+		return nil
+	case parser.StatementSetTransaction:
+		if c.txn == nil {
+			return errors.New("simpledb: SET TRANSACTION can only be used in transaction blocks")
+		}
+		return c.db.txns.SetIsolation(c.txn, isolationLevel(stmt.SetTxnStmt.Isolation))
+	case parser.StatementCommit, parser.StatementRollback:
+		if c.txn == nil {
+			return errors.New("simpledb: no transaction in progress")
+		}
+		t := c.txn
+		c.txn = nil
+		if err := c.closeCursors(); err != nil {
+			c.db.rollback(t)
+			return err
+		}
+		if stmt.StatementType == parser.StatementCommit {
+			return c.db.commit(t)
+		}
+		return c.db.rollback(t)
+	case parser.StatementSavepoint, parser.StatementRollbackTo, parser.StatementRelease:
+		if c.txn == nil {
+			return errors.New("simpledb: savepoints can only be used in transaction blocks")
+		}
+		return c.savepoint(stmt.StatementType, stmt.SavepointStmt.Name)
+	case parser.StatementCheckpoint:
+		if c.txn != nil {
+			return errors.New("simpledb: CHECKPOINT cannot run inside a transaction block")
+		}
+		return c.db.checkpoint()
+	case parser.StatementPrepare:
+		name := strings.ToLower(stmt.PrepareStmt.Name)
+		if _, exists := c.prepared[name]; exists {
+			return fmt.Errorf("simpledb: prepared statement %s already exists", stmt.PrepareStmt.Name)
+		}
+		p := executor.NewPreparedStatement(stmt.PrepareStmt.Statement)
+		c.db.mu.Lock()
+		err := c.db.executor.Prepare(p)
+		c.db.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if c.prepared == nil {
+			c.prepared = make(map[string]*executor.PreparedStatement)
+		}
+		c.prepared[name] = p
+		return nil
+	case parser.StatementDeallocate:
+		name := strings.ToLower(stmt.DeallocStmt.Name)
+		if _, exists := c.prepared[name]; !exists {
+			return fmt.Errorf("simpledb: prepared statement %s does not exist", stmt.DeallocStmt.Name)
+		}
+		delete(c.prepared, name)
+		return nil
+	case parser.StatementClose:
+		return c.closeCursor(stmt.CloseStmt.Name)
+	default:
+		return fmt.Errorf("simpledb: unsupported session statement %d", stmt.StatementType)
+	}
+}
+
+// savepoint sets, rolls back to or releases a savepoint of the connection's
+// transaction, as stmtType says. Rolling back closes the cursors declared
+// since the savepoint was set. The caller must hold c.mu.
+func (c *Conn) savepoint(stmtType parser.StatementTypeCode, name string) error {
+	switch stmtType {
+	case parser.StatementSavepoint:
+		return c.db.txns.Savepoint(c.txn, name)
+	case parser.StatementRollbackTo:
+		if err := c.db.rollbackTo(c.txn, name); err != nil {
+			return err
+		}
+		return c.closeCursorsFrom(c.txn.Savepoints())
+	default:
+		if err := c.db.txns.ReleaseSavepoint(c.txn, name); err != nil {
+			return err
+		}
+		// The cursors declared since now belong to the savepoint set before.
+		for _, cur := range c.cursors {
+			if cur.level > c.txn.Savepoints() {
+				cur.level = c.txn.Savepoints()
+			}
+		}
+		return nil
+	}
+}
+
+// txSavepoint runs savepoint on behalf of the connection's explicit
+// transaction t.
+func (c *Conn) txSavepoint(t *txn.Transaction, stmtType parser.StatementTypeCode, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.txn != t {
+		return ErrTxDone
+	}
+	return c.savepoint(stmtType, name)
+}
+
+// endTx finishes the connection's explicit transaction t.
+func (c *Conn) endTx(t *txn.Transaction, commit bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.txn != t {
+		return ErrTxDone
+	}
+	c.txn = nil
+	if err := c.closeCursors(); err != nil {
+		c.db.rollback(t)
+		return err
+	}
+	if commit {
+		return c.db.commit(t)
+	}
+	return c.db.rollback(t)
+}
+
+func (db *DB) isClosed() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closed
+}
@@ -0,0 +1,152 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/executor"
+	"github.com/roackb2/simple_db/internal/parser"
+)
+
+// cursor is a query declared with DECLARE CURSOR. Its rows stay open until
+// the cursor is closed or its transaction ends, so each FETCH carries on
+// where the previous one stopped instead of running the query again. Scans
+// only keep their position, a page and a slot, between FETCHes, so an idle
+// cursor pins no pages. Rolling back to a savepoint set before the cursor
+// was declared closes it.
+type cursor struct {
+	columns []catalog.Column
+	rows    executor.RowIterator
+	done    bool // set once the rows run out or the cursor is closed
+	level   int  // savepoints set in the transaction when it was declared
+}
+
+// declare runs the query of a DECLARE CURSOR in the connection's
+// transaction, binding args to its parameters. The query outlives ctx, which
+// only the DECLARE is run under: each FETCH is cancelled by its own context.
+// The caller must hold c.mu.
+func (c *Conn) declare(ctx context.Context, stmt *parser.Statement, args []interface{}) error {
+	declareStmt := stmt.DeclareStmt
+	if c.txn == nil {
+		return errors.New("simpledb: DECLARE CURSOR can only be used in transaction blocks")
+	}
+	name := strings.ToLower(declareStmt.Name)
+	if _, exists := c.cursors[name]; exists {
+		return fmt.Errorf("simpledb: cursor %s already exists", declareStmt.Name)
+	}
+	p := executor.NewPreparedStatement(&parser.Statement{
+		PrepareRes:    parser.PrepareSuccess,
+		StatementType: parser.StatementSelect,
+		SelectStmt:    declareStmt.Select,
+		NumParams:     stmt.NumParams,
+	})
+	c.db.mu.Lock()
+	err := c.db.executor.Prepare(p)
+	c.db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	res, err := c.db.run(context.WithoutCancel(ctx), c.txn, p, args)
+	if err != nil {
+		return err
+	}
+	if c.cursors == nil {
+		c.cursors = make(map[string]*cursor)
+	}
+	c.cursors[name] = &cursor{columns: res.Columns, rows: res.Rows, level: c.txn.Savepoints()}
+	return nil
+}
+
+// fetch returns the next rows of a cursor. They are read from the cursor as
+// the caller asks for them: rows of a FETCH left unread are returned by the
+// next one, and stop with ctx's error once it is done. The caller must hold
+// c.mu.
+func (c *Conn) fetch(ctx context.Context, fetchStmt *parser.FetchStatement) (*executor.Result, func(error) error, error) {
+	cur, ok := c.cursors[strings.ToLower(fetchStmt.Name)]
+	if !ok {
+		return nil, nil, fmt.Errorf("simpledb: cursor %s does not exist", fetchStmt.Name)
+	}
+	rows := &cursorRows{ctx: ctx, cursor: cur, remaining: fetchStmt.Count}
+	return &executor.Result{Columns: cur.columns, Rows: rows}, noFinish, nil
+}
+
+// closeCursor closes the named cursor, or every cursor of the connection
+// when name is empty. The caller must hold c.mu.
+func (c *Conn) closeCursor(name string) error {
+	if name == "" {
+		return c.closeCursors()
+	}
+	cur, ok := c.cursors[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("simpledb: cursor %s does not exist", name)
+	}
+	delete(c.cursors, strings.ToLower(name))
+	cur.done = true
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return cur.rows.Close()
+}
+
+// closeCursors closes the cursors of the connection, as its transaction
+// ends. The caller must hold c.mu.
+func (c *Conn) closeCursors() error {
+	err := c.closeCursorsFrom(0)
+	c.cursors = nil
+	return err
+}
+
+// closeCursorsFrom closes the cursors declared once level savepoints or
+// more were set, as the transaction rolls back to the savepoint at level.
+// The caller must hold c.mu.
+func (c *Conn) closeCursorsFrom(level int) error {
+	var err error
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for name, cur := range c.cursors {
+		if cur.level < level {
+			continue
+		}
+		cur.done = true
+		if closeErr := cur.rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(c.cursors, name)
+	}
+	return err
+}
+
+// cursorRows returns up to remaining rows of a cursor, or all of those left
+// when remaining is negative. Closing it leaves the cursor open.
+type cursorRows struct {
+	ctx       context.Context
+	cursor    *cursor
+	remaining int64
+}
+
+func (r *cursorRows) Next() (executor.Row, error) {
+	if r.remaining == 0 || r.cursor.done {
+		return nil, nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	row, err := r.cursor.rows.Next()
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		r.cursor.done = true
+		return nil, nil
+	}
+	if r.remaining > 0 {
+		r.remaining--
+	}
+	return row, nil
+}
+
+func (r *cursorRows) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Each FETCH runs under its own context, not the one of the DECLARE.
+func TestCursorContext(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY)")
+	for i := 1; i <= 5; i++ {
+		mustExec(t, db, "INSERT INTO t (id) VALUES (?)", i)
+	}
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	mustExec(t, c, "BEGIN")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := c.ExecContext(ctx, "DECLARE cur CURSOR FOR SELECT id FROM t ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if got := queryInts(t, c, "FETCH 2 FROM cur"); !reflect.DeepEqual(got, [][]int64{{1}, {2}}) {
+		t.Fatalf("got %v, want [[1] [2]]", got)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	rows, err := c.QueryContext(ctx, "FETCH ALL FROM cur")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for rows.Next() {
+	}
+	if err := rows.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v after cancelling the FETCH, want context.Canceled", err)
+	}
+	rows.Close()
+	if got := queryInts(t, c, "FETCH ALL FROM cur"); !reflect.DeepEqual(got, [][]int64{{4}, {5}}) {
+		t.Fatalf("got %v, want [[4] [5]]", got)
+	}
+}
@@ -0,0 +1,501 @@
+// Package simpledb is the embeddable Go API of Simple DB. It wraps the parser,
+// the executor and the buffer pool behind a small database/sql-like interface.
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/executor"
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/storage"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// DefaultBufferPoolSize is the number of pages cached when Options doesn't say otherwise.
+const DefaultBufferPoolSize = 256
+
+// DefaultLockTimeout bounds how long a statement waits for a table lock.
+const DefaultLockTimeout = 5 * time.Second
+
+// DefaultWriterDelay is how often the background writer runs when Options
+// doesn't say otherwise.
+const DefaultWriterDelay = 200 * time.Millisecond
+
+// DefaultWorkMem is the memory, in bytes, a sort, hash or WITH query may use
+// when Options doesn't say otherwise.
+const DefaultWorkMem = executor.DefaultWorkMem
+
+// DefaultMaxRecursion is how many times the recursive term of a WITH
+// RECURSIVE query may run when Options doesn't say otherwise.
+const DefaultMaxRecursion = executor.DefaultMaxRecursion
+
+var (
+	// ErrClosed is returned when using a database, connection or transaction after it was closed.
+	ErrClosed = errors.New("simpledb: database is closed")
+	// ErrTxDone is returned when using a transaction after Commit or Rollback.
+	ErrTxDone = errors.New("simpledb: transaction has already been committed or rolled back")
+	// ErrSerialization is returned when a REPEATABLE READ transaction
+	// changes a row another transaction changed since its snapshot was
+	// taken. The statement fails; the transaction should be retried.
+	ErrSerialization = txn.ErrSerialization
+)
+
+// ConstraintError is returned when a statement would leave a row breaking a
+// constraint of its table. Use errors.As to find which one.
+type ConstraintError = executor.ConstraintError
+
+// ConstraintType is the kind of constraint a ConstraintError reports.
+type ConstraintType = catalog.ConstraintType
+
+// Kinds of constraints.
+const (
+	PrimaryKey = catalog.PrimaryKey
+	Unique     = catalog.Unique
+	Check      = catalog.Check
+	ForeignKey = catalog.ForeignKey
+	NotNull    = catalog.NotNull
+)
+
+// JoinMethod is an algorithm joins can be forced to use with Options.JoinMethod.
+type JoinMethod = executor.JoinMethod
+
+// Join methods.
+const (
+	JoinAuto            = executor.JoinAuto
+	JoinNestedLoop      = executor.JoinNestedLoop
+	JoinIndexNestedLoop = executor.JoinIndexNestedLoop
+	JoinHash            = executor.JoinHash
+	JoinMerge           = executor.JoinMerge
+)
+
+// Options configures a database opened with Open.
+type Options struct {
+	// BufferPoolSize is the number of pages kept in memory.
+	BufferPoolSize int
+	// LockTimeout bounds how long a statement waits for locks held by other
+	// transactions. Negative values wait until the context is done.
+	LockTimeout time.Duration
+	// WorkMem is the memory, in bytes, a sort may use before it spills to
+	// temporary pages. Zero uses DefaultWorkMem.
+	WorkMem int
+	// JoinMethod forces the algorithm used for joins where it applies.
+	// JoinAuto, the zero value, lets the planner choose.
+	JoinMethod JoinMethod
+	// MaxRecursion is how many times the recursive term of a WITH
+	// RECURSIVE query may run. Zero uses DefaultMaxRecursion.
+	MaxRecursion int
+	// WriterDelay is how often the background writer writes back the
+	// dirty pages next in line for eviction. Negative values disable it.
+	WriterDelay time.Duration
+}
+
+// DB is a handle to a database file. It is safe for concurrent use.
+// Statements run one at a time. Transactions read through snapshots, as
+// their isolation level says, and writers take table-level locks held until
+// they finish.
+type DB struct {
+	mu       sync.Mutex // serializes access to the executor and buffer pool
+	bp       *storage.BufferPool
+	executor *executor.Executor
+	catalog  *catalog.Catalog
+	clog     *txn.CommitLog
+	txns     *txn.Manager
+	commits  sync.WaitGroup // commits syncing after releasing mu, which Close waits for
+	closed   bool
+}
+
+// Open opens the database file at path, creating it if it doesn't exist.
+// A nil opts uses the defaults. When the database wasn't closed the last
+// time it was open, the changes of the transactions that didn't commit are
+// removed first.
+func Open(path string, opts *Options) (*DB, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	size := opts.BufferPoolSize
+	if size == 0 {
+		size = DefaultBufferPoolSize
+	}
+	lockTimeout := opts.LockTimeout
+	if lockTimeout == 0 {
+		lockTimeout = DefaultLockTimeout
+	} else if lockTimeout < 0 {
+		lockTimeout = 0
+	}
+
+	bp, err := storage.NewBufferPool(path, size)
+	if err != nil {
+		return nil, err
+	}
+	cat, err := catalog.Load(bp)
+	if err != nil {
+		bp.Close()
+		return nil, err
+	}
+	clog, err := txn.LoadCommitLog(bp, cat)
+	if err != nil {
+		bp.Close()
+		return nil, err
+	}
+	exec := executor.NewExecutor(bp, cat)
+	if opts.WorkMem > 0 {
+		exec.SetWorkMem(opts.WorkMem)
+	}
+	exec.SetJoinMethod(opts.JoinMethod)
+	if opts.MaxRecursion > 0 {
+		exec.SetMaxRecursion(opts.MaxRecursion)
+	}
+	if cat.Running {
+		err = exec.Recover(context.Background(), clog)
+		if err == nil {
+			err = bp.FlushAllPages()
+		}
+	}
+	if err == nil {
+		err = cat.SetRunning(true)
+	}
+	if err != nil {
+		bp.Close()
+		return nil, err
+	}
+	switch {
+	case opts.WriterDelay == 0:
+		bp.StartWriter(DefaultWriterDelay)
+	case opts.WriterDelay > 0:
+		bp.StartWriter(opts.WriterDelay)
+	}
+	return &DB{
+		bp:       bp,
+		executor: exec,
+		catalog:  cat,
+		clog:     clog,
+		txns:     txn.NewManager(lockTimeout, cat.ReserveTxnIDs),
+	}, nil
+}
+
+// Close rolls back unfinished transactions, flushes all pages to disk and
+// closes the database file. Transactions already syncing their commit
+// finish it first.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.mu.Unlock()
+	db.commits.Wait()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var undoErr error
+	for _, t := range db.txns.Active() {
+		if err := db.undo(t, t.TakeUndoSince(0)); err != nil && undoErr == nil {
+			undoErr = err
+		}
+		db.bp.ReleaseTransaction(t.ID)
+		db.txns.Finish(t, txn.StateAborted)
+	}
+	// Running is cleared once every other page is on disk.
+	err := db.bp.FlushAllPages()
+	if err == nil {
+		err = db.catalog.SetRunning(false)
+	}
+	if closeErr := db.bp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return undoErr
+}
+
+// Conn returns a dedicated connection. Transactions started with BEGIN on a
+// connection span the following statements on it.
+func (db *DB) Conn(ctx context.Context) (*Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return &Conn{db: db}, nil
+}
+
+// Exec runs a statement that doesn't return rows in its own transaction.
+func (db *DB) Exec(query string, args ...interface{}) (Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext runs a statement that doesn't return rows in its own transaction.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (Result, error) {
+	conn := &Conn{db: db, autocommitOnly: true}
+	return conn.ExecContext(ctx, query, args...)
+}
+
+// Query runs a statement that returns rows in its own transaction.
+func (db *DB) Query(query string, args ...interface{}) (*Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext runs a statement that returns rows in its own transaction,
+// which ends when the rows are closed. The rows are produced lazily.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	conn := &Conn{db: db, autocommitOnly: true}
+	return conn.QueryContext(ctx, query, args...)
+}
+
+// Begin starts a transaction.
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction. The context is used until the transaction
+// commits or rolls back.
+func (db *DB) BeginTx(ctx context.Context, opts *TxOptions) (*Tx, error) {
+	conn := &Conn{db: db}
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	tx.closeConn = true
+	return tx, nil
+}
+
+// Prepare parses a statement once so it can be executed many times with
+// different arguments. Each execution runs in its own transaction.
+func (db *DB) Prepare(query string) (*Stmt, error) {
+	return db.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext parses a statement once so it can be executed many times
+// with different arguments. Each execution runs in its own transaction.
+func (db *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := db.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{db: db, prepared: p}, nil
+}
+
+// prepare parses the query text into a statement whose parameter types are
+// inferred against the catalog.
+func (db *DB) prepare(query string) (*executor.PreparedStatement, error) {
+	stmt, err := parser.ParseWith(query, db.executor.Functions())
+	if err != nil {
+		return nil, err
+	}
+	p := executor.NewPreparedStatement(stmt)
+	if executor.IsSessionStatement(stmt) {
+		return p, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if err := db.executor.Prepare(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// begin starts a transaction. Starting one may persist the transaction IDs
+// reserved in the catalog, so it runs under db.mu.
+func (db *DB) begin(readOnly bool, isolation txn.IsolationLevel) (*txn.Transaction, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return db.txns.Begin(readOnly, isolation)
+}
+
+// run binds args to a prepared statement and executes it inside transaction t
+// after acquiring the locks it needs, reading through the snapshot t takes
+// once it has them. Only SERIALIZABLE transactions lock the tables they read
+// through the snapshot. A failing statement has its partial changes undone,
+// leaving the transaction as it was before the statement started.
+func (db *DB) run(ctx context.Context, t *txn.Transaction, p *executor.PreparedStatement, args []interface{}) (*executor.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stmt := p.Statement
+	db.mu.Lock()
+	reqs := db.executor.LockRequests(stmt)
+	db.mu.Unlock()
+	for _, req := range reqs {
+		if req.Snapshot && t.Isolation != txn.Serializable {
+			continue
+		}
+		if err := db.txns.Lock(ctx, t, req.Resource, req.Mode); err != nil {
+			return nil, err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	params, err := db.executor.Bind(p, args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.txns.TakeSnapshot(t); err != nil {
+		return nil, err
+	}
+	// The pages the statement changes stay in memory until t finishes.
+	db.bp.SetTransaction(t.ID)
+	defer db.bp.SetTransaction(0)
+	mark := t.UndoMark()
+	res, err := db.executor.Execute(ctx, t, stmt, params)
+	if err != nil {
+		if undoErr := db.executor.Undo(t.TakeUndoSince(mark)); undoErr != nil {
+			return nil, undoErr
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// commit runs the checks the transaction deferred, makes its changes
+// durable and releases its locks. The pages it holds are written under
+// db.mu but synced after it is released, so that transactions committing
+// meanwhile share the fsync; the transaction keeps its locks, and its
+// changes stay invisible to snapshots, until the sync is done. Only then
+// does the commit log record that it committed, if it changed any page, and
+// that is synced in turn: a crash before leaves its pages to be cleaned up
+// by Open.
+func (db *DB) commit(t *txn.Transaction) error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	// A deferred constraint that fails rolls the transaction back.
+	if err := db.executor.CheckDeferred(t); err != nil {
+		undoErr := db.abort(t)
+		db.mu.Unlock()
+		if undoErr != nil {
+			return undoErr
+		}
+		return err
+	}
+	// A commit that fails rolls the transaction back as well, so that it
+	// doesn't keep its locks, and its changes, for good.
+	changed := db.bp.Holds(t.ID)
+	if err := db.bp.WriteTransaction(t.ID); err != nil {
+		db.abort(t)
+		db.mu.Unlock()
+		return err
+	}
+	// From here on, Close waits for the commit instead of rolling t back.
+	db.txns.Committing(t)
+	db.commits.Add(1)
+	defer db.commits.Done()
+	db.mu.Unlock()
+	err := db.bp.Sync()
+	if err == nil && changed {
+		db.mu.Lock()
+		err = db.clog.Commit(t.ID)
+		db.mu.Unlock()
+		if err == nil {
+			err = db.bp.Sync()
+		}
+	}
+	if err != nil {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		db.abort(t)
+		return err
+	}
+	db.bp.ReleaseTransaction(t.ID)
+	db.txns.Finish(t, txn.StateCommitted)
+	return nil
+}
+
+// rollback undoes the transaction's changes and releases its locks.
+func (db *DB) rollback(t *txn.Transaction) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.abort(t)
+}
+
+// abort undoes the changes of t and finishes it as aborted. The pages it
+// held were not written while it ran, but the catalog may have been, when
+// a sequence persisted it, so the undone pages are flushed. The caller
+// must hold db.mu.
+func (db *DB) abort(t *txn.Transaction) error {
+	err := db.undo(t, t.TakeUndoSince(0))
+	db.bp.ReleaseTransaction(t.ID)
+	if err == nil {
+		err = db.bp.FlushAllPages()
+	}
+	db.txns.Finish(t, txn.StateAborted)
+	return err
+}
+
+// checkpoint writes the dirty pages of finished transactions to disk and
+// syncs the file. Commits already write their own pages, as there is no
+// write-ahead log whose records could redo their changes after a crash, so
+// there is no log to truncate either: CHECKPOINT only saves the background
+// writer and evictions some work.
+func (db *DB) checkpoint() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.bp.FlushAllPages()
+}
+
+// rollbackTo undoes the changes t made since its savepoint named name and
+// releases the locks it acquired since.
+func (db *DB) rollbackTo(t *txn.Transaction, name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.txns.RollbackToSavepoint(t, name, func(records []txn.UndoRecord) error {
+		return db.undo(t, records)
+	})
+}
+
+// undo applies undo records of t, which holds the pages they change.
+func (db *DB) undo(t *txn.Transaction, records []txn.UndoRecord) error {
+	db.bp.SetTransaction(t.ID)
+	defer db.bp.SetTransaction(0)
+	return db.executor.Undo(records)
+}
+
+// Result summarizes a statement executed with Exec.
+type Result struct {
+	rowsAffected int64
+	lastInsertID int64
+}
+
+// LastInsertId returns the value generated for the SERIAL, identity or
+// AUTOINCREMENT column of the last inserted row, or its row ID when its
+// table has no such column.
+func (r Result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+// RowsAffected returns the number of rows inserted, updated or deleted.
+func (r Result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
@@ -0,0 +1,109 @@
+package simpledb
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// crashDirEnv passes the directory of the database a test crashes on to
+// the test binary re-executed to run up to the crash.
+const crashDirEnv = "SIMPLEDB_CRASH_DIR"
+
+// crashExitCode is how a re-executed test binary reports it reached the crash.
+const crashExitCode = 3
+
+// crash runs the calling test again in a child process, which opens a new
+// database with opts, passes it to run and exits without closing it. It
+// returns the path of the database. The test must call crash before
+// anything else, as the child process doesn't return from it.
+func crash(t *testing.T, opts *Options, run func(db *DB) error) string {
+	t.Helper()
+	if dir := os.Getenv(crashDirEnv); dir != "" {
+		db, err := Open(filepath.Join(dir, "test.db"), opts)
+		if err == nil {
+			err = run(db)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(crashExitCode)
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), crashDirEnv+"="+dir)
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != crashExitCode {
+		t.Fatalf("crashing process: %v\n%s", err, out)
+	}
+	return filepath.Join(dir, "test.db")
+}
+
+// openTest opens the database at path, or a new one when path is empty,
+// and closes it when the test ends.
+func openTest(t *testing.T, path string, opts *Options) *DB {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "test.db")
+	}
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// execer is what runs statements in tests: a DB, Conn or Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (Result, error)
+	Query(query string, args ...interface{}) (*Rows, error)
+}
+
+func mustExec(t *testing.T, db execer, query string, args ...interface{}) Result {
+	t.Helper()
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return res
+}
+
+// queryInts runs a query whose columns are integers and returns its rows.
+func queryInts(t *testing.T, db execer, query string, args ...interface{}) [][]int64 {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	var result [][]int64
+	for rows.Next() {
+		row := make([]int64, len(rows.Columns()))
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return result
+}
+
+// queryInt runs a query returning a single integer.
+func queryInt(t *testing.T, db execer, query string, args ...interface{}) int64 {
+	t.Helper()
+	rows := queryInts(t, db, query, args...)
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		t.Fatalf("%s: got %v, want a single value", query, rows)
+	}
+	return rows[0][0]
+}
@@ -0,0 +1,147 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/roackb2/simple_db/simpledb"
+)
+
+// conn implements database/sql/driver.Conn on top of a simpledb.Conn.
+type conn struct {
+	path string
+	conn *simpledb.Conn
+	tx   *simpledb.Tx
+}
+
+var (
+	_ sqldriver.ConnBeginTx        = (*conn)(nil)
+	_ sqldriver.ConnPrepareContext = (*conn)(nil)
+	_ sqldriver.ExecerContext      = (*conn)(nil)
+	_ sqldriver.QueryerContext     = (*conn)(nil)
+	_ sqldriver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (sqldriver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext parses the query once; executions reuse the parsed statement.
+func (c *conn) PrepareContext(ctx context.Context, query string) (sqldriver.Stmt, error) {
+	prepared, err := c.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{stmt: prepared}, nil
+}
+
+func (c *conn) Close() error {
+	err := c.conn.Close()
+	if releaseErr := releaseDB(c.path); releaseErr != nil && err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (c *conn) Begin() (sqldriver.Tx, error) {
+	return c.BeginTx(context.Background(), sqldriver.TxOptions{})
+}
+
+// BeginTx starts a transaction. READ UNCOMMITTED runs as READ COMMITTED and
+// SNAPSHOT as REPEATABLE READ; LINEARIZABLE isn't supported.
+func (c *conn) BeginTx(ctx context.Context, opts sqldriver.TxOptions) (sqldriver.Tx, error) {
+	var isolation simpledb.IsolationLevel
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted:
+		isolation = simpledb.ReadCommitted
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		isolation = simpledb.RepeatableRead
+	case sql.LevelSerializable:
+		isolation = simpledb.Serializable
+	default:
+		return nil, fmt.Errorf("simpledb: isolation level %s is not supported", sql.IsolationLevel(opts.Isolation))
+	}
+	tx, err := c.conn.BeginTx(ctx, &simpledb.TxOptions{ReadOnly: opts.ReadOnly, Isolation: isolation})
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return &transaction{conn: c, tx: tx}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Result, error) {
+	values, err := bindValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return c.conn.ExecContext(ctx, query, values...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	values, err := bindValues(args)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := c.conn.QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(rs), nil
+}
+
+// timeLayout is how time.Time arguments are passed to the engine: as text in
+// local time, the form NOW() returns and the date functions read.
+const timeLayout = "2006-01-02 15:04:05.999999999"
+
+// CheckNamedValue accepts the value types the engine can store, turns times
+// into text and leaves the rest to the default conversion.
+func (c *conn) CheckNamedValue(nv *sqldriver.NamedValue) error {
+	switch nv.Value.(type) {
+	case nil, int64, float64, bool, string, []byte:
+		return nil
+	}
+	value, err := sqldriver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := value.(time.Time); ok {
+		value = t.Local().Format(timeLayout)
+	}
+	nv.Value = value
+	return nil
+}
+
+// bindValues turns positional named values into engine arguments.
+func bindValues(args []sqldriver.NamedValue) ([]interface{}, error) {
+	values := make([]interface{}, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("simpledb: named parameters are not supported")
+		}
+		if arg.Ordinal < 1 || arg.Ordinal > len(args) {
+			return nil, errors.New("simpledb: invalid parameter ordinal")
+		}
+		values[arg.Ordinal-1] = arg.Value
+	}
+	return values, nil
+}
+
+// transaction implements database/sql/driver.Tx.
+type transaction struct {
+	conn *conn
+	tx   *simpledb.Tx
+}
+
+func (t *transaction) Commit() error {
+	t.conn.tx = nil
+	return t.tx.Commit()
+}
+
+func (t *transaction) Rollback() error {
+	t.conn.tx = nil
+	return t.tx.Rollback()
+}
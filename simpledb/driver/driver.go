@@ -0,0 +1,162 @@
+// Package driver registers Simple DB with database/sql under the name
+// "simpledb". The data source name is the path of the database file,
+// optionally followed by options such as "books.db?buffer_pool_size=512".
+//
+//	import _ "github.com/roackb2/simple_db/simpledb/driver"
+//
+//	db, err := sql.Open("simpledb", "books.db")
+package driver
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roackb2/simple_db/simpledb"
+)
+
+// DriverName is the name the driver is registered under.
+const DriverName = "simpledb"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver implements database/sql/driver.Driver and DriverContext.
+type Driver struct{}
+
+// Open opens a new connection to the database named by dsn.
+func (d *Driver) Open(dsn string) (sqldriver.Conn, error) {
+	connector, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+// OpenConnector parses dsn once so that connections can be opened without
+// parsing it again.
+func (d *Driver) OpenConnector(dsn string) (sqldriver.Connector, error) {
+	path, opts, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{driver: d, path: path, opts: opts}, nil
+}
+
+// parseDSN splits a data source name into the file path and the options.
+func parseDSN(dsn string) (string, *simpledb.Options, error) {
+	opts := &simpledb.Options{}
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	if path == "" {
+		return "", nil, fmt.Errorf("simpledb: missing database path in %q", dsn)
+	}
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, fmt.Errorf("simpledb: invalid options in %q: %w", dsn, err)
+	}
+	for key, values := range params {
+		value := values[len(values)-1]
+		switch key {
+		case "buffer_pool_size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 {
+				return "", nil, fmt.Errorf("simpledb: invalid buffer_pool_size %q", value)
+			}
+			opts.BufferPoolSize = size
+		case "lock_timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("simpledb: invalid lock_timeout %q", value)
+			}
+			opts.LockTimeout = timeout
+		case "work_mem":
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 {
+				return "", nil, fmt.Errorf("simpledb: invalid work_mem %q", value)
+			}
+			opts.WorkMem = size
+		case "writer_delay":
+			delay, err := time.ParseDuration(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("simpledb: invalid writer_delay %q", value)
+			}
+			opts.WriterDelay = delay
+		default:
+			return "", nil, fmt.Errorf("simpledb: unknown option %q", key)
+		}
+	}
+	return path, opts, nil
+}
+
+// openDatabases shares one *simpledb.DB between all connections to the same
+// file, since the buffer pool and lock manager must be shared.
+var openDatabases = struct {
+	sync.Mutex
+	dbs map[string]*sharedDB
+}{dbs: make(map[string]*sharedDB)}
+
+type sharedDB struct {
+	db   *simpledb.DB
+	refs int
+}
+
+func acquireDB(path string, opts *simpledb.Options) (*simpledb.DB, error) {
+	openDatabases.Lock()
+	defer openDatabases.Unlock()
+	if shared, ok := openDatabases.dbs[path]; ok {
+		shared.refs++
+		return shared.db, nil
+	}
+	db, err := simpledb.Open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	openDatabases.dbs[path] = &sharedDB{db: db, refs: 1}
+	return db, nil
+}
+
+func releaseDB(path string) error {
+	openDatabases.Lock()
+	defer openDatabases.Unlock()
+	shared, ok := openDatabases.dbs[path]
+	if !ok {
+		return nil
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+	delete(openDatabases.dbs, path)
+	return shared.db.Close()
+}
+
+// connector implements database/sql/driver.Connector.
+type connector struct {
+	driver *Driver
+	path   string
+	opts   *simpledb.Options
+}
+
+func (c *connector) Connect(ctx context.Context) (sqldriver.Conn, error) {
+	db, err := acquireDB(c.path, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := db.Conn(ctx)
+	if err != nil {
+		releaseDB(c.path)
+		return nil, err
+	}
+	return &conn{path: c.path, conn: sc}, nil
+}
+
+func (c *connector) Driver() sqldriver.Driver {
+	return c.driver
+}
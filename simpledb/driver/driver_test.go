@@ -0,0 +1,123 @@
+package driver
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDSN(t *testing.T) {
+	path, opts, err := parseDSN("books.db?buffer_pool_size=512&lock_timeout=2s&work_mem=65536&writer_delay=-1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "books.db" || opts.BufferPoolSize != 512 || opts.LockTimeout != 2*time.Second ||
+		opts.WorkMem != 65536 || opts.WriterDelay != -time.Second {
+		t.Fatalf("got %s %+v", path, opts)
+	}
+	for _, dsn := range []string{
+		"",
+		"?buffer_pool_size=512",
+		"books.db?buffer_pool_size=0",
+		"books.db?lock_timeout=soon",
+		"books.db?page_size=4096",
+	} {
+		if _, _, err := parseDSN(dsn); err == nil {
+			t.Errorf("%q: parsed, want an error", dsn)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	db, err := sql.Open(DriverName, filepath.Join(t.TempDir(), "test.db")+"?buffer_pool_size=16&lock_timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, s TEXT NOT NULL, ts TEXT, r REAL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Times are stored as text the date functions read.
+	at := time.Date(2024, 3, 5, 10, 30, 15, 0, time.Local)
+	if _, err := db.Exec("INSERT INTO t (id, s, ts) VALUES (?, ?, ?)", 1, "a", at); err != nil {
+		t.Fatal(err)
+	}
+	var ts string
+	var year float64
+	if err := db.QueryRow("SELECT ts, EXTRACT(year FROM ts) FROM t WHERE id = 1").Scan(&ts, &year); err != nil {
+		t.Fatal(err)
+	}
+	if ts != "2024-03-05 10:30:15" || year != 2024 {
+		t.Fatalf("got %q and year %v", ts, year)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO t (id, s) VALUES (?, ?)", 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.Prepare("INSERT INTO t (id, s, r) VALUES ($1, $2, $3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for i := 2; i <= 4; i++ {
+		if _, err := stmt.Exec(i, "x", float64(i)/2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query("SELECT id, s, r FROM t ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []struct {
+		name     string
+		typeName string
+		nullable bool
+		scanType reflect.Type
+	}{
+		{"id", "INTEGER", false, reflect.TypeOf(int64(0))},
+		{"s", "TEXT", false, reflect.TypeOf("")},
+		{"r", "REAL", true, reflect.TypeOf(float64(0))},
+	}
+	for i, want := range wantTypes {
+		ct := columnTypes[i]
+		nullable, ok := ct.Nullable()
+		if ct.Name() != want.name || ct.DatabaseTypeName() != want.typeName || !ok || nullable != want.nullable || ct.ScanType() != want.scanType {
+			t.Errorf("column %d: got %s %s nullable %v scan type %v", i, ct.Name(), ct.DatabaseTypeName(), nullable, ct.ScanType())
+		}
+	}
+	var ids []int64
+	var sum float64
+	for rows.Next() {
+		var id int64
+		var s string
+		var r sql.NullFloat64
+		if err := rows.Scan(&id, &s, &r); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		sum += r.Float64
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4}) || sum != 4.5 {
+		t.Fatalf("got ids %v and sum %v, want [1 2 3 4] and 4.5", ids, sum)
+	}
+}
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	sqldriver "database/sql/driver"
+	"io"
+	"reflect"
+
+	"github.com/roackb2/simple_db/simpledb"
+)
+
+// rows implements database/sql/driver.Rows with column type reporting.
+type rows struct {
+	rows        *simpledb.Rows
+	columnTypes []simpledb.ColumnType
+}
+
+var (
+	_ sqldriver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+	_ sqldriver.RowsColumnTypeScanType         = (*rows)(nil)
+	_ sqldriver.RowsColumnTypeNullable         = (*rows)(nil)
+)
+
+func newRows(rs *simpledb.Rows) *rows {
+	return &rows{rows: rs, columnTypes: rs.ColumnTypes()}
+}
+
+func (r *rows) Columns() []string {
+	return r.rows.Columns()
+}
+
+func (r *rows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *rows) Next(dest []sqldriver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, value := range r.rows.Values() {
+		dest[i] = value
+	}
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columnTypes[index].DatabaseTypeName()
+}
+
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return r.columnTypes[index].Nullable(), true
+}
+
+var (
+	scanTypeInt64   = reflect.TypeOf(int64(0))
+	scanTypeFloat64 = reflect.TypeOf(float64(0))
+	scanTypeString  = reflect.TypeOf("")
+	scanTypeBool    = reflect.TypeOf(false)
+	scanTypeAny     = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.columnTypes[index].DatabaseTypeName() {
+	case "INTEGER":
+		return scanTypeInt64
+	case "REAL":
+		return scanTypeFloat64
+	case "TEXT":
+		return scanTypeString
+	case "BOOLEAN":
+		return scanTypeBool
+	default:
+		return scanTypeAny
+	}
+}
@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"context"
+	sqldriver "database/sql/driver"
+
+	"github.com/roackb2/simple_db/simpledb"
+)
+
+// stmt implements database/sql/driver.Stmt on top of a statement prepared on
+// the connection, so the query is parsed only once.
+type stmt struct {
+	stmt *simpledb.Stmt
+}
+
+var (
+	_ sqldriver.StmtExecContext  = (*stmt)(nil)
+	_ sqldriver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return s.stmt.Close()
+}
+
+// NumInput returns the number of ? or $n parameters in the statement.
+func (s *stmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *stmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
+	return s.ExecContext(context.Background(), toNamedValues(args))
+}
+
+func (s *stmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
+	return s.QueryContext(context.Background(), toNamedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []sqldriver.NamedValue) (sqldriver.Result, error) {
+	values, err := bindValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.stmt.ExecContext(ctx, values...)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	values, err := bindValues(args)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := s.stmt.QueryContext(ctx, values...)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(rs), nil
+}
+
+func toNamedValues(args []sqldriver.Value) []sqldriver.NamedValue {
+	named := make([]sqldriver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = sqldriver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
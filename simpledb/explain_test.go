@@ -0,0 +1,43 @@
+package simpledb
+
+import (
+	"strings"
+	"testing"
+)
+
+// Every operator EXPLAIN shows has the planner's estimate of it.
+func TestExplainEstimates(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)")
+	for _, query := range []string{
+		"SELECT v, COUNT(*) FROM t GROUP BY v ORDER BY v LIMIT 3",
+		"SELECT COUNT(*) FROM t",
+		"SELECT id, ROW_NUMBER() OVER (ORDER BY v) FROM t",
+		"SELECT id FROM t UNION SELECT v FROM t ORDER BY id",
+		"SELECT id FROM t INTERSECT SELECT v FROM t",
+		"SELECT DISTINCT v FROM t",
+		"SELECT id FROM t ORDER BY v LIMIT 10 OFFSET 5",
+		"INSERT INTO t (id, v) VALUES (1, 2), (2, 3)",
+		"UPDATE t SET v = 1 WHERE id = 1",
+		"DELETE FROM t WHERE v = 1",
+	} {
+		rows, err := db.Query("EXPLAIN " + query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		first := true
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				t.Fatal(err)
+			}
+			if (first || strings.Contains(line, "->")) && !strings.Contains(line, "(cost=") {
+				t.Errorf("%s: no estimate on %q", query, line)
+			}
+			first = false
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package simpledb
+
+import (
+	"strings"
+
+	"github.com/roackb2/simple_db/internal/executor"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Function is a scalar function implemented in Go and callable from SQL.
+// Types are SQL type names such as "INTEGER", "REAL", "TEXT" or "BOOLEAN".
+// Arguments are passed to Func as nil, int64, float64, string or bool.
+type Function struct {
+	Name string
+	// Args are the types the arguments are converted to. "ANY" passes an
+	// argument of any type as it is.
+	Args []string
+	// Variadic functions take any number of arguments of their last type.
+	Variadic bool
+	// Returns is the type Func's result is converted to.
+	Returns string
+	// Volatile functions may return different results when called with the
+	// same arguments, like RANDOM(). Deterministic functions are called
+	// once per statement when their arguments don't depend on the row.
+	Volatile bool
+	// CalledOnNullInput functions are called with NULL arguments. Calls of
+	// the others return NULL if any argument is NULL.
+	CalledOnNullInput bool
+	Func              func(args []interface{}) (interface{}, error)
+}
+
+// Aggregate is an aggregate function implemented in Go and callable from
+// SQL. Each group starts with the state returned by Init, Step folds the
+// arguments of each row into the state and Final returns the result. Types
+// and argument values are as for Function.
+type Aggregate struct {
+	Name    string
+	Args    []string
+	Returns string
+	// CalledOnNullInput aggregates see rows with NULL arguments. The others
+	// skip them, like SUM does.
+	CalledOnNullInput bool
+	Init              func() interface{}
+	Step              func(state interface{}, args []interface{}) (interface{}, error)
+	Final             func(state interface{}) (interface{}, error)
+}
+
+// RegisterFunction makes fn callable from statements prepared afterwards,
+// replacing any function registered under the same name. Built-in functions
+// can't be replaced.
+func (db *DB) RegisterFunction(fn Function) error {
+	args, err := parseTypes(fn.Args)
+	if err != nil {
+		return err
+	}
+	result, err := types.ParseType(fn.Returns)
+	if err != nil {
+		return err
+	}
+	def := &executor.ScalarFunction{
+		Name:              fn.Name,
+		Args:              args,
+		Variadic:          fn.Variadic,
+		Returns:           result,
+		Volatile:          fn.Volatile,
+		CalledOnNullInput: fn.CalledOnNullInput,
+	}
+	if fn.Func != nil {
+		call := fn.Func
+		def.Func = func(args []types.Value) (types.Value, error) {
+			result, err := call(goValues(args))
+			if err != nil {
+				return types.Null(), err
+			}
+			return types.FromInterface(result)
+		}
+	}
+	return db.executor.Functions().RegisterScalar(def)
+}
+
+// RegisterAggregate makes agg callable from statements prepared afterwards,
+// replacing any function registered under the same name. Built-in functions
+// can't be replaced.
+func (db *DB) RegisterAggregate(agg Aggregate) error {
+	args, err := parseTypes(agg.Args)
+	if err != nil {
+		return err
+	}
+	result, err := types.ParseType(agg.Returns)
+	if err != nil {
+		return err
+	}
+	def := &executor.AggregateFunction{
+		Name:              agg.Name,
+		Args:              args,
+		Returns:           result,
+		CalledOnNullInput: agg.CalledOnNullInput,
+		Init:              agg.Init,
+	}
+	if agg.Step != nil {
+		step := agg.Step
+		def.Step = func(state interface{}, args []types.Value) (interface{}, error) {
+			return step(state, goValues(args))
+		}
+	}
+	if agg.Final != nil {
+		final := agg.Final
+		def.Final = func(state interface{}) (types.Value, error) {
+			result, err := final(state)
+			if err != nil {
+				return types.Null(), err
+			}
+			return types.FromInterface(result)
+		}
+	}
+	return db.executor.Functions().RegisterAggregate(def)
+}
+
+// UnregisterFunction removes a function registered with RegisterFunction or
+// RegisterAggregate and reports whether there was one.
+func (db *DB) UnregisterFunction(name string) bool {
+	return db.executor.Functions().Unregister(name)
+}
+
+func parseTypes(names []string) ([]types.Type, error) {
+	ts := make([]types.Type, len(names))
+	for i, name := range names {
+		if strings.EqualFold(name, "ANY") {
+			ts[i] = types.TypeNull
+			continue
+		}
+		t, err := types.ParseType(name)
+		if err != nil {
+			return nil, err
+		}
+		ts[i] = t
+	}
+	return ts, nil
+}
+
+func goValues(values []types.Value) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value.Interface()
+	}
+	return args
+}
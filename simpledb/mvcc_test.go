@@ -0,0 +1,216 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotVisibility(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)")
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (1, 10)")
+
+	readCommitted, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readCommitted.Rollback()
+	repeatableRead, err := db.BeginTx(context.Background(), &TxOptions{Isolation: RepeatableRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repeatableRead.Rollback()
+	queryInts(t, readCommitted, "SELECT v FROM t")
+	queryInts(t, repeatableRead, "SELECT v FROM t")
+
+	writer, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, writer, "UPDATE t SET v = 20 WHERE id = 1")
+	mustExec(t, writer, "INSERT INTO t (id, v) VALUES (2, 30)")
+	for name, tx := range map[string]*Tx{"READ COMMITTED": readCommitted, "REPEATABLE READ": repeatableRead} {
+		if got := queryInts(t, tx, "SELECT id, v FROM t"); !reflect.DeepEqual(got, [][]int64{{1, 10}}) {
+			t.Errorf("%s sees %v before the writer commits, want [[1 10]]", name, got)
+		}
+	}
+	if got := queryInts(t, writer, "SELECT id, v FROM t ORDER BY id"); !reflect.DeepEqual(got, [][]int64{{1, 20}, {2, 30}}) {
+		t.Errorf("writer sees %v, want its own changes", got)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := queryInts(t, readCommitted, "SELECT id, v FROM t ORDER BY id"); !reflect.DeepEqual(got, [][]int64{{1, 20}, {2, 30}}) {
+		t.Errorf("READ COMMITTED sees %v after the writer commits, want [[1 20] [2 30]]", got)
+	}
+	if got := queryInts(t, repeatableRead, "SELECT id, v FROM t ORDER BY id"); !reflect.DeepEqual(got, [][]int64{{1, 10}}) {
+		t.Errorf("REPEATABLE READ sees %v after the writer commits, want [[1 10]]", got)
+	}
+}
+
+func TestRolledBackChangesInvisible(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)")
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (1, 10)")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, tx, "UPDATE t SET v = 20 WHERE id = 1")
+	mustExec(t, tx, "INSERT INTO t (id, v) VALUES (2, 30)")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryInts(t, db, "SELECT id, v FROM t"); !reflect.DeepEqual(got, [][]int64{{1, 10}}) {
+		t.Fatalf("got %v, want [[1 10]]", got)
+	}
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (2, 40)")
+}
+
+func TestRepeatableReadWriteConflict(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)")
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (1, 10), (2, 20)")
+
+	tx, err := db.BeginTx(context.Background(), &TxOptions{Isolation: RepeatableRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	queryInts(t, tx, "SELECT v FROM t")
+	mustExec(t, db, "UPDATE t SET v = 11 WHERE id = 1")
+
+	// Rows changed by nobody else since the snapshot can still be changed.
+	mustExec(t, tx, "UPDATE t SET v = 21 WHERE id = 2")
+	if _, err := tx.Exec("UPDATE t SET v = 12 WHERE id = 1"); !errors.Is(err, ErrSerialization) {
+		t.Fatalf("got %v, want ErrSerialization", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryInts(t, db, "SELECT id, v FROM t ORDER BY id"); !reflect.DeepEqual(got, [][]int64{{1, 11}, {2, 20}}) {
+		t.Fatalf("got %v, want [[1 11] [2 20]]", got)
+	}
+
+	// READ COMMITTED updates the latest version instead.
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryInts(t, tx, "SELECT v FROM t")
+	mustExec(t, db, "UPDATE t SET v = 12 WHERE id = 1")
+	mustExec(t, tx, "UPDATE t SET v = v + 1 WHERE id = 1")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := queryInt(t, db, "SELECT v FROM t WHERE id = 1"); n != 13 {
+		t.Fatalf("got %d, want 13", n)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)")
+	for i := 0; i < 10; i++ {
+		mustExec(t, db, "INSERT INTO t (id, v) VALUES (?, ?)", i, i)
+	}
+
+	reader, err := db.BeginTx(context.Background(), &TxOptions{Isolation: RepeatableRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryInts(t, reader, "SELECT v FROM t")
+	mustExec(t, db, "DELETE FROM t WHERE id < 3")
+	mustExec(t, db, "UPDATE t SET v = 100 WHERE id = 9")
+
+	// The reader's snapshot still sees the old versions.
+	res := mustExec(t, db, "VACUUM t")
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Fatalf("VACUUM removed %d versions a snapshot sees", n)
+	}
+	if n := queryInt(t, reader, "SELECT SUM(v) FROM t"); n != 45 {
+		t.Fatalf("reader got sum %d, want 45", n)
+	}
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	res = mustExec(t, db, "VACUUM t")
+	if n, _ := res.RowsAffected(); n != 4 {
+		t.Fatalf("VACUUM removed %d versions, want 4", n)
+	}
+	if got := queryInts(t, db, "SELECT COUNT(*), SUM(v) FROM t"); !reflect.DeepEqual(got, [][]int64{{7, 133}}) {
+		t.Fatalf("got %v, want [[7 133]]", got)
+	}
+	if got := queryInts(t, db, "SELECT v FROM t WHERE id = 9"); !reflect.DeepEqual(got, [][]int64{{100}}) {
+		t.Fatalf("index lookup got %v, want [[100]]", got)
+	}
+	if got := queryInts(t, db, "SELECT v FROM t WHERE id = 1"); len(got) != 0 {
+		t.Fatalf("index lookup got %v for a deleted row", got)
+	}
+}
+
+// A statement doesn't see the changes its transaction makes after it
+// started, while its rows are still being read.
+func TestOwnLaterChangesInvisible(t *testing.T) {
+	db := openTest(t, "", nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)")
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (1, 1), (2, 2), (3, 3)")
+
+	for _, isolation := range []IsolationLevel{ReadCommitted, RepeatableRead} {
+		c, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustExec(t, c, "BEGIN")
+		mustExec(t, c, "SET TRANSACTION ISOLATION LEVEL "+isolation.String())
+		mustExec(t, c, "DECLARE c CURSOR FOR SELECT * FROM t")
+		if got := queryInts(t, c, "FETCH 1 FROM c"); !reflect.DeepEqual(got, [][]int64{{1, 1}}) {
+			t.Fatalf("%s: got %v, want [[1 1]]", isolation, got)
+		}
+		mustExec(t, c, "INSERT INTO t (id, v) VALUES (4, 4)")
+		mustExec(t, c, "DELETE FROM t WHERE id = 3")
+		mustExec(t, c, "UPDATE t SET v = 20 WHERE id = 2")
+		if got := queryInts(t, c, "FETCH ALL FROM c"); !reflect.DeepEqual(got, [][]int64{{2, 2}, {3, 3}}) {
+			t.Errorf("%s: cursor got %v, want [[2 2] [3 3]]", isolation, got)
+		}
+		if got := queryInts(t, c, "SELECT * FROM t ORDER BY id"); !reflect.DeepEqual(got, [][]int64{{1, 1}, {2, 20}, {4, 4}}) {
+			t.Errorf("%s: later statement got %v, want [[1 1] [2 20] [4 4]]", isolation, got)
+		}
+		mustExec(t, c, "ROLLBACK")
+		c.Close()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if id == 1 {
+			mustExec(t, tx, "DELETE FROM t WHERE id = 3")
+			mustExec(t, tx, "INSERT INTO t (id, v) VALUES (5, 5)")
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("open rows got %v, want [1 2 3]", ids)
+	}
+}
@@ -0,0 +1,164 @@
+package simpledb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecoverUncommittedInsert(t *testing.T) {
+	opts := &Options{BufferPoolSize: 8}
+	path := crash(t, opts, func(db *DB) error {
+		if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)"); err != nil {
+			return err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 3000; i++ {
+			if _, err := tx.Exec("INSERT INTO t (id, v) VALUES (?, ?)", i, fmt.Sprintf("row %d", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	db := openTest(t, path, opts)
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM t"); n != 0 {
+		t.Fatalf("got %d rows of an uncommitted transaction", n)
+	}
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (1, 'one')")
+	if got := queryInts(t, db, "SELECT id FROM t WHERE id = 1"); !reflect.DeepEqual(got, [][]int64{{1}}) {
+		t.Fatalf("got %v, want [[1]]", got)
+	}
+}
+
+func TestRecoverCheckpointedUpdate(t *testing.T) {
+	path := crash(t, nil, func(db *DB) error {
+		for _, query := range []string{
+			"CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)",
+			"INSERT INTO t (id, v) VALUES (1, 10)",
+		} {
+			if _, err := db.Exec(query); err != nil {
+				return err
+			}
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE t SET v = 50 WHERE id = 1"); err != nil {
+			return err
+		}
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			return err
+		}
+		_, err = conn.Exec("CHECKPOINT")
+		return err
+	})
+
+	db := openTest(t, path, nil)
+	if got := queryInts(t, db, "SELECT id, v FROM t"); !reflect.DeepEqual(got, [][]int64{{1, 10}}) {
+		t.Fatalf("got %v, want [[1 10]]", got)
+	}
+}
+
+func TestRecoverCommitted(t *testing.T) {
+	path := crash(t, nil, func(db *DB) error {
+		if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)"); err != nil {
+			return err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if _, err := tx.Exec("INSERT INTO t (id, v) VALUES (?, ?)", i, i); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if _, err := db.Exec("UPDATE t SET v = v + 1 WHERE id < 10"); err != nil {
+			return err
+		}
+		_, err = db.Exec("DELETE FROM t WHERE id >= 90")
+		return err
+	})
+
+	db := openTest(t, path, nil)
+	if got := queryInts(t, db, "SELECT COUNT(*), SUM(v) FROM t"); !reflect.DeepEqual(got, [][]int64{{90, 4015}}) {
+		t.Fatalf("got %v, want [[90 4015]]", got)
+	}
+}
+
+// A crash between writing the pages of a transaction and recording its
+// commit leaves its changes on disk; they must not show after recovery.
+func TestRecoverTornCommit(t *testing.T) {
+	path := crash(t, nil, func(db *DB) error {
+		for _, query := range []string{
+			"CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER)",
+			"CREATE INDEX t_v ON t (v)",
+			"INSERT INTO t (id, v) VALUES (1, 10)",
+		} {
+			if _, err := db.Exec(query); err != nil {
+				return err
+			}
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, query := range []string{
+			"INSERT INTO t (id, v) VALUES (2, 20)",
+			"UPDATE t SET v = 11 WHERE id = 1",
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if err := db.bp.WriteTransaction(tx.txn.ID); err != nil {
+			return err
+		}
+		return db.bp.Sync()
+	})
+
+	db := openTest(t, path, nil)
+	if got := queryInts(t, db, "SELECT id, v FROM t"); !reflect.DeepEqual(got, [][]int64{{1, 10}}) {
+		t.Fatalf("got %v, want [[1 10]]", got)
+	}
+	if got := queryInts(t, db, "SELECT id FROM t WHERE v = 11"); len(got) != 0 {
+		t.Fatalf("index finds %v for the torn update", got)
+	}
+	mustExec(t, db, "INSERT INTO t (id, v) VALUES (2, 21)")
+	if got := queryInts(t, db, "SELECT id, v FROM t WHERE v > 10"); !reflect.DeepEqual(got, [][]int64{{2, 21}}) {
+		t.Fatalf("got %v, want [[2 21]]", got)
+	}
+}
+
+func TestReopenAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := openTest(t, path, nil)
+	mustExec(t, db, "CREATE TABLE t (id INTEGER PRIMARY KEY)")
+	mustExec(t, db, "INSERT INTO t (id) VALUES (1)")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, tx, "INSERT INTO t (id) VALUES (2)")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openTest(t, path, nil)
+	if got := queryInts(t, db, "SELECT id FROM t"); !reflect.DeepEqual(got, [][]int64{{1}}) {
+		t.Fatalf("got %v, want [[1]]", got)
+	}
+}
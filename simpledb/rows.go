@@ -0,0 +1,212 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/roackb2/simple_db/internal/catalog"
+	"github.com/roackb2/simple_db/internal/executor"
+	"github.com/roackb2/simple_db/internal/types"
+)
+
+// Rows is an iterator over the result of a query. Rows are read from the
+// pages on demand, so Close must be called once done.
+type Rows struct {
+	ctx     context.Context
+	db      *DB
+	iter    executor.RowIterator
+	columns []catalog.Column
+	finish  func(error) error // ends the implicit transaction, if any
+	current executor.Row
+	err     error
+	closed  bool
+}
+
+func newRows(ctx context.Context, db *DB, res *executor.Result, finish func(error) error) *Rows {
+	rows := &Rows{ctx: ctx, db: db, iter: res.Rows, columns: res.Columns, finish: finish}
+	if rows.iter == nil {
+		rows.closed = true
+	}
+	return rows
+}
+
+// Columns returns the names of the result columns.
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, col := range r.columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// ColumnType describes a result column.
+type ColumnType struct {
+	name     string
+	typeName string
+	nullable bool
+}
+
+// Name returns the column name.
+func (c ColumnType) Name() string {
+	return c.name
+}
+
+// DatabaseTypeName returns the SQL type of the column, such as "INTEGER".
+// It is empty when the type is not known.
+func (c ColumnType) DatabaseTypeName() string {
+	return c.typeName
+}
+
+// Nullable reports whether the column may contain NULL.
+func (c ColumnType) Nullable() bool {
+	return c.nullable
+}
+
+// ColumnTypes returns the types of the result columns.
+func (r *Rows) ColumnTypes() []ColumnType {
+	columnTypes := make([]ColumnType, len(r.columns))
+	for i, col := range r.columns {
+		columnTypes[i] = ColumnType{name: col.Name, nullable: !col.NotNull}
+		if col.Type != types.TypeNull {
+			columnTypes[i].typeName = col.Type.String()
+		}
+	}
+	return columnTypes
+}
+
+// Next advances to the next row, returning false at the end of the result or
+// on error. Err reports which one happened.
+func (r *Rows) Next() bool {
+	if r.closed {
+		return false
+	}
+	if err := r.ctx.Err(); err != nil {
+		r.err = err
+		r.Close()
+		return false
+	}
+
+	r.db.mu.Lock()
+	if r.db.closed {
+		r.db.mu.Unlock()
+		r.err = ErrClosed
+		r.closed = true
+		return false
+	}
+	row, err := r.iter.Next()
+	r.db.mu.Unlock()
+
+	if err != nil || row == nil {
+		r.err = err
+		r.Close()
+		return false
+	}
+	r.current = row
+	return true
+}
+
+// Err returns the error, if any, that ended the iteration.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close releases the iterator and ends the implicit transaction the query ran
+// in, if any. It is safe to call more than once.
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.current = nil
+	r.db.mu.Lock()
+	err := r.iter.Close()
+	r.db.mu.Unlock()
+	if r.finish != nil {
+		if finishErr := r.finish(r.err); finishErr != nil && err == nil {
+			err = finishErr
+		}
+	}
+	return err
+}
+
+// Values returns the current row as plain Go values.
+func (r *Rows) Values() []interface{} {
+	values := make([]interface{}, len(r.current))
+	for i, value := range r.current {
+		values[i] = value.Interface()
+	}
+	return values
+}
+
+// Scan copies the columns of the current row into dest. Supported destinations
+// are *int, *int64, *float64, *string, *[]byte, *bool and *interface{}.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.current == nil {
+		return errors.New("simpledb: Scan called without a current row")
+	}
+	if len(dest) != len(r.current) {
+		return fmt.Errorf("simpledb: expected %d destinations, got %d", len(r.current), len(dest))
+	}
+	for i, value := range r.current {
+		if err := scanValue(value, dest[i]); err != nil {
+			return fmt.Errorf("simpledb: column %s: %w", r.columns[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func scanValue(value types.Value, dest interface{}) error {
+	if d, ok := dest.(*interface{}); ok {
+		*d = value.Interface()
+		return nil
+	}
+	if value.IsNull() {
+		switch d := dest.(type) {
+		case *[]byte:
+			*d = nil
+			return nil
+		case *string, *int, *int64, *float64, *bool:
+			return fmt.Errorf("cannot scan NULL into %T", dest)
+		default:
+			return fmt.Errorf("unsupported destination %T", dest)
+		}
+	}
+	switch d := dest.(type) {
+	case *string:
+		*d = value.String()
+	case *[]byte:
+		*d = []byte(value.String())
+	case *int64:
+		v, err := value.Cast(types.TypeInteger)
+		if err != nil {
+			return err
+		}
+		*d = v.Int
+	case *int:
+		v, err := value.Cast(types.TypeInteger)
+		if err != nil {
+			return err
+		}
+		if int64(int(v.Int)) != v.Int {
+			return errors.New("value " + strconv.FormatInt(v.Int, 10) + " overflows int")
+		}
+		*d = int(v.Int)
+	case *float64:
+		v, err := value.Cast(types.TypeReal)
+		if err != nil {
+			return err
+		}
+		*d = v.Float
+	case *bool:
+		v, err := value.Cast(types.TypeBoolean)
+		if err != nil {
+			return err
+		}
+		*d = v.Bool
+	default:
+		return fmt.Errorf("unsupported destination %T", dest)
+	}
+	return nil
+}
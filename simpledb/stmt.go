@@ -0,0 +1,76 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roackb2/simple_db/internal/executor"
+)
+
+// Stmt is a prepared statement. The query is parsed once and the types of its
+// ? or $n parameters are inferred from the catalog; arguments are converted to
+// those types when the statement runs.
+type Stmt struct {
+	db       *DB
+	conn     *Conn // nil for statements prepared on the DB, which run in autocommit mode
+	tx       *Tx   // set for statements prepared on a transaction
+	prepared *executor.PreparedStatement
+	closed   bool
+}
+
+var errStmtClosed = errors.New("simpledb: statement is closed")
+
+// NumInput returns the number of parameters the statement expects.
+func (s *Stmt) NumInput() int {
+	return s.prepared.NumParams()
+}
+
+// Exec runs the prepared statement with the given arguments.
+func (s *Stmt) Exec(args ...interface{}) (Result, error) {
+	return s.ExecContext(context.Background(), args...)
+}
+
+// ExecContext runs the prepared statement with the given arguments.
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (Result, error) {
+	conn, err := s.target()
+	if err != nil {
+		return Result{}, err
+	}
+	return conn.execPrepared(ctx, s.prepared, args)
+}
+
+// Query runs the prepared statement with the given arguments and returns its rows.
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext runs the prepared statement with the given arguments and returns its rows.
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	conn, err := s.target()
+	if err != nil {
+		return nil, err
+	}
+	return conn.queryPrepared(ctx, s.prepared, args)
+}
+
+// Close releases the statement.
+func (s *Stmt) Close() error {
+	s.closed = true
+	return nil
+}
+
+// target returns the connection the statement runs on.
+func (s *Stmt) target() (*Conn, error) {
+	if s.closed {
+		return nil, errStmtClosed
+	}
+	if s.tx != nil {
+		if err := s.tx.check(); err != nil {
+			return nil, err
+		}
+	}
+	if s.conn != nil {
+		return s.conn, nil
+	}
+	return &Conn{db: s.db, autocommitOnly: true}, nil
+}
@@ -0,0 +1,150 @@
+package simpledb
+
+import (
+	"context"
+
+	"github.com/roackb2/simple_db/internal/parser"
+	"github.com/roackb2/simple_db/internal/txn"
+)
+
+// TxOptions configures a transaction started with BeginTx.
+type TxOptions struct {
+	// ReadOnly rejects statements that modify the database.
+	ReadOnly bool
+	// Isolation is the isolation level of the transaction, READ COMMITTED
+	// unless set.
+	Isolation IsolationLevel
+}
+
+// IsolationLevel is how much a transaction sees of the changes other
+// transactions commit while it runs.
+type IsolationLevel = txn.IsolationLevel
+
+// Isolation levels.
+const (
+	ReadCommitted  = txn.ReadCommitted
+	RepeatableRead = txn.RepeatableRead
+	Serializable   = txn.Serializable
+)
+
+// isolationLevel converts the isolation level named by SET TRANSACTION.
+func isolationLevel(level parser.IsolationLevel) IsolationLevel {
+	switch level {
+	case parser.IsolationRepeatableRead:
+		return RepeatableRead
+	case parser.IsolationSerializable:
+		return Serializable
+	default:
+		return ReadCommitted
+	}
+}
+
+// Tx is an in-progress transaction. It holds its table locks until Commit or
+// Rollback.
+type Tx struct {
+	ctx       context.Context
+	conn      *Conn
+	txn       *txn.Transaction
+	closeConn bool // the connection was opened just for this transaction
+}
+
+// Exec runs a statement that doesn't return rows inside the transaction.
+func (tx *Tx) Exec(query string, args ...interface{}) (Result, error) {
+	return tx.ExecContext(tx.ctx, query, args...)
+}
+
+// ExecContext runs a statement that doesn't return rows inside the transaction.
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (Result, error) {
+	if err := tx.check(); err != nil {
+		return Result{}, err
+	}
+	return tx.conn.ExecContext(ctx, query, args...)
+}
+
+// Query runs a statement that returns rows inside the transaction.
+func (tx *Tx) Query(query string, args ...interface{}) (*Rows, error) {
+	return tx.QueryContext(tx.ctx, query, args...)
+}
+
+// QueryContext runs a statement that returns rows inside the transaction.
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	return tx.conn.QueryContext(ctx, query, args...)
+}
+
+// Prepare parses a statement that runs inside the transaction.
+func (tx *Tx) Prepare(query string) (*Stmt, error) {
+	return tx.PrepareContext(tx.ctx, query)
+}
+
+// PrepareContext parses a statement that runs inside the transaction.
+func (tx *Tx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	stmt, err := tx.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	stmt.tx = tx
+	return stmt, nil
+}
+
+// Savepoint sets a savepoint named name, like SAVEPOINT name.
+func (tx *Tx) Savepoint(name string) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.conn.txSavepoint(tx.txn, parser.StatementSavepoint, name)
+}
+
+// RollbackTo undoes the changes made since the savepoint named name was set
+// and releases the locks acquired since, like ROLLBACK TO SAVEPOINT name.
+// The savepoint stays set.
+func (tx *Tx) RollbackTo(name string) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.conn.txSavepoint(tx.txn, parser.StatementRollbackTo, name)
+}
+
+// Release forgets the savepoint named name and those set after it, keeping
+// the changes made since, like RELEASE SAVEPOINT name.
+func (tx *Tx) Release(name string) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.conn.txSavepoint(tx.txn, parser.StatementRelease, name)
+}
+
+// Commit makes the transaction's changes durable.
+func (tx *Tx) Commit() error {
+	if err := tx.ctx.Err(); err != nil {
+		tx.end(false)
+		return err
+	}
+	return tx.end(true)
+}
+
+// Rollback discards the transaction's changes.
+func (tx *Tx) Rollback() error {
+	return tx.end(false)
+}
+
+func (tx *Tx) end(commit bool) error {
+	err := tx.conn.endTx(tx.txn, commit)
+	if tx.closeConn && err != ErrTxDone {
+		tx.conn.Close()
+	}
+	return err
+}
+
+// check fails once the transaction has ended or its context is done.
+func (tx *Tx) check() error {
+	if tx.txn.State() != txn.StateActive {
+		return ErrTxDone
+	}
+	return tx.ctx.Err()
+}
@@ -0,0 +1,48 @@
+package simpledb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollbackToSavepoint(t *testing.T) {
+	db := openTest(t, "", &Options{LockTimeout: 100 * time.Millisecond})
+	mustExec(t, db, "CREATE TABLE a (id INTEGER PRIMARY KEY)")
+	mustExec(t, db, "CREATE TABLE b (id INTEGER PRIMARY KEY)")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	mustExec(t, tx, "INSERT INTO a (id) VALUES (1)")
+	mustExec(t, tx, "SAVEPOINT s")
+	mustExec(t, tx, "INSERT INTO a (id) VALUES (2)")
+	mustExec(t, tx, "INSERT INTO b (id) VALUES (1)")
+	mustExec(t, tx, "ROLLBACK TO SAVEPOINT s")
+
+	// The lock on b, taken after the savepoint, is released with the row.
+	mustExec(t, db, "INSERT INTO b (id) VALUES (1)")
+	if got := queryInts(t, tx, "SELECT id FROM a"); !reflect.DeepEqual(got, [][]int64{{1}}) {
+		t.Fatalf("got %v, want [[1]]", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryInts(t, db, "SELECT id FROM b"); !reflect.DeepEqual(got, [][]int64{{1}}) {
+		t.Fatalf("got %v, want [[1]]", got)
+	}
+}
+
+func TestCheckpointInTransaction(t *testing.T) {
+	db := openTest(t, "", nil)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("CHECKPOINT"); err == nil {
+		t.Fatal("CHECKPOINT ran inside a transaction")
+	}
+}